@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/OTakumi/data-importer/internal/config"
@@ -16,19 +19,91 @@ import (
 	"github.com/OTakumi/data-importer/internal/utils"
 )
 
+// Exit codes
+const (
+	exitCodeError       = 1   // The import failed
+	exitCodeErrorBudget = 3   // An error budget was exceeded and the import was aborted (2 is used for invalid flags)
+	exitCodeDeadline    = 4   // The run deadline passed and the import was aborted
+	exitCodeInterrupted = 130 // The import was interrupted by SIGINT or SIGTERM
+)
+
 func main() {
+	os.Exit(run())
+}
+
+// run runs the importer and returns the exit code
+// Deferred cleanup such as disconnecting from MongoDB runs before the process exits
+func run() int {
 	// Parse command line arguments
 	var showHelp bool
 	var envFile string
+	var extendedJSON bool
+	var keepID bool
+	var idFields string
+	var mode string
+	var keyFields string
+	var unordered bool
+	var dropCollections bool
+	var preview bool
+	var deadLetter string
+	var replayPath string
+	var maxFileErrors int
+	var maxFileErrorRatio string
+	var maxErrors int
+	var maxErrorRatio string
+	var transaction string
+	var transactionMaxBytes int
+	var swapMinRatio string
+	var runField string
+	var resume bool
+	var force bool
+	var workers int
+	var insertWorkers int
+	var maxBatchBytes int
+	var autoBatchSize bool
+	var batchTargetLatency int
+	var maxRetries int
+	var connectTimeout int
+	var batchTimeout int
+	var runTimeout int
 	flag.BoolVar(&showHelp, "help", false, "Show usage information")
 	flag.BoolVar(&showHelp, "h", false, "Show usage information (shorthand)")
 	flag.StringVar(&envFile, "env", ".env", "Path to .env file")
+	flag.BoolVar(&extendedJSON, "extjson", false, "Decode JSON files as MongoDB Extended JSON v2 (e.g. mongoexport output)")
+	flag.BoolVar(&keepID, "keep-id", false, "Keep _id fields from the source (24-hex strings and $oid become ObjectIDs)")
+	flag.StringVar(&idFields, "id-fields", "", "Comma-separated fields used to derive a deterministic _id")
+	flag.StringVar(&mode, "mode", "", "Write mode: insert, upsert, replace, merge, reload, swap or sync (default: insert)")
+	flag.StringVar(&keyFields, "key", "", "Comma-separated fields matched by upsert, replace, merge and sync (default: _id)")
+	flag.BoolVar(&unordered, "unordered", false, "Keep inserting past failed documents and print a failure table")
+	flag.BoolVar(&dropCollections, "drop-collections", false, "In sync mode, drop collections that have no matching file")
+	flag.BoolVar(&preview, "preview", false, "In sync mode, show the changes without writing anything")
+	flag.StringVar(&deadLetter, "dead-letter", "", "Send rejected documents to a \"file\" next to the source or a \"collection\" named <collection>_rejected")
+	flag.StringVar(&replayPath, "replay", "", "Re-import a fixed dead-letter file (it is kept with a .replayed suffix)")
+	flag.IntVar(&maxFileErrors, "max-file-errors", 0, "Abort the run when more documents of one file fail (default: no limit)")
+	flag.StringVar(&maxFileErrorRatio, "max-file-error-ratio", "", "Abort the run when a larger share of one file fails, e.g. 0.05 or 5% (default: no limit)")
+	flag.IntVar(&maxErrors, "max-errors", 0, "Abort the run when more documents fail in total (default: no limit)")
+	flag.StringVar(&maxErrorRatio, "max-error-ratio", "", "Abort the run when a larger share of all documents fails, e.g. 0.05 or 5% (default: no limit)")
+	flag.StringVar(&transaction, "transaction", "", "Import each \"file\", or the whole \"run\", in a transaction (requires a replica set)")
+	flag.IntVar(&transactionMaxBytes, "transaction-max-bytes", 0, "Imports larger than this go through staging collections instead of a transaction (default: 16MiB)")
+	flag.StringVar(&swapMinRatio, "swap-min-ratio", "", "In swap mode, keep the live collection when the new one holds fewer documents than this share of it, e.g. 0.9 or 90% (default: no check)")
+	flag.StringVar(&runField, "run-field", "", "Stamp the run ID into every written document under this field, e.g. _importRun (required for rollback)")
+	flag.BoolVar(&resume, "resume", false, "Continue files from the last batch written by an interrupted import (refused if a file changed)")
+	flag.BoolVar(&force, "force", false, "Re-import every file of a directory, including files unchanged since their last import")
+	flag.IntVar(&workers, "workers", 0, "Number of files of a directory imported at the same time (default: number of CPUs)")
+	flag.IntVar(&insertWorkers, "insert-workers", 0, "Number of batches of a file inserted at the same time in insert, reload and swap mode (default: 1)")
+	flag.IntVar(&maxBatchBytes, "max-batch-bytes", 0, "Largest encoded size of the documents written in one batch, up to 46MB (default: 16MiB)")
+	flag.BoolVar(&autoBatchSize, "auto-batch-size", false, "Grow or shrink the batch size toward a target round-trip time per batch")
+	flag.IntVar(&batchTargetLatency, "batch-target-latency", 0, "Round-trip time per batch aimed for by -auto-batch-size, in milliseconds (default: 500)")
+	flag.IntVar(&maxRetries, "max-retries", -1, "Retries of a batch that failed with a transient MongoDB error, 0 to disable (default: 3)")
+	flag.IntVar(&connectTimeout, "connect-timeout", 0, "Seconds allowed for connecting to MongoDB (default: 10)")
+	flag.IntVar(&batchTimeout, "batch-timeout", -1, "Seconds allowed for writing one batch before it is retried, 0 for no limit (default: 60)")
+	flag.IntVar(&runTimeout, "run-timeout", 0, "Abort the run when it takes longer than this many seconds (default: no limit)")
 	flag.Parse()
 
 	// Display help
-	if showHelp || flag.NArg() == 0 {
+	if showHelp || (flag.NArg() == 0 && replayPath == "") {
 		printUsage()
-		os.Exit(0)
+		return 0
 	}
 
 	// Set the env file path to use
@@ -36,30 +111,171 @@ func main() {
 		os.Setenv("DOTENV_PATH", envFile)
 	}
 
-	// Get the first argument as import path
+	// Get the first argument as import path (not needed when replaying)
 	importPath := flag.Arg(0)
 
+	// "rollback <run-id>" undoes an earlier run instead of importing
+	rollbackRunID := ""
+	if importPath == "rollback" {
+		if flag.NArg() != 2 {
+			fmt.Println("Usage: importer [options] rollback <run-id>")
+			return exitCodeError
+		}
+		rollbackRunID = flag.Arg(1)
+	}
+
 	// Initialize configuration
 	cfg := config.NewConfig()
+	if extendedJSON {
+		cfg.ExtendedJSON = true
+	}
+	if keepID {
+		cfg.KeepID = true
+	}
+	if idFields != "" {
+		cfg.IDFields = config.SplitList(idFields)
+	}
+	if mode != "" {
+		cfg.Mode = mode
+	}
+	if keyFields != "" {
+		cfg.KeyFields = config.SplitList(keyFields)
+	}
+	if unordered {
+		cfg.Unordered = true
+	}
+	if dropCollections {
+		cfg.SyncDropCollections = true
+	}
+	if preview {
+		cfg.Preview = true
+	}
+	if deadLetter != "" {
+		cfg.DeadLetter = deadLetter
+	}
+	if maxFileErrors > 0 {
+		cfg.MaxFileErrors = maxFileErrors
+	}
+	if maxErrors > 0 {
+		cfg.MaxErrors = maxErrors
+	}
+	if maxFileErrorRatio != "" {
+		ratio, err := config.ParseRatio(maxFileErrorRatio)
+		if err != nil {
+			log.Printf("Invalid -max-file-error-ratio: %v", err)
+			return exitCodeError
+		}
+		cfg.MaxFileErrorRatio = ratio
+	}
+	if maxErrorRatio != "" {
+		ratio, err := config.ParseRatio(maxErrorRatio)
+		if err != nil {
+			log.Printf("Invalid -max-error-ratio: %v", err)
+			return exitCodeError
+		}
+		cfg.MaxErrorRatio = ratio
+	}
+
+	if transaction != "" {
+		cfg.Transaction = transaction
+	}
+	if transactionMaxBytes > 0 {
+		cfg.TransactionMaxBytes = transactionMaxBytes
+	}
+	if runField != "" {
+		cfg.RunField = runField
+	}
+	if workers > 0 {
+		cfg.Workers = workers
+	}
+	if autoBatchSize {
+		cfg.BatchAutoTune = true
+	}
+	if batchTargetLatency > 0 {
+		cfg.BatchTargetLatencyMS = batchTargetLatency
+	}
+	if maxBatchBytes > 0 {
+		cfg.MaxBatchBytes = maxBatchBytes
+	}
+	if maxRetries >= 0 {
+		cfg.MaxRetries = maxRetries
+	}
+	if connectTimeout > 0 {
+		cfg.TimeoutSeconds = connectTimeout
+	}
+	if batchTimeout >= 0 {
+		cfg.BatchTimeoutSeconds = batchTimeout
+	}
+	if runTimeout > 0 {
+		cfg.RunTimeoutSeconds = runTimeout
+	}
+	if insertWorkers > 0 {
+		cfg.InsertWorkers = insertWorkers
+	}
+	if swapMinRatio != "" {
+		ratio, err := config.ParseRatio(swapMinRatio)
+		if err != nil {
+			log.Printf("Invalid -swap-min-ratio: %v", err)
+			return exitCodeError
+		}
+		cfg.SwapMinRatio = ratio
+	}
+
+	importMode, err := domain.ParseImportMode(cfg.Mode)
+	if err != nil {
+		log.Printf("Invalid write mode: %v", err)
+		return exitCodeError
+	}
+	transactionScope, err := domain.ParseTransactionScope(cfg.Transaction)
+	if err != nil {
+		log.Printf("Invalid transaction scope: %v", err)
+		return exitCodeError
+	}
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.TimeoutSeconds)*time.Second)
+	// Create the context of the run, which ends at the run deadline if one is set
+	// Connecting and each batch have timeouts of their own
+	ctx, cancel := service.WithRunDeadline(context.Background(), time.Duration(cfg.RunTimeoutSeconds)*time.Second)
 	defer cancel()
 
-	// Setup signal handling (for graceful shutdown when Ctrl+C is pressed)
+	// Setup signal handling for a graceful shutdown: after the first Ctrl+C or SIGTERM no new file
+	// or batch is started and the batches in flight are finished; a second one exits at once
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+	interrupted := make(chan struct{})
 	go func() {
-		<-signalChan
-		fmt.Println("\nReceived interrupt signal. Cleaning up...")
-		cancel()
-		os.Exit(1)
+		sig := <-signalChan
+		fmt.Printf("\nReceived %v. Finishing the batches in flight (send it again to exit immediately)...\n", sig)
+		close(interrupted)
+
+		sig = <-signalChan
+		fmt.Printf("\nReceived %v again. Exiting without waiting for the batches in flight\n", sig)
+		os.Exit(exitCodeInterrupted)
 	}()
 
 	// Initialize MongoDB repository
-	repo, err := repository.NewMongoRepository(ctx, cfg)
+	// The first signal also gives up connecting, so a Ctrl+C doesn't wait for the connect timeout
+	connectCtx, cancelConnect := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-interrupted:
+			cancelConnect()
+		case <-connectCtx.Done():
+		}
+	}()
+	repo, err := repository.NewMongoRepository(connectCtx, cfg)
+	cancelConnect()
+	select {
+	case <-interrupted:
+		fmt.Println("Interrupted before the import started")
+		if repo != nil {
+			repo.Disconnect(context.Background())
+		}
+		return exitCodeInterrupted
+	default:
+	}
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+		log.Printf("Failed to connect to MongoDB: %v", err)
+		return exitCodeError
 	}
 	defer func() {
 		if err := repo.Disconnect(context.Background()); err != nil {
@@ -68,36 +284,227 @@ func main() {
 	}()
 
 	// Initialize file utilities
-	fileUtils := utils.NewFileUtils(nil) // Use actual file system
+	fileUtils := utils.NewFileUtilsWithOptions(nil, utils.ParseOptions{ // Use actual file system
+		CSVDelimiter:  cfg.CSVDelimiter,
+		CSVQuote:      cfg.CSVQuote,
+		UseDecimal128: cfg.UseDecimal128,
+		ExtendedJSON:  cfg.ExtendedJSON,
+	})
+
+	// Initialize the dead-letter sink for rejected documents
+	var deadLetterSink service.DeadLetterSink
+	switch cfg.DeadLetter {
+	case "":
+	case "file":
+		deadLetterSink = service.NewFileDeadLetterSink()
+	case "collection":
+		deadLetterSink = service.NewCollectionDeadLetterSink(ctx, repo)
+	default:
+		log.Printf("Invalid dead-letter output %q: use \"file\" or \"collection\"", cfg.DeadLetter)
+		return exitCodeError
+	}
+	if deadLetterSink != nil {
+		defer func() {
+			if err := deadLetterSink.Close(); err != nil {
+				log.Printf("Error closing dead-letter output: %v", err)
+			}
+		}()
+	}
 
 	// Initialize importer service
-	importer := service.NewMongoImporterWithOptions(ctx, fileUtils, repo, cfg.BatchSize, true)
+	importer := service.NewMongoImporter(ctx, fileUtils, repo, service.ImporterOptions{
+		BatchSize:     readBatchSize(cfg),
+		RemoveIDField: removeIDField(cfg, importMode),
+		ExtendedJSON:  cfg.ExtendedJSON,
+		IDFields:      cfg.IDFields,
+		Mode:          importMode,
+		KeyFields:     cfg.KeyFields,
+		Unordered:     cfg.Unordered,
+		DeadLetter:    deadLetterSink,
+
+		FileErrorBudget: service.ErrorBudget{MaxErrors: cfg.MaxFileErrors, MaxErrorRatio: cfg.MaxFileErrorRatio},
+		RunErrorBudget:  service.ErrorBudget{MaxErrors: cfg.MaxErrors, MaxErrorRatio: cfg.MaxErrorRatio},
+
+		Transaction:         transactionScope,
+		MaxTransactionBytes: int64(cfg.TransactionMaxBytes),
+
+		RunField:      cfg.RunField,
+		Resume:        resume,
+		Force:         force,
+		Workers:       cfg.Workers,
+		InsertWorkers: cfg.InsertWorkers,
+		SwapMinRatio:  cfg.SwapMinRatio,
+
+		DropMissingCollections: cfg.SyncDropCollections,
+		Preview:                cfg.Preview,
+	})
+
+	// Stop starting new files and batches once interrupted
+	go func() {
+		<-interrupted
+		importer.Stop()
+	}()
+
+	// Roll back an earlier run instead of importing a path
+	if rollbackRunID != "" {
+		startTime := time.Now()
+		fmt.Printf("Rolling back import run: %s\n", rollbackRunID)
+
+		results, err := importer.RollbackRun(rollbackRunID)
+		if results != nil {
+			displayResults(results, time.Since(startTime))
+		}
+		if err != nil {
+			log.Printf("Error during rollback: %v", err)
+			return exitCodeError
+		}
+		return 0
+	}
+
+	// Replay a dead-letter file instead of importing a path
+	if replayPath != "" {
+		startTime := time.Now()
+
+		// Move the file aside so documents rejected again start a new dead-letter file
+		replayedPath := replayPath + ".replayed"
+		if err := os.Rename(replayPath, replayedPath); err != nil {
+			log.Printf("Error preparing dead-letter file: %v", err)
+			return exitCodeError
+		}
+		fmt.Printf("Replaying dead-letter file: %s (kept as %s)\n", replayPath, replayedPath)
+		fmt.Printf("Run ID: %s\n", importer.RunID())
+
+		results, err := importer.ReplayDeadLetter(replayedPath)
+		if err != nil {
+			log.Printf("Error during replay: %v", err)
+			restoreDeadLetter(replayedPath, replayPath)
+			return exitCodeError
+		}
+
+		displayResults(results, time.Since(startTime))
+		return 0
+	}
 
 	// Execute import process
 	startTime := time.Now()
 	fmt.Printf("Starting import: %s\n", importPath)
 	fmt.Printf("Using MongoDB: %s, Database: %s\n", cfg.MongoURI, cfg.DatabaseName)
+	fmt.Printf("Run ID: %s\n", importer.RunID())
 
 	result, err := importer.ImportPath(importPath)
+	var budgetErr *service.BudgetExceededError
+	if errors.As(err, &budgetErr) {
+		// Show what was imported before the run was aborted
+		displayResults(result, time.Since(startTime))
+		fmt.Printf("\nImport aborted: %v\n", budgetErr)
+		return exitCodeErrorBudget
+	}
+	if errors.Is(err, service.ErrImportInterrupted) {
+		// Show what was written before the import stopped
+		displayResults(result, time.Since(startTime))
+		fmt.Printf("\nImport interrupted: %v\n", err)
+		if importer.Resumable() {
+			fmt.Println("Run the import again with -resume to continue unfinished files after their last written batch")
+		}
+		return exitCodeInterrupted
+	}
+	if err != nil && errors.Is(context.Cause(ctx), service.ErrRunDeadlineExceeded) {
+		// Show what was imported before the deadline
+		displayResults(result, time.Since(startTime))
+		fmt.Printf("\nImport aborted: %v after %ds: %v\n", service.ErrRunDeadlineExceeded, cfg.RunTimeoutSeconds, err)
+		return exitCodeDeadline
+	}
 	if err != nil {
-		log.Fatalf("Error during import process: %v", err)
+		log.Printf("Error during import process: %v", err)
+		return exitCodeError
 	}
 
 	// Display results
 	displayResults(result, time.Since(startTime))
+	return 0
 }
 
 // printUsage displays usage information
 func printUsage() {
 	fmt.Println("MongoDB JSON Importer")
 	fmt.Println("Usage: importer [options] <file-path or directory-path>")
+	fmt.Println("       importer [options] rollback <run-id>")
+	fmt.Println("\nSupported files: .json, .jsonl, .ndjson, .csv, .tsv")
 	fmt.Println("\nOptions:")
 	flag.PrintDefaults()
 	fmt.Println("\nEnvironment Variables (can be set in .env file):")
 	fmt.Println("  MONGODB_URI        - MongoDB connection URI (default: mongodb://mongodb:27017)")
 	fmt.Println("  MONGODB_DATABASE   - Database name (default: test_db)")
-	fmt.Println("  MONGODB_TIMEOUT    - Timeout in seconds (default: 10)")
+	fmt.Println("  MONGODB_CONNECT_TIMEOUT - Connect timeout in seconds (default: MONGODB_TIMEOUT)")
+	fmt.Println("  MONGODB_TIMEOUT    - Connect timeout in seconds; batches and runs have their own timeouts (default: 10)")
+	fmt.Println("  MONGODB_BATCH_TIMEOUT - Seconds allowed for writing one batch, 0 for no limit (default: 60)")
+	fmt.Println("  IMPORT_RUN_TIMEOUT - Deadline of the whole run in seconds (default: no limit)")
 	fmt.Println("  MONGODB_BATCH_SIZE - Batch size for imports (default: 1000)")
+	fmt.Println("  MONGODB_MAX_BATCH_BYTES - Largest encoded size of one batch, up to 46MB (default: 16MiB)")
+	fmt.Println("  MONGODB_BATCH_AUTO_TUNE - Grow or shrink the batch size toward a target round-trip time (default: false)")
+	fmt.Println("  MONGODB_BATCH_TARGET_LATENCY_MS - Round-trip time per batch aimed for when auto-tuning (default: 500)")
+	fmt.Println("  MONGODB_BATCH_MIN_SIZE / MONGODB_BATCH_MAX_SIZE - Bounds of the auto-tuned batch size (default: 100 / 10000)")
+	fmt.Println("  MONGODB_MAX_RETRIES - Retries of a batch that failed with a transient error (default: 3)")
+	fmt.Println("  MONGODB_RETRY_BASE_DELAY_MS / MONGODB_RETRY_MAX_DELAY_MS - Backoff between retries (default: 100 / 5000)")
+	fmt.Println("  IMPORT_WORKERS     - Files of a directory imported at the same time (default: number of CPUs)")
+	fmt.Println("  IMPORT_INSERT_WORKERS - Batches of a file inserted at the same time (default: 1)")
+	fmt.Println("  IMPORT_CSV_DELIMITER - Field delimiter for .csv files (default: ,)")
+	fmt.Println("  IMPORT_CSV_QUOTE     - Quote character for .csv and .tsv files (default: \")")
+	fmt.Println("  IMPORT_DECIMAL128    - Store fractional numbers as Decimal128 (default: false)")
+	fmt.Println("  IMPORT_EXTENDED_JSON - Decode JSON files as MongoDB Extended JSON v2 (default: false)")
+	fmt.Println("  IMPORT_KEEP_ID       - Keep _id fields from the source (default: false)")
+	fmt.Println("  IMPORT_ID_FIELDS     - Comma-separated fields used to derive a deterministic _id")
+	fmt.Println("  IMPORT_MODE          - Write mode: insert, upsert, replace, merge, reload, swap or sync (default: insert)")
+	fmt.Println("  IMPORT_KEY_FIELDS    - Comma-separated fields matched by upsert, replace, merge and sync (default: _id)")
+	fmt.Println("  IMPORT_UNORDERED     - Keep inserting past failed documents and report them (default: false)")
+	fmt.Println("  IMPORT_DEAD_LETTER   - Send rejected documents to a \"file\" or a \"collection\" (default: fail instead)")
+	fmt.Println("  IMPORT_SYNC_DROP_COLLECTIONS - In sync mode, drop collections that have no matching file (default: false)")
+	fmt.Println("  IMPORT_PREVIEW       - In sync mode, show the changes without writing anything (default: false)")
+	fmt.Println("  IMPORT_MAX_FILE_ERRORS      - Failed documents tolerated per file (default: no limit)")
+	fmt.Println("  IMPORT_MAX_FILE_ERROR_RATIO - Share of failed documents tolerated per file, e.g. 0.05 or 5% (default: no limit)")
+	fmt.Println("  IMPORT_MAX_ERRORS           - Failed documents tolerated per run (default: no limit)")
+	fmt.Println("  IMPORT_MAX_ERROR_RATIO      - Share of failed documents tolerated per run (default: no limit)")
+	fmt.Println("  IMPORT_TRANSACTION           - Import each \"file\", or the whole \"run\", in a transaction (default: none)")
+	fmt.Println("  IMPORT_TRANSACTION_MAX_BYTES - Imports larger than this go through staging collections (default: 16MiB)")
+	fmt.Println("  IMPORT_SWAP_MIN_RATIO        - In swap mode, minimum size of the new collection relative to the live one (default: no check)")
+	fmt.Println("  IMPORT_RUN_FIELD             - Stamp the run ID into every written document under this field (default: none)")
+	fmt.Printf("\nExit codes: 0 success, %d import failed, %d error budget exceeded, %d run deadline passed, %d interrupted\n",
+		exitCodeError, exitCodeErrorBudget, exitCodeDeadline, exitCodeInterrupted)
+}
+
+// readBatchSize returns the number of documents read from a file before they are written
+// When the batch size is auto-tuned, files are read in chunks of the largest batch,
+// so the repository can grow its batches up to that size
+func readBatchSize(cfg *config.Config) int {
+	if cfg.BatchAutoTune {
+		return max(cfg.BatchSize, cfg.BatchMaxSize)
+	}
+	return cfg.BatchSize
+}
+
+// removeIDField reports whether _id fields from the source should be dropped
+// They are kept when requested, when a deterministic _id is derived,
+// and when documents are matched on _id by upsert, replace, merge or sync
+func removeIDField(cfg *config.Config, mode domain.ImportMode) bool {
+	if cfg.KeepID || len(cfg.IDFields) > 0 {
+		return false
+	}
+	return !(mode.MatchesByKey() && len(cfg.KeyFields) == 0)
+}
+
+// restoreDeadLetter moves a dead-letter file back after a failed replay, so it can be replayed again
+// If records rejected again during the replay already started a new dead-letter file,
+// that file is kept and the original stays at replayedPath
+func restoreDeadLetter(replayedPath, path string) {
+	if _, err := os.Stat(path); err == nil {
+		fmt.Printf("The replayed records are kept in %s\n", replayedPath)
+		return
+	}
+	if err := os.Rename(replayedPath, path); err != nil {
+		log.Printf("Error restoring dead-letter file: %v", err)
+		return
+	}
+	fmt.Printf("Dead-letter file restored: %s\n", path)
 }
 
 // displayResults displays the results of the import process
@@ -107,35 +514,195 @@ func displayResults(result any, duration time.Duration) {
 		// Display results for a single file
 		fmt.Printf("\nImport results for file '%s':\n", r.FileName)
 		fmt.Printf("  Collection: %s\n", r.CollectionName)
-		fmt.Printf("  Documents inserted: %d\n", r.InsertedCount)
+		if r.PreviousCount > 0 {
+			fmt.Printf("  Documents inserted: %d (previously %d)\n", r.InsertedCount, r.PreviousCount)
+		} else {
+			fmt.Printf("  Documents inserted: %d\n", r.InsertedCount)
+		}
+		if r.MatchedCount > 0 || r.UpsertedCount > 0 {
+			fmt.Printf("  Documents matched: %d (modified: %d)\n", r.MatchedCount, r.ModifiedCount)
+			fmt.Printf("  Documents upserted: %d\n", r.UpsertedCount)
+		}
 		fmt.Printf("  Processing time: %v\n", r.Duration)
+		if r.BatchSize > 0 {
+			fmt.Printf("  Batch size: tuned to %d documents\n", r.BatchSize)
+		}
+		if r.ResumedCount > 0 {
+			fmt.Printf("  Resumed: skipped %d documents written by an interrupted import\n", r.ResumedCount)
+		}
+		if r.Staged {
+			fmt.Println("  Written through a staging collection")
+		}
+		if r.RolledBack {
+			fmt.Println("  Rolled back: nothing was written")
+		}
 		if r.Error != nil {
 			fmt.Printf("  Error: %v\n", r.Error)
 		}
+		if r.RejectedCount > 0 {
+			fmt.Printf("  Documents rejected: %d (see dead-letter output)\n", r.RejectedCount)
+		}
+		printFailures(r.Failed, "  ")
 
 	case []*domain.ImportResult:
 		// Display results for a directory (multiple files)
 		fmt.Printf("\nDirectory import results (%d files):\n", len(r))
 
 		totalDocuments := 0
+		totalMatched, totalModified, totalUpserted := 0, 0, 0
 		successCount := 0
 		errorCount := 0
+		failedDocuments := 0
+		rejectedDocuments := 0
+		rolledBack := 0
+		resumedDocuments := 0
+		unchangedCount := 0
 
 		for _, res := range r {
+			resumedDocuments += res.ResumedCount
 			totalDocuments += res.InsertedCount
+			totalMatched += res.MatchedCount
+			totalModified += res.ModifiedCount
+			totalUpserted += res.UpsertedCount
+			failedDocuments += len(res.Failed)
+			rejectedDocuments += res.RejectedCount
+			if res.RolledBack {
+				rolledBack++
+			}
+			if res.Unchanged {
+				unchangedCount++
+				fmt.Printf("  - %s -> %s (unchanged since its last import, skipped)\n", res.FileName, res.CollectionName)
+				continue
+			}
 			if res.Error == nil {
 				successCount++
-				fmt.Printf("  ✓ %s -> %s (%d documents, %v)\n",
-					res.FileName, res.CollectionName, res.InsertedCount, res.Duration)
+				if res.PreviousCount > 0 {
+					fmt.Printf("  ✓ %s -> %s (%d documents, previously %d, %v)\n",
+						res.FileName, res.CollectionName, res.InsertedCount, res.PreviousCount, res.Duration)
+				} else {
+					fmt.Printf("  ✓ %s -> %s (%d documents, %v)\n",
+						res.FileName, res.CollectionName, res.InsertedCount, res.Duration)
+				}
+				if res.BatchSize > 0 {
+					fmt.Printf("    batch size tuned to %d documents\n", res.BatchSize)
+				}
 			} else {
 				errorCount++
 				fmt.Printf("  ✗ %s -> Error: %v\n", res.FileName, res.Error)
 			}
+			printFailures(res.Failed, "    ")
 		}
 
 		fmt.Printf("\nTotal: %d documents, %d files succeeded, %d files failed\n",
 			totalDocuments, successCount, errorCount)
+		if totalMatched > 0 || totalUpserted > 0 {
+			fmt.Printf("Matched: %d, modified: %d, upserted: %d\n", totalMatched, totalModified, totalUpserted)
+		}
+		if failedDocuments > 0 {
+			fmt.Printf("Failed documents: %d\n", failedDocuments)
+		}
+		if rejectedDocuments > 0 {
+			fmt.Printf("Rejected documents: %d (see dead-letter output)\n", rejectedDocuments)
+		}
+		if rolledBack > 0 {
+			fmt.Printf("Rolled back files: %d (nothing was written for them)\n", rolledBack)
+		}
+		if unchangedCount > 0 {
+			fmt.Printf("Unchanged files skipped: %d (use -force to import them again)\n", unchangedCount)
+		}
+		if resumedDocuments > 0 {
+			fmt.Printf("Resumed: skipped %d documents written by an interrupted import\n", resumedDocuments)
+		}
+
+	case *domain.SyncResult:
+		// Display results for a single synced file
+		fmt.Printf("\nSync results for file '%s'%s:\n", r.FileName, previewNote(r.Preview))
+		fmt.Printf("  Collection: %s\n", r.CollectionName)
+		fmt.Printf("  Inserted: %d, updated: %d, deleted: %d, unchanged: %d\n",
+			r.InsertedCount, r.UpdatedCount, r.DeletedCount, r.UnchangedCount)
+		fmt.Printf("  Processing time: %v\n", r.Duration)
+		if r.Error != nil {
+			fmt.Printf("  Error: %v\n", r.Error)
+		}
+
+	case []*domain.SyncResult:
+		// Display a per-collection summary for a synced directory
+		preview := len(r) > 0 && r[0].Preview
+		fmt.Printf("\nDirectory sync results (%d collections)%s:\n", len(r), previewNote(preview))
+
+		inserted, updated, deleted, dropped, errorCount := 0, 0, 0, 0, 0
+		for _, res := range r {
+			switch {
+			case res.Error != nil:
+				errorCount++
+				fmt.Printf("  ✗ %s -> Error: %v\n", res.CollectionName, res.Error)
+			case res.Dropped:
+				dropped++
+				fmt.Printf("  - %s dropped (no matching file)\n", res.CollectionName)
+			default:
+				inserted += res.InsertedCount
+				updated += res.UpdatedCount
+				deleted += res.DeletedCount
+				fmt.Printf("  ✓ %s -> %s (%d inserted, %d updated, %d deleted, %d unchanged, %v)\n",
+					res.FileName, res.CollectionName, res.InsertedCount, res.UpdatedCount,
+					res.DeletedCount, res.UnchangedCount, res.Duration)
+			}
+		}
+
+		fmt.Printf("\nTotal: %d inserted, %d updated, %d deleted, %d collections dropped, %d failed\n",
+			inserted, updated, deleted, dropped, errorCount)
+
+	case []*domain.RollbackResult:
+		// Display a per-collection summary of a rollback
+		fmt.Printf("\nRollback results (%d collections):\n", len(r))
+
+		deleted, restored, errorCount := 0, 0, 0
+		for _, res := range r {
+			deleted += res.DeletedCount
+			restored += res.RestoredCount
+			if res.Error != nil {
+				errorCount++
+				fmt.Printf("  ✗ %s -> Error: %v\n", res.CollectionName, res.Error)
+				continue
+			}
+			fmt.Printf("  ✓ %s (%d deleted, %d restored)\n", res.CollectionName, res.DeletedCount, res.RestoredCount)
+		}
+
+		fmt.Printf("\nTotal: %d deleted, %d restored, %d collections failed\n", deleted, restored, errorCount)
 	}
 
 	fmt.Printf("\nTotal processing time: %v\n", duration)
 }
+
+// maxFailureRows is the number of failed documents listed per file
+const maxFailureRows = 20
+
+// printFailures prints a table of documents that failed to be written
+func printFailures(failures []domain.DocumentFailure, indent string) {
+	if len(failures) == 0 {
+		return
+	}
+
+	fmt.Printf("%sFailed documents: %d\n", indent, len(failures))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s  INDEX\tCODE\tMESSAGE\n", indent)
+	for i, failure := range failures {
+		if i == maxFailureRows {
+			break
+		}
+		fmt.Fprintf(w, "%s  %d\t%d\t%s\n", indent, failure.Index, failure.Code, failure.Message)
+	}
+	w.Flush()
+
+	if len(failures) > maxFailureRows {
+		fmt.Printf("%s  ... and %d more\n", indent, len(failures)-maxFailureRows)
+	}
+}
+
+// previewNote returns a note to show in headings when nothing was written
+func previewNote(preview bool) string {
+	if preview {
+		return " [preview, nothing written]"
+	}
+	return ""
+}
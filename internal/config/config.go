@@ -4,17 +4,52 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"runtime"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/joho/godotenv"
 )
 
 // Config holds application configuration
 type Config struct {
-	MongoURI       string
-	DatabaseName   string
-	TimeoutSeconds int
-	BatchSize      int
+	MongoURI             string
+	DatabaseName         string
+	TimeoutSeconds       int // Connect and ping timeout, in seconds (MONGODB_CONNECT_TIMEOUT or MONGODB_TIMEOUT)
+	BatchSize            int
+	MaxBatchBytes        int      // Encoded size of the documents written in one batch
+	BatchAutoTune        bool     // Grow or shrink the batch size toward BatchTargetLatencyMS
+	BatchTargetLatencyMS int      // Round-trip time aimed for per batch when auto-tuning, in milliseconds
+	BatchMinSize         int      // Smallest batch size when auto-tuning
+	BatchMaxSize         int      // Largest batch size when auto-tuning
+	MaxRetries           int      // Retries of a batch that failed with a transient error (0: no retries)
+	RetryBaseDelayMS     int      // Wait before the first retry, doubled on each further retry, in milliseconds
+	RetryMaxDelayMS      int      // Longest wait between retries, in milliseconds
+	BatchTimeoutSeconds  int      // Time allowed for writing one batch before it is retried, in seconds (0: no limit)
+	RunTimeoutSeconds    int      // Deadline of the whole run, in seconds (0: no limit)
+	Workers              int      // Files of a directory imported at the same time
+	InsertWorkers        int      // Batches of a file inserted at the same time
+	CSVDelimiter         rune     // Field delimiter for CSV files
+	CSVQuote             rune     // Quote character for CSV and TSV files
+	UseDecimal128        bool     // Store fractional numbers as Decimal128 instead of double
+	ExtendedJSON         bool     // Decode JSON input as MongoDB Extended JSON v2
+	KeepID               bool     // Keep _id fields from the source instead of removing them
+	IDFields             []string // Fields used to derive a deterministic _id
+	Mode                 string   // Write mode: insert, upsert, replace, merge, reload, swap or sync
+	KeyFields            []string // Fields matched by upsert, replace, merge and sync (default: _id)
+	Unordered            bool     // Keep inserting past failed documents and report them
+	DeadLetter           string   // Where rejected documents go: "file", "collection" or empty to fail instead
+	SyncDropCollections  bool     // In sync mode, drop collections that have no matching file
+	Preview              bool     // In sync mode, only show the changes without writing anything
+	MaxFileErrors        int      // Failed documents tolerated per file before the run is aborted (0: no limit)
+	MaxFileErrorRatio    float64  // Share of failed documents tolerated per file (0: no limit)
+	MaxErrors            int      // Failed documents tolerated per run (0: no limit)
+	MaxErrorRatio        float64  // Share of failed documents tolerated per run (0: no limit)
+	Transaction          string   // Transaction scope: "file", "run" or empty for none
+	TransactionMaxBytes  int      // Input size above which staging collections replace a transaction (0: default)
+	SwapMinRatio         float64  // In swap mode, minimum document count of the new collection relative to the live one (0: no check)
+	RunField             string   // Field that receives the run ID in every written document (empty: none)
 }
 
 // LoadEnv loads environment variables from .env file if it exists
@@ -91,12 +126,17 @@ func NewConfig() *Config {
 	// Try to load .env file (ignore if not exists)
 	_ = LoadEnv()
 
-	// Parse timeout seconds
+	// Parse the connect timeout seconds
+	// MONGODB_CONNECT_TIMEOUT takes precedence over MONGODB_TIMEOUT, which also only
+	// limits connecting now that batches and runs have timeouts of their own
 	timeoutStr := getEnv("MONGODB_TIMEOUT", "10")
 	timeout, err := strconv.Atoi(timeoutStr)
 	if err != nil {
 		timeout = 10 // Default if parsing fails
 	}
+	if connectTimeout := getEnvInt("MONGODB_CONNECT_TIMEOUT", 0); connectTimeout > 0 {
+		timeout = connectTimeout
+	}
 
 	// Parse batch size
 	batchSizeStr := getEnv("MONGODB_BATCH_SIZE", "1000")
@@ -105,11 +145,49 @@ func NewConfig() *Config {
 		batchSize = 1000 // Default if parsing fails
 	}
 
+	// Parse the number of workers, one per CPU unless set
+	workers := getEnvInt("IMPORT_WORKERS", 0)
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	}
+
 	return &Config{
-		MongoURI:       BuildMongoURI(),
-		DatabaseName:   getEnv("MONGODB_DATABASE", "test_db"),
-		TimeoutSeconds: timeout,
-		BatchSize:      batchSize,
+		MongoURI:             BuildMongoURI(),
+		DatabaseName:         getEnv("MONGODB_DATABASE", "test_db"),
+		TimeoutSeconds:       timeout,
+		BatchSize:            batchSize,
+		MaxBatchBytes:        getEnvInt("MONGODB_MAX_BATCH_BYTES", 16*1024*1024),
+		BatchAutoTune:        getEnvBool("MONGODB_BATCH_AUTO_TUNE", false),
+		BatchTargetLatencyMS: getEnvInt("MONGODB_BATCH_TARGET_LATENCY_MS", 500),
+		BatchMinSize:         getEnvInt("MONGODB_BATCH_MIN_SIZE", 100),
+		BatchMaxSize:         getEnvInt("MONGODB_BATCH_MAX_SIZE", 10000),
+		MaxRetries:           getEnvInt("MONGODB_MAX_RETRIES", 3),
+		RetryBaseDelayMS:     getEnvInt("MONGODB_RETRY_BASE_DELAY_MS", 100),
+		RetryMaxDelayMS:      getEnvInt("MONGODB_RETRY_MAX_DELAY_MS", 5000),
+		BatchTimeoutSeconds:  getEnvInt("MONGODB_BATCH_TIMEOUT", 60),
+		RunTimeoutSeconds:    getEnvInt("IMPORT_RUN_TIMEOUT", 0),
+		Workers:              workers,
+		InsertWorkers:        getEnvInt("IMPORT_INSERT_WORKERS", 1),
+		CSVDelimiter:         getEnvRune("IMPORT_CSV_DELIMITER", ','),
+		CSVQuote:             getEnvRune("IMPORT_CSV_QUOTE", '"'),
+		UseDecimal128:        getEnvBool("IMPORT_DECIMAL128", false),
+		ExtendedJSON:         getEnvBool("IMPORT_EXTENDED_JSON", false),
+		KeepID:               getEnvBool("IMPORT_KEEP_ID", false),
+		IDFields:             getEnvList("IMPORT_ID_FIELDS"),
+		Mode:                 getEnv("IMPORT_MODE", "insert"),
+		KeyFields:            getEnvList("IMPORT_KEY_FIELDS"),
+		Unordered:            getEnvBool("IMPORT_UNORDERED", false),
+		DeadLetter:           getEnv("IMPORT_DEAD_LETTER", ""),
+		SyncDropCollections:  getEnvBool("IMPORT_SYNC_DROP_COLLECTIONS", false),
+		Preview:              getEnvBool("IMPORT_PREVIEW", false),
+		MaxFileErrors:        getEnvInt("IMPORT_MAX_FILE_ERRORS", 0),
+		MaxFileErrorRatio:    getEnvRatio("IMPORT_MAX_FILE_ERROR_RATIO", 0),
+		MaxErrors:            getEnvInt("IMPORT_MAX_ERRORS", 0),
+		MaxErrorRatio:        getEnvRatio("IMPORT_MAX_ERROR_RATIO", 0),
+		Transaction:          getEnv("IMPORT_TRANSACTION", ""),
+		TransactionMaxBytes:  getEnvInt("IMPORT_TRANSACTION_MAX_BYTES", 0),
+		SwapMinRatio:         getEnvRatio("IMPORT_SWAP_MIN_RATIO", 0),
+		RunField:             getEnv("IMPORT_RUN_FIELD", ""),
 	}
 }
 
@@ -121,3 +199,84 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvRune gets a single-character environment variable value or returns a default
+// The escape sequence \t and the word "tab" are accepted for a tab character
+func getEnvRune(key string, defaultValue rune) rune {
+	value := os.Getenv(key)
+	switch value {
+	case "":
+		return defaultValue
+	case `\t`, "tab":
+		return '\t'
+	}
+
+	r, size := utf8.DecodeRuneInString(value)
+	if r == utf8.RuneError || size != len(value) {
+		return defaultValue // Default if not a single character
+	}
+	return r
+}
+
+// getEnvBool gets a boolean environment variable value or returns a default
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvInt gets a non-negative integer environment variable value or returns a default
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvRatio gets a ratio environment variable value or returns a default
+// See ParseRatio for the accepted formats
+func getEnvRatio(key string, defaultValue float64) float64 {
+	value, err := ParseRatio(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// ParseRatio parses a ratio between 0 and 1, given either as a fraction ("0.05")
+// or as a percentage ("5%")
+func ParseRatio(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	percent := strings.HasSuffix(value, "%")
+	ratio, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ratio %q: %w", value, err)
+	}
+	if percent {
+		ratio /= 100
+	}
+	if ratio < 0 || ratio > 1 {
+		return 0, fmt.Errorf("invalid ratio %q: must be between 0 and 1 (or 0%% and 100%%)", value)
+	}
+	return ratio, nil
+}
+
+// getEnvList gets a comma-separated environment variable value as a list
+// Empty items are ignored; nil is returned if the variable is not set
+func getEnvList(key string) []string {
+	return SplitList(os.Getenv(key))
+}
+
+// SplitList splits a comma-separated list, trimming spaces and dropping empty items
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
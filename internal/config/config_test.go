@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 )
 
@@ -11,7 +12,16 @@ func TestNewConfig(t *testing.T) {
 	os.Unsetenv("MONGODB_URI")
 	os.Unsetenv("MONGODB_DATABASE")
 	os.Unsetenv("MONGODB_TIMEOUT")
+	os.Unsetenv("MONGODB_CONNECT_TIMEOUT")
 	os.Unsetenv("MONGODB_BATCH_SIZE")
+	os.Unsetenv("IMPORT_WORKERS")
+	os.Unsetenv("IMPORT_INSERT_WORKERS")
+	os.Unsetenv("MONGODB_MAX_BATCH_BYTES")
+	os.Unsetenv("MONGODB_BATCH_AUTO_TUNE")
+	os.Unsetenv("MONGODB_BATCH_MAX_SIZE")
+	os.Unsetenv("MONGODB_MAX_RETRIES")
+	os.Unsetenv("MONGODB_BATCH_TIMEOUT")
+	os.Unsetenv("IMPORT_RUN_TIMEOUT")
 
 	// Test default values
 	config := NewConfig()
@@ -27,12 +37,41 @@ func TestNewConfig(t *testing.T) {
 	if config.BatchSize != 1000 {
 		t.Errorf("Expected default BatchSize to be 1000, got %d", config.BatchSize)
 	}
+	if config.Workers != runtime.NumCPU() {
+		t.Errorf("Expected default Workers to be %d, got %d", runtime.NumCPU(), config.Workers)
+	}
+	if config.InsertWorkers != 1 {
+		t.Errorf("Expected default InsertWorkers to be 1, got %d", config.InsertWorkers)
+	}
+	if config.MaxBatchBytes != 16*1024*1024 {
+		t.Errorf("Expected default MaxBatchBytes to be 16MiB, got %d", config.MaxBatchBytes)
+	}
+	if config.BatchAutoTune || config.BatchTargetLatencyMS != 500 || config.BatchMinSize != 100 || config.BatchMaxSize != 10000 {
+		t.Errorf("Expected batch auto-tuning to be off with 500ms and 100-10000 bounds, got %v %d %d-%d",
+			config.BatchAutoTune, config.BatchTargetLatencyMS, config.BatchMinSize, config.BatchMaxSize)
+	}
+	if config.MaxRetries != 3 || config.RetryBaseDelayMS != 100 || config.RetryMaxDelayMS != 5000 {
+		t.Errorf("Expected 3 retries between 100ms and 5000ms, got %d %d-%d",
+			config.MaxRetries, config.RetryBaseDelayMS, config.RetryMaxDelayMS)
+	}
+	if config.BatchTimeoutSeconds != 60 || config.RunTimeoutSeconds != 0 {
+		t.Errorf("Expected a 60s batch timeout and no run deadline, got %d %d",
+			config.BatchTimeoutSeconds, config.RunTimeoutSeconds)
+	}
 
 	// Test environment variables
 	os.Setenv("MONGODB_URI", "mongodb://custom:27017")
 	os.Setenv("MONGODB_DATABASE", "custom_db")
 	os.Setenv("MONGODB_TIMEOUT", "30")
 	os.Setenv("MONGODB_BATCH_SIZE", "500")
+	os.Setenv("IMPORT_WORKERS", "3")
+	os.Setenv("IMPORT_INSERT_WORKERS", "4")
+	os.Setenv("MONGODB_MAX_BATCH_BYTES", "1048576")
+	os.Setenv("MONGODB_BATCH_AUTO_TUNE", "true")
+	os.Setenv("MONGODB_BATCH_MAX_SIZE", "5000")
+	os.Setenv("MONGODB_MAX_RETRIES", "0")
+	os.Setenv("MONGODB_BATCH_TIMEOUT", "0")
+	os.Setenv("IMPORT_RUN_TIMEOUT", "3600")
 
 	config = NewConfig()
 	if config.MongoURI != "mongodb://custom:27017" {
@@ -47,6 +86,25 @@ func TestNewConfig(t *testing.T) {
 	if config.BatchSize != 500 {
 		t.Errorf("Expected BatchSize to be 500, got %d", config.BatchSize)
 	}
+	if config.Workers != 3 {
+		t.Errorf("Expected Workers to be 3, got %d", config.Workers)
+	}
+	if config.InsertWorkers != 4 {
+		t.Errorf("Expected InsertWorkers to be 4, got %d", config.InsertWorkers)
+	}
+	if config.MaxBatchBytes != 1048576 {
+		t.Errorf("Expected MaxBatchBytes to be 1048576, got %d", config.MaxBatchBytes)
+	}
+	if !config.BatchAutoTune || config.BatchMaxSize != 5000 {
+		t.Errorf("Expected batch auto-tuning up to 5000, got %v %d", config.BatchAutoTune, config.BatchMaxSize)
+	}
+	if config.MaxRetries != 0 {
+		t.Errorf("Expected MaxRetries to be 0, got %d", config.MaxRetries)
+	}
+	if config.BatchTimeoutSeconds != 0 || config.RunTimeoutSeconds != 3600 {
+		t.Errorf("Expected no batch timeout and a 3600s run deadline, got %d %d",
+			config.BatchTimeoutSeconds, config.RunTimeoutSeconds)
+	}
 
 	// Test invalid timeout value
 	os.Setenv("MONGODB_TIMEOUT", "invalid")
@@ -55,6 +113,14 @@ func TestNewConfig(t *testing.T) {
 		t.Errorf("Expected TimeoutSeconds to be default 10 when invalid value, got %d", config.TimeoutSeconds)
 	}
 
+	// MONGODB_CONNECT_TIMEOUT takes precedence over MONGODB_TIMEOUT
+	os.Setenv("MONGODB_CONNECT_TIMEOUT", "5")
+	config = NewConfig()
+	if config.TimeoutSeconds != 5 {
+		t.Errorf("Expected TimeoutSeconds to be 5 from MONGODB_CONNECT_TIMEOUT, got %d", config.TimeoutSeconds)
+	}
+	os.Unsetenv("MONGODB_CONNECT_TIMEOUT")
+
 	// Test invalid batch size value
 	os.Setenv("MONGODB_TIMEOUT", "30") // Reset timeout to valid value
 	os.Setenv("MONGODB_BATCH_SIZE", "invalid")
@@ -75,6 +141,14 @@ func TestNewConfig(t *testing.T) {
 	os.Unsetenv("MONGODB_DATABASE")
 	os.Unsetenv("MONGODB_TIMEOUT")
 	os.Unsetenv("MONGODB_BATCH_SIZE")
+	os.Unsetenv("IMPORT_WORKERS")
+	os.Unsetenv("IMPORT_INSERT_WORKERS")
+	os.Unsetenv("MONGODB_MAX_BATCH_BYTES")
+	os.Unsetenv("MONGODB_BATCH_AUTO_TUNE")
+	os.Unsetenv("MONGODB_BATCH_MAX_SIZE")
+	os.Unsetenv("MONGODB_MAX_RETRIES")
+	os.Unsetenv("MONGODB_BATCH_TIMEOUT")
+	os.Unsetenv("IMPORT_RUN_TIMEOUT")
 }
 
 func TestGetEnv(t *testing.T) {
@@ -96,6 +170,117 @@ func TestGetEnv(t *testing.T) {
 	os.Unsetenv("TEST_ENV")
 }
 
+func TestGetEnvRune(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected rune
+	}{
+		{value: "", expected: ','},
+		{value: ";", expected: ';'},
+		{value: `\t`, expected: '\t'},
+		{value: "tab", expected: '\t'},
+		{value: "ab", expected: ','},
+	}
+
+	for _, tt := range tests {
+		os.Setenv("TEST_ENV_RUNE", tt.value)
+		if r := getEnvRune("TEST_ENV_RUNE", ','); r != tt.expected {
+			t.Errorf("Expected %q for value %q, got %q", tt.expected, tt.value, r)
+		}
+	}
+
+	// Clean up
+	os.Unsetenv("TEST_ENV_RUNE")
+}
+
+func TestGetEnvBool(t *testing.T) {
+	os.Unsetenv("TEST_ENV_BOOL")
+	if !getEnvBool("TEST_ENV_BOOL", true) {
+		t.Error("Expected default value true when not set")
+	}
+
+	os.Setenv("TEST_ENV_BOOL", "true")
+	if !getEnvBool("TEST_ENV_BOOL", false) {
+		t.Error("Expected true when set to 'true'")
+	}
+
+	os.Setenv("TEST_ENV_BOOL", "invalid")
+	if getEnvBool("TEST_ENV_BOOL", false) {
+		t.Error("Expected default value false when invalid")
+	}
+
+	// Clean up
+	os.Unsetenv("TEST_ENV_BOOL")
+}
+
+func TestGetEnvInt(t *testing.T) {
+	os.Unsetenv("TEST_ENV_INT")
+	if v := getEnvInt("TEST_ENV_INT", 5); v != 5 {
+		t.Errorf("Expected default value 5 when not set, got %d", v)
+	}
+
+	os.Setenv("TEST_ENV_INT", "20")
+	if v := getEnvInt("TEST_ENV_INT", 5); v != 20 {
+		t.Errorf("Expected 20, got %d", v)
+	}
+
+	os.Setenv("TEST_ENV_INT", "-1")
+	if v := getEnvInt("TEST_ENV_INT", 5); v != 5 {
+		t.Errorf("Expected default value 5 when negative, got %d", v)
+	}
+
+	// Clean up
+	os.Unsetenv("TEST_ENV_INT")
+}
+
+func TestParseRatio(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected float64
+		wantErr  bool
+	}{
+		{value: "0.05", expected: 0.05},
+		{value: "5%", expected: 0.05},
+		{value: " 100% ", expected: 1},
+		{value: "0", expected: 0},
+		{value: "1.5", wantErr: true},
+		{value: "-1%", wantErr: true},
+		{value: "abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		ratio, err := ParseRatio(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRatio(%q): expected an error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRatio(%q): unexpected error: %v", tt.value, err)
+		}
+		if ratio != tt.expected {
+			t.Errorf("ParseRatio(%q) = %v, expected %v", tt.value, ratio, tt.expected)
+		}
+	}
+}
+
+func TestGetEnvList(t *testing.T) {
+	os.Unsetenv("TEST_ENV_LIST")
+	if list := getEnvList("TEST_ENV_LIST"); list != nil {
+		t.Errorf("Expected nil when not set, got %v", list)
+	}
+
+	os.Setenv("TEST_ENV_LIST", " tenant, code ,,")
+	list := getEnvList("TEST_ENV_LIST")
+	if len(list) != 2 || list[0] != "tenant" || list[1] != "code" {
+		t.Errorf("Expected [tenant code], got %v", list)
+	}
+
+	// Clean up
+	os.Unsetenv("TEST_ENV_LIST")
+}
+
 func TestLoadEnv(t *testing.T) {
 	// Create a temporary directory for test .env file
 	tempDir, err := os.MkdirTemp("", "config-test")
@@ -1,19 +1,310 @@
 package domain
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
 
 // Document MongoDBに保存するドキュメントを表す型
-type Document map[string]any
+// 入力ファイルのフィールド順を保持するため bson.D をベースにしている
+type Document bson.D
+
+// Get 指定したキーの値を返す
+func (d Document) Get(key string) (any, bool) {
+	for _, elem := range d {
+		if elem.Key == key {
+			return elem.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Lookup ドット区切りのパス（例: "address.city"）で入れ子のドキュメントの値を返す
+func (d Document) Lookup(path string) (any, bool) {
+	key, rest, nested := strings.Cut(path, ".")
+	value, ok := d.Get(key)
+	if !ok || !nested {
+		return value, ok
+	}
+
+	child, ok := value.(bson.D)
+	if !ok {
+		return nil, false
+	}
+	return Document(child).Lookup(rest)
+}
+
+// Set 指定したキーに値を設定する（キーが存在しない場合は末尾に追加する）
+func (d *Document) Set(key string, value any) {
+	for i := range *d {
+		if (*d)[i].Key == key {
+			(*d)[i].Value = value
+			return
+		}
+	}
+	*d = append(*d, bson.E{Key: key, Value: value})
+}
+
+// Delete 指定したキーを削除し、削除したかどうかを返す（他のフィールドの順序は保持する）
+func (d *Document) Delete(key string) bool {
+	for i := range *d {
+		if (*d)[i].Key == key {
+			*d = append((*d)[:i], (*d)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ImportMode ドキュメントの書き込みモードを表す型
+type ImportMode string
+
+const (
+	ImportModeInsert  ImportMode = "insert"  // 常に新規挿入する（既定）
+	ImportModeUpsert  ImportMode = "upsert"  // キーが一致するドキュメントを置き換え、なければ挿入する
+	ImportModeReplace ImportMode = "replace" // キーが一致するドキュメントのみ置き換える
+	ImportModeMerge   ImportMode = "merge"   // 入力にあるフィールドのみ $set で更新し、なければ挿入する
+	ImportModeReload  ImportMode = "reload"  // コレクションを空にしてインデックスを再作成してから挿入する
+	ImportModeSync    ImportMode = "sync"    // キーで upsert し、ファイルにないドキュメントを削除する
+	ImportModeSwap    ImportMode = "swap"    // staging コレクションに挿入し、検証後に名前を変更して置き換える
+)
+
+// ParseImportMode 文字列を ImportMode に変換する（空文字列は insert として扱う）
+func ParseImportMode(s string) (ImportMode, error) {
+	switch mode := ImportMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return ImportModeInsert, nil
+	case ImportModeInsert, ImportModeUpsert, ImportModeReplace, ImportModeMerge, ImportModeReload, ImportModeSync, ImportModeSwap:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown import mode %q", s)
+}
+
+// MatchesByKey キーによる照合が必要なモードかどうかを返す
+func (m ImportMode) MatchesByKey() bool {
+	return m == ImportModeUpsert || m == ImportModeReplace || m == ImportModeMerge || m == ImportModeSync
+}
+
+// TransactionScope トランザクションで囲む範囲を表す型
+type TransactionScope string
+
+const (
+	TransactionNone TransactionScope = ""     // トランザクションを使わない（既定）
+	TransactionFile TransactionScope = "file" // ファイルごとにすべて書き込むか、まったく書き込まない
+	TransactionRun  TransactionScope = "run"  // ディレクトリ内のすべてのファイルをまとめて書き込むか、まったく書き込まない
+)
+
+// ParseTransactionScope 文字列を TransactionScope に変換する（空文字列と "none" はトランザクションなし）
+func ParseTransactionScope(s string) (TransactionScope, error) {
+	switch scope := TransactionScope(strings.ToLower(strings.TrimSpace(s))); scope {
+	case TransactionNone, "none":
+		return TransactionNone, nil
+	case TransactionFile, TransactionRun:
+		return scope, nil
+	}
+	return "", fmt.Errorf("unknown transaction scope %q", s)
+}
+
+// ErrTransactionLimit トランザクションのサイズまたは時間の上限を超えたことを表すエラー
+var ErrTransactionLimit = errors.New("transaction size or time limit exceeded")
+
+// WriteOptions リポジトリへの書き込み方法を表す構造体
+type WriteOptions struct {
+	Mode      ImportMode // 書き込みモード
+	KeyFields []string   // 照合に使うフィールド（空の場合は _id で照合する）
+	Unordered bool       // 失敗したドキュメントがあっても残りの書き込みを続けるか
+}
+
+// DocumentFailure 書き込みに失敗したドキュメントの情報を表す構造体
+type DocumentFailure struct {
+	Index   int    // ファイル内でのドキュメントの位置（0始まり）
+	Code    int    // MongoDBのエラーコード
+	Message string // エラーメッセージ
+}
+
+// RejectStage ドキュメントを取り込めなかった処理段階を表す型
+type RejectStage string
+
+const (
+	RejectStageParse   RejectStage = "parse"   // ファイルの解析
+	RejectStageConvert RejectStage = "convert" // 日付や _id の変換
+	RejectStageWrite   RejectStage = "write"   // データベースへの書き込み
+)
+
+// RejectedDocument 取り込めなかったドキュメントの記録を表す構造体
+type RejectedDocument struct {
+	Document       any         // 元のドキュメント（解析できなかった場合は元のテキスト）
+	SourceFile     string      // 読み込んだファイルのパス
+	Format         string      // 読み込んだファイルの形式（json、csv、tsv。元のテキストを解析し直すのに使う）
+	CollectionName string      // 書き込み先のコレクション名
+	Position       int         // ファイル内でのドキュメントの位置（0始まり）
+	Line           int         // ファイル内の行番号（分かる場合のみ、1始まり）
+	Stage          RejectStage // 拒否した処理段階
+	Error          string      // エラーメッセージ
+	Code           int         // MongoDBのエラーコード（write 段階のみ）
+}
 
 // ImportResult インポート処理の結果を表す構造体
 type ImportResult struct {
-	FileName       string        // 処理されたファイル名（サービス層で使用）
-	CollectionName string        // ドキュメントが挿入されたコレクション名
-	InsertedCount  int           // 挿入されたドキュメントの数
-	Duration       time.Duration // インポート処理にかかった時間（サービス層で使用）
+	FileName       string            // 処理されたファイル名（サービス層で使用）
+	CollectionName string            // ドキュメントが挿入されたコレクション名
+	InsertedCount  int               // 挿入されたドキュメントの数
+	MatchedCount   int               // キーが一致したドキュメントの数（upsert/replace/merge）
+	ModifiedCount  int               // 実際に更新されたドキュメントの数（upsert/replace/merge）
+	UpsertedCount  int               // 一致するドキュメントがなく新規作成された数（upsert/merge）
+	PreviousCount  int               // 削除前にコレクションに存在したドキュメントの数（reload）
+	Failed         []DocumentFailure // 書き込みに失敗したドキュメント（unordered モード）
+	RejectedCount  int               // デッドレターに送られたドキュメントの数
+	ProcessedCount int               // 読み込んだドキュメントの数（失敗したものを含む）
+	ErrorCount     int               // 解析・変換・書き込みに失敗したドキュメントの数
+	RolledBack     bool              // トランザクションの中止により書き込みが取り消されたか
+	Staged         bool              // ステージングコレクション経由で書き込んだか（トランザクションの上限超過または swap モード）
+	ResumedCount   int               // 中断した実行で書き込み済みのため読み飛ばしたドキュメントの数
+	Unchanged      bool              // 前回取り込んだときから内容が変わっていないため読み飛ばしたか
+	BatchSize      int               // 自動調整した最後のバッチサイズ（自動調整が無効な場合は 0）
+	Duration       time.Duration     // インポート処理にかかった時間（サービス層で使用）
+	Error          error             // エラーが発生した場合のエラー情報
+}
+
+// SyncResult ファイルとコレクションを同期した結果を表す構造体（コレクション単位）
+type SyncResult struct {
+	FileName       string        // 同期元のファイル名（削除されたコレクションでは空）
+	CollectionName string        // 同期先のコレクション名
+	InsertedCount  int           // 新規に追加されたドキュメントの数
+	UpdatedCount   int           // 内容が変わり更新されたドキュメントの数
+	DeletedCount   int           // ファイルになく削除されたドキュメントの数
+	UnchangedCount int           // 内容が同じで書き込まなかったドキュメントの数
+	Dropped        bool          // 対応するファイルがなくコレクションごと削除されたか
+	Preview        bool          // 変更を計算しただけで書き込んでいないか
+	Duration       time.Duration // 同期処理にかかった時間
 	Error          error         // エラーが発生した場合のエラー情報
 }
 
+// AddCounts 別の結果の件数をこの結果に加算する
+// 自動調整したバッチサイズは後の結果のものを引き継ぐ
+func (r *ImportResult) AddCounts(other *ImportResult) {
+	if other == nil {
+		return
+	}
+	r.InsertedCount += other.InsertedCount
+	r.MatchedCount += other.MatchedCount
+	r.ModifiedCount += other.ModifiedCount
+	r.UpsertedCount += other.UpsertedCount
+	if other.BatchSize > 0 {
+		r.BatchSize = other.BatchSize
+	}
+}
+
+// Discard 書き込みが取り消された結果として件数をリセットする
+func (r *ImportResult) Discard() {
+	r.InsertedCount = 0
+	r.MatchedCount = 0
+	r.ModifiedCount = 0
+	r.UpsertedCount = 0
+	r.RolledBack = true
+}
+
+// インポート実行の記録に使うコレクション
+// 同期モードで対応するファイルがなくても削除されない
+const (
+	ImportRunsCollection        = "_import_runs"         // 実行ごとの記録
+	ImportRunVersionsCollection = "_import_run_versions" // 実行が上書き・削除したドキュメントの変更前の内容
+	ImportCheckpointsCollection = "_import_checkpoints"  // 中断したインポートを再開するためのチェックポイント
+	ImportLedgerCollection      = "_import_ledger"       // 取り込みに成功したファイルの台帳
+)
+
+// IsImporterCollection インポーター自身が記録に使うコレクションかどうかを返す
+func IsImporterCollection(name string) bool {
+	switch name {
+	case ImportRunsCollection, ImportRunVersionsCollection, ImportCheckpointsCollection, ImportLedgerCollection:
+		return true
+	}
+	return false
+}
+
+// Checkpoint ファイルのインポートがどこまで書き込まれたかの記録（バッチごとに更新する）
+type Checkpoint struct {
+	File       string          `bson:"_id"`             // ファイルの絶対パス
+	Collection string          `bson:"collection"`      // 書き込み先のコレクション名
+	Hash       string          `bson:"hash"`            // ファイル内容の SHA-256
+	Documents  int             `bson:"documents"`       // 書き込みが確定したドキュメントの数（ファイル内で次に読む位置）
+	Batches    int             `bson:"batches"`         // 書き込みが確定したバッチの数
+	Ahead      []PositionRange `bson:"ahead,omitempty"` // Documents より後ろで書き込み済みの範囲（並列書き込みで先に終わったバッチ）
+	RunID      string          `bson:"runId"`           // 最後に更新した実行ID
+	UpdatedAt  time.Time       `bson:"updatedAt"`       // 最後に更新した日時
+}
+
+// PositionRange ファイル内のドキュメント位置の範囲 [Start, End)
+type PositionRange struct {
+	Start int `bson:"start"` // 範囲の最初の位置
+	End   int `bson:"end"`   // 範囲の直後の位置
+}
+
+// Contains 位置が範囲に含まれるかを返す
+func (r PositionRange) Contains(position int) bool {
+	return r.Start <= position && position < r.End
+}
+
+// ImportRunStatus インポート実行の状態を表す型
+type ImportRunStatus string
+
+const (
+	ImportRunRunning    ImportRunStatus = "running"     // 実行中（または途中で終了した）
+	ImportRunCompleted  ImportRunStatus = "completed"   // すべてのファイルを取り込んだ
+	ImportRunFailed     ImportRunStatus = "failed"      // 一部またはすべてのファイルが失敗した
+	ImportRunRolledBack ImportRunStatus = "rolled_back" // ロールバック済み
+)
+
+// ImportRun 1回のインポート実行の記録（_import_runs コレクションに保存する）
+type ImportRun struct {
+	ID           string          `bson:"_id"`                    // 実行ID
+	Path         string          `bson:"path"`                   // インポートしたファイルまたはディレクトリ
+	Mode         ImportMode      `bson:"mode"`                   // 書き込みモード
+	RunField     string          `bson:"runField,omitempty"`     // 実行IDを書き込んだフィールド（書き込んでいない場合は空）
+	Status       ImportRunStatus `bson:"status"`                 // 実行の状態
+	StartedAt    time.Time       `bson:"startedAt"`              // 開始日時
+	FinishedAt   *time.Time      `bson:"finishedAt,omitempty"`   // 終了日時
+	RolledBackAt *time.Time      `bson:"rolledBackAt,omitempty"` // ロールバックした日時
+	Files        []ImportRunFile `bson:"files"`                  // ファイルごとの結果
+	Collections  []string        `bson:"collections"`            // 書き込んだコレクション
+	Error        string          `bson:"error,omitempty"`        // 失敗した場合のエラーメッセージ
+}
+
+// ImportRunFile インポート実行で処理したファイル1件の記録
+type ImportRunFile struct {
+	File       string `bson:"file"`
+	Collection string `bson:"collection"`
+	Inserted   int    `bson:"inserted"`
+	Matched    int    `bson:"matched"`
+	Modified   int    `bson:"modified"`
+	Upserted   int    `bson:"upserted"`
+	Deleted    int    `bson:"deleted"`
+	Error      string `bson:"error,omitempty"`
+}
+
+// LedgerEntry 取り込みに成功したファイルの台帳の記録（内容が変わっていないファイルの判定に使う）
+type LedgerEntry struct {
+	File       string    `bson:"_id"`        // ファイルの絶対パス
+	Collection string    `bson:"collection"` // 書き込み先のコレクション名
+	Size       int64     `bson:"size"`       // ファイルサイズ（バイト）
+	ModTime    time.Time `bson:"modTime"`    // ファイルの更新日時
+	Hash       string    `bson:"hash"`       // ファイル内容の SHA-256
+	RunID      string    `bson:"runId"`      // 取り込んだ実行ID
+	ImportedAt time.Time `bson:"importedAt"` // 取り込んだ日時
+}
+
+// RollbackResult インポート実行をロールバックした結果を表す構造体（コレクション単位）
+type RollbackResult struct {
+	CollectionName string // ロールバックしたコレクション名
+	DeletedCount   int    // 実行が書き込んだため削除したドキュメントの数
+	RestoredCount  int    // 変更前の内容に戻したドキュメントの数
+	Error          error  // エラーが発生した場合のエラー情報
+}
+
 // RepositoryError リポジトリ層のエラーを表す構造体
 type RepositoryError struct {
 	Operation string
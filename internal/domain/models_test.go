@@ -6,10 +6,18 @@ package domain
 // 1. RepositoryErrorのError()メソッドが適切なエラーメッセージを生成するか
 // 2. RepositoryErrorのUnwrap()メソッドが元のエラーを正しく返すか
 // 3. ImportResult構造体のフィールドが適切に設定され、アクセス可能か
+// 4. DocumentのGet/Set/Deleteがフィールド順を保持したまま動作するか
+// 5. 書き込みモードの解析と結果件数の加算が正しく行われるか
+// 6. トランザクション範囲の解析と、取り消された結果の件数リセットが正しく行われるか
+// 7. インポーター自身が記録に使うコレクションを判定できるか
+// 8. ドキュメント位置の範囲に位置が含まれるかを判定できるか
 
 import (
 	"errors"
+	"reflect"
 	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 func TestRepositoryError(t *testing.T) {
@@ -65,3 +73,160 @@ func TestImportResult(t *testing.T) {
 		t.Errorf("Expected Error to be 'test error'")
 	}
 }
+
+func TestDocument(t *testing.T) {
+	doc := Document{{Key: "z", Value: 1}, {Key: "_id", Value: "x"}, {Key: "a", Value: 2}}
+
+	// Get
+	if v, ok := doc.Get("a"); !ok || v != 2 {
+		t.Errorf("Expected Get(\"a\") to return 2, got %v (found=%v)", v, ok)
+	}
+	if _, ok := doc.Get("missing"); ok {
+		t.Errorf("Expected Get(\"missing\") to report not found")
+	}
+
+	// Delete はほかのフィールドの順序を保持する
+	if !doc.Delete("_id") {
+		t.Errorf("Expected Delete(\"_id\") to return true")
+	}
+	if doc.Delete("_id") {
+		t.Errorf("Expected second Delete(\"_id\") to return false")
+	}
+
+	// Set は既存のキーをその場で更新し、新しいキーは末尾に追加する
+	doc.Set("z", 10)
+	doc.Set("m", 3)
+
+	expected := Document{{Key: "z", Value: 10}, {Key: "a", Value: 2}, {Key: "m", Value: 3}}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf("Expected %v, got %v", expected, doc)
+	}
+
+	// Lookup はドット区切りのパスで入れ子の値を返す
+	nested := Document{{Key: "address", Value: bson.D{{Key: "city", Value: "Tokyo"}}}, {Key: "name", Value: "x"}}
+	if v, ok := nested.Lookup("address.city"); !ok || v != "Tokyo" {
+		t.Errorf("Expected Lookup(\"address.city\") to return Tokyo, got %v (found=%v)", v, ok)
+	}
+	if _, ok := nested.Lookup("name.first"); ok {
+		t.Errorf("Expected Lookup(\"name.first\") to report not found")
+	}
+
+	// bson.D との相互変換
+	if _, ok := any(bson.D(doc)).(bson.D); !ok {
+		t.Errorf("Expected Document to convert to bson.D")
+	}
+}
+
+func TestParseImportMode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ImportMode
+		wantErr  bool
+	}{
+		{input: "", expected: ImportModeInsert},
+		{input: "insert", expected: ImportModeInsert},
+		{input: "Upsert", expected: ImportModeUpsert},
+		{input: " replace ", expected: ImportModeReplace},
+		{input: "merge", expected: ImportModeMerge},
+		{input: "reload", expected: ImportModeReload},
+		{input: "sync", expected: ImportModeSync},
+		{input: "swap", expected: ImportModeSwap},
+		{input: "delete", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		mode, err := ParseImportMode(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseImportMode(%q): expected an error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseImportMode(%q): unexpected error: %v", tt.input, err)
+		}
+		if mode != tt.expected {
+			t.Errorf("ParseImportMode(%q) = %q, expected %q", tt.input, mode, tt.expected)
+		}
+	}
+
+	// キーによる照合が必要なのは insert 以外のモード
+	if ImportModeInsert.MatchesByKey() {
+		t.Errorf("Expected insert mode not to match by key")
+	}
+	if !ImportModeMerge.MatchesByKey() {
+		t.Errorf("Expected merge mode to match by key")
+	}
+}
+
+func TestImportResultAddCounts(t *testing.T) {
+	result := &ImportResult{InsertedCount: 1, MatchedCount: 2}
+	result.AddCounts(&ImportResult{InsertedCount: 3, MatchedCount: 4, ModifiedCount: 1, UpsertedCount: 5, BatchSize: 800})
+	result.AddCounts(&ImportResult{InsertedCount: 1})
+	result.AddCounts(nil)
+
+	expected := &ImportResult{InsertedCount: 5, MatchedCount: 6, ModifiedCount: 1, UpsertedCount: 5, BatchSize: 800}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestParseTransactionScope(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected TransactionScope
+		wantErr  bool
+	}{
+		{input: "", expected: TransactionNone},
+		{input: "none", expected: TransactionNone},
+		{input: "File", expected: TransactionFile},
+		{input: " run ", expected: TransactionRun},
+		{input: "batch", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		scope, err := ParseTransactionScope(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseTransactionScope(%q): expected an error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTransactionScope(%q): unexpected error: %v", tt.input, err)
+		}
+		if scope != tt.expected {
+			t.Errorf("ParseTransactionScope(%q) = %q, expected %q", tt.input, scope, tt.expected)
+		}
+	}
+}
+
+func TestImportResultDiscard(t *testing.T) {
+	result := &ImportResult{InsertedCount: 3, MatchedCount: 2, ModifiedCount: 1, UpsertedCount: 1, ProcessedCount: 7}
+	result.Discard()
+
+	expected := &ImportResult{ProcessedCount: 7, RolledBack: true}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestIsImporterCollection(t *testing.T) {
+	for _, name := range []string{ImportRunsCollection, ImportRunVersionsCollection, ImportCheckpointsCollection, ImportLedgerCollection} {
+		if !IsImporterCollection(name) {
+			t.Errorf("%s はインポーターのコレクションと判定されるべきです", name)
+		}
+	}
+	if IsImporterCollection("users") {
+		t.Error("users はインポーターのコレクションと判定されるべきではありません")
+	}
+}
+
+func TestPositionRangeContains(t *testing.T) {
+	r := PositionRange{Start: 10, End: 20}
+	for position, expected := range map[int]bool{9: false, 10: true, 19: true, 20: false} {
+		if r.Contains(position) != expected {
+			t.Errorf("位置 %d の判定が %v になるべきです", position, expected)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// バッチ分割の既定値と上限
+const (
+	DefaultBatchSize     = 1000             // 1バッチあたりのドキュメント数の既定値
+	DefaultMaxBatchBytes = 16 * 1024 * 1024 // 1バッチあたりのエンコード後のバイト数の既定値
+	// MaxBatchBytesLimit MongoDB のメッセージ上限（48MB）からコマンド自体の分を差し引いた上限
+	MaxBatchBytesLimit = 46 * 1000 * 1000
+)
+
+// batchRange 1バッチに含めるドキュメントの範囲 [start, end)
+type batchRange struct {
+	start int
+	end   int
+}
+
+// batchLimits 1バッチあたりのドキュメント数とバイト数の上限を返す（未設定の場合は既定値）
+func (r *MongoRepository) batchLimits() (int, int) {
+	batchSize := r.batchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	maxBytes := r.maxBatchBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBatchBytes
+	}
+	return batchSize, min(maxBytes, MaxBatchBytesLimit)
+}
+
+// batchContext バッチの書き込み1回に使うコンテキストを返す
+// 制限時間を過ぎた書き込みは打ち切られ、実行全体の期限が残っていれば再試行される
+func (r *MongoRepository) batchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.batchTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.batchTimeout)
+}
+
+// splitBatches ドキュメントの件数とバイト数が上限を超えないようにバッチに分割する
+// 小さなドキュメントは件数の上限まで詰め、上限より大きなドキュメントは単独のバッチにする
+func splitBatches(sizes []int, batchSize, maxBytes int) []batchRange {
+	var batches []batchRange
+	for start := 0; start < len(sizes); {
+		batch := nextBatch(sizes, start, batchSize, maxBytes)
+		batches = append(batches, batch)
+		start = batch.end
+	}
+	return batches
+}
+
+// nextBatch start から始まり、件数とバイト数が上限を超えないバッチの範囲を返す
+// バッチには少なくとも 1件のドキュメントが含まれる
+func nextBatch(sizes []int, start, batchSize, maxBytes int) batchRange {
+	end, bytes := start, 0
+	for end < len(sizes) && end-start < batchSize {
+		if end > start && bytes+sizes[end] > maxBytes {
+			break
+		}
+		bytes += sizes[end]
+		end++
+	}
+	return batchRange{start: start, end: max(end, start+1)}
+}
+
+// encodeDocuments ドキュメントを BSON にエンコードする（フィールド順は保持される）
+func encodeDocuments(collectionName string, documents []domain.Document) ([]bson.Raw, error) {
+	raws := make([]bson.Raw, 0, len(documents))
+	for i, doc := range documents {
+		raw, err := bson.Marshal(bson.D(doc))
+		if err != nil {
+			return nil, &domain.RepositoryError{
+				Operation: fmt.Sprintf("コレクション %s のドキュメント %d のエンコード", collectionName, i),
+				Err:       err,
+			}
+		}
+		raws = append(raws, raw)
+	}
+	return raws, nil
+}
+
+// rawSizes エンコード済みのドキュメントのバイト数を返す
+func rawSizes(raws []bson.Raw) []int {
+	sizes := make([]int, 0, len(raws))
+	for _, raw := range raws {
+		sizes = append(sizes, len(raw))
+	}
+	return sizes
+}
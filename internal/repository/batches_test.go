@@ -0,0 +1,74 @@
+package repository
+
+// TestSplitBatches パッケージはバッチ分割をテストします。
+//
+// テスト観点:
+// 1. 件数の上限でバッチが分割されるか
+// 2. バイト数の上限でバッチが分割され、小さなドキュメントは詰めて送られるか
+// 3. 上限より大きなドキュメントが単独のバッチになるか
+// 4. 未設定の上限に既定値が使われ、バイト数がメッセージ上限に収まるか
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitBatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		sizes     []int
+		batchSize int
+		maxBytes  int
+		expected  []batchRange
+	}{
+		{
+			name:      "件数の上限",
+			sizes:     []int{10, 10, 10, 10, 10},
+			batchSize: 2,
+			maxBytes:  1000,
+			expected:  []batchRange{{0, 2}, {2, 4}, {4, 5}},
+		},
+		{
+			name:      "バイト数の上限",
+			sizes:     []int{40, 40, 40, 10, 10, 10},
+			batchSize: 100,
+			maxBytes:  100,
+			expected:  []batchRange{{0, 2}, {2, 6}},
+		},
+		{
+			name:      "上限より大きなドキュメント",
+			sizes:     []int{10, 500, 10},
+			batchSize: 100,
+			maxBytes:  100,
+			expected:  []batchRange{{0, 1}, {1, 2}, {2, 3}},
+		},
+		{
+			name:      "ドキュメントなし",
+			sizes:     nil,
+			batchSize: 100,
+			maxBytes:  100,
+			expected:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches := splitBatches(tt.sizes, tt.batchSize, tt.maxBytes)
+			if !reflect.DeepEqual(batches, tt.expected) {
+				t.Errorf("バッチが一致しません: expected=%v, got=%v", tt.expected, batches)
+			}
+		})
+	}
+}
+
+func TestBatchLimits(t *testing.T) {
+	batchSize, maxBytes := (&MongoRepository{}).batchLimits()
+	if batchSize != DefaultBatchSize || maxBytes != DefaultMaxBatchBytes {
+		t.Errorf("既定値が使われるべきです: batchSize=%d, maxBytes=%d", batchSize, maxBytes)
+	}
+
+	batchSize, maxBytes = (&MongoRepository{batchSize: 50, maxBatchBytes: 64 * 1000 * 1000}).batchLimits()
+	if batchSize != 50 || maxBytes != MaxBatchBytesLimit {
+		t.Errorf("設定値が使われ、バイト数は上限に収まるべきです: batchSize=%d, maxBytes=%d", batchSize, maxBytes)
+	}
+}
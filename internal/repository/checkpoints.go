@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// SaveCheckpoint ファイルのチェックポイントを保存する（同じファイルのチェックポイントは置き換える）
+func (r *MongoRepository) SaveCheckpoint(ctx context.Context, checkpoint *domain.Checkpoint) error {
+	_, err := r.db.Collection(domain.ImportCheckpointsCollection).ReplaceOne(ctx,
+		bson.D{{Key: "_id", Value: checkpoint.File}}, checkpoint, options.Replace().SetUpsert(true))
+	if err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("ファイル %s のチェックポイントの保存", checkpoint.File),
+			Err:       err,
+		}
+	}
+	return nil
+}
+
+// FindCheckpoint ファイルのチェックポイントを取得する（チェックポイントがない場合は nil を返す）
+func (r *MongoRepository) FindCheckpoint(ctx context.Context, file string) (*domain.Checkpoint, error) {
+	var checkpoint domain.Checkpoint
+	err := r.db.Collection(domain.ImportCheckpointsCollection).FindOne(ctx, bson.D{{Key: "_id", Value: file}}).Decode(&checkpoint)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &domain.RepositoryError{
+			Operation: fmt.Sprintf("ファイル %s のチェックポイントの取得", file),
+			Err:       err,
+		}
+	}
+	return &checkpoint, nil
+}
+
+// DeleteCheckpoint ファイルのチェックポイントを削除する
+func (r *MongoRepository) DeleteCheckpoint(ctx context.Context, file string) error {
+	if _, err := r.db.Collection(domain.ImportCheckpointsCollection).DeleteOne(ctx, bson.D{{Key: "_id", Value: file}}); err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("ファイル %s のチェックポイントの削除", file),
+			Err:       err,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// ListCollections データベース内のコレクション名を名前順で取得する（ビューは除く）
+func (r *MongoRepository) ListCollections(ctx context.Context) ([]string, error) {
+	names, err := r.db.ListCollectionNames(ctx, bson.D{{Key: "type", Value: "collection"}})
+	if err != nil {
+		return nil, &domain.RepositoryError{
+			Operation: "コレクション一覧の取得",
+			Err:       err,
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DropCollection 指定したコレクションを削除する
+func (r *MongoRepository) DropCollection(ctx context.Context, collectionName string) error {
+	if err := r.db.Collection(collectionName).Drop(ctx); err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の削除", collectionName),
+			Err:       err,
+		}
+	}
+	return nil
+}
+
+// MergeCollection source コレクションのすべてのドキュメントを、モードに応じて target に書き込む
+// 書き込みはサーバー側の $merge で行い、書き込んだドキュメントの数を返す
+// 1つのコマンドで実行されるが、途中で失敗した場合に書き込み済みの分は取り消されない
+func (r *MongoRepository) MergeCollection(ctx context.Context, source, target string, opts domain.WriteOptions) (int, error) {
+	pipeline, err := mergePipeline(target, opts)
+	if err != nil {
+		return 0, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s から %s へのマージ", source, target),
+			Err:       err,
+		}
+	}
+
+	collection := r.db.Collection(source)
+	count, err := collection.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return 0, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s のドキュメント数取得", source),
+			Err:       err,
+		}
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s から %s へのマージ", source, target),
+			Err:       err,
+		}
+	}
+	if err := cursor.Close(ctx); err != nil {
+		return 0, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s から %s へのマージ", source, target),
+			Err:       err,
+		}
+	}
+
+	fmt.Printf("コレクション %s の %d件を %s に書き込みました\n", source, count, target)
+	return int(count), nil
+}
+
+// ResetCollection コレクションを削除し、元のオプションとインデックスで作り直す
+// 削除前に存在したドキュメントの数を返す（コレクションが存在しない場合は 0）
+func (r *MongoRepository) ResetCollection(ctx context.Context, collectionName string) (int, error) {
+	// コレクションのオプション（バリデーション等）を取得
+	specs, err := r.db.ListCollectionSpecifications(ctx, bson.D{{Key: "name", Value: collectionName}})
+	if err != nil {
+		return 0, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の情報取得", collectionName),
+			Err:       err,
+		}
+	}
+	if len(specs) == 0 {
+		// コレクションが存在しない場合は何もしない
+		return 0, nil
+	}
+
+	collection := r.db.Collection(collectionName)
+
+	previousCount, err := collection.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return 0, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s のドキュメント数取得", collectionName),
+			Err:       err,
+		}
+	}
+
+	indexes, err := listIndexSpecs(ctx, collection)
+	if err != nil {
+		return 0, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s のインデックス取得", collectionName),
+			Err:       err,
+		}
+	}
+
+	if err := collection.Drop(ctx); err != nil {
+		return 0, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の削除", collectionName),
+			Err:       err,
+		}
+	}
+
+	if err := r.createCollection(ctx, collectionName, specs[0].Options, indexes); err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("コレクション %s を再作成しました（削除前 %d件、インデックス %d件）\n",
+		collectionName, previousCount, len(indexes))
+	return int(previousCount), nil
+}
+
+// CreateStagingCollection source と同じオプションとインデックスで staging コレクションを作成する
+// source が存在しない場合はオプションとインデックスなしで作成する
+// 以前の実行で残った同名の staging コレクションは削除してから作成する
+func (r *MongoRepository) CreateStagingCollection(ctx context.Context, source, staging string) error {
+	if err := r.db.Collection(staging).Drop(ctx); err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の削除", staging),
+			Err:       err,
+		}
+	}
+
+	specs, err := r.db.ListCollectionSpecifications(ctx, bson.D{{Key: "name", Value: source}})
+	if err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の情報取得", source),
+			Err:       err,
+		}
+	}
+
+	var collectionOptions bson.Raw
+	var indexes []bson.D
+	if len(specs) > 0 {
+		collectionOptions = specs[0].Options
+		indexes, err = listIndexSpecs(ctx, r.db.Collection(source))
+		if err != nil {
+			return &domain.RepositoryError{
+				Operation: fmt.Sprintf("コレクション %s のインデックス取得", source),
+				Err:       err,
+			}
+		}
+	}
+
+	if err := r.createCollection(ctx, staging, collectionOptions, indexes); err != nil {
+		return err
+	}
+	fmt.Printf("コレクション %s を作成しました（%s のインデックス %d件）\n", staging, source, len(indexes))
+	return nil
+}
+
+// CountDocuments コレクションのドキュメント数を返す（コレクションが存在しない場合は 0）
+func (r *MongoRepository) CountDocuments(ctx context.Context, collectionName string) (int, error) {
+	count, err := r.db.Collection(collectionName).CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return 0, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s のドキュメント数取得", collectionName),
+			Err:       err,
+		}
+	}
+	return int(count), nil
+}
+
+// ValidateCollection validate コマンドでコレクションのデータとインデックスを検証する
+func (r *MongoRepository) ValidateCollection(ctx context.Context, collectionName string) error {
+	var result struct {
+		Valid  bool     `bson:"valid"`
+		Errors []string `bson:"errors"`
+	}
+	command := bson.D{{Key: "validate", Value: collectionName}}
+	if err := r.db.RunCommand(ctx, command).Decode(&result); err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の検証", collectionName),
+			Err:       err,
+		}
+	}
+	if !result.Valid {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の検証", collectionName),
+			Err:       fmt.Errorf("collection is not valid: %s", strings.Join(result.Errors, "; ")),
+		}
+	}
+	return nil
+}
+
+// RenameCollection source コレクションの名前を target に変更する
+// target が存在する場合は置き換える（dropTarget）。名前の変更はアトミックに行われ、
+// 読み取り側からは変更前か変更後のどちらかのコレクションだけが見える
+func (r *MongoRepository) RenameCollection(ctx context.Context, source, target string) error {
+	command := bson.D{
+		{Key: "renameCollection", Value: r.db.Name() + "." + source},
+		{Key: "to", Value: r.db.Name() + "." + target},
+		{Key: "dropTarget", Value: true},
+	}
+	if err := r.client.Database("admin").RunCommand(ctx, command).Err(); err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s から %s への名前変更", source, target),
+			Err:       err,
+		}
+	}
+	return nil
+}
+
+// createCollection 指定したオプションでコレクションを作成し、インデックスを作成する
+func (r *MongoRepository) createCollection(ctx context.Context, collectionName string, collectionOptions bson.Raw, indexes []bson.D) error {
+	create := bson.D{{Key: "create", Value: collectionName}}
+	if len(collectionOptions) > 0 {
+		var opts bson.D
+		if err := bson.Unmarshal(collectionOptions, &opts); err != nil {
+			return &domain.RepositoryError{
+				Operation: fmt.Sprintf("コレクション %s のオプション読み込み", collectionName),
+				Err:       err,
+			}
+		}
+		create = append(create, opts...)
+	}
+	if err := r.db.RunCommand(ctx, create).Err(); err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の作成", collectionName),
+			Err:       err,
+		}
+	}
+
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	// 元の定義をそのまま使うため createIndexes コマンドで再作成する
+	indexList := make(bson.A, 0, len(indexes))
+	for _, index := range indexes {
+		indexList = append(indexList, index)
+	}
+	command := bson.D{{Key: "createIndexes", Value: collectionName}, {Key: "indexes", Value: indexList}}
+	if err := r.db.RunCommand(ctx, command).Err(); err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s のインデックス作成", collectionName),
+			Err:       err,
+		}
+	}
+	return nil
+}
+
+// listIndexSpecs コレクションのインデックス定義を取得する
+// 自動で作成される _id インデックスと、サーバーが付与する v/ns フィールドは除く
+func listIndexSpecs(ctx context.Context, collection *mongo.Collection) ([]bson.D, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []bson.D
+	if err := cursor.All(ctx, &all); err != nil {
+		return nil, err
+	}
+
+	indexes := make([]bson.D, 0, len(all))
+	for _, spec := range all {
+		if name, _ := domain.Document(spec).Get("name"); name == "_id_" {
+			continue
+		}
+		index := make(bson.D, 0, len(spec))
+		for _, elem := range spec {
+			if elem.Key != "v" && elem.Key != "ns" {
+				index = append(index, elem)
+			}
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
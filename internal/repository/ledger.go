@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// FindLedgerEntry ファイルの台帳の記録を取得する（記録がない場合は nil を返す）
+func (r *MongoRepository) FindLedgerEntry(ctx context.Context, file string) (*domain.LedgerEntry, error) {
+	var entry domain.LedgerEntry
+	err := r.db.Collection(domain.ImportLedgerCollection).FindOne(ctx, bson.D{{Key: "_id", Value: file}}).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &domain.RepositoryError{
+			Operation: fmt.Sprintf("ファイル %s の台帳の取得", file),
+			Err:       err,
+		}
+	}
+	return &entry, nil
+}
+
+// SaveLedgerEntry ファイルの台帳の記録を保存する（同じファイルの記録は置き換える）
+func (r *MongoRepository) SaveLedgerEntry(ctx context.Context, entry *domain.LedgerEntry) error {
+	_, err := r.db.Collection(domain.ImportLedgerCollection).ReplaceOne(ctx,
+		bson.D{{Key: "_id", Value: entry.File}}, entry, options.Replace().SetUpsert(true))
+	if err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("ファイル %s の台帳の保存", entry.File),
+			Err:       err,
+		}
+	}
+	return nil
+}
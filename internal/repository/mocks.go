@@ -5,14 +5,39 @@ package repository
 import (
 	"context"
 
+	"go.mongodb.org/mongo-driver/bson"
+
 	"github.com/OTakumi/data-importer/internal/domain"
 )
 
 // MockMongoRepository はMongoRepositoryのモック実装です
 // テスト用途に使用されます
 type MockMongoRepository struct {
-	InsertDocumentsFn func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error)
-	DisconnectFn      func(ctx context.Context) error
+	InsertDocumentsFn  func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error)
+	WriteDocumentsFn   func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error)
+	FindDocumentsFn    func(ctx context.Context, collectionName string) ([]domain.Document, error)
+	ScanDocumentsFn    func(ctx context.Context, collectionName string, fn func(doc bson.Raw) error) error
+	DeleteDocumentsFn  func(ctx context.Context, collectionName string, ids []any) (int, error)
+	ResetCollectionFn  func(ctx context.Context, collectionName string) (int, error)
+	ListCollectionsFn  func(ctx context.Context) ([]string, error)
+	DropCollectionFn   func(ctx context.Context, collectionName string) error
+	MergeCollectionFn  func(ctx context.Context, source, target string, opts domain.WriteOptions) (int, error)
+	WithTransactionFn  func(ctx context.Context, fn func(ctx context.Context) error) error
+	CreateStagingFn    func(ctx context.Context, source, staging string) error
+	CountDocumentsFn   func(ctx context.Context, collectionName string) (int, error)
+	ValidateFn         func(ctx context.Context, collectionName string) error
+	RenameFn           func(ctx context.Context, source, target string) error
+	SaveImportRunFn    func(ctx context.Context, run *domain.ImportRun) error
+	FindImportRunFn    func(ctx context.Context, runID string) (*domain.ImportRun, error)
+	FindMatchingFn     func(ctx context.Context, collectionName string, documents []domain.Document, keyFields []string) ([]domain.Document, error)
+	SaveVersionsFn     func(ctx context.Context, runID, collectionName string, documents []domain.Document) error
+	RollbackFn         func(ctx context.Context, runID, runField, collectionName string) (*domain.RollbackResult, error)
+	SaveCheckpointFn   func(ctx context.Context, checkpoint *domain.Checkpoint) error
+	FindCheckpointFn   func(ctx context.Context, file string) (*domain.Checkpoint, error)
+	DeleteCheckpointFn func(ctx context.Context, file string) error
+	FindLedgerEntryFn  func(ctx context.Context, file string) (*domain.LedgerEntry, error)
+	SaveLedgerEntryFn  func(ctx context.Context, entry *domain.LedgerEntry) error
+	DisconnectFn       func(ctx context.Context) error
 }
 
 // InsertDocuments はInsertDocumentsのモック実装です
@@ -28,6 +53,219 @@ func (m *MockMongoRepository) InsertDocuments(ctx context.Context, collectionNam
 	}, nil
 }
 
+// WriteDocuments はWriteDocumentsのモック実装です
+func (m *MockMongoRepository) WriteDocuments(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+	if m.WriteDocumentsFn != nil {
+		return m.WriteDocumentsFn(ctx, collectionName, documents, opts)
+	}
+	if !opts.Mode.MatchesByKey() {
+		return m.InsertDocuments(ctx, collectionName, documents)
+	}
+	// デフォルトの実装（すべて新規作成として扱う）
+	return &domain.ImportResult{
+		CollectionName: collectionName,
+		UpsertedCount:  len(documents),
+	}, nil
+}
+
+// FindDocuments はFindDocumentsのモック実装です
+func (m *MockMongoRepository) FindDocuments(ctx context.Context, collectionName string) ([]domain.Document, error) {
+	if m.FindDocumentsFn != nil {
+		return m.FindDocumentsFn(ctx, collectionName)
+	}
+	// デフォルトの実装（空のコレクション）
+	return nil, nil
+}
+
+// ScanDocuments はScanDocumentsのモック実装です
+func (m *MockMongoRepository) ScanDocuments(ctx context.Context, collectionName string, fn func(doc bson.Raw) error) error {
+	if m.ScanDocumentsFn != nil {
+		return m.ScanDocumentsFn(ctx, collectionName, fn)
+	}
+	// デフォルトの実装（空のコレクション）
+	return nil
+}
+
+// DeleteDocuments はDeleteDocumentsのモック実装です
+func (m *MockMongoRepository) DeleteDocuments(ctx context.Context, collectionName string, ids []any) (int, error) {
+	if m.DeleteDocumentsFn != nil {
+		return m.DeleteDocumentsFn(ctx, collectionName, ids)
+	}
+	// デフォルトの実装
+	return len(ids), nil
+}
+
+// ListCollections はListCollectionsのモック実装です
+func (m *MockMongoRepository) ListCollections(ctx context.Context) ([]string, error) {
+	if m.ListCollectionsFn != nil {
+		return m.ListCollectionsFn(ctx)
+	}
+	// デフォルトの実装
+	return nil, nil
+}
+
+// DropCollection はDropCollectionのモック実装です
+func (m *MockMongoRepository) DropCollection(ctx context.Context, collectionName string) error {
+	if m.DropCollectionFn != nil {
+		return m.DropCollectionFn(ctx, collectionName)
+	}
+	// デフォルトの実装
+	return nil
+}
+
+// ResetCollection はResetCollectionのモック実装です
+func (m *MockMongoRepository) ResetCollection(ctx context.Context, collectionName string) (int, error) {
+	if m.ResetCollectionFn != nil {
+		return m.ResetCollectionFn(ctx, collectionName)
+	}
+	// デフォルトの実装
+	return 0, nil
+}
+
+// MergeCollection はMergeCollectionのモック実装です
+func (m *MockMongoRepository) MergeCollection(ctx context.Context, source, target string, opts domain.WriteOptions) (int, error) {
+	if m.MergeCollectionFn != nil {
+		return m.MergeCollectionFn(ctx, source, target, opts)
+	}
+	// デフォルトの実装
+	return 0, nil
+}
+
+// WithTransaction はWithTransactionのモック実装です
+func (m *MockMongoRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if m.WithTransactionFn != nil {
+		return m.WithTransactionFn(ctx, fn)
+	}
+	// デフォルトの実装（トランザクションなしで実行する）
+	return fn(ctx)
+}
+
+// CreateStagingCollection はCreateStagingCollectionのモック実装です
+func (m *MockMongoRepository) CreateStagingCollection(ctx context.Context, source, staging string) error {
+	if m.CreateStagingFn != nil {
+		return m.CreateStagingFn(ctx, source, staging)
+	}
+	// デフォルトの実装
+	return nil
+}
+
+// CountDocuments はCountDocumentsのモック実装です
+func (m *MockMongoRepository) CountDocuments(ctx context.Context, collectionName string) (int, error) {
+	if m.CountDocumentsFn != nil {
+		return m.CountDocumentsFn(ctx, collectionName)
+	}
+	// デフォルトの実装
+	return 0, nil
+}
+
+// ValidateCollection はValidateCollectionのモック実装です
+func (m *MockMongoRepository) ValidateCollection(ctx context.Context, collectionName string) error {
+	if m.ValidateFn != nil {
+		return m.ValidateFn(ctx, collectionName)
+	}
+	// デフォルトの実装
+	return nil
+}
+
+// RenameCollection はRenameCollectionのモック実装です
+func (m *MockMongoRepository) RenameCollection(ctx context.Context, source, target string) error {
+	if m.RenameFn != nil {
+		return m.RenameFn(ctx, source, target)
+	}
+	// デフォルトの実装
+	return nil
+}
+
+// SaveImportRun はSaveImportRunのモック実装です
+func (m *MockMongoRepository) SaveImportRun(ctx context.Context, run *domain.ImportRun) error {
+	if m.SaveImportRunFn != nil {
+		return m.SaveImportRunFn(ctx, run)
+	}
+	// デフォルトの実装
+	return nil
+}
+
+// FindImportRun はFindImportRunのモック実装です
+func (m *MockMongoRepository) FindImportRun(ctx context.Context, runID string) (*domain.ImportRun, error) {
+	if m.FindImportRunFn != nil {
+		return m.FindImportRunFn(ctx, runID)
+	}
+	// デフォルトの実装
+	return nil, nil
+}
+
+// FindMatchingDocuments はFindMatchingDocumentsのモック実装です
+func (m *MockMongoRepository) FindMatchingDocuments(ctx context.Context, collectionName string, documents []domain.Document, keyFields []string) ([]domain.Document, error) {
+	if m.FindMatchingFn != nil {
+		return m.FindMatchingFn(ctx, collectionName, documents, keyFields)
+	}
+	// デフォルトの実装
+	return nil, nil
+}
+
+// SaveDocumentVersions はSaveDocumentVersionsのモック実装です
+func (m *MockMongoRepository) SaveDocumentVersions(ctx context.Context, runID, collectionName string, documents []domain.Document) error {
+	if m.SaveVersionsFn != nil {
+		return m.SaveVersionsFn(ctx, runID, collectionName, documents)
+	}
+	// デフォルトの実装
+	return nil
+}
+
+// RollbackCollection はRollbackCollectionのモック実装です
+func (m *MockMongoRepository) RollbackCollection(ctx context.Context, runID, runField, collectionName string) (*domain.RollbackResult, error) {
+	if m.RollbackFn != nil {
+		return m.RollbackFn(ctx, runID, runField, collectionName)
+	}
+	// デフォルトの実装
+	return &domain.RollbackResult{CollectionName: collectionName}, nil
+}
+
+// SaveCheckpoint はSaveCheckpointのモック実装です
+func (m *MockMongoRepository) SaveCheckpoint(ctx context.Context, checkpoint *domain.Checkpoint) error {
+	if m.SaveCheckpointFn != nil {
+		return m.SaveCheckpointFn(ctx, checkpoint)
+	}
+	// デフォルトの実装
+	return nil
+}
+
+// FindCheckpoint はFindCheckpointのモック実装です
+func (m *MockMongoRepository) FindCheckpoint(ctx context.Context, file string) (*domain.Checkpoint, error) {
+	if m.FindCheckpointFn != nil {
+		return m.FindCheckpointFn(ctx, file)
+	}
+	// デフォルトの実装
+	return nil, nil
+}
+
+// DeleteCheckpoint はDeleteCheckpointのモック実装です
+func (m *MockMongoRepository) DeleteCheckpoint(ctx context.Context, file string) error {
+	if m.DeleteCheckpointFn != nil {
+		return m.DeleteCheckpointFn(ctx, file)
+	}
+	// デフォルトの実装
+	return nil
+}
+
+// FindLedgerEntry はFindLedgerEntryのモック実装です
+func (m *MockMongoRepository) FindLedgerEntry(ctx context.Context, file string) (*domain.LedgerEntry, error) {
+	if m.FindLedgerEntryFn != nil {
+		return m.FindLedgerEntryFn(ctx, file)
+	}
+	// デフォルトの実装
+	return nil, nil
+}
+
+// SaveLedgerEntry はSaveLedgerEntryのモック実装です
+func (m *MockMongoRepository) SaveLedgerEntry(ctx context.Context, entry *domain.LedgerEntry) error {
+	if m.SaveLedgerEntryFn != nil {
+		return m.SaveLedgerEntryFn(ctx, entry)
+	}
+	// デフォルトの実装
+	return nil
+}
+
 // Disconnect はDisconnectのモック実装です
 func (m *MockMongoRepository) Disconnect(ctx context.Context) error {
 	if m.DisconnectFn != nil {
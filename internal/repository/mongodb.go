@@ -3,7 +3,11 @@ package repository
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sync"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
@@ -14,19 +18,52 @@ import (
 // Repository データアクセスのインターフェース
 type Repository interface {
 	InsertDocuments(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error)
+	WriteDocuments(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error)
+	FindDocuments(ctx context.Context, collectionName string) ([]domain.Document, error)
+	ScanDocuments(ctx context.Context, collectionName string, fn func(doc bson.Raw) error) error
+	DeleteDocuments(ctx context.Context, collectionName string, ids []any) (int, error)
+	ResetCollection(ctx context.Context, collectionName string) (int, error)
+	ListCollections(ctx context.Context) ([]string, error)
+	DropCollection(ctx context.Context, collectionName string) error
+	MergeCollection(ctx context.Context, source, target string, opts domain.WriteOptions) (int, error)
+	CreateStagingCollection(ctx context.Context, source, staging string) error
+	CountDocuments(ctx context.Context, collectionName string) (int, error)
+	ValidateCollection(ctx context.Context, collectionName string) error
+	RenameCollection(ctx context.Context, source, target string) error
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	SaveImportRun(ctx context.Context, run *domain.ImportRun) error
+	FindImportRun(ctx context.Context, runID string) (*domain.ImportRun, error)
+	FindMatchingDocuments(ctx context.Context, collectionName string, documents []domain.Document, keyFields []string) ([]domain.Document, error)
+	SaveDocumentVersions(ctx context.Context, runID, collectionName string, documents []domain.Document) error
+	RollbackCollection(ctx context.Context, runID, runField, collectionName string) (*domain.RollbackResult, error)
+	SaveCheckpoint(ctx context.Context, checkpoint *domain.Checkpoint) error
+	FindCheckpoint(ctx context.Context, file string) (*domain.Checkpoint, error)
+	DeleteCheckpoint(ctx context.Context, file string) error
+	FindLedgerEntry(ctx context.Context, file string) (*domain.LedgerEntry, error)
+	SaveLedgerEntry(ctx context.Context, entry *domain.LedgerEntry) error
 	Disconnect(ctx context.Context) error
 }
 
 // MongoRepository MongoDBとの接続を管理するリポジトリ
 type MongoRepository struct {
-	client *mongo.Client
-	db     *mongo.Database
+	client        *mongo.Client
+	db            *mongo.Database
+	batchSize     int // 1バッチあたりのドキュメント数の上限（0 の場合は DefaultBatchSize）
+	maxBatchBytes int // 1バッチあたりのバイト数の上限（0 の場合は DefaultMaxBatchBytes）
+
+	tuning   *BatchTuning           // バッチサイズの自動調整の設定（nil の場合は調整しない）
+	tunersMu sync.Mutex             // tuners を保護する
+	tuners   map[string]*batchTuner // コレクションごとのバッチサイズの調整器
+
+	retry        RetryPolicy   // 一時的なエラーで失敗したバッチの再試行の設定
+	batchTimeout time.Duration // 1バッチの書き込み1回あたりの制限時間（0 の場合は制限しない）
 }
 
 // NewMongoRepository MongoDBリポジトリの新しいインスタンスを作成する
 func NewMongoRepository(ctx context.Context, cfg *config.Config) (*MongoRepository, error) {
-	// 接続オプションの設定
-	clientOptions := options.Client().ApplyURI(cfg.MongoURI)
+	// 接続オプションの設定（接続と接続確認は MONGODB_CONNECT_TIMEOUT または MONGODB_TIMEOUT の時間で打ち切る）
+	connectTimeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	clientOptions := options.Client().ApplyURI(cfg.MongoURI).SetConnectTimeout(connectTimeout)
 
 	// MongoDBに接続
 	client, err := mongo.Connect(ctx, clientOptions)
@@ -38,7 +75,9 @@ func NewMongoRepository(ctx context.Context, cfg *config.Config) (*MongoReposito
 	}
 
 	// 接続確認
-	err = client.Ping(ctx, nil)
+	pingCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	err = client.Ping(pingCtx, nil)
 	if err != nil {
 		return nil, &domain.RepositoryError{
 			Operation: "MongoDB接続確認",
@@ -49,14 +88,40 @@ func NewMongoRepository(ctx context.Context, cfg *config.Config) (*MongoReposito
 	// データベースの取得
 	db := client.Database(cfg.DatabaseName)
 
+	// バッチサイズの自動調整
+	var tuning *BatchTuning
+	if cfg.BatchAutoTune {
+		tuning = &BatchTuning{
+			TargetLatency: time.Duration(cfg.BatchTargetLatencyMS) * time.Millisecond,
+			MinSize:       cfg.BatchMinSize,
+			MaxSize:       cfg.BatchMaxSize,
+		}
+	}
+
 	return &MongoRepository{
-		client: client,
-		db:     db,
+		client:        client,
+		db:            db,
+		batchSize:     cfg.BatchSize,
+		maxBatchBytes: cfg.MaxBatchBytes,
+		tuning:        tuning,
+		retry: RetryPolicy{
+			MaxRetries: cfg.MaxRetries,
+			BaseDelay:  time.Duration(cfg.RetryBaseDelayMS) * time.Millisecond,
+			MaxDelay:   time.Duration(cfg.RetryMaxDelayMS) * time.Millisecond,
+		},
+		batchTimeout: time.Duration(cfg.BatchTimeoutSeconds) * time.Second,
 	}, nil
 }
 
 // InsertDocuments 指定したコレクションに複数のドキュメントをバッチ処理で挿入する
 func (r *MongoRepository) InsertDocuments(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+	return r.insertDocuments(ctx, collectionName, documents, true)
+}
+
+// insertDocuments ドキュメントをバッチ処理で挿入する
+// ordered が false の場合は失敗したドキュメントを飛ばして挿入を続け、
+// 失敗したドキュメントを結果の Failed に記録する
+func (r *MongoRepository) insertDocuments(ctx context.Context, collectionName string, documents []domain.Document, ordered bool) (*domain.ImportResult, error) {
 	if len(documents) == 0 {
 		return &domain.ImportResult{
 			CollectionName: collectionName,
@@ -68,44 +133,118 @@ func (r *MongoRepository) InsertDocuments(ctx context.Context, collectionName st
 	// コレクションの取得
 	collection := r.db.Collection(collectionName)
 
-	// バッチサイズを設定（パフォーマンスとメモリ使用量のバランスを取る）
-	batchSize := 1000
-	totalBatches := (len(documents) + batchSize - 1) / batchSize // 切り上げ除算
+	// 再試行しても重複して挿入されないように、最初の試行の前に _id を決めておく
+	// ドキュメントを一度だけエンコードし、件数とバイト数の上限でバッチに分割する
+	assigned := assignIDs(documents)
+	raws, err := encodeDocuments(collectionName, assigned)
+	if err != nil {
+		return nil, err
+	}
+	_, maxBytes := r.batchLimits()
+	sizes := rawSizes(raws)
+	tuner := r.tuner(collectionName)
 	totalInserted := 0
+	var failed []domain.DocumentFailure
+	insertOptions := options.InsertMany().SetOrdered(ordered)
 
-	// バッチ処理
-	for i := 0; i < len(documents); i += batchSize {
-		// 現在のバッチの終了位置を計算
-		end := i + batchSize
-		if end > len(documents) {
-			end = len(documents)
+	// バッチ処理（自動調整が有効な場合、バッチサイズは観測した往復時間に応じてバッチごとに変わる）
+	for b, start := 0, 0; start < len(raws); b++ {
+		batch := nextBatch(sizes, start, tuner.current(), maxBytes)
+		start = batch.end
+		multiple := b > 0 || batch.end < len(raws)
+
+		// まだ挿入されていないドキュメントの位置（再試行では前の試行で書き込まれたものを除く）
+		pending := make([]int, 0, batch.end-batch.start)
+		for i := batch.start; i < batch.end; i++ {
+			pending = append(pending, i)
 		}
+		inserted := 0
+		var batchFailures, retrying []domain.DocumentFailure
+		// 前の試行でどのドキュメントが書き込まれたかが分かっているか（書き込みエラーで失敗した場合）
+		known := false
 
-		// 現在のバッチを取得
-		batch := documents[i:end]
+		operation := fmt.Sprintf("コレクション %s へのドキュメント挿入（バッチ %d、%d/%d件）",
+			collectionName, b+1, batch.end, len(raws))
+		err := r.withRetry(ctx, operation, func(attempt int) error {
+			batchCtx, cancel := r.batchContext(ctx)
+			defer cancel()
 
-		// インターフェースのスライスに変換
-		var interfaceSlice []interface{}
-		for _, doc := range batch {
-			interfaceSlice = append(interfaceSlice, doc)
-		}
+			retrying = nil
+			if attempt > 0 && !known {
+				remaining, err := unwrittenDocuments(batchCtx, collection, raws, pending)
+				if err != nil {
+					return err
+				}
+				inserted += len(pending) - len(remaining)
+				pending = remaining
+			}
+			if len(pending) == 0 {
+				return nil
+			}
+
+			// インターフェースのスライスに変換（エンコード済みの BSON をそのまま渡す）
+			interfaceSlice := make([]interface{}, 0, len(pending))
+			for _, i := range pending {
+				interfaceSlice = append(interfaceSlice, raws[i])
+			}
 
-		// バッチをInsertManyで挿入
-		result, err := collection.InsertMany(ctx, interfaceSlice)
+			// バッチをInsertManyで挿入
+			sent := time.Now()
+			result, err := collection.InsertMany(batchCtx, interfaceSlice, insertOptions)
+			tuner.observe(len(interfaceSlice), time.Since(sent), err)
+			if failures, ok := documentFailures(err, 0); ok && !ordered {
+				// 失敗したドキュメント以外は挿入されている
+				inserted += len(interfaceSlice) - len(failures)
+				// 書き込みの競合などの一時的なエラーで失敗したドキュメントだけを再試行する
+				var retry []int
+				for _, failure := range failures {
+					position := pending[failure.Index]
+					failure.Index = position
+					if slices.Contains(retryableCodes, failure.Code) {
+						retry = append(retry, position)
+						retrying = append(retrying, failure)
+					} else {
+						batchFailures = append(batchFailures, failure)
+					}
+				}
+				if len(retry) == 0 {
+					return nil
+				}
+				pending, known = retry, true
+				return err
+			}
+			if err != nil {
+				known = false
+				return err
+			}
+			inserted += len(result.InsertedIDs)
+			return nil
+		})
+		if err != nil && len(retrying) > 0 {
+			// 再試行しても一時的なエラーが続いたドキュメントは、失敗したドキュメントとして返す
+			batchFailures = append(batchFailures, retrying...)
+			slices.SortFunc(batchFailures, func(a, b domain.DocumentFailure) int { return a.Index - b.Index })
+			err = nil
+		}
 		if err != nil {
 			return nil, &domain.RepositoryError{
-				Operation: fmt.Sprintf("コレクション %s へのドキュメント挿入（バッチ %d/%d）",
-					collectionName, i/batchSize+1, totalBatches),
-				Err: err,
+				Operation: operation,
+				Err:       err,
 			}
 		}
 
-		totalInserted += len(result.InsertedIDs)
+		totalInserted += inserted
+		if len(batchFailures) > 0 {
+			failed = append(failed, batchFailures...)
+			fmt.Printf("コレクション %s: バッチ %d（%d/%d件）で %d件の挿入に失敗しました\n",
+				collectionName, b+1, batch.end, len(raws), len(batchFailures))
+			continue
+		}
 
 		// バッチ処理の進捗をログに出力（大量データのデバッグに役立つ）
-		if totalBatches > 1 {
-			fmt.Printf("コレクション %s: バッチ %d/%d 完了（%d件挿入）\n",
-				collectionName, i/batchSize+1, totalBatches, len(result.InsertedIDs))
+		if multiple {
+			fmt.Printf("コレクション %s: バッチ %d 完了（%d件挿入、%d/%d件）\n",
+				collectionName, b+1, inserted, batch.end, len(raws))
 		}
 	}
 
@@ -113,10 +252,182 @@ func (r *MongoRepository) InsertDocuments(ctx context.Context, collectionName st
 	return &domain.ImportResult{
 		CollectionName: collectionName,
 		InsertedCount:  totalInserted,
+		Failed:         failed,
+		BatchSize:      tuner.tuned(),
 		Error:          nil,
 	}, nil
 }
 
+// WriteDocuments 指定したモードでドキュメントを書き込む
+// insert モードでは InsertDocuments と同じ動作になり、それ以外のモードでは
+// キーで照合する書き込みを BulkWrite でバッチ処理する
+func (r *MongoRepository) WriteDocuments(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+	if !opts.Mode.MatchesByKey() {
+		return r.insertDocuments(ctx, collectionName, documents, !opts.Unordered)
+	}
+
+	result := &domain.ImportResult{CollectionName: collectionName}
+	if len(documents) == 0 {
+		return result, nil
+	}
+
+	// コレクションの取得
+	collection := r.db.Collection(collectionName)
+	bulkOptions := options.BulkWrite().SetOrdered(!opts.Unordered)
+
+	raws, err := encodeDocuments(collectionName, documents)
+	if err != nil {
+		return nil, err
+	}
+	_, maxBytes := r.batchLimits()
+	sizes := rawSizes(raws)
+	tuner := r.tuner(collectionName)
+
+	for b, start := 0, 0; start < len(documents); b++ {
+		batch := nextBatch(sizes, start, tuner.current(), maxBytes)
+		start = batch.end
+		multiple := b > 0 || batch.end < len(documents)
+
+		// 書き込みモデルの作成
+		models := make([]mongo.WriteModel, 0, batch.end-batch.start)
+		for j, doc := range documents[batch.start:batch.end] {
+			model, err := buildWriteModel(doc, opts)
+			if err != nil {
+				return nil, &domain.RepositoryError{
+					Operation: fmt.Sprintf("コレクション %s の書き込みモデル作成（ドキュメント %d）", collectionName, batch.start+j),
+					Err:       err,
+				}
+			}
+			models = append(models, model)
+		}
+
+		// バッチをBulkWriteで書き込み
+		// キーで照合する書き込みは繰り返しても結果が変わらないため、一時的なエラーではバッチ全体を再試行する
+		// （件数は最後の試行のもので、前の試行で新規作成されたドキュメントは一致として数えられる）
+		operation := fmt.Sprintf("コレクション %s への %s 書き込み（バッチ %d、%d/%d件）",
+			collectionName, opts.Mode, b+1, batch.end, len(documents))
+		var bulkResult *mongo.BulkWriteResult
+		err := r.withRetry(ctx, operation, func(int) error {
+			batchCtx, cancel := r.batchContext(ctx)
+			defer cancel()
+
+			sent := time.Now()
+			var err error
+			bulkResult, err = collection.BulkWrite(batchCtx, models, bulkOptions)
+			tuner.observe(len(models), time.Since(sent), err)
+			return err
+		})
+		if batchFailures, ok := documentFailures(err, batch.start); ok && opts.Unordered && bulkResult != nil {
+			// 失敗したドキュメント以外の書き込みは結果に反映されている
+			result.Failed = append(result.Failed, batchFailures...)
+			fmt.Printf("コレクション %s: バッチ %d（%d/%d件）で %d件の書き込みに失敗しました\n",
+				collectionName, b+1, batch.end, len(documents), len(batchFailures))
+		} else if err != nil {
+			return nil, &domain.RepositoryError{
+				Operation: operation,
+				Err:       err,
+			}
+		}
+
+		result.InsertedCount += int(bulkResult.InsertedCount)
+		result.MatchedCount += int(bulkResult.MatchedCount)
+		result.ModifiedCount += int(bulkResult.ModifiedCount)
+		result.UpsertedCount += int(bulkResult.UpsertedCount)
+
+		if multiple {
+			fmt.Printf("コレクション %s: バッチ %d 完了（一致 %d件、更新 %d件、新規 %d件、%d/%d件）\n",
+				collectionName, b+1,
+				bulkResult.MatchedCount, bulkResult.ModifiedCount, bulkResult.UpsertedCount,
+				batch.end, len(documents))
+		}
+	}
+
+	result.BatchSize = tuner.tuned()
+	return result, nil
+}
+
+// FindDocuments 指定したコレクションのすべてのドキュメントを取得する
+func (r *MongoRepository) FindDocuments(ctx context.Context, collectionName string) ([]domain.Document, error) {
+	cursor, err := r.db.Collection(collectionName).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の検索", collectionName),
+			Err:       err,
+		}
+	}
+
+	var results []bson.D
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の読み込み", collectionName),
+			Err:       err,
+		}
+	}
+
+	documents := make([]domain.Document, 0, len(results))
+	for _, doc := range results {
+		documents = append(documents, domain.Document(doc))
+	}
+	return documents, nil
+}
+
+// ScanDocuments 指定したコレクションのドキュメントを1件ずつ fn に渡す
+// コレクション全体をメモリに読み込まない。doc はカーソルのバッファを指すため、fn から戻った後は使えない
+func (r *MongoRepository) ScanDocuments(ctx context.Context, collectionName string, fn func(doc bson.Raw) error) error {
+	cursor, err := r.db.Collection(collectionName).Find(ctx, bson.D{})
+	if err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の検索", collectionName),
+			Err:       err,
+		}
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		if err := fn(cursor.Current); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の読み込み", collectionName),
+			Err:       err,
+		}
+	}
+	return nil
+}
+
+// DeleteDocuments 指定した _id のドキュメントをバッチ処理で削除する
+func (r *MongoRepository) DeleteDocuments(ctx context.Context, collectionName string, ids []any) (int, error) {
+	collection := r.db.Collection(collectionName)
+
+	batchSize, _ := r.batchLimits()
+	totalDeleted := 0
+	for i := 0; i < len(ids); i += batchSize {
+		end := min(i+batchSize, len(ids))
+
+		// 削除は繰り返しても結果が変わらないため、一時的なエラーではバッチを再試行する
+		filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids[i:end]}}}}
+		var result *mongo.DeleteResult
+		err := r.withRetry(ctx, fmt.Sprintf("コレクション %s からのドキュメント削除", collectionName), func(int) error {
+			batchCtx, cancel := r.batchContext(ctx)
+			defer cancel()
+
+			var err error
+			result, err = collection.DeleteMany(batchCtx, filter)
+			return err
+		})
+		if err != nil {
+			return totalDeleted, &domain.RepositoryError{
+				Operation: fmt.Sprintf("コレクション %s からのドキュメント削除", collectionName),
+				Err:       err,
+			}
+		}
+		totalDeleted += int(result.DeletedCount)
+	}
+	return totalDeleted, nil
+}
+
 // Disconnect MongoDBとの接続を切断する
 func (r *MongoRepository) Disconnect(ctx context.Context) error {
 	if r.client != nil {
@@ -8,13 +8,33 @@ package repository
 // 3. 空のドキュメント配列の処理が適切に行われるか
 // 4. エラーケースが適切に処理されるか
 // 5. エラーのラップと取り出しが正常に機能するか
+// 6. WriteDocumentsがBulkWriteの結果から一致・更新・新規作成の件数を返すか
+// 7. ResetCollectionがオプションとインデックスを引き継いでコレクションを作り直すか
+// 8. unorderedモードで失敗したドキュメントの位置・コード・メッセージを返すか
+// 9. MergeCollectionが$mergeで書き込み、元のコレクションの件数を返すか
+// 10. RenameCollectionがdropTarget付きのrenameCollectionコマンドを送るか
+// 11. ValidateCollectionが検証に失敗したコレクションをエラーにするか
+// 12. RollbackCollectionが実行のドキュメントを削除し、変更前の内容を一度だけ書き戻すか
+// 13. FindImportRunが記録のない実行に nil を返すか
+// 14. SaveCheckpointがファイルのパスをキーにチェックポイントを置き換えるか
+// 15. InsertDocumentsが件数とバイト数の上限でバッチを分割するか
+// 16. 自動調整が有効な場合にバッチサイズを変えながら挿入し、調整後のサイズを返すか
+// 17. 一時的なエラーで失敗したバッチを、前の試行で書き込まれたドキュメントを除いて再試行するか
+// 18. 再試行で内容の異なる既存の _id をこの実行の書き込みとして数えず、重複キーの失敗として返すか
+// 19. unorderedモードで一時的なエラーで失敗したドキュメントだけを再試行し、再試行が尽きたら失敗として返すか
+// 20. ソースの _id のドキュメントも、前の試行で書き込まれたものを除いて再試行するか
+// 21. ScanDocumentsがカーソルの複数のバッチにまたがってドキュメントを1件ずつ渡すか
 
 import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 
 	"github.com/OTakumi/data-importer/internal/config"
@@ -25,8 +45,8 @@ import (
 func TestMockMongoRepository(t *testing.T) {
 	ctx := context.Background()
 	documents := []domain.Document{
-		{"name": "test1", "value": 1},
-		{"name": "test2", "value": 2},
+		{{Key: "name", Value: "test1"}, {Key: "value", Value: 1}},
+		{{Key: "name", Value: "test2"}, {Key: "value", Value: 2}},
 	}
 
 	// 成功ケース
@@ -101,8 +121,8 @@ func TestMongoRepository_Integration(t *testing.T) {
 
 		// テストデータ
 		documents := []domain.Document{
-			{"name": "test1", "value": 1},
-			{"name": "test2", "value": 2},
+			{{Key: "name", Value: "test1"}, {Key: "value", Value: 1}},
+			{{Key: "name", Value: "test2"}, {Key: "value", Value: 2}},
 		}
 
 		// ドキュメントの挿入
@@ -139,8 +159,8 @@ func TestMongoRepository_Integration(t *testing.T) {
 		largeDocuments := make([]domain.Document, 0, 2000)
 		for i := 0; i < 2000; i++ {
 			largeDocuments = append(largeDocuments, domain.Document{
-				"index": i,
-				"value": fmt.Sprintf("test value %d", i),
+				{Key: "index", Value: i},
+				{Key: "value", Value: fmt.Sprintf("test value %d", i)},
 			})
 		}
 
@@ -153,6 +173,669 @@ func TestMongoRepository_Integration(t *testing.T) {
 			t.Errorf("コレクション名が一致しません: expected=%s, got=%s", "largeCollection", result.CollectionName)
 		}
 	})
+
+	mt.Run("insert_batch_limits", func(mt *mtest.T) {
+		// 件数の上限（2件）とバイト数の上限で 5件のドキュメントを分割する
+		for i := 0; i < 4; i++ {
+			mt.AddMockResponses(mtest.CreateSuccessResponse())
+		}
+
+		repo := &MongoRepository{
+			client:        mt.Client,
+			db:            mt.Client.Database("test_db"),
+			batchSize:     2,
+			maxBatchBytes: 1024,
+		}
+
+		// 3件目のドキュメントだけが大きく、単独のバッチになる
+		documents := []domain.Document{
+			{{Key: "index", Value: 0}},
+			{{Key: "index", Value: 1}},
+			{{Key: "index", Value: 2}, {Key: "payload", Value: strings.Repeat("x", 2048)}},
+			{{Key: "index", Value: 3}},
+			{{Key: "index", Value: 4}},
+		}
+		if _, err := repo.InsertDocuments(context.Background(), "testCollection", documents); err != nil {
+			t.Fatalf("ドキュメント挿入でエラーが発生しました: %v", err)
+		}
+
+		var batchSizes []int
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "insert" {
+				values, _ := event.Command.Lookup("documents").Array().Values()
+				batchSizes = append(batchSizes, len(values))
+			}
+		}
+		if expected := []int{2, 1, 2}; !reflect.DeepEqual(batchSizes, expected) {
+			t.Errorf("バッチの件数が一致しません: expected=%v, got=%v", expected, batchSizes)
+		}
+	})
+
+	mt.Run("insert_auto_tuned", func(mt *mtest.T) {
+		for i := 0; i < 4; i++ {
+			mt.AddMockResponses(mtest.CreateSuccessResponse())
+		}
+
+		// 目標の往復時間が長いため、バッチごとに 2倍ずつ上限まで大きくなる
+		repo := &MongoRepository{
+			client:    mt.Client,
+			db:        mt.Client.Database("test_db"),
+			batchSize: 2,
+			tuning:    &BatchTuning{TargetLatency: time.Hour, MinSize: 1, MaxSize: 8},
+		}
+
+		documents := make([]domain.Document, 0, 20)
+		for i := 0; i < 20; i++ {
+			documents = append(documents, domain.Document{{Key: "index", Value: i}})
+		}
+		result, err := repo.InsertDocuments(context.Background(), "testCollection", documents)
+		if err != nil {
+			t.Fatalf("ドキュメント挿入でエラーが発生しました: %v", err)
+		}
+
+		var batchSizes []int
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "insert" {
+				values, _ := event.Command.Lookup("documents").Array().Values()
+				batchSizes = append(batchSizes, len(values))
+			}
+		}
+		if expected := []int{2, 4, 8, 6}; !reflect.DeepEqual(batchSizes, expected) {
+			t.Errorf("バッチの件数が一致しません: expected=%v, got=%v", expected, batchSizes)
+		}
+		if result.BatchSize != 8 {
+			t.Errorf("調整後のバッチサイズを返すべきです: %d", result.BatchSize)
+		}
+	})
+
+	mt.Run("insert_retry", func(mt *mtest.T) {
+		// 挿入はドライバー自身の再試行も含めてプライマリの切り替えで失敗し、どのドキュメントも書き込まれていない
+		stepDown := mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    189,
+			Name:    "PrimarySteppedDown",
+			Message: "primary stepped down",
+			Labels:  []string{"RetryableWriteError"},
+		})
+		mt.AddMockResponses(
+			stepDown,
+			stepDown,
+			mtest.CreateCursorResponse(0, "test_db.testCollection", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 3}),
+		)
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+			retry:  RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		}
+
+		documents := []domain.Document{
+			{{Key: "_id", Value: 1}, {Key: "name", Value: "test1"}},
+			{{Key: "name", Value: "test2"}},
+			{{Key: "name", Value: "test3"}},
+		}
+		result, err := repo.InsertDocuments(context.Background(), "testCollection", documents)
+		if err != nil {
+			t.Fatalf("再試行で挿入できるべきです: %v", err)
+		}
+		if result.InsertedCount != 3 {
+			t.Errorf("挿入件数が一致しません: %d", result.InsertedCount)
+		}
+
+		var batchSizes []int
+		var queried []bson.RawValue
+		for _, event := range mt.GetAllStartedEvents() {
+			switch event.CommandName {
+			case "insert":
+				values, _ := event.Command.Lookup("documents").Array().Values()
+				batchSizes = append(batchSizes, len(values))
+			case "find":
+				queried, _ = event.Command.Lookup("filter", "_id", "$in").Array().Values()
+			}
+		}
+		if expected := []int{3, 3, 3}; !reflect.DeepEqual(batchSizes, expected) {
+			t.Errorf("書き込まれていないドキュメントを再試行するべきです: expected=%v, got=%v", expected, batchSizes)
+		}
+		// 生成した _id もソースの _id も、書き込まれたかを確かめる
+		if len(queried) != 3 {
+			t.Errorf("すべての _id を問い合わせるべきです: %v", queried)
+		}
+	})
+
+	mt.Run("insert_retry_existing_id", func(mt *mtest.T) {
+		// _id 1 のドキュメントは実行の前から存在し、挿入は一時的なエラーで失敗する
+		stepDown := mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    189,
+			Name:    "PrimarySteppedDown",
+			Message: "primary stepped down",
+			Labels:  []string{"RetryableWriteError"},
+		})
+		mt.AddMockResponses(
+			stepDown,
+			stepDown,
+			mtest.CreateCursorResponse(0, "test_db.testCollection", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "existing"}}),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}),
+		)
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+			retry:  RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		}
+
+		documents := []domain.Document{
+			{{Key: "_id", Value: 1}, {Key: "name", Value: "test1"}},
+			{{Key: "_id", Value: 2}, {Key: "name", Value: "test2"}},
+		}
+		result, err := repo.WriteDocuments(context.Background(), "testCollection", documents,
+			domain.WriteOptions{Mode: domain.ImportModeInsert, Unordered: true})
+		if err != nil {
+			t.Fatalf("失敗したドキュメントはエラーではなく結果で返すべきです: %v", err)
+		}
+		if result.InsertedCount != 1 {
+			t.Errorf("既存の _id を挿入件数に数えるべきではありません: %d", result.InsertedCount)
+		}
+		if len(result.Failed) != 1 || result.Failed[0].Index != 0 || result.Failed[0].Code != 11000 {
+			t.Errorf("既存の _id を重複キーの失敗として返すべきです: %+v", result.Failed)
+		}
+
+		var insertSizes []int
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "insert" {
+				values, _ := event.Command.Lookup("documents").Array().Values()
+				insertSizes = append(insertSizes, len(values))
+			}
+		}
+		if expected := []int{2, 2, 2}; !reflect.DeepEqual(insertSizes, expected) {
+			t.Errorf("内容の異なる既存のドキュメントと同じ _id のドキュメントは再送するべきです: expected=%v, got=%v", expected, insertSizes)
+		}
+	})
+
+	mt.Run("insert_retry_partial_write", func(mt *mtest.T) {
+		// -id-fields で _id を決めたドキュメントのうち、最初の2件は失敗した試行で書き込まれている
+		stepDown := mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    189,
+			Name:    "PrimarySteppedDown",
+			Message: "primary stepped down",
+			Labels:  []string{"RetryableWriteError"},
+		})
+		documents := []domain.Document{
+			{{Key: "code", Value: "a"}, {Key: "_id", Value: "jp-a"}},
+			{{Key: "code", Value: "b"}, {Key: "_id", Value: "jp-b"}},
+			{{Key: "code", Value: "c"}, {Key: "_id", Value: "jp-c"}},
+		}
+		mt.AddMockResponses(
+			stepDown,
+			stepDown,
+			mtest.CreateCursorResponse(0, "test_db.testCollection", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: "jp-a"}, {Key: "code", Value: "a"}},
+				bson.D{{Key: "_id", Value: "jp-b"}, {Key: "code", Value: "b"}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+			retry:  RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		}
+
+		result, err := repo.InsertDocuments(context.Background(), "testCollection", documents)
+		if err != nil {
+			t.Fatalf("前の試行で書き込まれたドキュメントで再試行が失敗するべきではありません: %v", err)
+		}
+		if result.InsertedCount != 3 {
+			t.Errorf("前の試行で書き込まれたドキュメントも挿入件数に数えるべきです: %d", result.InsertedCount)
+		}
+
+		var insertSizes []int
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "insert" {
+				values, _ := event.Command.Lookup("documents").Array().Values()
+				insertSizes = append(insertSizes, len(values))
+			}
+		}
+		if expected := []int{3, 3, 1}; !reflect.DeepEqual(insertSizes, expected) {
+			t.Errorf("書き込まれていないドキュメントだけを再試行するべきです: expected=%v, got=%v", expected, insertSizes)
+		}
+	})
+
+	mt.Run("insert_unordered_retryable_write_errors", func(mt *mtest.T) {
+		documents := []domain.Document{
+			{{Key: "_id", Value: 1}, {Key: "name", Value: "test1"}},
+			{{Key: "_id", Value: 2}, {Key: "name", Value: "test2"}},
+			{{Key: "_id", Value: 3}, {Key: "name", Value: "test3"}},
+		}
+		writeConflict := mtest.WriteError{Index: 1, Code: 112, Message: "write conflict"}
+		duplicate := mtest.WriteError{Index: 2, Code: 11000, Message: "duplicate key"}
+
+		cases := []struct {
+			name        string
+			maxRetries  int
+			responses   []bson.D
+			inserted    int
+			failures    []domain.DocumentFailure
+			insertSizes []int
+		}{
+			{
+				name:       "再試行で成功",
+				maxRetries: 2,
+				responses: []bson.D{
+					mtest.CreateWriteErrorsResponse(writeConflict, duplicate),
+					mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+				},
+				inserted:    2,
+				failures:    []domain.DocumentFailure{{Index: 2, Code: 11000, Message: "duplicate key"}},
+				insertSizes: []int{3, 1},
+			},
+			{
+				name:       "再試行が尽きる",
+				maxRetries: 1,
+				responses: []bson.D{
+					mtest.CreateWriteErrorsResponse(writeConflict, duplicate),
+					mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 112, Message: "write conflict"}),
+				},
+				inserted: 1,
+				failures: []domain.DocumentFailure{
+					{Index: 1, Code: 112, Message: "write conflict"},
+					{Index: 2, Code: 11000, Message: "duplicate key"},
+				},
+				insertSizes: []int{3, 1},
+			},
+		}
+
+		for _, tc := range cases {
+			mt.Run(tc.name, func(mt *mtest.T) {
+				mt.AddMockResponses(tc.responses...)
+				repo := &MongoRepository{
+					client: mt.Client,
+					db:     mt.Client.Database("test_db"),
+					retry:  RetryPolicy{MaxRetries: tc.maxRetries, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+				}
+
+				result, err := repo.WriteDocuments(context.Background(), "testCollection", documents,
+					domain.WriteOptions{Mode: domain.ImportModeInsert, Unordered: true})
+				if err != nil {
+					t.Fatalf("失敗したドキュメントはエラーではなく結果で返すべきです: %v", err)
+				}
+				if result.InsertedCount != tc.inserted {
+					t.Errorf("挿入件数が一致しません: expected=%d, got=%d", tc.inserted, result.InsertedCount)
+				}
+				if !reflect.DeepEqual(result.Failed, tc.failures) {
+					t.Errorf("失敗したドキュメントが一致しません: expected=%+v, got=%+v", tc.failures, result.Failed)
+				}
+
+				var insertSizes []int
+				for _, event := range mt.GetAllStartedEvents() {
+					if event.CommandName == "insert" {
+						values, _ := event.Command.Lookup("documents").Array().Values()
+						insertSizes = append(insertSizes, len(values))
+					}
+				}
+				if !reflect.DeepEqual(insertSizes, tc.insertSizes) {
+					t.Errorf("一時的なエラーのドキュメントだけを再試行するべきです: expected=%v, got=%v", tc.insertSizes, insertSizes)
+				}
+			})
+		}
+	})
+
+	mt.Run("insert_permanent_error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    13,
+			Name:    "Unauthorized",
+			Message: "not authorized",
+		}))
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+			retry:  RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond},
+		}
+
+		_, err := repo.InsertDocuments(context.Background(), "testCollection",
+			[]domain.Document{{{Key: "name", Value: "test"}}})
+		if err == nil {
+			t.Fatal("恒久的なエラーは再試行せずに返すべきです")
+		}
+		if started := len(mt.GetAllStartedEvents()); started != 1 {
+			t.Errorf("コマンドは 1回だけ送るべきです: %d", started)
+		}
+	})
+
+	mt.Run("upsert_documents", func(mt *mtest.T) {
+		// BulkWrite の応答（1件は既存ドキュメントを更新、1件は新規作成）
+		mt.AddMockResponses(mtest.CreateSuccessResponse(
+			bson.E{Key: "n", Value: 2},
+			bson.E{Key: "nModified", Value: 1},
+			bson.E{Key: "upserted", Value: bson.A{
+				bson.D{{Key: "index", Value: 1}, {Key: "_id", Value: "u2"}},
+			}},
+		))
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+		}
+
+		documents := []domain.Document{
+			{{Key: "_id", Value: "u1"}, {Key: "name", Value: "test1"}},
+			{{Key: "_id", Value: "u2"}, {Key: "name", Value: "test2"}},
+		}
+
+		result, err := repo.WriteDocuments(context.Background(), "users", documents,
+			domain.WriteOptions{Mode: domain.ImportModeUpsert})
+		if err != nil {
+			t.Fatalf("upsert でエラーが発生しました: %v", err)
+		}
+		if result.MatchedCount != 1 || result.ModifiedCount != 1 || result.UpsertedCount != 1 {
+			t.Errorf("件数が一致しません: matched=%d, modified=%d, upserted=%d",
+				result.MatchedCount, result.ModifiedCount, result.UpsertedCount)
+		}
+	})
+	mt.Run("insert_unordered_with_failures", func(mt *mtest.T) {
+		// 2件目が重複キーで失敗し、残りは挿入される
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   1,
+			Code:    11000,
+			Message: "E11000 duplicate key error",
+		}))
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+		}
+
+		documents := []domain.Document{
+			{{Key: "_id", Value: 1}},
+			{{Key: "_id", Value: 1}},
+			{{Key: "_id", Value: 2}},
+		}
+
+		result, err := repo.WriteDocuments(context.Background(), "users", documents,
+			domain.WriteOptions{Mode: domain.ImportModeInsert, Unordered: true})
+		if err != nil {
+			t.Fatalf("unordered モードではエラーを返すべきではありません: %v", err)
+		}
+		if result.InsertedCount != 2 {
+			t.Errorf("挿入されたドキュメント数が一致しません: expected=2, got=%d", result.InsertedCount)
+		}
+		expected := []domain.DocumentFailure{{Index: 1, Code: 11000, Message: "E11000 duplicate key error"}}
+		if fmt.Sprint(result.Failed) != fmt.Sprint(expected) {
+			t.Errorf("失敗したドキュメントが一致しません: expected=%v, got=%v", expected, result.Failed)
+		}
+
+		// サーバーへ ordered=false で送信されたことを確認
+		ordered, ok := mt.GetStartedEvent().Command.Lookup("ordered").BooleanOK()
+		if !ok || ordered {
+			t.Errorf("unordered モードでは ordered=false で送信すべきです")
+		}
+	})
+
+	mt.Run("insert_ordered_failure", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "dup"}))
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+		}
+
+		_, err := repo.InsertDocuments(context.Background(), "users", []domain.Document{{{Key: "_id", Value: 1}}})
+		var repoErr *domain.RepositoryError
+		if !errors.As(err, &repoErr) {
+			t.Errorf("ordered モードでは RepositoryError を返すべきです: %v", err)
+		}
+	})
+
+	mt.Run("reset_collection", func(mt *mtest.T) {
+		validator := bson.D{{Key: "validator", Value: bson.D{{Key: "name", Value: bson.D{{Key: "$type", Value: "string"}}}}}}
+		mt.AddMockResponses(
+			// listCollections
+			mtest.CreateCursorResponse(0, "test_db.$cmd.listCollections", mtest.FirstBatch,
+				bson.D{{Key: "name", Value: "users"}, {Key: "type", Value: "collection"}, {Key: "options", Value: validator}}),
+			// countDocuments (aggregate)
+			mtest.CreateCursorResponse(0, "test_db.users", mtest.FirstBatch, bson.D{{Key: "n", Value: int32(5)}}),
+			// listIndexes
+			mtest.CreateCursorResponse(0, "test_db.users", mtest.FirstBatch,
+				bson.D{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "_id", Value: 1}}}, {Key: "name", Value: "_id_"}},
+				bson.D{{Key: "v", Value: 2}, {Key: "key", Value: bson.D{{Key: "email", Value: 1}}}, {Key: "name", Value: "email_1"}, {Key: "unique", Value: true}}),
+			// drop, create, createIndexes
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+		)
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+		}
+
+		previousCount, err := repo.ResetCollection(context.Background(), "users")
+		if err != nil {
+			t.Fatalf("コレクションの再作成でエラーが発生しました: %v", err)
+		}
+		if previousCount != 5 {
+			t.Errorf("削除前のドキュメント数が一致しません: expected=5, got=%d", previousCount)
+		}
+
+		// バリデーションを引き継いでコレクションを作成し、_id 以外のインデックスを再作成する
+		var commands []string
+		for _, event := range mt.GetAllStartedEvents() {
+			commands = append(commands, event.CommandName)
+			switch event.CommandName {
+			case "create":
+				if _, err := event.Command.LookupErr("validator"); err != nil {
+					t.Errorf("create コマンドにバリデーションが含まれていません: %v", event.Command)
+				}
+			case "createIndexes":
+				indexes := event.Command.Lookup("indexes").Array()
+				values, _ := indexes.Values()
+				if len(values) != 1 {
+					t.Errorf("再作成するインデックス数が一致しません: expected=1, got=%d", len(values))
+				}
+				if _, err := indexes.Index(0).Value().Document().LookupErr("v"); err == nil {
+					t.Errorf("インデックス定義から v フィールドが除かれていません")
+				}
+			}
+		}
+		expectedCommands := []string{"listCollections", "aggregate", "listIndexes", "drop", "create", "createIndexes"}
+		if fmt.Sprint(commands) != fmt.Sprint(expectedCommands) {
+			t.Errorf("コマンドが一致しません: expected=%v, got=%v", expectedCommands, commands)
+		}
+	})
+
+	mt.Run("reset_missing_collection", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test_db.$cmd.listCollections", mtest.FirstBatch))
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+		}
+
+		previousCount, err := repo.ResetCollection(context.Background(), "users")
+		if err != nil || previousCount != 0 {
+			t.Errorf("存在しないコレクションは何もしないべきです: count=%d, err=%v", previousCount, err)
+		}
+	})
+
+	mt.Run("merge_collection", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "test_db.users__staging", mtest.FirstBatch, bson.D{{Key: "n", Value: int64(3)}}),
+			mtest.CreateCursorResponse(0, "test_db.users__staging", mtest.FirstBatch),
+		)
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+		}
+
+		count, err := repo.MergeCollection(context.Background(), "users__staging", "users", domain.WriteOptions{Mode: domain.ImportModeUpsert})
+		if err != nil {
+			t.Fatalf("マージに失敗しました: %v", err)
+		}
+		if count != 3 {
+			t.Errorf("件数が一致しません: expected=3, got=%d", count)
+		}
+
+		started := mt.GetAllStartedEvents()
+		aggregate := started[len(started)-1]
+		if aggregate.CommandName != "aggregate" {
+			t.Fatalf("最後のコマンドは aggregate であるべきです: %s", aggregate.CommandName)
+		}
+		stage := aggregate.Command.Lookup("pipeline", "0", "$merge", "whenMatched").StringValue()
+		if stage != "replace" {
+			t.Errorf("upsert モードでは whenMatched が replace であるべきです: %s", stage)
+		}
+	})
+
+	mt.Run("rename_collection", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+		}
+
+		if err := repo.RenameCollection(context.Background(), "users__staging", "users"); err != nil {
+			t.Fatalf("名前の変更に失敗しました: %v", err)
+		}
+
+		command := mt.GetStartedEvent().Command
+		if source := command.Lookup("renameCollection").StringValue(); source != "test_db.users__staging" {
+			t.Errorf("変更元が一致しません: %s", source)
+		}
+		if target := command.Lookup("to").StringValue(); target != "test_db.users" {
+			t.Errorf("変更先が一致しません: %s", target)
+		}
+		if !command.Lookup("dropTarget").Boolean() {
+			t.Error("dropTarget が指定されていません")
+		}
+	})
+
+	mt.Run("validate_collection", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "valid", Value: true}),
+			mtest.CreateSuccessResponse(bson.E{Key: "valid", Value: false}, bson.E{Key: "errors", Value: bson.A{"index users_1 is corrupt"}}),
+		)
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+		}
+
+		if err := repo.ValidateCollection(context.Background(), "users__staging"); err != nil {
+			t.Errorf("正常なコレクションでエラーが返されました: %v", err)
+		}
+		err := repo.ValidateCollection(context.Background(), "users__staging")
+		var repoErr *domain.RepositoryError
+		if !errors.As(err, &repoErr) {
+			t.Fatalf("RepositoryError が返されるべきです: %v", err)
+		}
+	})
+
+	mt.Run("rollback_collection", func(mt *mtest.T) {
+		previous := bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "before"}}
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 3}),
+			mtest.CreateCursorResponse(0, "test_db._import_run_versions", mtest.FirstBatch,
+				bson.D{{Key: "runId", Value: "run1"}, {Key: "collection", Value: "users"}, {Key: "document", Value: previous}},
+				bson.D{{Key: "runId", Value: "run1"}, {Key: "collection", Value: "users"}, {Key: "document", Value: bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "later"}}}},
+			),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+		}
+
+		result, err := repo.RollbackCollection(context.Background(), "run1", "_importRun", "users")
+		if err != nil {
+			t.Fatalf("ロールバックに失敗しました: %v", err)
+		}
+		if result.DeletedCount != 3 || result.RestoredCount != 1 {
+			t.Errorf("件数が一致しません: deleted=%d, restored=%d", result.DeletedCount, result.RestoredCount)
+		}
+
+		started := mt.GetAllStartedEvents()
+		deleteFilter := started[0].Command.Lookup("deletes", "0", "q", "_importRun").StringValue()
+		if deleteFilter != "run1" {
+			t.Errorf("実行IDで削除するべきです: %s", deleteFilter)
+		}
+		update := started[len(started)-1].Command
+		restored := update.Lookup("updates", "0", "u", "name").StringValue()
+		if restored != "before" {
+			t.Errorf("最初に保存した内容を書き戻すべきです: %s", restored)
+		}
+		if !update.Lookup("updates", "0", "upsert").Boolean() {
+			t.Error("書き戻しは upsert であるべきです")
+		}
+	})
+
+	mt.Run("scan_documents", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "test_db.testCollection", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: 1}, {Key: "code", Value: "JP"}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "code", Value: "US"}}),
+			mtest.CreateCursorResponse(0, "test_db.testCollection", mtest.NextBatch,
+				bson.D{{Key: "_id", Value: 3}, {Key: "code", Value: "FR"}}),
+		)
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+		}
+
+		var codes []string
+		err := repo.ScanDocuments(context.Background(), "testCollection", func(doc bson.Raw) error {
+			codes = append(codes, doc.Lookup("code").StringValue())
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("コレクションの読み込みに失敗しました: %v", err)
+		}
+		if !reflect.DeepEqual(codes, []string{"JP", "US", "FR"}) {
+			t.Errorf("すべてのドキュメントが順に渡されるべきです: %v", codes)
+		}
+	})
+
+	mt.Run("find_import_run_not_found", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test_db._import_runs", mtest.FirstBatch))
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+		}
+
+		run, err := repo.FindImportRun(context.Background(), "missing")
+		if err != nil || run != nil {
+			t.Errorf("記録のない実行では nil が返されるべきです: run=%v, err=%v", run, err)
+		}
+	})
+
+	mt.Run("save_checkpoint", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+
+		repo := &MongoRepository{
+			client: mt.Client,
+			db:     mt.Client.Database("test_db"),
+		}
+
+		checkpoint := &domain.Checkpoint{File: "/data/users.json", Collection: "users", Hash: "abc", Documents: 2000, Batches: 2}
+		if err := repo.SaveCheckpoint(context.Background(), checkpoint); err != nil {
+			t.Fatalf("チェックポイントの保存に失敗しました: %v", err)
+		}
+
+		update := mt.GetStartedEvent().Command.Lookup("updates", "0")
+		if id := update.Document().Lookup("q", "_id").StringValue(); id != "/data/users.json" {
+			t.Errorf("ファイルのパスで置き換えるべきです: %s", id)
+		}
+		if documents := update.Document().Lookup("u", "documents").Int32(); documents != 2000 {
+			t.Errorf("ドキュメント数が一致しません: %d", documents)
+		}
+		if !update.Document().Lookup("upsert").Boolean() {
+			t.Error("チェックポイントは upsert で保存するべきです")
+		}
+	})
 }
 
 // エラーケースのテスト
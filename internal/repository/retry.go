@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// RetryPolicy 一時的なエラーで失敗したバッチの再試行の設定
+// 再試行の間隔は BaseDelay から倍々に MaxDelay まで伸ばし、その範囲でランダムに揺らす
+type RetryPolicy struct {
+	MaxRetries int           // 1バッチあたりの再試行の上限（0 の場合は再試行しない）
+	BaseDelay  time.Duration // 最初の再試行までの間隔
+	MaxDelay   time.Duration // 再試行の間隔の上限
+}
+
+// 再試行すれば成功する可能性のあるサーバーのエラーコード
+var retryableCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	112,   // WriteConflict
+	189,   // PrimarySteppedDown
+	262,   // ExceededTimeLimit
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// isRetryable エラーが一時的なもの（再試行すれば成功する可能性がある）かを返す
+// ネットワークエラー、RetryableWriteError ラベル、プライマリの切り替えや書き込みの競合を表すコードを一時的とみなす
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if mongo.IsNetworkError(err) {
+		return true
+	}
+
+	var labeled mongo.LabeledError
+	if errors.As(err, &labeled) && labeled.HasErrorLabel("RetryableWriteError") {
+		return true
+	}
+
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	for _, code := range retryableCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// delay 再試行の前に待つ時間を返す（attempt は 0 から数えた再試行の回数）
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+	backoff := p.BaseDelay
+	for range attempt {
+		if backoff > math.MaxInt64/2 || (p.MaxDelay > 0 && backoff >= p.MaxDelay) {
+			break
+		}
+		backoff *= 2
+	}
+	if p.MaxDelay > 0 {
+		backoff = min(backoff, max(p.MaxDelay, p.BaseDelay))
+	}
+	// 同時に失敗した書き込みが一斉に再試行しないように、半分から全体の範囲で揺らす
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// wait 再試行の前に待つ（コンテキストが終了した場合はそのエラーを返す）
+func (p RetryPolicy) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(p.delay(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// withRetry 一時的なエラーで失敗した fn を再試行する
+// バッチの制限時間を過ぎた書き込みも、ctx が終了していなければ一時的なエラーとして再試行する
+// トランザクションの中では再試行しない（エラーでトランザクションは中止されるため、WithTransaction がトランザクション全体をやり直す）
+func (r *MongoRepository) withRetry(ctx context.Context, operation string, fn func(attempt int) error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || attempt >= r.retry.MaxRetries || ctx.Err() != nil || mongo.SessionFromContext(ctx) != nil {
+			return err
+		}
+		if !isRetryable(err) && !mongo.IsTimeout(err) {
+			return err
+		}
+		fmt.Printf("%s: 一時的なエラーのため再試行します（%d/%d回目）: %v\n", operation, attempt+1, r.retry.MaxRetries, err)
+		if waitErr := r.retry.wait(ctx, attempt); waitErr != nil {
+			return err
+		}
+	}
+}
+
+// assignIDs _id のないドキュメントに ObjectID を付けた複製を返す（元のドキュメントは変更しない）
+// 最初の試行の前に _id を決めておくことで、再試行したバッチが重複して挿入されないようにする
+func assignIDs(documents []domain.Document) []domain.Document {
+	assigned := make([]domain.Document, 0, len(documents))
+	for _, doc := range documents {
+		if _, ok := doc.Get("_id"); !ok {
+			// ドライバーと同じく _id を先頭に置く
+			doc = append(domain.Document{{Key: "_id", Value: primitive.NewObjectID()}}, doc...)
+		}
+		assigned = append(assigned, doc)
+	}
+	return assigned
+}
+
+// idKey _id の値を型も含めて比較するためのキーを返す
+func idKey(id bson.RawValue) string {
+	return string(rune(id.Type)) + string(id.Value)
+}
+
+// unwrittenDocuments pending の位置のドキュメントのうち、前の試行で書き込まれたと分からないものの位置を返す
+// 結果が分からないまま失敗した挿入を再試行する前に、前の試行で書き込まれたドキュメントを除くのに使う
+// 生成した _id もソースの _id も最初の試行の前に決まっているため、すべての _id を問い合わせる
+// 同じ _id で内容の異なるドキュメントは実行の前からあったものとみなし、そのまま再送して重複キーのエラーとして報告させる
+func unwrittenDocuments(ctx context.Context, collection *mongo.Collection, raws []bson.Raw, pending []int) ([]int, error) {
+	ids := make(bson.A, 0, len(pending))
+	for _, i := range pending {
+		ids = append(ids, raws[i].Lookup("_id"))
+	}
+
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []bson.Raw
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	stored := make(map[string]bson.Raw, len(found))
+	for _, doc := range found {
+		stored[idKey(doc.Lookup("_id"))] = doc
+	}
+
+	remaining := make([]int, 0, len(pending))
+	for _, i := range pending {
+		doc, ok := stored[idKey(raws[i].Lookup("_id"))]
+		if !ok || !sameDocument(doc, raws[i]) {
+			remaining = append(remaining, i)
+		}
+	}
+	return remaining, nil
+}
+
+// sameDocument 保存されているドキュメントが送ったドキュメントと同じ内容かを返す
+// サーバーは _id を先頭に移すため、_id 以外のフィールドを順に比べる
+func sameDocument(stored, sent bson.Raw) bool {
+	storedElements, err := stored.Elements()
+	if err != nil {
+		return false
+	}
+	sentElements, err := sent.Elements()
+	if err != nil {
+		return false
+	}
+	storedElements = slices.DeleteFunc(storedElements, isIDElement)
+	sentElements = slices.DeleteFunc(sentElements, isIDElement)
+	return slices.EqualFunc(storedElements, sentElements, func(a, b bson.RawElement) bool {
+		return bytes.Equal(a, b)
+	})
+}
+
+// isIDElement フィールドが _id かを返す
+func isIDElement(element bson.RawElement) bool {
+	return element.Key() == "_id"
+}
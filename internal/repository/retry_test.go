@@ -0,0 +1,161 @@
+package repository
+
+// TestRetry パッケージは一時的なエラーの再試行をテストします。
+//
+// テスト観点:
+// 1. ネットワークエラー・RetryableWriteError ラベル・プライマリの切り替えを一時的なエラーとみなすか
+// 2. 重複キーなどの恒久的なエラーを再試行しないか
+// 3. 再試行の間隔が倍々に伸び、上限を超えないか
+// 4. _id のないドキュメントにだけ _id を付け、元のドキュメントを変更しないか
+// 5. バッチの制限時間を過ぎた書き込みを、実行全体のコンテキストが終了するまで再試行するか
+// 6. 保存されているドキュメントを、_id の位置にかかわらず送った内容と同じときだけ書き込み済みとみなすか
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"エラーなし", nil, false},
+		{"RetryableWriteError ラベル", mongo.CommandError{Code: 1, Labels: []string{"RetryableWriteError"}}, true},
+		{"ネットワークエラー", mongo.CommandError{Labels: []string{"NetworkError"}}, true},
+		{"プライマリの切り替え", mongo.CommandError{Code: 189}, true},
+		{"ラップされた書き込みの競合", fmt.Errorf("挿入: %w", mongo.CommandError{Code: 112}), true},
+		{"書き込み保証のエラー", mongo.BulkWriteException{
+			WriteConcernError: &mongo.WriteConcernError{Code: 91},
+		}, true},
+		{"重複キー", mongo.BulkWriteException{
+			WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Code: 11000}}},
+		}, false},
+		{"認証エラー", mongo.CommandError{Code: 13}, false},
+		{"その他のエラー", errors.New("不明なエラー"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.expected {
+				t.Errorf("isRetryable() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt, backoff := range []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond,
+		time.Second, time.Second, time.Second,
+	} {
+		for range 20 {
+			delay := policy.delay(attempt)
+			if delay < backoff/2 || delay > backoff {
+				t.Errorf("再試行 %d の間隔は %v から %v の範囲であるべきです: %v", attempt, backoff/2, backoff, delay)
+			}
+		}
+	}
+
+	if delay := (RetryPolicy{MaxDelay: time.Second}).delay(100); delay != 0 {
+		t.Errorf("BaseDelay が 0 の場合は待たないべきです: %v", delay)
+	}
+	if delay := (RetryPolicy{BaseDelay: time.Second}).delay(100); delay < 0 {
+		t.Errorf("上限がない場合も桁あふれしないべきです: %v", delay)
+	}
+}
+
+func TestAssignIDs(t *testing.T) {
+	documents := []domain.Document{
+		{{Key: "_id", Value: "a"}, {Key: "name", Value: "first"}},
+		{{Key: "name", Value: "second"}},
+	}
+
+	assigned := assignIDs(documents)
+	if id, _ := assigned[0].Get("_id"); id != "a" {
+		t.Errorf("既存の _id を保持するべきです: %v", id)
+	}
+	if assigned[1][0].Key != "_id" || assigned[1][1].Key != "name" {
+		t.Errorf("_id を先頭に付けるべきです: %v", assigned[1])
+	}
+	if _, ok := documents[1].Get("_id"); ok {
+		t.Error("元のドキュメントを変更しないべきです")
+	}
+}
+
+func TestWithRetryBatchTimeout(t *testing.T) {
+	repo := &MongoRepository{
+		retry:        RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond},
+		batchTimeout: time.Millisecond,
+	}
+
+	t.Run("制限時間を過ぎたバッチ", func(t *testing.T) {
+		attempts := 0
+		err := repo.withRetry(context.Background(), "テスト", func(int) error {
+			attempts++
+			batchCtx, cancel := repo.batchContext(context.Background())
+			defer cancel()
+			if attempts == 1 {
+				<-batchCtx.Done()
+				return batchCtx.Err()
+			}
+			return nil
+		})
+		if err != nil || attempts != 2 {
+			t.Errorf("制限時間を過ぎたバッチを再試行するべきです: attempts=%d, err=%v", attempts, err)
+		}
+	})
+
+	t.Run("実行全体の期限切れ", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		attempts := 0
+		err := repo.withRetry(ctx, "テスト", func(int) error {
+			attempts++
+			return ctx.Err()
+		})
+		if !errors.Is(err, context.Canceled) || attempts != 1 {
+			t.Errorf("終了したコンテキストでは再試行しないべきです: attempts=%d, err=%v", attempts, err)
+		}
+	})
+}
+
+func TestSameDocument(t *testing.T) {
+	marshal := func(doc bson.D) bson.Raw {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	sent := marshal(bson.D{{Key: "code", Value: "a"}, {Key: "_id", Value: "a"}, {Key: "n", Value: int32(1)}})
+
+	tests := []struct {
+		name     string
+		stored   bson.D
+		expected bool
+	}{
+		{"_id を先頭に移した同じ内容", bson.D{{Key: "_id", Value: "a"}, {Key: "code", Value: "a"}, {Key: "n", Value: int32(1)}}, true},
+		{"値が異なる", bson.D{{Key: "_id", Value: "a"}, {Key: "code", Value: "a"}, {Key: "n", Value: int32(2)}}, false},
+		{"型が異なる", bson.D{{Key: "_id", Value: "a"}, {Key: "code", Value: "a"}, {Key: "n", Value: int64(1)}}, false},
+		{"フィールドが多い", bson.D{{Key: "_id", Value: "a"}, {Key: "code", Value: "a"}, {Key: "n", Value: int32(1)}, {Key: "x", Value: true}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameDocument(marshal(tt.stored), sent); got != tt.expected {
+				t.Errorf("sameDocument() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// documentVersion 実行が上書き・削除する前のドキュメントの内容（_import_run_versions に保存する）
+type documentVersion struct {
+	RunID      string `bson:"runId"`
+	Collection string `bson:"collection"`
+	Document   bson.D `bson:"document"`
+}
+
+// SaveImportRun インポート実行の記録を保存する（同じ実行IDの記録は置き換える）
+func (r *MongoRepository) SaveImportRun(ctx context.Context, run *domain.ImportRun) error {
+	_, err := r.db.Collection(domain.ImportRunsCollection).ReplaceOne(ctx,
+		bson.D{{Key: "_id", Value: run.ID}}, run, options.Replace().SetUpsert(true))
+	if err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("インポート実行 %s の記録", run.ID),
+			Err:       err,
+		}
+	}
+	return nil
+}
+
+// FindImportRun インポート実行の記録を取得する（記録がない場合は nil を返す）
+func (r *MongoRepository) FindImportRun(ctx context.Context, runID string) (*domain.ImportRun, error) {
+	var run domain.ImportRun
+	err := r.db.Collection(domain.ImportRunsCollection).FindOne(ctx, bson.D{{Key: "_id", Value: runID}}).Decode(&run)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &domain.RepositoryError{
+			Operation: fmt.Sprintf("インポート実行 %s の取得", runID),
+			Err:       err,
+		}
+	}
+	return &run, nil
+}
+
+// FindMatchingDocuments documents のキーに一致する、コレクションに保存済みのドキュメントを返す
+// キーフィールドが欠けているドキュメントは書き込みにも失敗するため無視する
+func (r *MongoRepository) FindMatchingDocuments(ctx context.Context, collectionName string, documents []domain.Document, keyFields []string) ([]domain.Document, error) {
+	filters := make(bson.A, 0, len(documents))
+	for _, doc := range documents {
+		filter, err := keyFilter(doc, keyFields)
+		if err != nil {
+			continue
+		}
+		filters = append(filters, filter)
+	}
+	if len(filters) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := r.db.Collection(collectionName).Find(ctx, bson.D{{Key: "$or", Value: filters}})
+	if err != nil {
+		return nil, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の検索", collectionName),
+			Err:       err,
+		}
+	}
+
+	var results []bson.D
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の読み込み", collectionName),
+			Err:       err,
+		}
+	}
+
+	matched := make([]domain.Document, 0, len(results))
+	for _, doc := range results {
+		matched = append(matched, domain.Document(doc))
+	}
+	return matched, nil
+}
+
+// SaveDocumentVersions 実行が上書き・削除するドキュメントの変更前の内容を保存する
+func (r *MongoRepository) SaveDocumentVersions(ctx context.Context, runID, collectionName string, documents []domain.Document) error {
+	if len(documents) == 0 {
+		return nil
+	}
+
+	versions := make([]any, 0, len(documents))
+	for _, doc := range documents {
+		versions = append(versions, documentVersion{RunID: runID, Collection: collectionName, Document: bson.D(doc)})
+	}
+	if _, err := r.db.Collection(domain.ImportRunVersionsCollection).InsertMany(ctx, versions); err != nil {
+		return &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s の変更前のドキュメントの保存", collectionName),
+			Err:       err,
+		}
+	}
+	return nil
+}
+
+// RollbackCollection インポート実行がコレクションに書き込んだ内容を取り消す
+// runField に実行IDを持つドキュメントを削除してから、保存しておいた変更前の内容を書き戻す
+// 同じドキュメントの変更前の内容が複数ある場合は、最初に保存したものを使う
+func (r *MongoRepository) RollbackCollection(ctx context.Context, runID, runField, collectionName string) (*domain.RollbackResult, error) {
+	result := &domain.RollbackResult{CollectionName: collectionName}
+	collection := r.db.Collection(collectionName)
+
+	deleted, err := collection.DeleteMany(ctx, bson.D{{Key: runField, Value: runID}})
+	if err != nil {
+		return result, &domain.RepositoryError{
+			Operation: fmt.Sprintf("コレクション %s からの実行 %s のドキュメント削除", collectionName, runID),
+			Err:       err,
+		}
+	}
+	result.DeletedCount = int(deleted.DeletedCount)
+
+	filter := bson.D{{Key: "runId", Value: runID}, {Key: "collection", Value: collectionName}}
+	cursor, err := r.db.Collection(domain.ImportRunVersionsCollection).Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return result, &domain.RepositoryError{
+			Operation: fmt.Sprintf("実行 %s の変更前のドキュメントの検索", runID),
+			Err:       err,
+		}
+	}
+
+	var versions []documentVersion
+	if err := cursor.All(ctx, &versions); err != nil {
+		return result, &domain.RepositoryError{
+			Operation: fmt.Sprintf("実行 %s の変更前のドキュメントの読み込み", runID),
+			Err:       err,
+		}
+	}
+
+	models := make([]mongo.WriteModel, 0, len(versions))
+	sizes := make([]int, 0, len(versions))
+	restored := make(map[string]bool, len(versions))
+	for _, version := range versions {
+		id, ok := domain.Document(version.Document).Get("_id")
+		if !ok {
+			continue
+		}
+		// 型の異なる同じ値を区別するため、BSON にした _id で比較する
+		raw, err := bson.Marshal(bson.D{{Key: "_id", Value: id}})
+		if err != nil || restored[string(raw)] {
+			continue
+		}
+		restored[string(raw)] = true
+
+		// エンコードしたサイズでバッチを分割するため、エンコード済みのドキュメントで置き換える
+		document, err := bson.Marshal(version.Document)
+		if err != nil {
+			return result, &domain.RepositoryError{
+				Operation: fmt.Sprintf("コレクション %s の変更前のドキュメントのエンコード", collectionName),
+				Err:       err,
+			}
+		}
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: id}}).
+			SetReplacement(bson.Raw(document)).
+			SetUpsert(true))
+		sizes = append(sizes, len(document))
+	}
+
+	batchSize, maxBytes := r.batchLimits()
+	for _, batch := range splitBatches(sizes, batchSize, maxBytes) {
+		if _, err := collection.BulkWrite(ctx, models[batch.start:batch.end]); err != nil {
+			return result, &domain.RepositoryError{
+				Operation: fmt.Sprintf("コレクション %s への変更前のドキュメントの書き戻し", collectionName),
+				Err:       err,
+			}
+		}
+		result.RestoredCount += batch.end - batch.start
+	}
+	return result, nil
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// トランザクションの上限に関するエラー
+// NoSuchTransaction（251）はフェイルオーバーや期限切れのセッションでも返されるため含めない
+const (
+	transactionTooLargeCode         = 257                           // TransactionTooLarge: トランザクションのサイズ上限を超えた
+	exceededTimeLimitCode           = 262                           // ExceededTimeLimit: コミットが時間切れになった
+	transactionTooLargeForCacheName = "TransactionTooLargeForCache" // ストレージエンジンのキャッシュに収まらない
+)
+
+// トランザクションの再試行に関するエラーラベル
+const (
+	transientTransactionLabel = "TransientTransactionError"      // トランザクション全体をやり直せば成功する可能性がある
+	unknownCommitResultLabel  = "UnknownTransactionCommitResult" // コミットの結果が分からない（コミットだけをやり直せる）
+)
+
+// WithTransaction fn を1つのトランザクション内で実行する
+// fn に渡されるコンテキストを使った操作はトランザクションに含まれ、
+// fn がエラーを返すかコミットに失敗した場合はすべて取り消される
+// TransientTransactionError ラベルの付いたエラーで失敗した場合は、再試行の設定に従ってトランザクション全体を
+// やり直すため、fn は複数回呼ばれることがある。結果が分からないまま失敗したコミットはコミットだけをやり直す
+// トランザクションの上限を超えた場合は domain.ErrTransactionLimit を含むエラーを返す
+// レプリカセットまたはシャードクラスタが必要
+func (r *MongoRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := r.client.StartSession()
+	if err != nil {
+		return &domain.RepositoryError{
+			Operation: "セッションの開始",
+			Err:       err,
+		}
+	}
+	defer session.EndSession(context.WithoutCancel(ctx))
+
+	for attempt := 0; ; attempt++ {
+		err := r.runTransaction(ctx, session, fn)
+		if err == nil || attempt >= r.retry.MaxRetries || ctx.Err() != nil ||
+			!hasErrorLabel(err, transientTransactionLabel) || errors.Is(err, domain.ErrTransactionLimit) {
+			return err
+		}
+		fmt.Printf("トランザクション: 一時的なエラーのためやり直します（%d/%d回目）: %v\n", attempt+1, r.retry.MaxRetries, err)
+		if waitErr := r.retry.wait(ctx, attempt); waitErr != nil {
+			return err
+		}
+	}
+}
+
+// runTransaction トランザクションを開始して fn を実行し、コミットする
+func (r *MongoRepository) runTransaction(ctx context.Context, session mongo.Session, fn func(ctx context.Context) error) error {
+	txnOptions := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.Majority())
+	if err := session.StartTransaction(txnOptions); err != nil {
+		return &domain.RepositoryError{
+			Operation: "トランザクションの開始",
+			Err:       err,
+		}
+	}
+
+	if err := fn(mongo.NewSessionContext(ctx, session)); err != nil {
+		// 中止はキャンセルされたコンテキストでも実行する
+		_ = session.AbortTransaction(context.WithoutCancel(ctx))
+		if isTransactionLimitError(err, false) {
+			return &domain.RepositoryError{
+				Operation: "トランザクション内の書き込み",
+				Err:       fmt.Errorf("%w: %w", domain.ErrTransactionLimit, err),
+			}
+		}
+		return err
+	}
+
+	if err := r.commitTransaction(ctx, session); err != nil {
+		_ = session.AbortTransaction(context.WithoutCancel(ctx))
+		if isTransactionLimitError(err, true) {
+			err = fmt.Errorf("%w: %w", domain.ErrTransactionLimit, err)
+		}
+		return &domain.RepositoryError{
+			Operation: "トランザクションのコミット",
+			Err:       err,
+		}
+	}
+	return nil
+}
+
+// commitTransaction トランザクションをコミットする
+// 結果が分からないまま失敗したコミットは、再試行の設定に従ってコミットだけをやり直す
+func (r *MongoRepository) commitTransaction(ctx context.Context, session mongo.Session) error {
+	for attempt := 0; ; attempt++ {
+		err := session.CommitTransaction(ctx)
+		if err == nil || attempt >= r.retry.MaxRetries || ctx.Err() != nil ||
+			!hasErrorLabel(err, unknownCommitResultLabel) || isTransactionLimitError(err, true) {
+			return err
+		}
+		fmt.Printf("トランザクションのコミット: 結果が分からないためやり直します（%d/%d回目）: %v\n", attempt+1, r.retry.MaxRetries, err)
+		if waitErr := r.retry.wait(ctx, attempt); waitErr != nil {
+			return err
+		}
+	}
+}
+
+// hasErrorLabel エラーにラベルが付いているかを返す
+func hasErrorLabel(err error, label string) bool {
+	var labeled mongo.LabeledError
+	return errors.As(err, &labeled) && labeled.HasErrorLabel(label)
+}
+
+// isTransactionLimitError トランザクションのサイズまたは時間の上限によるエラーかどうかを返す
+// 時間切れはコミットで起きた場合（commit が true の場合）だけ上限によるエラーとみなす
+func isTransactionLimitError(err error, commit bool) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	if serverErr.HasErrorCode(transactionTooLargeCode) || (commit && serverErr.HasErrorCode(exceededTimeLimitCode)) {
+		return true
+	}
+	return hasErrorName(err, transactionTooLargeForCacheName)
+}
+
+// hasErrorName エラーがコード名 name のサーバーエラーかを返す
+// 書き込みエラーはコード名を持たないため、コマンドのエラーと書き込み保証のエラーだけを調べる
+func hasErrorName(err error, name string) bool {
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && commandErr.Name == name {
+		return true
+	}
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) && writeErr.WriteConcernError != nil && writeErr.WriteConcernError.Name == name {
+		return true
+	}
+	var bulkErr mongo.BulkWriteException
+	return errors.As(err, &bulkErr) && bulkErr.WriteConcernError != nil && bulkErr.WriteConcernError.Name == name
+}
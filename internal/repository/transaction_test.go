@@ -0,0 +1,164 @@
+package repository
+
+// TestWithTransaction パッケージはトランザクションの再試行をテストします。
+//
+// テスト観点:
+// 1. TransientTransactionError ラベルの付いたエラーでトランザクション全体をやり直すか
+// 2. 再試行の上限を超えた場合やラベルのないエラーではやり直さないか
+// 3. 結果の分からないコミットをコミットだけやり直すか
+// 4. 上限によるエラーはラベルが付いていてもやり直さず、domain.ErrTransactionLimit を返すか
+// 5. フェイルオーバーで失われたトランザクション（NoSuchTransaction）を上限によるエラーとせずにやり直すか
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+func TestWithTransaction(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	writeConflict := mtest.CreateCommandErrorResponse(mtest.CommandError{
+		Code:    112,
+		Name:    "WriteConflict",
+		Message: "write conflict",
+		Labels:  []string{transientTransactionLabel},
+	})
+	noSuchTransaction := mtest.CreateCommandErrorResponse(mtest.CommandError{
+		Code:    251,
+		Name:    "NoSuchTransaction",
+		Message: "transaction aborted by a failover",
+		Labels:  []string{transientTransactionLabel},
+	})
+	unknownCommit := mtest.CreateCommandErrorResponse(mtest.CommandError{
+		Code:    91,
+		Name:    "ShutdownInProgress",
+		Message: "shutdown in progress",
+		Labels:  []string{unknownCommitResultLabel},
+	})
+	duplicate := mtest.CreateCommandErrorResponse(mtest.CommandError{
+		Code:    11000,
+		Name:    "DuplicateKey",
+		Message: "duplicate key",
+	})
+	tooLargeForCache := mtest.CreateCommandErrorResponse(mtest.CommandError{
+		Code:    1,
+		Name:    "TransactionTooLargeForCache",
+		Message: "transaction is too large to fit in the cache",
+		Labels:  []string{transientTransactionLabel},
+	})
+	success := mtest.CreateSuccessResponse()
+
+	cases := []struct {
+		name       string
+		maxRetries int
+		responses  []bson.D
+		attempts   int  // fn が呼ばれる回数
+		commits    int  // commitTransaction の送信回数
+		fails      bool // エラーを返すか
+		limit      bool // 上限によるエラーを返すか
+	}{
+		{
+			name:       "書き込みの競合でやり直す",
+			maxRetries: 2,
+			// 1回目: 挿入が失敗して中止、2回目: 挿入とコミットが成功
+			responses: []bson.D{writeConflict, success, success, success},
+			attempts:  2,
+			commits:   1,
+		},
+		{
+			name:       "フェイルオーバーで失われたトランザクションをやり直す",
+			maxRetries: 2,
+			// 1回目: コミットが失敗して中止、2回目: 挿入とコミットが成功
+			responses: []bson.D{success, noSuchTransaction, success, success, success},
+			attempts:  2,
+			commits:   2,
+		},
+		{
+			name:       "結果の分からないコミットだけをやり直す",
+			maxRetries: 2,
+			responses:  []bson.D{success, unknownCommit, success},
+			attempts:   1,
+			commits:    2,
+		},
+		{
+			name:       "再試行が尽きる",
+			maxRetries: 1,
+			responses:  []bson.D{writeConflict, success, writeConflict, success},
+			attempts:   2,
+			fails:      true,
+		},
+		{
+			name:       "上限によるエラーはやり直さない",
+			maxRetries: 2,
+			responses:  []bson.D{tooLargeForCache, success},
+			attempts:   1,
+			fails:      true,
+			limit:      true,
+		},
+		{
+			name:       "ラベルのないエラーはやり直さない",
+			maxRetries: 2,
+			responses:  []bson.D{duplicate, success},
+			attempts:   1,
+			fails:      true,
+		},
+	}
+
+	for _, tc := range cases {
+		mt.Run(tc.name, func(mt *mtest.T) {
+			mt.AddMockResponses(tc.responses...)
+			repo := &MongoRepository{
+				client: mt.Client,
+				db:     mt.Client.Database("test_db"),
+				retry:  RetryPolicy{MaxRetries: tc.maxRetries, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			}
+
+			attempts := 0
+			err := repo.WithTransaction(context.Background(), func(ctx context.Context) error {
+				attempts++
+				_, err := repo.db.Collection("testCollection").InsertOne(ctx, bson.D{{Key: "_id", Value: 1}})
+				return err
+			})
+			if (err != nil) != tc.fails {
+				t.Fatalf("エラーが期待と異なります: fails=%v, err=%v", tc.fails, err)
+			}
+			if errors.Is(err, domain.ErrTransactionLimit) != tc.limit {
+				t.Errorf("上限によるエラーかどうかが期待と異なります: limit=%v, err=%v", tc.limit, err)
+			}
+			if attempts != tc.attempts {
+				t.Errorf("トランザクションの実行回数が一致しません: expected=%d, got=%d", tc.attempts, attempts)
+			}
+
+			commits := 0
+			for _, event := range mt.GetAllStartedEvents() {
+				if event.CommandName == "commitTransaction" {
+					commits++
+				}
+			}
+			if commits != tc.commits {
+				t.Errorf("コミットの送信回数が一致しません: expected=%d, got=%d", tc.commits, commits)
+			}
+		})
+	}
+}
+
+func TestHasErrorLabel(t *testing.T) {
+	err := mongo.CommandError{Code: 251, Labels: []string{transientTransactionLabel}}
+	if !hasErrorLabel(&domain.RepositoryError{Operation: "コミット", Err: err}, transientTransactionLabel) {
+		t.Errorf("ラップされたエラーのラベルを判別するべきです")
+	}
+	if hasErrorLabel(err, unknownCommitResultLabel) {
+		t.Errorf("付いていないラベルを判別するべきではありません")
+	}
+	if hasErrorLabel(errors.New("parse error"), transientTransactionLabel) {
+		t.Errorf("サーバー以外のエラーにラベルはありません")
+	}
+}
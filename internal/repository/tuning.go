@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BatchTuning バッチサイズの自動調整の設定
+// 設定したバッチサイズから始め、1バッチの往復時間が目標に近づくように範囲内で増減させる
+type BatchTuning struct {
+	TargetLatency time.Duration // 1バッチの往復時間の目標
+	MinSize       int           // バッチサイズの下限
+	MaxSize       int           // バッチサイズの上限
+}
+
+// 調整の度合い
+const (
+	maxGrowth      = 2.0  // 1回の調整で大きくする最大の倍率
+	maxShrink      = 0.5  // 往復時間が目標を超えたときに小さくする最大の倍率
+	backoffShrink  = 0.25 // 書き込みの競合やタイムアウトが起きたときの倍率
+	throughputDrop = 0.9  // これより前回のスループットが落ちた場合は大きくしない
+)
+
+// writeConflictCode 書き込みの競合を表すエラーコード
+const writeConflictCode = 112
+
+// batchTuner コレクションごとのバッチサイズを観測した往復時間に応じて調整する
+// 同じコレクションへの並列の書き込みで共有される
+type batchTuner struct {
+	mu         sync.Mutex
+	tuning     *BatchTuning // nil の場合は調整しない
+	size       int          // 次のバッチのドキュメント数
+	throughput float64      // 直前のバッチのスループット（件/秒）
+}
+
+// tuner コレクションへの書き込みに使うバッチサイズの調整器を返す
+// 自動調整が無効な場合は設定したバッチサイズのままの調整器を返す
+func (r *MongoRepository) tuner(collectionName string) *batchTuner {
+	batchSize, _ := r.batchLimits()
+	if r.tuning == nil {
+		return &batchTuner{size: batchSize}
+	}
+
+	r.tunersMu.Lock()
+	defer r.tunersMu.Unlock()
+	if r.tuners == nil {
+		r.tuners = make(map[string]*batchTuner)
+	}
+	t, ok := r.tuners[collectionName]
+	if !ok {
+		t = &batchTuner{tuning: r.tuning, size: r.tuning.clamp(batchSize)}
+		r.tuners[collectionName] = t
+	}
+	return t
+}
+
+// clamp バッチサイズを下限と上限の範囲に収める
+func (c *BatchTuning) clamp(size int) int {
+	minSize := max(c.MinSize, 1)
+	maxSize := max(c.MaxSize, minSize)
+	return min(max(size, minSize), maxSize)
+}
+
+// current 次のバッチのドキュメント数を返す
+func (t *batchTuner) current() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// tuned 調整後のバッチサイズを返す（自動調整が無効な場合は 0）
+func (t *batchTuner) tuned() int {
+	if t.tuning == nil {
+		return 0
+	}
+	return t.current()
+}
+
+// observe バッチの件数・往復時間・エラーから次のバッチサイズを決める
+//   - 書き込みの競合やタイムアウト: 大きく縮める
+//   - 往復時間が目標を超えた: 目標との比で縮める
+//   - 往復時間が目標未満: スループットが落ちていなければ目標との比で大きくする
+func (t *batchTuner) observe(count int, latency time.Duration, err error) {
+	if t.tuning == nil || count == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if isBackoffError(err) {
+		t.size = t.tuning.clamp(int(float64(t.size) * backoffShrink))
+		t.throughput = 0
+		return
+	}
+	if err != nil || latency <= 0 {
+		return
+	}
+
+	throughput := float64(count) / latency.Seconds()
+	ratio := float64(t.tuning.TargetLatency) / float64(latency)
+	switch {
+	case ratio < 1:
+		t.size = t.tuning.clamp(int(float64(t.size) * max(ratio, maxShrink)))
+	case throughput >= t.throughput*throughputDrop:
+		t.size = t.tuning.clamp(int(float64(t.size) * min(ratio, maxGrowth)))
+	}
+	t.throughput = throughput
+}
+
+// isBackoffError サーバーが過負荷であることを示す、書き込みの競合またはタイムアウトのエラーかを返す
+func isBackoffError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if mongo.IsTimeout(err) {
+		return true
+	}
+
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(writeConflictCode)
+}
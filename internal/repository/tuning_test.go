@@ -0,0 +1,93 @@
+package repository
+
+// TestBatchTuner パッケージはバッチサイズの自動調整をテストします。
+//
+// テスト観点:
+// 1. 往復時間が目標より短い場合にバッチサイズを大きくし、上限で止めるか
+// 2. 往復時間が目標より長い場合にバッチサイズを小さくし、下限で止めるか
+// 3. スループットが落ちた場合にバッチサイズを大きくしないか
+// 4. 書き込みの競合やタイムアウトで大きく縮めるか
+// 5. 自動調整が無効な場合はバッチサイズを変えないか
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestBatchTuner(t *testing.T) {
+	tuning := &BatchTuning{TargetLatency: 100 * time.Millisecond, MinSize: 100, MaxSize: 5000}
+
+	t.Run("目標より速い", func(t *testing.T) {
+		tuner := &batchTuner{tuning: tuning, size: 1000}
+		tuner.observe(1000, 80*time.Millisecond, nil)
+		if tuner.current() != 1250 {
+			t.Errorf("目標との比で大きくするべきです: %d", tuner.current())
+		}
+		tuner.observe(1250, 10*time.Millisecond, nil)
+		if tuner.current() != 2500 {
+			t.Errorf("1回に大きくするのは 2倍までであるべきです: %d", tuner.current())
+		}
+		tuner.observe(2500, 10*time.Millisecond, nil)
+		tuner.observe(5000, 10*time.Millisecond, nil)
+		if tuner.current() != 5000 {
+			t.Errorf("上限で止めるべきです: %d", tuner.current())
+		}
+	})
+
+	t.Run("目標より遅い", func(t *testing.T) {
+		tuner := &batchTuner{tuning: tuning, size: 1000}
+		tuner.observe(1000, 125*time.Millisecond, nil)
+		if tuner.current() != 800 {
+			t.Errorf("目標との比で小さくするべきです: %d", tuner.current())
+		}
+		tuner.observe(800, time.Second, nil)
+		if tuner.current() != 400 {
+			t.Errorf("1回に小さくするのは半分までであるべきです: %d", tuner.current())
+		}
+		tuner.observe(400, time.Second, nil)
+		tuner.observe(200, time.Second, nil)
+		if tuner.current() != 100 {
+			t.Errorf("下限で止めるべきです: %d", tuner.current())
+		}
+	})
+
+	t.Run("スループットの低下", func(t *testing.T) {
+		tuner := &batchTuner{tuning: tuning, size: 1000}
+		tuner.observe(1000, 20*time.Millisecond, nil) // 50000件/秒
+		tuner.observe(2000, 80*time.Millisecond, nil) // 25000件/秒
+		if tuner.current() != 2000 {
+			t.Errorf("スループットが落ちた場合は大きくするべきではありません: %d", tuner.current())
+		}
+	})
+
+	t.Run("書き込みの競合とタイムアウト", func(t *testing.T) {
+		conflict := mongo.CommandError{Code: writeConflictCode, Message: "WriteConflict"}
+		for _, err := range []error{conflict, fmt.Errorf("バッチ 3: %w", context.DeadlineExceeded)} {
+			tuner := &batchTuner{tuning: tuning, size: 4000}
+			tuner.observe(4000, time.Millisecond, err)
+			if tuner.current() != 1000 {
+				t.Errorf("%v で 4分の1 に縮めるべきです: %d", err, tuner.current())
+			}
+		}
+
+		// それ以外のエラーではバッチサイズを変えない
+		tuner := &batchTuner{tuning: tuning, size: 4000}
+		tuner.observe(4000, time.Millisecond, errors.New("duplicate key"))
+		if tuner.current() != 4000 {
+			t.Errorf("バッチサイズを変えるべきではありません: %d", tuner.current())
+		}
+	})
+
+	t.Run("自動調整なし", func(t *testing.T) {
+		tuner := (&MongoRepository{batchSize: 300}).tuner("users")
+		tuner.observe(300, time.Millisecond, nil)
+		if tuner.current() != 300 || tuner.tuned() != 0 {
+			t.Errorf("設定したバッチサイズのままであるべきです: %d", tuner.current())
+		}
+	})
+}
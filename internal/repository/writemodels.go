@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// buildWriteModel モードに応じた BulkWrite 用の書き込みモデルを作成する
+//   - upsert:  キーで一致したドキュメントを置き換え、なければ挿入する
+//   - replace: キーで一致したドキュメントのみ置き換える
+//   - merge:   入力にあるフィールドのみ $set で更新し、なければ挿入する
+func buildWriteModel(doc domain.Document, opts domain.WriteOptions) (mongo.WriteModel, error) {
+	filter, err := keyFilter(doc, opts.KeyFields)
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.Mode {
+	case domain.ImportModeUpsert:
+		return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(bson.D(doc)).SetUpsert(true), nil
+	case domain.ImportModeReplace:
+		return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(bson.D(doc)), nil
+	case domain.ImportModeMerge:
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(mergeUpdate(doc)).SetUpsert(true), nil
+	}
+	return nil, fmt.Errorf("unsupported write mode %q", opts.Mode)
+}
+
+// keyFilter キーフィールドの値から照合条件を作成する（キーフィールドが空の場合は _id を使う）
+func keyFilter(doc domain.Document, keyFields []string) (bson.D, error) {
+	if len(keyFields) == 0 {
+		keyFields = []string{"_id"}
+	}
+
+	filter := make(bson.D, 0, len(keyFields))
+	for _, field := range keyFields {
+		value, ok := doc.Lookup(field)
+		if !ok {
+			return nil, fmt.Errorf("key field %q is missing", field)
+		}
+		filter = append(filter, bson.E{Key: field, Value: value})
+	}
+	return filter, nil
+}
+
+// mergeUpdate 入力にあるフィールドを $set する更新内容を作成する
+// _id は変更できないため、新規作成時にのみ設定されるよう $setOnInsert に分ける
+func mergeUpdate(doc domain.Document) bson.D {
+	fields := make(bson.D, 0, len(doc))
+	var id any
+	hasID := false
+	for _, elem := range doc {
+		if elem.Key == "_id" {
+			id, hasID = elem.Value, true
+			continue
+		}
+		fields = append(fields, elem)
+	}
+
+	update := bson.D{{Key: "$set", Value: fields}}
+	if hasID {
+		update = append(update, bson.E{Key: "$setOnInsert", Value: bson.D{{Key: "_id", Value: id}}})
+	}
+	return update
+}
+
+// mergePipeline 別のコレクションのドキュメントをモードに応じて target に書き込む $merge パイプラインを作成する
+//   - insert:  新規挿入し、キーが一致するドキュメントがあれば失敗する
+//   - upsert:  キーで一致したドキュメントを置き換え、なければ挿入する
+//   - replace: キーで一致したドキュメントのみ置き換える
+//   - merge:   一致したドキュメントにフィールドをマージし、なければ挿入する
+//
+// _id 以外のキーで照合する場合、target にはキーフィールドの一意インデックスが必要で、
+// 既存ドキュメントの _id を保つため書き込む側の _id は取り除く
+func mergePipeline(target string, opts domain.WriteOptions) (mongo.Pipeline, error) {
+	var whenMatched, whenNotMatched string
+	switch opts.Mode {
+	case domain.ImportModeInsert:
+		whenMatched, whenNotMatched = "fail", "insert"
+	case domain.ImportModeUpsert:
+		whenMatched, whenNotMatched = "replace", "insert"
+	case domain.ImportModeReplace:
+		whenMatched, whenNotMatched = "replace", "discard"
+	case domain.ImportModeMerge:
+		whenMatched, whenNotMatched = "merge", "insert"
+	default:
+		return nil, fmt.Errorf("unsupported write mode %q", opts.Mode)
+	}
+
+	keyFields := opts.KeyFields
+	if len(keyFields) == 0 || opts.Mode == domain.ImportModeInsert {
+		keyFields = []string{"_id"}
+	}
+
+	var pipeline mongo.Pipeline
+	if len(keyFields) != 1 || keyFields[0] != "_id" {
+		pipeline = append(pipeline, bson.D{{Key: "$unset", Value: "_id"}})
+	}
+	on := make(bson.A, 0, len(keyFields))
+	for _, field := range keyFields {
+		on = append(on, field)
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$merge", Value: bson.D{
+		{Key: "into", Value: target},
+		{Key: "on", Value: on},
+		{Key: "whenMatched", Value: whenMatched},
+		{Key: "whenNotMatched", Value: whenNotMatched},
+	}}})
+	return pipeline, nil
+}
+
+// documentFailures BulkWriteException をドキュメントごとの失敗情報に変換する
+// offset はバッチの先頭ドキュメントの位置で、各失敗の Index に加算される
+// 書き込み保証（write concern）のエラーなど、ドキュメント単位でないエラーの場合は false を返す
+func documentFailures(err error, offset int) ([]domain.DocumentFailure, bool) {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return nil, false
+	}
+
+	failures := make([]domain.DocumentFailure, 0, len(bulkErr.WriteErrors))
+	for _, writeErr := range bulkErr.WriteErrors {
+		failures = append(failures, domain.DocumentFailure{
+			Index:   offset + writeErr.Index,
+			Code:    writeErr.Code,
+			Message: writeErr.Message,
+		})
+	}
+	return failures, true
+}
@@ -0,0 +1,157 @@
+package repository
+
+// TestBuildWriteModel パッケージは書き込みモードごとのモデル作成をテストします。
+//
+// テスト観点:
+// 1. upsert/replace はキーで照合する ReplaceOne モデルを作成するか
+// 2. merge は _id を $setOnInsert に分けた UpdateOne モデルを作成するか
+// 3. キーフィールドが欠けている場合にエラーとなるか
+// 4. $merge パイプラインがモードとキーに応じた動作を指定するか
+// 5. トランザクションの上限によるエラーを判別できるか
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+func TestBuildWriteModel(t *testing.T) {
+	doc := domain.Document{
+		{Key: "_id", Value: "u1"},
+		{Key: "email", Value: "a@example.com"},
+		{Key: "profile", Value: bson.D{{Key: "name", Value: "Alice"}}},
+	}
+
+	// upsert: _id で照合し、ドキュメント全体を置き換える
+	model, err := buildWriteModel(doc, domain.WriteOptions{Mode: domain.ImportModeUpsert})
+	if err != nil {
+		t.Fatalf("予期しないエラー: %v", err)
+	}
+	replace, ok := model.(*mongo.ReplaceOneModel)
+	if !ok {
+		t.Fatalf("ReplaceOneModel が期待されましたが %T でした", model)
+	}
+	if !reflect.DeepEqual(replace.Filter, bson.D{{Key: "_id", Value: "u1"}}) {
+		t.Errorf("フィルタが一致しません: %v", replace.Filter)
+	}
+	if replace.Upsert == nil || !*replace.Upsert {
+		t.Errorf("upsert モードでは Upsert が true であるべきです")
+	}
+
+	// replace: 入れ子のキーで照合し、upsert は行わない
+	model, err = buildWriteModel(doc, domain.WriteOptions{Mode: domain.ImportModeReplace, KeyFields: []string{"email", "profile.name"}})
+	if err != nil {
+		t.Fatalf("予期しないエラー: %v", err)
+	}
+	replace = model.(*mongo.ReplaceOneModel)
+	expectedFilter := bson.D{{Key: "email", Value: "a@example.com"}, {Key: "profile.name", Value: "Alice"}}
+	if !reflect.DeepEqual(replace.Filter, expectedFilter) {
+		t.Errorf("フィルタが一致しません: expected=%v, got=%v", expectedFilter, replace.Filter)
+	}
+	if replace.Upsert != nil && *replace.Upsert {
+		t.Errorf("replace モードでは Upsert を設定すべきではありません")
+	}
+
+	// merge: _id は新規作成時のみ設定する
+	model, err = buildWriteModel(doc, domain.WriteOptions{Mode: domain.ImportModeMerge, KeyFields: []string{"email"}})
+	if err != nil {
+		t.Fatalf("予期しないエラー: %v", err)
+	}
+	update, ok := model.(*mongo.UpdateOneModel)
+	if !ok {
+		t.Fatalf("UpdateOneModel が期待されましたが %T でした", model)
+	}
+	expectedUpdate := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "email", Value: "a@example.com"},
+			{Key: "profile", Value: bson.D{{Key: "name", Value: "Alice"}}},
+		}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "_id", Value: "u1"}}},
+	}
+	if !reflect.DeepEqual(update.Update, expectedUpdate) {
+		t.Errorf("更新内容が一致しません: expected=%v, got=%v", expectedUpdate, update.Update)
+	}
+	if update.Upsert == nil || !*update.Upsert {
+		t.Errorf("merge モードでは Upsert が true であるべきです")
+	}
+
+	// キーフィールドが欠けている場合
+	if _, err := buildWriteModel(doc, domain.WriteOptions{Mode: domain.ImportModeUpsert, KeyFields: []string{"code"}}); err == nil {
+		t.Errorf("キーフィールドが欠けている場合はエラーになるべきです")
+	}
+}
+
+func TestMergePipeline(t *testing.T) {
+	// insert: _id で照合し、一致した場合は失敗する
+	pipeline, err := mergePipeline("users", domain.WriteOptions{Mode: domain.ImportModeInsert, KeyFields: []string{"email"}})
+	if err != nil {
+		t.Fatalf("予期しないエラー: %v", err)
+	}
+	expected := mongo.Pipeline{
+		{{Key: "$merge", Value: bson.D{
+			{Key: "into", Value: "users"},
+			{Key: "on", Value: bson.A{"_id"}},
+			{Key: "whenMatched", Value: "fail"},
+			{Key: "whenNotMatched", Value: "insert"},
+		}}},
+	}
+	if !reflect.DeepEqual(pipeline, expected) {
+		t.Errorf("パイプラインが一致しません: expected=%v, got=%v", expected, pipeline)
+	}
+
+	// replace: _id 以外のキーでは既存の _id を保つため _id を取り除く
+	pipeline, err = mergePipeline("users", domain.WriteOptions{Mode: domain.ImportModeReplace, KeyFields: []string{"tenant", "email"}})
+	if err != nil {
+		t.Fatalf("予期しないエラー: %v", err)
+	}
+	expected = mongo.Pipeline{
+		{{Key: "$unset", Value: "_id"}},
+		{{Key: "$merge", Value: bson.D{
+			{Key: "into", Value: "users"},
+			{Key: "on", Value: bson.A{"tenant", "email"}},
+			{Key: "whenMatched", Value: "replace"},
+			{Key: "whenNotMatched", Value: "discard"},
+		}}},
+	}
+	if !reflect.DeepEqual(pipeline, expected) {
+		t.Errorf("パイプラインが一致しません: expected=%v, got=%v", expected, pipeline)
+	}
+
+	// sync など $merge で表せないモード
+	if _, err := mergePipeline("users", domain.WriteOptions{Mode: domain.ImportModeSync}); err == nil {
+		t.Errorf("sync モードはエラーになるべきです")
+	}
+}
+
+func TestIsTransactionLimitError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		commit   bool
+		expected bool
+	}{
+		{"TransactionTooLarge", fmt.Errorf("バッチ 3/7: %w", mongo.CommandError{Code: 257, Name: "TransactionTooLarge"}), false, true},
+		{"TransactionTooLargeForCache", mongo.CommandError{Code: 1, Name: "TransactionTooLargeForCache"}, false, true},
+		{"コミットの時間切れ", mongo.CommandError{Code: 262, Name: "ExceededTimeLimit"}, true, true},
+		{"書き込みの時間切れ", mongo.CommandError{Code: 262, Name: "ExceededTimeLimit"}, false, false},
+		{"フェイルオーバーで失われたトランザクション", mongo.CommandError{
+			Code: 251, Name: "NoSuchTransaction", Labels: []string{"TransientTransactionError"},
+		}, true, false},
+		{"重複キー", mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "duplicate key"}}}, false, false},
+		{"サーバー以外のエラー", errors.New("parse error"), true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransactionLimitError(tt.err, tt.commit); got != tt.expected {
+				t.Errorf("isTransactionLimitError() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// Error budget scopes
+const (
+	BudgetScopeFile = "file" // Failures of a single file
+	BudgetScopeRun  = "run"  // Failures of all files of a run
+)
+
+// ErrorBudget limits how many documents may fail before an import is aborted
+// Zero values mean no limit
+type ErrorBudget struct {
+	MaxErrors     int     // Maximum number of failed documents
+	MaxErrorRatio float64 // Maximum share of failed documents, between 0 and 1
+}
+
+// BudgetExceededError reports that an error budget was exceeded and which threshold was crossed
+type BudgetExceededError struct {
+	Scope         string  // BudgetScopeFile or BudgetScopeRun
+	File          string  // File being imported when the budget was exceeded
+	Errors        int     // Number of failed documents
+	Processed     int     // Number of documents read, including the failed ones
+	MaxErrors     int     // Set when the count threshold was crossed
+	MaxErrorRatio float64 // Set when the ratio threshold was crossed
+}
+
+// Error returns the error message including the crossed threshold
+func (e *BudgetExceededError) Error() string {
+	if e.MaxErrors > 0 {
+		return fmt.Sprintf("%s error budget exceeded in %s: %d documents failed, more than the limit of %d",
+			e.Scope, e.File, e.Errors, e.MaxErrors)
+	}
+	return fmt.Sprintf("%s error budget exceeded in %s: %d of %d documents failed (%.1f%%), more than the limit of %.1f%%",
+		e.Scope, e.File, e.Errors, e.Processed, ratio(e.Errors, e.Processed)*100, e.MaxErrorRatio*100)
+}
+
+// check returns an error if the failed documents exceed the budget
+// The ratio is only checked once at least minSample documents were read, or when
+// final is set, so that a failure early in a file doesn't abort it on its own
+func (b ErrorBudget) check(scope, file string, errors, processed, minSample int, final bool) *BudgetExceededError {
+	if b.MaxErrors > 0 && errors > b.MaxErrors {
+		return &BudgetExceededError{Scope: scope, File: file, Errors: errors, Processed: processed, MaxErrors: b.MaxErrors}
+	}
+	if b.MaxErrorRatio > 0 && (final || processed >= minSample) && ratio(errors, processed) > b.MaxErrorRatio {
+		return &BudgetExceededError{Scope: scope, File: file, Errors: errors, Processed: processed, MaxErrorRatio: b.MaxErrorRatio}
+	}
+	return nil
+}
+
+// ratio returns the share of failed documents
+func ratio(errors, processed int) float64 {
+	if processed == 0 {
+		return 0
+	}
+	return float64(errors) / float64(processed)
+}
+
+// runBudget tracks failed documents across all files of a run
+// Files of a directory are imported in parallel, so it is safe for concurrent use
+type runBudget struct {
+	budget ErrorBudget
+
+	mu        sync.Mutex
+	errors    int
+	processed int
+	exceeded  *BudgetExceededError // Set once any budget is exceeded; the rest of the run is aborted
+}
+
+// add adds counts of a file to the run totals and checks the budget
+func (r *runBudget) add(file string, errors, processed, minSample int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.exceeded != nil {
+		return r.exceeded
+	}
+
+	r.errors += errors
+	r.processed += processed
+	if err := r.budget.check(BudgetScopeRun, file, r.errors, r.processed, minSample, false); err != nil {
+		r.exceeded = err
+		return err
+	}
+	return nil
+}
+
+// abort records a budget exceeded by a single file, which aborts the run as well
+func (r *runBudget) abort(err *BudgetExceededError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.exceeded == nil {
+		r.exceeded = err
+	}
+}
+
+// err returns the error of an exceeded budget, or nil
+func (r *runBudget) err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.exceeded != nil {
+		return r.exceeded
+	}
+	return nil
+}
+
+// finish checks the run totals once every file has been imported
+// path names the imported file or directory in the error message
+func (r *runBudget) finish(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.exceeded != nil {
+		return r.exceeded
+	}
+	if err := r.budget.check(BudgetScopeRun, path, r.errors, r.processed, 0, true); err != nil {
+		r.exceeded = err
+		return err
+	}
+	return nil
+}
+
+// budgetTracker enforces the error budgets while one file is imported
+type budgetTracker struct {
+	file      string      // File being imported
+	budget    ErrorBudget // Per-file budget
+	run       *runBudget  // Per-run budget shared by all files
+	minSample int         // Documents to read before checking ratios
+	errors    int         // Failed documents already added to the run totals
+	processed int         // Read documents already added to the run totals
+}
+
+// check checks the file and run budgets against the counts of the result so far
+// inFlight is the number of read documents whose batches are still being written;
+// they are left out until their outcome is known, so they don't dilute the error ratio
+// final is set once the whole file has been read
+// Exceeding the file budget aborts the rest of the run too
+func (t *budgetTracker) check(result *domain.ImportResult, inFlight int, final bool) error {
+	committed := result.ProcessedCount - inFlight
+
+	// Only the counts added since the previous check go to the run totals
+	errors, processed := result.ErrorCount-t.errors, committed-t.processed
+	t.errors, t.processed = result.ErrorCount, committed
+	runErr := t.run.add(t.file, errors, processed, t.minSample)
+
+	if err := t.budget.check(BudgetScopeFile, t.file, result.ErrorCount, committed, t.minSample, final); err != nil {
+		t.run.abort(err)
+		return err
+	}
+	return runErr
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// TestErrorBudgetCheck tests the count and ratio thresholds of an error budget
+func TestErrorBudgetCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		budget    ErrorBudget
+		errors    int
+		processed int
+		final     bool
+		exceeded  bool
+	}{
+		{name: "no limit", budget: ErrorBudget{}, errors: 100, processed: 100},
+		{name: "count within limit", budget: ErrorBudget{MaxErrors: 3}, errors: 3, processed: 10},
+		{name: "count exceeded", budget: ErrorBudget{MaxErrors: 3}, errors: 4, processed: 10, exceeded: true},
+		{name: "ratio exceeded", budget: ErrorBudget{MaxErrorRatio: 0.1}, errors: 20, processed: 100, exceeded: true},
+		{name: "ratio before sample", budget: ErrorBudget{MaxErrorRatio: 0.1}, errors: 2, processed: 5},
+		{name: "ratio at end of file", budget: ErrorBudget{MaxErrorRatio: 0.1}, errors: 2, processed: 5, final: true, exceeded: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.budget.check(BudgetScopeFile, "users.json", tt.errors, tt.processed, 10, tt.final)
+			if (err != nil) != tt.exceeded {
+				t.Fatalf("Expected exceeded=%v, got %v", tt.exceeded, err)
+			}
+			if err == nil {
+				return
+			}
+			// Only the crossed threshold is reported
+			if tt.budget.MaxErrors > 0 && err.MaxErrorRatio != 0 || tt.budget.MaxErrorRatio > 0 && err.MaxErrors != 0 {
+				t.Errorf("Expected only the crossed threshold, got %+v", err)
+			}
+		})
+	}
+}
+
+// TestImportFileErrorBudget tests that a file is aborted once its error budget is exceeded
+func TestImportFileErrorBudget(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			docs := make([]bson.D, 0, 6)
+			for i := 0; i < 6; i++ {
+				docs = append(docs, bson.D{{Key: "n", Value: i}})
+			}
+			return docs, nil
+		},
+	}
+
+	writes := 0
+	mockRepo := &MockRepository{
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			writes++
+			// The first document of every batch fails
+			return &domain.ImportResult{
+				CollectionName: collectionName,
+				InsertedCount:  len(documents) - 1,
+				Failed:         []domain.DocumentFailure{{Index: 0, Code: 121, Message: "Document failed validation"}},
+			}, nil
+		},
+	}
+
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{
+		BatchSize:       2,
+		Unordered:       true,
+		FileErrorBudget: ErrorBudget{MaxErrors: 1},
+	})
+	result, err := importer.ImportFile("/data/users.json")
+
+	var budgetErr *BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("Expected a BudgetExceededError, got %v", err)
+	}
+	if budgetErr.Scope != BudgetScopeFile || budgetErr.MaxErrors != 1 || budgetErr.Errors != 2 {
+		t.Errorf("Unexpected budget error: %+v", budgetErr)
+	}
+
+	// The third batch is never written
+	if writes != 2 {
+		t.Errorf("Expected 2 batches to be written, got %d", writes)
+	}
+	if result.ProcessedCount != 4 || result.ErrorCount != 2 || result.InsertedCount != 2 {
+		t.Errorf("Expected 4 processed, 2 failed and 2 inserted documents, got %+v", result)
+	}
+
+	// An exceeded budget aborts the rest of the run
+	if _, err := importer.ImportFile("/data/orders.json"); !errors.As(err, &budgetErr) {
+		t.Errorf("Expected later files to be skipped, got %v", err)
+	}
+}
+
+// TestImportDirectoryErrorBudget tests that the run budget is checked across all files
+func TestImportDirectoryErrorBudget(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		IsDirectoryFunc: func(path string) (bool, error) {
+			return true, nil
+		},
+		FindJSONFilesFunc: func(dirPath string) ([]string, error) {
+			return []string{"/data/users.json", "/data/orders.json"}, nil
+		},
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "n", Value: 1}}, {{Key: "n", Value: 2}}}, nil
+		},
+	}
+
+	mockRepo := &MockRepository{
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			return &domain.ImportResult{
+				CollectionName: collectionName,
+				InsertedCount:  len(documents) - 1,
+				Failed:         []domain.DocumentFailure{{Index: 0, Code: 121, Message: "Document failed validation"}},
+			}, nil
+		},
+	}
+
+	// Each file stays within the file budget, but together they exceed the run budget
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{
+		BatchSize:       10,
+		Unordered:       true,
+		FileErrorBudget: ErrorBudget{MaxErrorRatio: 0.5},
+		RunErrorBudget:  ErrorBudget{MaxErrors: 1},
+	})
+	_, err := importer.ImportPath("/data")
+
+	var budgetErr *BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("Expected a BudgetExceededError, got %v", err)
+	}
+	if budgetErr.Scope != BudgetScopeRun || budgetErr.MaxErrors != 1 {
+		t.Errorf("Unexpected budget error: %+v", budgetErr)
+	}
+}
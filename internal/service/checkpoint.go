@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// fileCheckpoint tracks how far the import of one file has been written
+type fileCheckpoint struct {
+	checkpoint domain.Checkpoint
+	path       string // Path of the file as given, hashed when the checkpoint is first saved
+	resumeFrom int    // Documents before this position were written by an interrupted import
+}
+
+// written reports whether the document at position was written by an interrupted import,
+// either before its checkpoint or in a batch that finished ahead of it
+func (cp *fileCheckpoint) written(position int) bool {
+	if cp == nil {
+		return false
+	}
+	if position < cp.resumeFrom {
+		return true
+	}
+	for _, span := range cp.checkpoint.Ahead {
+		if span.Contains(position) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkResumeMode returns an error if imports can't be resumed with the configured options
+// Transactions write a file as a whole, and reload, swap and sync rewrite whole collections
+func (m *MongoImporter) checkResumeMode() error {
+	if m.transaction != domain.TransactionNone {
+		return fmt.Errorf("imports in a transaction can't be resumed")
+	}
+	switch m.writeOptions.Mode {
+	case domain.ImportModeReload, domain.ImportModeSwap, domain.ImportModeSync:
+		return fmt.Errorf("imports can't be resumed in %s mode", m.writeOptions.Mode)
+	}
+	return nil
+}
+
+// startCheckpoint prepares the checkpoint of a file before it is imported
+// With resume set, the checkpoint left by an interrupted import of the file is continued,
+// unless the file changed since then
+// The file is only hashed to check a saved checkpoint or when the first checkpoint is saved
+func (m *MongoImporter) startCheckpoint(filePath, collectionName string) (*fileCheckpoint, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving path %s: %w", filePath, err)
+	}
+
+	cp := &fileCheckpoint{path: filePath, checkpoint: domain.Checkpoint{
+		File:       absPath,
+		Collection: collectionName,
+		RunID:      m.runID,
+	}}
+	if !m.resume {
+		return cp, nil
+	}
+
+	saved, err := m.repo.FindCheckpoint(m.ctx, absPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint of %s: %w", filePath, err)
+	}
+	if saved == nil {
+		// Nothing was written by an earlier import, so the file is imported from the start
+		return cp, nil
+	}
+	if err := m.hashCheckpoint(cp); err != nil {
+		return nil, err
+	}
+	if saved.Hash != cp.checkpoint.Hash {
+		return nil, fmt.Errorf("%s changed since its import was interrupted, refusing to resume (import it without resuming to start over)", filePath)
+	}
+	if saved.Collection != collectionName {
+		return nil, fmt.Errorf("%s was being imported into collection %s, refusing to resume into %s", filePath, saved.Collection, collectionName)
+	}
+
+	cp.checkpoint.Documents = saved.Documents
+	cp.checkpoint.Batches = saved.Batches
+	cp.checkpoint.Ahead = saved.Ahead
+	cp.resumeFrom = saved.Documents
+	fmt.Printf("Resuming %s after %d documents (%d batches)\n", filePath, saved.Documents, saved.Batches)
+	return cp, nil
+}
+
+// commitCheckpoint records that the documents of a file before position were written
+func (m *MongoImporter) commitCheckpoint(cp *fileCheckpoint, position int) error {
+	cp.checkpoint.Batches++
+	return m.saveCheckpoint(cp, position)
+}
+
+// commitRejects records that the documents before position were handled when the last
+// of them were rejected by the parser after the last written batch, so resuming
+// doesn't pass them to the dead-letter sink again
+func (m *MongoImporter) commitRejects(cp *fileCheckpoint, position int) error {
+	if position <= cp.checkpoint.Documents {
+		return nil
+	}
+	return m.saveCheckpoint(cp, position)
+}
+
+// saveCheckpoint saves the checkpoint of a file with the documents before position handled
+func (m *MongoImporter) saveCheckpoint(cp *fileCheckpoint, position int) error {
+	cp.checkpoint.Documents = position
+
+	// Batches written ahead are no longer needed once the checkpoint has passed them
+	var ahead []domain.PositionRange
+	for _, span := range cp.checkpoint.Ahead {
+		if span.End > position {
+			ahead = append(ahead, span)
+		}
+	}
+	cp.checkpoint.Ahead = ahead
+
+	if err := m.hashCheckpoint(cp); err != nil {
+		return fmt.Errorf("error saving checkpoint: %w", err)
+	}
+	cp.checkpoint.UpdatedAt = time.Now().UTC()
+	if err := m.repo.SaveCheckpoint(m.ctx, &cp.checkpoint); err != nil {
+		return fmt.Errorf("error saving checkpoint: %w", err)
+	}
+	return nil
+}
+
+// hashCheckpoint sets the hash of the file of a checkpoint, unless it is already set
+func (m *MongoImporter) hashCheckpoint(cp *fileCheckpoint) error {
+	if cp.checkpoint.Hash != "" {
+		return nil
+	}
+	hash, err := m.fileHash(cp.path)
+	if err != nil {
+		return err
+	}
+	cp.checkpoint.Hash = hash
+	return nil
+}
+
+// saveAhead records batches that were written after the batch an import stopped at,
+// so that resuming the import doesn't write them again
+// It is saved even when the import was cancelled, and a failure only means they are written again
+func (m *MongoImporter) saveAhead(cp *fileCheckpoint, spans []domain.PositionRange) {
+	cp.checkpoint.Ahead = append(cp.checkpoint.Ahead, spans...)
+	if err := m.hashCheckpoint(cp); err != nil {
+		fmt.Printf("Warning: could not record the batches written ahead in the checkpoint of %s: %v\n", cp.checkpoint.File, err)
+		return
+	}
+	cp.checkpoint.UpdatedAt = time.Now().UTC()
+	if err := m.repo.SaveCheckpoint(context.WithoutCancel(m.ctx), &cp.checkpoint); err != nil {
+		fmt.Printf("Warning: could not record the batches written ahead in the checkpoint of %s: %v\n", cp.checkpoint.File, err)
+	}
+}
+
+// finishCheckpoint removes the checkpoint of a file that was imported completely
+func (m *MongoImporter) finishCheckpoint(cp *fileCheckpoint) {
+	if err := m.repo.DeleteCheckpoint(context.WithoutCancel(m.ctx), cp.checkpoint.File); err != nil {
+		fmt.Printf("Warning: could not remove the checkpoint of %s: %v\n", cp.checkpoint.File, err)
+	}
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+	"github.com/OTakumi/data-importer/internal/utils"
+)
+
+// checkpointFileUtils returns file utilities serving five numbered documents with the given hash
+func checkpointFileUtils(hash string) *MockFileUtils {
+	return &MockFileUtils{
+		IsDirectoryFunc: func(path string) (bool, error) {
+			return false, nil
+		},
+		FileHashFunc: func(path string) (string, error) {
+			return hash, nil
+		},
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			var documents []bson.D
+			for n := 0; n < 5; n++ {
+				documents = append(documents, bson.D{{Key: "n", Value: n}})
+			}
+			return documents, nil
+		},
+	}
+}
+
+// TestImportFileCheckpoints tests that each written batch is checkpointed and the checkpoint removed at the end
+func TestImportFileCheckpoints(t *testing.T) {
+	var saved []domain.Checkpoint
+	var deleted []string
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+		SaveCheckpointFunc: func(ctx context.Context, checkpoint *domain.Checkpoint) error {
+			saved = append(saved, *checkpoint)
+			return nil
+		},
+		DeleteCheckpointFunc: func(ctx context.Context, file string) error {
+			deleted = append(deleted, file)
+			return nil
+		},
+	}
+
+	importer := NewMongoImporter(context.Background(), checkpointFileUtils("abc"), mockRepo, ImporterOptions{BatchSize: 2})
+	if _, err := importer.ImportFile("/data/users.json"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var progress [][2]int
+	for _, checkpoint := range saved {
+		if checkpoint.File != "/data/users.json" || checkpoint.Collection != "users" || checkpoint.Hash != "abc" {
+			t.Errorf("Unexpected checkpoint %+v", checkpoint)
+		}
+		progress = append(progress, [2]int{checkpoint.Documents, checkpoint.Batches})
+	}
+	if expected := [][2]int{{2, 1}, {4, 2}, {5, 3}}; !reflect.DeepEqual(progress, expected) {
+		t.Errorf("Expected checkpoints %v, got %v", expected, progress)
+	}
+	if !reflect.DeepEqual(deleted, []string{"/data/users.json"}) {
+		t.Errorf("Expected the checkpoint to be removed, got %v", deleted)
+	}
+}
+
+// TestImportFileResume tests that a resumed import skips the documents written before
+func TestImportFileResume(t *testing.T) {
+	interrupted := &domain.Checkpoint{File: "/data/users.json", Collection: "users", Hash: "abc", Documents: 3, Batches: 1}
+
+	t.Run("Resume", func(t *testing.T) {
+		var written []any
+		var saved []domain.Checkpoint
+		mockRepo := &MockRepository{
+			FindCheckpointFunc: func(ctx context.Context, file string) (*domain.Checkpoint, error) {
+				return interrupted, nil
+			},
+			InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+				for _, doc := range documents {
+					n, _ := doc.Get("n")
+					written = append(written, n)
+				}
+				return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+			},
+			SaveCheckpointFunc: func(ctx context.Context, checkpoint *domain.Checkpoint) error {
+				saved = append(saved, *checkpoint)
+				return nil
+			},
+		}
+
+		importer := NewMongoImporter(context.Background(), checkpointFileUtils("abc"), mockRepo, ImporterOptions{BatchSize: 2, Resume: true})
+		output, err := importer.ImportPath("/data/users.json")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		result := output.(*domain.ImportResult)
+		if !reflect.DeepEqual(written, []any{3, 4}) {
+			t.Errorf("Expected only documents 3 and 4 to be written, got %v", written)
+		}
+		if result.ResumedCount != 3 || result.InsertedCount != 2 || result.ProcessedCount != 2 {
+			t.Errorf("Expected 3 skipped and 2 inserted documents, got %+v", result)
+		}
+		// The batch counter continues from the interrupted import
+		if len(saved) != 2 || saved[1].Documents != 5 || saved[1].Batches != 3 {
+			t.Errorf("Expected checkpoints up to 5 documents in 3 batches, got %+v", saved)
+		}
+	})
+
+	t.Run("ChangedFile", func(t *testing.T) {
+		mockRepo := &MockRepository{
+			FindCheckpointFunc: func(ctx context.Context, file string) (*domain.Checkpoint, error) {
+				return interrupted, nil
+			},
+			InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+				t.Error("Expected nothing to be written")
+				return &domain.ImportResult{}, nil
+			},
+		}
+
+		importer := NewMongoImporter(context.Background(), checkpointFileUtils("def"), mockRepo, ImporterOptions{BatchSize: 2, Resume: true})
+		if _, err := importer.ImportPath("/data/users.json"); err == nil || !strings.Contains(err.Error(), "refusing to resume") {
+			t.Errorf("Expected the resume to be refused, got %v", err)
+		}
+	})
+
+	t.Run("UnsupportedMode", func(t *testing.T) {
+		importer := NewMongoImporter(context.Background(), checkpointFileUtils("abc"), &MockRepository{}, ImporterOptions{
+			Mode:   domain.ImportModeReload,
+			Resume: true,
+		})
+		if _, err := importer.ImportPath("/data/users.json"); err == nil || !strings.Contains(err.Error(), "can't be resumed") {
+			t.Errorf("Expected the resume to be refused in reload mode, got %v", err)
+		}
+	})
+}
+
+// TestImportFileHashes tests that a file is hashed once for its ledger entry and checkpoints,
+// and not at all when no checkpoint is saved
+func TestImportFileHashes(t *testing.T) {
+	var mu sync.Mutex
+	hashed := make(map[string]int)
+	mockFileUtils := checkpointFileUtils("abc")
+	mockFileUtils.FindJSONFilesFunc = func(dirPath string) ([]string, error) {
+		return []string{"/data/a.json", "/data/b.json"}, nil
+	}
+	mockFileUtils.FileHashFunc = func(path string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		hashed[path]++
+		return "abc", nil
+	}
+
+	newRepo := func(fail bool) *MockRepository {
+		return &MockRepository{
+			InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+				if fail {
+					return nil, errors.New("connection refused")
+				}
+				return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+			},
+		}
+	}
+
+	t.Run("Directory", func(t *testing.T) {
+		clear(hashed)
+		importer := NewMongoImporter(context.Background(), mockFileUtils, newRepo(false), ImporterOptions{BatchSize: 2})
+		if _, err := importer.ImportDirectory("/data"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := map[string]int{"/data/a.json": 1, "/data/b.json": 1}; !reflect.DeepEqual(hashed, expected) {
+			t.Errorf("Expected each file to be hashed once, got %v", hashed)
+		}
+	})
+
+	t.Run("NoCheckpointSaved", func(t *testing.T) {
+		clear(hashed)
+		importer := NewMongoImporter(context.Background(), mockFileUtils, newRepo(true), ImporterOptions{BatchSize: 2})
+		if _, err := importer.ImportFile("/data/a.json"); err == nil {
+			t.Fatal("Expected an error but got none")
+		}
+		if len(hashed) != 0 {
+			t.Errorf("Expected the file not to be hashed, got %v", hashed)
+		}
+	})
+}
+
+// TestImportFileCheckpointTrailingRejects tests that documents rejected by the parser after
+// the last written batch are checkpointed, so resuming doesn't reject them again
+func TestImportFileCheckpointTrailingRejects(t *testing.T) {
+	mockFileUtils := checkpointFileUtils("abc")
+	mockFileUtils.StreamRejectsFunc = func(filePath string, batchSize int, handler utils.BatchHandler, reject utils.RejectHandler) error {
+		if err := handler([]bson.D{{{Key: "n", Value: 0}}, {{Key: "n", Value: 1}}}); err != nil {
+			return err
+		}
+		for position := 2; position < 4; position++ {
+			if err := reject(position, `{"n":`, &utils.ParseError{File: filePath, Line: position + 1, Err: errors.New("unexpected EOF")}); err != nil {
+				return err
+			}
+		}
+		// The file ends in a syntax error the parser can't skip
+		return &utils.ParseError{File: filePath, Line: 5, Err: errors.New("unexpected EOF")}
+	}
+
+	var saved *domain.Checkpoint
+	mockRepo := &MockRepository{
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+		SaveCheckpointFunc: func(ctx context.Context, checkpoint *domain.Checkpoint) error {
+			copied := *checkpoint
+			saved = &copied
+			return nil
+		},
+		FindCheckpointFunc: func(ctx context.Context, file string) (*domain.Checkpoint, error) {
+			return saved, nil
+		},
+	}
+
+	sink := &MockDeadLetterSink{}
+	importer := NewMongoImporter(context.Background(), mockFileUtils, mockRepo, ImporterOptions{BatchSize: 2, DeadLetter: sink})
+	if _, err := importer.ImportFile("/data/users.jsonl"); err == nil {
+		t.Fatal("Expected an error but got none")
+	}
+	if saved == nil || saved.Documents != 4 || saved.Batches != 1 {
+		t.Fatalf("Expected the checkpoint to cover the rejected documents, got %+v", saved)
+	}
+
+	resumed := NewMongoImporter(context.Background(), mockFileUtils, mockRepo, ImporterOptions{BatchSize: 2, DeadLetter: sink, Resume: true})
+	result, _ := resumed.ImportFile("/data/users.jsonl")
+	if len(sink.Rejected) != 2 || result.ResumedCount != 4 {
+		t.Errorf("Expected the rejected documents to be skipped when resuming, got %d rejects and %+v", len(sink.Rejected), result)
+	}
+}
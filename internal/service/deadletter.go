@@ -0,0 +1,329 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+	"github.com/OTakumi/data-importer/internal/utils"
+)
+
+// DeadLetterCollectionSuffix is appended to a collection name to name the
+// collection that receives its rejected documents
+const DeadLetterCollectionSuffix = "_rejected"
+
+// DeadLetterSink receives documents that could not be parsed, converted or written
+// Implementations must be safe for concurrent use, since the files of a
+// directory are imported in parallel
+type DeadLetterSink interface {
+	// Reject records one rejected document
+	Reject(rejected domain.RejectedDocument) error
+	// Close flushes and releases the sink
+	Close() error
+}
+
+// FileDeadLetterSink writes rejected documents as NDJSON next to their source file
+// (see utils.DeadLetterPath), one canonical Extended JSON record per line so that
+// replaying a record restores the exact BSON types of the document
+// Each dead-letter file is started afresh the first time it is written in a run
+type FileDeadLetterSink struct {
+	mu     sync.Mutex
+	files  map[string]io.WriteCloser
+	create func(path string) (io.WriteCloser, error)
+}
+
+// NewFileDeadLetterSink creates a dead-letter sink writing files next to the sources
+func NewFileDeadLetterSink() *FileDeadLetterSink {
+	return &FileDeadLetterSink{
+		files: make(map[string]io.WriteCloser),
+		create: func(path string) (io.WriteCloser, error) {
+			return os.Create(path)
+		},
+	}
+}
+
+// Reject appends the rejected document to the dead-letter file of its source
+func (s *FileDeadLetterSink) Reject(rejected domain.RejectedDocument) error {
+	line, err := bson.MarshalExtJSON(rejectedRecord(rejected), true, false)
+	if err != nil {
+		return fmt.Errorf("error encoding rejected document: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := utils.DeadLetterPath(rejected.SourceFile)
+	file, ok := s.files[path]
+	if !ok {
+		file, err = s.create(path)
+		if err != nil {
+			return fmt.Errorf("error creating dead-letter file %s: %w", path, err)
+		}
+		s.files[path] = file
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing dead-letter file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Close closes every dead-letter file written in this run
+func (s *FileDeadLetterSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for path, file := range s.files {
+		if err := file.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing dead-letter file %s: %w", path, err))
+		}
+	}
+	s.files = make(map[string]io.WriteCloser)
+	return errors.Join(errs...)
+}
+
+// CollectionDeadLetterSink writes rejected documents to a "<collection>_rejected" collection
+type CollectionDeadLetterSink struct {
+	ctx  context.Context
+	repo DocumentRepository
+}
+
+// NewCollectionDeadLetterSink creates a dead-letter sink writing to the database
+func NewCollectionDeadLetterSink(ctx context.Context, repo DocumentRepository) *CollectionDeadLetterSink {
+	return &CollectionDeadLetterSink{ctx: ctx, repo: repo}
+}
+
+// Reject inserts the rejected document into the dead-letter collection
+func (s *CollectionDeadLetterSink) Reject(rejected domain.RejectedDocument) error {
+	collectionName := rejected.CollectionName + DeadLetterCollectionSuffix
+	record := domain.Document(rejectedRecord(rejected))
+	if _, err := s.repo.InsertDocuments(s.ctx, collectionName, []domain.Document{record}); err != nil {
+		return fmt.Errorf("error writing dead-letter collection %s: %w", collectionName, err)
+	}
+	return nil
+}
+
+// Close does nothing, the repository is closed by its owner
+func (s *CollectionDeadLetterSink) Close() error {
+	return nil
+}
+
+// ReplayDeadLetter re-imports the records of a dead-letter file into the
+// collections they were rejected from, using the configured write mode
+// Records are read and written in batches, so a record that can't be read stops the
+// replay after the records before it. Callers usually move the file aside first,
+// so that documents rejected again start a new dead-letter file
+// Like an import, the replay is recorded as a run that can be rolled back
+func (m *MongoImporter) ReplayDeadLetter(filePath string) ([]*domain.ImportResult, error) {
+	output, err := m.trackRun(filePath, func() (any, error) {
+		return m.replayDeadLetter(filePath)
+	})
+	results, _ := output.([]*domain.ImportResult)
+	return results, err
+}
+
+// replayDeadLetter re-imports the records of a dead-letter file
+func (m *MongoImporter) replayDeadLetter(filePath string) ([]*domain.ImportResult, error) {
+	if m.writeOptions.Mode == domain.ImportModeSync {
+		return nil, fmt.Errorf("dead-letter files can't be replayed in %s mode", domain.ImportModeSync)
+	}
+
+	startTime := time.Now()
+
+	// Records are written in batches of consecutive records with the same collection and source
+	var results []*domain.ImportResult
+	byCollection := make(map[string]*domain.ImportResult)
+	var current *domain.ImportResult
+	var source string
+	var batch []domain.Document
+	var positions []int
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := m.importBatch(m.defaultTarget(current.CollectionName), current, source, batch, positions)
+		batch, positions = nil, nil
+		if err != nil {
+			current.Error = fmt.Errorf("error importing documents to collection %s: %w", current.CollectionName, err)
+			return current.Error
+		}
+		return nil
+	}
+
+	replay := func(record domain.RejectedDocument) error {
+		result, ok := byCollection[record.CollectionName]
+		if !ok {
+			result = &domain.ImportResult{
+				FileName:       filepath.Base(filePath),
+				CollectionName: record.CollectionName,
+			}
+			byCollection[record.CollectionName] = result
+			results = append(results, result)
+		}
+
+		if result != current || record.SourceFile != source || len(batch) == m.batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+			current, source = result, record.SourceFile
+		}
+
+		doc, err := m.replayDocument(record)
+		if err != nil {
+			// The document still can't be parsed
+			record.Stage = domain.RejectStageParse
+			record.Error = err.Error()
+			if err := m.defaultTarget(result.CollectionName).reject(result, record); err != nil {
+				result.Error = err
+				return err
+			}
+			return nil
+		}
+		batch = append(batch, doc)
+		positions = append(positions, record.Position)
+		return nil
+	}
+
+	// Errors of the writes are returned as they are, errors of the file are wrapped
+	var writeErr error
+	count := 0
+	err := m.fileUtils.StreamDeadLetter(filePath, m.batchSize, func(docs []bson.D) error {
+		for _, doc := range docs {
+			count++
+			record, err := parseRejectedRecord(domain.Document(doc))
+			if err != nil {
+				return fmt.Errorf("record %d: %w", count, err)
+			}
+			if writeErr = replay(record); writeErr != nil {
+				return writeErr
+			}
+		}
+		return nil
+	})
+	if writeErr != nil {
+		return results, writeErr
+	}
+	if err != nil {
+		return results, fmt.Errorf("error reading dead-letter file %s: %w", filePath, err)
+	}
+	if err := flush(); err != nil {
+		return results, err
+	}
+
+	for _, result := range results {
+		result.Duration = time.Since(startTime)
+	}
+	return results, nil
+}
+
+// reject sends a document to the dead-letter sink of the target and counts it in the result
+func (t importTarget) reject(result *domain.ImportResult, rejected domain.RejectedDocument) error {
+	rejected.CollectionName = result.CollectionName
+	if err := t.deadLetter.Reject(rejected); err != nil {
+		return err
+	}
+	result.RejectedCount++
+	return nil
+}
+
+// rejectedRecord converts a rejected document to the record stored by a dead-letter sink
+func rejectedRecord(rejected domain.RejectedDocument) bson.D {
+	record := bson.D{
+		{Key: "document", Value: rejected.Document},
+		{Key: "source", Value: rejected.SourceFile},
+		{Key: "collection", Value: rejected.CollectionName},
+		{Key: "position", Value: rejected.Position},
+	}
+	if rejected.Format != "" {
+		record = append(record, bson.E{Key: "format", Value: rejected.Format})
+	}
+	if rejected.Line > 0 {
+		record = append(record, bson.E{Key: "line", Value: rejected.Line})
+	}
+	record = append(record,
+		bson.E{Key: "stage", Value: string(rejected.Stage)},
+		bson.E{Key: "error", Value: rejected.Error},
+	)
+	if rejected.Code != 0 {
+		record = append(record, bson.E{Key: "code", Value: rejected.Code})
+	}
+	return append(record, bson.E{Key: "rejectedAt", Value: time.Now().UTC()})
+}
+
+// parseRejectedRecord reads a record written by rejectedRecord
+func parseRejectedRecord(record domain.Document) (domain.RejectedDocument, error) {
+	var rejected domain.RejectedDocument
+
+	document, ok := record.Get("document")
+	if !ok {
+		return rejected, fmt.Errorf("missing field %q", "document")
+	}
+	collectionName, _ := record.Get("collection")
+	name, ok := collectionName.(string)
+	if !ok || name == "" {
+		return rejected, fmt.Errorf("missing field %q", "collection")
+	}
+
+	rejected.Document = document
+	rejected.CollectionName = name
+	if source, ok := record.Get("source"); ok {
+		rejected.SourceFile, _ = source.(string)
+	}
+	if format, ok := record.Get("format"); ok {
+		rejected.Format, _ = format.(string)
+	}
+	if stage, ok := record.Get("stage"); ok {
+		s, _ := stage.(string)
+		rejected.Stage = domain.RejectStage(s)
+	}
+	if message, ok := record.Get("error"); ok {
+		rejected.Error, _ = message.(string)
+	}
+	rejected.Position = intField(record, "position")
+	rejected.Line = intField(record, "line")
+	rejected.Code = intField(record, "code")
+	return rejected, nil
+}
+
+// intField returns an integer field of a record, or 0 if it is missing
+func intField(record domain.Document, key string) int {
+	value, _ := record.Get(key)
+	switch v := value.(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// replayDocument returns the document of a dead-letter record
+// Documents rejected by the parser are stored as their original text; once fixed, that text
+// is parsed like its source file, with the same number, Extended JSON and CSV options
+func (m *MongoImporter) replayDocument(record domain.RejectedDocument) (domain.Document, error) {
+	switch v := record.Document.(type) {
+	case bson.D:
+		return domain.Document(v), nil
+	case string:
+		format := cmp.Or(record.Format, utils.FormatJSON)
+		doc, err := m.fileUtils.ParseDocument(v, format)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s document: %w", format, err)
+		}
+		return domain.Document(doc), nil
+	}
+	return nil, fmt.Errorf("document is a %T, not an object", record.Document)
+}
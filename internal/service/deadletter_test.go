@@ -0,0 +1,339 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+	"github.com/OTakumi/data-importer/internal/utils"
+)
+
+// MockDeadLetterSink records rejected documents for testing
+type MockDeadLetterSink struct {
+	mu       sync.Mutex
+	Rejected []domain.RejectedDocument
+}
+
+// Reject records the rejected document
+func (s *MockDeadLetterSink) Reject(rejected domain.RejectedDocument) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Rejected = append(s.Rejected, rejected)
+	return nil
+}
+
+// Close does nothing
+func (s *MockDeadLetterSink) Close() error {
+	return nil
+}
+
+// TestImportFileDeadLetter tests that documents rejected at each stage go to the dead-letter sink
+func TestImportFileDeadLetter(t *testing.T) {
+	ctx := context.Background()
+
+	// Position 1 can't be parsed, position 2 has no key field, position 4 fails to be written
+	mockFileUtils := &MockFileUtils{
+		StreamRejectsFunc: func(filePath string, batchSize int, handler utils.BatchHandler, reject utils.RejectHandler) error {
+			if err := reject(1, `{"code":`, &utils.ParseError{File: filePath, Line: 2, Err: errors.New("unexpected EOF")}); err != nil {
+				return err
+			}
+			return handler([]bson.D{
+				{{Key: "code", Value: "a"}},
+				{{Key: "name", Value: "no code"}},
+				{{Key: "code", Value: "c"}},
+				{{Key: "code", Value: "d"}},
+			})
+		},
+	}
+
+	mockRepo := &MockRepository{
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			if !opts.Unordered {
+				t.Error("Expected a dead-letter sink to imply unordered writes")
+			}
+			return &domain.ImportResult{
+				InsertedCount: len(documents) - 1,
+				Failed:        []domain.DocumentFailure{{Index: 2, Code: 11000, Message: "E11000 duplicate key error"}},
+			}, nil
+		},
+	}
+
+	sink := &MockDeadLetterSink{}
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{
+		IDFields:   []string{"code"},
+		DeadLetter: sink,
+	})
+
+	result, err := importer.ImportFile("/data/codes.jsonl")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.InsertedCount != 2 || result.RejectedCount != 3 {
+		t.Errorf("Expected 2 inserted and 3 rejected, got %d and %d", result.InsertedCount, result.RejectedCount)
+	}
+
+	type summary struct {
+		position int
+		stage    domain.RejectStage
+		code     int
+	}
+	var got []summary
+	for _, rejected := range sink.Rejected {
+		if rejected.SourceFile != "/data/codes.jsonl" || rejected.CollectionName != "codes" {
+			t.Errorf("Unexpected source or collection: %+v", rejected)
+		}
+		got = append(got, summary{position: rejected.Position, stage: rejected.Stage, code: rejected.Code})
+	}
+	expected := []summary{
+		{position: 1, stage: domain.RejectStageParse},
+		{position: 2, stage: domain.RejectStageConvert},
+		{position: 4, stage: domain.RejectStageWrite, code: 11000},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if raw, _ := sink.Rejected[0].Document.(string); raw != `{"code":` {
+		t.Errorf("Expected the original text of the unparseable document, got %v", sink.Rejected[0].Document)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Index != 4 {
+		t.Errorf("Expected the write failure at position 4, got %v", result.Failed)
+	}
+}
+
+// nopWriteCloser adds a Close method to a buffer
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// TestFileDeadLetterSink tests the records written next to the source file
+func TestFileDeadLetterSink(t *testing.T) {
+	files := make(map[string]*bytes.Buffer)
+	sink := NewFileDeadLetterSink()
+	sink.create = func(path string) (io.WriteCloser, error) {
+		files[path] = &bytes.Buffer{}
+		return nopWriteCloser{files[path]}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		err := sink.Reject(domain.RejectedDocument{
+			Document:       bson.D{{Key: "n", Value: int64(i)}},
+			SourceFile:     "/data/users.json",
+			CollectionName: "users",
+			Position:       i,
+			Stage:          domain.RejectStageWrite,
+			Error:          "E11000 duplicate key error",
+			Code:           11000,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output, ok := files["/data/users.rejected.ndjson"]
+	if !ok {
+		t.Fatalf("Expected a dead-letter file next to the source, got %v", files)
+	}
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(lines))
+	}
+
+	// Each line is an Extended JSON record that can be read back
+	var record bson.D
+	if err := bson.UnmarshalExtJSON([]byte(lines[1]), false, &record); err != nil {
+		t.Fatalf("Invalid record %s: %v", lines[1], err)
+	}
+	rejected, err := parseRejectedRecord(domain.Document(record))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rejected.Position != 1 || rejected.Stage != domain.RejectStageWrite || rejected.Code != 11000 || rejected.CollectionName != "users" {
+		t.Errorf("Unexpected record: %+v", rejected)
+	}
+	if !reflect.DeepEqual(rejected.Document, bson.D{{Key: "n", Value: int64(1)}}) {
+		t.Errorf("Expected the original document, got %v", rejected.Document)
+	}
+}
+
+// TestReplayDeadLetter tests re-importing the records of a dead-letter file
+func TestReplayDeadLetter(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		StreamDeadLetterFunc: func(filePath string, batchSize int, handler utils.BatchHandler) error {
+			return handler([]bson.D{
+				{{Key: "document", Value: bson.D{{Key: "name", Value: "Alice"}}}, {Key: "source", Value: "/data/users.json"}, {Key: "collection", Value: "users"}, {Key: "position", Value: int32(3)}},
+				{{Key: "document", Value: `{"name": "Bob"}`}, {Key: "source", Value: "/data/users.json"}, {Key: "collection", Value: "users"}, {Key: "position", Value: int32(5)}},
+				{{Key: "document", Value: `{"name": `}, {Key: "source", Value: "/data/users.json"}, {Key: "collection", Value: "users"}, {Key: "position", Value: int32(6)}},
+				{{Key: "document", Value: bson.D{{Key: "code", Value: "JP"}}}, {Key: "source", Value: "/data/countries.json"}, {Key: "collection", Value: "countries"}, {Key: "position", Value: int32(0)}},
+			})
+		},
+	}
+
+	// Writes are unordered with a dead-letter sink, so they go through WriteDocuments
+	inserted := make(map[string][]string)
+	mockRepo := &MockRepository{
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			for _, doc := range documents {
+				inserted[collectionName] = append(inserted[collectionName], doc[0].Value.(string))
+			}
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	sink := &MockDeadLetterSink{}
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{DeadLetter: sink})
+
+	results, err := importer.ReplayDeadLetter("/data/users.rejected.ndjson.replayed")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string][]string{"users": {"Alice", "Bob"}, "countries": {"JP"}}
+	if !reflect.DeepEqual(inserted, expected) {
+		t.Errorf("Expected %v, got %v", expected, inserted)
+	}
+	if len(results) != 2 || results[0].CollectionName != "users" || results[0].InsertedCount != 2 || results[0].RejectedCount != 1 {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+
+	// The document that still can't be parsed is rejected again with its original position
+	if len(sink.Rejected) != 1 || sink.Rejected[0].Position != 6 || sink.Rejected[0].Stage != domain.RejectStageParse {
+		t.Errorf("Unexpected rejections: %+v", sink.Rejected)
+	}
+}
+
+// TestReplayDeadLetterStreamed tests that records are written while the dead-letter file is read,
+// and that fixed JSON text is decoded with the parse options of the importer
+func TestReplayDeadLetterStreamed(t *testing.T) {
+	ctx := context.Background()
+
+	record := func(document any, position int32) bson.D {
+		return bson.D{
+			{Key: "document", Value: document},
+			{Key: "source", Value: "/data/items.json"},
+			{Key: "format", Value: utils.FormatJSON},
+			{Key: "collection", Value: "items"},
+			{Key: "position", Value: position},
+		}
+	}
+	mockFileUtils := &MockFileUtils{
+		ParseDocumentFunc: utils.NewFileUtilsWithOptions(nil, utils.ParseOptions{UseDecimal128: true}).ParseDocument,
+		StreamDeadLetterFunc: func(filePath string, batchSize int, handler utils.BatchHandler) error {
+			if err := handler([]bson.D{record(`{"price": 1.5}`, 0), record(bson.D{{Key: "price", Value: 2.5}}, 1)}); err != nil {
+				return err
+			}
+			if err := handler([]bson.D{record(bson.D{{Key: "price", Value: 3.5}}, 2)}); err != nil {
+				return err
+			}
+			return errors.New("unexpected end of line 4")
+		},
+	}
+
+	var written []domain.Document
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			written = append(written, documents...)
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{BatchSize: 2})
+	_, err := importer.ReplayDeadLetter("/data/items.rejected.ndjson.replayed")
+	if err == nil || !strings.Contains(err.Error(), "error reading dead-letter file") {
+		t.Fatalf("Expected a read error, got %v", err)
+	}
+
+	// The full batch was written before the file failed to be read
+	if len(written) != 2 {
+		t.Fatalf("Expected the first batch to be written, got %v", written)
+	}
+	price, _ := primitive.ParseDecimal128("1.5")
+	if value, _ := written[0].Get("price"); value != price {
+		t.Errorf("Expected the fixed text to be decoded with -decimal128, got %T %v", value, value)
+	}
+}
+
+// TestReplayDeadLetterCSV tests that a rejected CSV record can be fixed in the
+// dead-letter file and replayed through the CSV conversion
+func TestReplayDeadLetterCSV(t *testing.T) {
+	ctx := context.Background()
+	sourcePath := filepath.Join(t.TempDir(), "users.csv")
+	source := "id:int,name\r\n1,Alice\r\nx,\"Smith, \"\"J\"\"\"\r\n"
+	if err := os.WriteFile(sourcePath, []byte(source), 0o644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	var written []domain.Document
+	mockRepo := &MockRepository{
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			written = append(written, documents...)
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	fileUtils := utils.NewFileUtils(nil)
+	sink := NewFileDeadLetterSink()
+	importer := NewMongoImporter(ctx, fileUtils, mockRepo, ImporterOptions{DeadLetter: sink})
+	if _, err := importer.ImportFile(sourcePath); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The record is stored with its header line, original quoting and source format
+	deadLetterPath := utils.DeadLetterPath(sourcePath)
+	content, err := os.ReadFile(deadLetterPath)
+	if err != nil {
+		t.Fatalf("Expected a dead-letter file: %v", err)
+	}
+	var record bson.D
+	if err := bson.UnmarshalExtJSON(bytes.TrimSpace(content), false, &record); err != nil {
+		t.Fatalf("Invalid record %s: %v", content, err)
+	}
+	rejected, err := parseRejectedRecord(domain.Document(record))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "id:int,name\nx,\"Smith, \"\"J\"\"\""; rejected.Document != expected || rejected.Format != utils.FormatCSV {
+		t.Fatalf("Expected the CSV text %q, got %+v", expected, rejected)
+	}
+
+	// Fix the value and replay the file moved aside
+	fixed := strings.Replace(string(content), `\nx,`, `\n2,`, 1)
+	replayPath := deadLetterPath + ".replayed"
+	if err := os.WriteFile(replayPath, []byte(fixed), 0o644); err != nil {
+		t.Fatalf("Failed to write dead-letter file: %v", err)
+	}
+	written = nil
+	results, err := importer.ReplayDeadLetter(replayPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []domain.Document{{{Key: "id", Value: int32(2)}, {Key: "name", Value: `Smith, "J"`}}}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected the fixed record to be converted like the CSV file\nexpected: %v\ngot:      %v", expected, written)
+	}
+	if len(results) != 1 || results[0].InsertedCount != 1 || results[0].RejectedCount != 0 {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrRunDeadlineExceeded is the cause of the run context once the run deadline has passed
+var ErrRunDeadlineExceeded = errors.New("import run deadline exceeded")
+
+// WithRunDeadline returns the context of a run that ends when timeout has passed
+// A timeout of 0 means no deadline; the returned context can be cancelled either way
+func WithRunDeadline(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeoutCause(parent, timeout, ErrRunDeadlineExceeded)
+}
+
+// deadlineErr returns ErrRunDeadlineExceeded once the run deadline has passed
+func (m *MongoImporter) deadlineErr() error {
+	if errors.Is(context.Cause(m.ctx), ErrRunDeadlineExceeded) {
+		return ErrRunDeadlineExceeded
+	}
+	return nil
+}
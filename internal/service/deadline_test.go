@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// TestWithRunDeadline tests that the run context ends with ErrRunDeadlineExceeded only when a deadline is set
+func TestWithRunDeadline(t *testing.T) {
+	ctx, cancel := WithRunDeadline(context.Background(), 0)
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("Expected no deadline for a timeout of 0")
+	}
+	cancel()
+	if errors.Is(context.Cause(ctx), ErrRunDeadlineExceeded) {
+		t.Error("Expected a cancelled run not to report the run deadline")
+	}
+
+	ctx, cancel = WithRunDeadline(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	if !errors.Is(context.Cause(ctx), ErrRunDeadlineExceeded) {
+		t.Errorf("Expected ErrRunDeadlineExceeded as the cause, got %v", context.Cause(ctx))
+	}
+}
+
+// TestImportDirectoryRunDeadline tests that files not started before the run deadline are skipped
+// and the deadline is reported as the cause of the failed import
+func TestImportDirectoryRunDeadline(t *testing.T) {
+	files := []string{"/data/file0.json", "/data/file1.json", "/data/file2.json"}
+	mockFileUtils := &MockFileUtils{
+		FindJSONFilesFunc: func(dirPath string) ([]string, error) {
+			return files, nil
+		},
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "n", Value: 1}}}, nil
+		},
+	}
+
+	inserted := 0
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			// The first file outlasts the run deadline
+			inserted++
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	ctx, cancel := WithRunDeadline(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{Workers: 1})
+	results, err := importer.ImportDirectory("/data")
+	if !errors.Is(err, ErrRunDeadlineExceeded) {
+		t.Fatalf("Expected the run deadline as the cause, got %v", err)
+	}
+
+	if inserted != 1 {
+		t.Errorf("Expected only the first file to be written, got %d", inserted)
+	}
+	for i, result := range results[1:] {
+		if !errors.Is(result.Error, ErrRunDeadlineExceeded) {
+			t.Errorf("Expected file %d to be skipped at the run deadline, got %v", i+1, result.Error)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// assignID sets the _id of a document that is imported with its _id kept
+// If idFields is configured, _id is derived from those fields so re-imports produce
+// identical IDs; otherwise an existing _id given as a 24-hex string becomes an ObjectID
+// The _id field is moved to the front of the document, as MongoDB stores it
+func (m *MongoImporter) assignID(doc *domain.Document) error {
+	if len(m.idFields) > 0 {
+		id, err := deterministicID(*doc, m.idFields)
+		if err != nil {
+			return err
+		}
+		setIDFirst(doc, id)
+		return nil
+	}
+
+	if m.removeIDField {
+		return nil
+	}
+
+	value, ok := doc.Get("_id")
+	if !ok {
+		return nil
+	}
+	// In Extended JSON an ObjectID is written as $oid, so a string _id stays a string
+	if hex, ok := value.(string); ok && !m.extendedJSON {
+		if oid, err := primitive.ObjectIDFromHex(hex); err == nil {
+			value = oid
+		}
+	}
+	setIDFirst(doc, value)
+	return nil
+}
+
+// deterministicID derives an ObjectID from the values of the given key fields
+// The first 12 bytes of the SHA-256 of the BSON encoded key values are used,
+// so the same key values always produce the same _id
+func deterministicID(doc domain.Document, fields []string) (primitive.ObjectID, error) {
+	key := make(bson.D, 0, len(fields))
+	for _, field := range fields {
+		value, ok := doc.Lookup(field)
+		if !ok {
+			return primitive.NilObjectID, fmt.Errorf("key field %q for _id is missing", field)
+		}
+		key = append(key, bson.E{Key: field, Value: value})
+	}
+
+	data, err := bson.Marshal(key)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("error encoding key fields for _id: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	var id primitive.ObjectID
+	copy(id[:], sum[:len(id)])
+	return id, nil
+}
+
+// objectIDFromWrapper converts MongoDB's {"$oid": "<hex>"} format to an ObjectID
+func objectIDFromWrapper(doc bson.D) (primitive.ObjectID, bool) {
+	if len(doc) != 1 || doc[0].Key != "$oid" {
+		return primitive.NilObjectID, false
+	}
+	hex, ok := doc[0].Value.(string)
+	if !ok {
+		return primitive.NilObjectID, false
+	}
+	oid, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	return oid, true
+}
+
+// setIDFirst sets the _id of a document and moves it to the first position
+func setIDFirst(doc *domain.Document, id any) {
+	doc.Delete("_id")
+	*doc = append(domain.Document{{Key: "_id", Value: id}}, *doc...)
+}
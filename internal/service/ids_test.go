@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// TestDeterministicID tests that _id derived from key fields is stable
+func TestDeterministicID(t *testing.T) {
+	importer := NewMongoImporter(context.Background(), &MockFileUtils{}, &MockRepository{}, ImporterOptions{
+		IDFields: []string{"tenant", "profile.code"},
+	})
+
+	newDoc := func(tenant string, code int32, extra string) domain.Document {
+		return domain.Document{
+			{Key: "_id", Value: "ignored"},
+			{Key: "tenant", Value: tenant},
+			{Key: "profile", Value: bson.D{{Key: "code", Value: code}}},
+			{Key: "extra", Value: extra},
+		}
+	}
+
+	docs, err := importer.cleanDocuments([]domain.Document{
+		newDoc("acme", 1, "a"),
+		newDoc("acme", 1, "b"), // Same key fields, other data
+		newDoc("acme", 2, "a"),
+		newDoc("other", 1, "a"),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ids := make([]primitive.ObjectID, len(docs))
+	for i, doc := range docs {
+		if doc[0].Key != "_id" {
+			t.Fatalf("Expected _id to be the first field, got %s", doc[0].Key)
+		}
+		id, ok := doc[0].Value.(primitive.ObjectID)
+		if !ok {
+			t.Fatalf("Expected _id to be an ObjectID, got %T", doc[0].Value)
+		}
+		ids[i] = id
+	}
+
+	if ids[0] != ids[1] {
+		t.Errorf("Expected equal key fields to produce the same _id, got %s and %s", ids[0].Hex(), ids[1].Hex())
+	}
+	if ids[0] == ids[2] || ids[0] == ids[3] {
+		t.Errorf("Expected different key fields to produce different _ids")
+	}
+
+	// Re-importing produces identical IDs
+	again, err := importer.cleanDocuments([]domain.Document{newDoc("acme", 1, "c")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if again[0][0].Value != ids[0] {
+		t.Errorf("Expected re-import to produce _id %s, got %v", ids[0].Hex(), again[0][0].Value)
+	}
+
+	// A missing key field is an error
+	_, err = importer.cleanDocuments([]domain.Document{{{Key: "tenant", Value: "acme"}}})
+	if err == nil {
+		t.Error("Expected an error for a missing key field but got none")
+	}
+}
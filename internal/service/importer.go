@@ -1,13 +1,18 @@
 package service
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+
 	"github.com/OTakumi/data-importer/internal/domain"
 	"github.com/OTakumi/data-importer/internal/utils"
 )
@@ -20,6 +25,18 @@ type ImporterService interface {
 	// ImportDirectory imports all JSON files in a directory to MongoDB
 	ImportDirectory(dirPath string) ([]*domain.ImportResult, error)
 
+	// SyncFile mirrors a single file into its collection
+	SyncFile(filePath string) (*domain.SyncResult, error)
+
+	// SyncDirectory mirrors all files in a directory into their collections
+	SyncDirectory(dirPath string) ([]*domain.SyncResult, error)
+
+	// ReplayDeadLetter re-imports the records of a dead-letter file
+	ReplayDeadLetter(filePath string) ([]*domain.ImportResult, error)
+
+	// RollbackRun undoes what an import run wrote
+	RollbackRun(runID string) ([]*domain.RollbackResult, error)
+
 	// ImportPath determines if the path is a file or directory and processes accordingly
 	ImportPath(path string) (any, error)
 }
@@ -29,10 +46,86 @@ type ImporterService interface {
 type DocumentRepository interface {
 	// InsertDocuments inserts multiple documents into a collection
 	InsertDocuments(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error)
+	// WriteDocuments writes documents using the given write mode (insert, upsert, replace or merge)
+	WriteDocuments(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error)
+	// ScanDocuments passes the documents of a collection to fn one at a time
+	// doc is only valid until fn returns
+	ScanDocuments(ctx context.Context, collectionName string, fn func(doc bson.Raw) error) error
+	// DeleteDocuments deletes the documents with the given _id values
+	DeleteDocuments(ctx context.Context, collectionName string, ids []any) (int, error)
+	// ListCollections returns the names of the collections in the database
+	ListCollections(ctx context.Context) ([]string, error)
+	// DropCollection drops a collection
+	DropCollection(ctx context.Context, collectionName string) error
+	// MergeCollection writes all documents of one collection to another using the given write mode
+	MergeCollection(ctx context.Context, source, target string, opts domain.WriteOptions) (int, error)
+	// WithTransaction runs fn in a transaction; operations using the context passed to fn are part of it
+	// fn runs again when the transaction is retried after a transient error
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	// CreateStagingCollection creates a staging collection with the options and indexes of source
+	CreateStagingCollection(ctx context.Context, source, staging string) error
+	// CountDocuments returns the number of documents in a collection
+	CountDocuments(ctx context.Context, collectionName string) (int, error)
+	// ValidateCollection checks the data and indexes of a collection
+	ValidateCollection(ctx context.Context, collectionName string) error
+	// RenameCollection renames source to target, replacing target if it exists
+	RenameCollection(ctx context.Context, source, target string) error
+	// SaveImportRun records an import run, replacing an earlier record of the same run
+	SaveImportRun(ctx context.Context, run *domain.ImportRun) error
+	// FindImportRun returns the record of an import run, or nil if there is none
+	FindImportRun(ctx context.Context, runID string) (*domain.ImportRun, error)
+	// FindMatchingDocuments returns the stored documents matching the keys of documents
+	FindMatchingDocuments(ctx context.Context, collectionName string, documents []domain.Document, keyFields []string) ([]domain.Document, error)
+	// SaveDocumentVersions saves documents as they were before a run overwrote or deleted them
+	SaveDocumentVersions(ctx context.Context, runID, collectionName string, documents []domain.Document) error
+	// SaveCheckpoint records how far the import of a file was written
+	SaveCheckpoint(ctx context.Context, checkpoint *domain.Checkpoint) error
+	// FindCheckpoint returns the checkpoint of a file, or nil if there is none
+	FindCheckpoint(ctx context.Context, file string) (*domain.Checkpoint, error)
+	// DeleteCheckpoint removes the checkpoint of a file
+	DeleteCheckpoint(ctx context.Context, file string) error
+	// FindLedgerEntry returns the import ledger entry of a file, or nil if there is none
+	FindLedgerEntry(ctx context.Context, file string) (*domain.LedgerEntry, error)
+	// SaveLedgerEntry records a successfully imported file in the import ledger
+	SaveLedgerEntry(ctx context.Context, entry *domain.LedgerEntry) error
+	// RollbackCollection deletes the documents a run wrote to a collection and restores the versions it saved
+	RollbackCollection(ctx context.Context, runID, runField, collectionName string) (*domain.RollbackResult, error)
+	// ResetCollection drops a collection and recreates its indexes, returning the previous document count
+	ResetCollection(ctx context.Context, collectionName string) (int, error)
 	// Disconnect closes the connection to MongoDB
 	Disconnect(ctx context.Context) error
 }
 
+// ImporterOptions holds the settings of a MongoImporter
+type ImporterOptions struct {
+	BatchSize     int               // Batch size for document imports
+	RemoveIDField bool              // Whether to remove _id fields during import
+	ExtendedJSON  bool              // Input is decoded as Extended JSON, so values are stored exactly as decoded
+	IDFields      []string          // Fields used to derive a deterministic _id (see deterministicID)
+	Mode          domain.ImportMode // Write mode; empty means insert
+	KeyFields     []string          // Fields matched by upsert, replace, merge and sync; empty means _id
+	Unordered     bool              // Keep writing past failed documents and report them in ImportResult.Failed
+	DeadLetter    DeadLetterSink    // Receives rejected documents instead of failing the file; implies Unordered
+
+	FileErrorBudget ErrorBudget // Failed documents tolerated per file before it is aborted
+	RunErrorBudget  ErrorBudget // Failed documents tolerated per run before it is aborted
+
+	Transaction         domain.TransactionScope // Import each file, or the whole run, in a transaction; writes become ordered
+	MaxTransactionBytes int64                   // Larger imports go through staging collections; 0 means DefaultMaxTransactionBytes
+
+	RunField string // Field that receives the run ID in every written document; empty to not stamp documents
+	Resume   bool   // Continue files from the checkpoint left by an interrupted import
+	Force    bool   // Import every file of a directory, including files unchanged since their last import
+
+	Workers       int // Files of a directory imported at the same time; 0 means one per CPU
+	InsertWorkers int // Batches of a file inserted at the same time in insert, reload and swap mode; 0 or 1 inserts them one after another
+
+	SwapMinRatio float64 // In swap mode, refuse to swap when the new collection holds fewer documents than this share of the live one
+
+	DropMissingCollections bool // In sync mode, drop collections that have no matching file
+	Preview                bool // In sync mode, only compute the changes without writing anything
+}
+
 // MongoImporter implements the ImporterService interface
 type MongoImporter struct {
 	fileUtils     utils.FileUtilsInterface // For file operations (インターフェースに変更)
@@ -40,75 +133,356 @@ type MongoImporter struct {
 	batchSize     int                      // Batch size for document imports
 	ctx           context.Context          // Context for database operations
 	removeIDField bool                     // Whether to remove _id fields during import
+	extendedJSON  bool                     // Skip the date and ObjectID heuristics for Extended JSON input
+	idFields      []string                 // Fields used to derive a deterministic _id
+	writeOptions  domain.WriteOptions      // How documents are written to the repository
+	deadLetter    DeadLetterSink           // Receives rejected documents, nil to fail on the first one
+	fileBudget    ErrorBudget              // Failed documents tolerated per file
+	runBudget     *runBudget               // Failed documents tolerated per run, shared by all files
+	runID         string                   // Identifies this run, e.g. in staging collection names
+	runField      string                   // Field that receives the run ID, empty to not stamp documents
+	resume        bool                     // Continue files from their checkpoint
+	force         bool                     // Don't skip files unchanged since their last import
+
+	transaction         domain.TransactionScope // Whether files or the whole run are imported in a transaction
+	maxTransactionBytes int64                   // Input size above which staging collections are used instead
+	stagingMu           sync.Mutex              // Serializes imports through staging collections
+
+	workers       int // Files of a directory imported at the same time
+	insertWorkers int // Batches of a file inserted at the same time
+
+	swapMinRatio float64 // Minimum document count of a swapped collection relative to the live one
+
+	dropMissingCollections bool // Drop collections without a matching file when syncing a directory
+	preview                bool // Compute sync changes without writing them
+
+	resetMu     sync.Mutex     // Guards resetCounts
+	resetCounts map[string]int // Previous document counts of collections already reset in reload mode
+
+	hashMu sync.Mutex        // Guards hashes
+	hashes map[string]string // Hashes of the files hashed so far, shared by the ledger and checkpoints
+
+	stopped atomic.Bool // Set by Stop; no new file or batch is started once set
 }
 
-// NewMongoImporter creates a new MongoDB importer service
+// NewMongoImporterWithOptions creates a new MongoDB importer service
 func NewMongoImporterWithOptions(ctx context.Context, fileUtils utils.FileUtilsInterface, repo DocumentRepository, batchSize int, removeIDField bool) *MongoImporter {
+	return NewMongoImporter(ctx, fileUtils, repo, ImporterOptions{
+		BatchSize:     batchSize,
+		RemoveIDField: removeIDField,
+	})
+}
+
+// NewMongoImporter creates a new MongoDB importer service with the given options
+func NewMongoImporter(ctx context.Context, fileUtils utils.FileUtilsInterface, repo DocumentRepository, opts ImporterOptions) *MongoImporter {
 	// Use a reasonable default batch size if not specified
+	batchSize := opts.BatchSize
 	if batchSize <= 0 {
 		batchSize = 1000
 	}
 
+	mode := opts.Mode
+	if mode == "" {
+		mode = domain.ImportModeInsert
+	}
+
+	maxTransactionBytes := opts.MaxTransactionBytes
+	if maxTransactionBytes <= 0 {
+		maxTransactionBytes = DefaultMaxTransactionBytes
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
 	return &MongoImporter{
 		fileUtils:     fileUtils,
 		repo:          repo,
 		batchSize:     batchSize,
 		ctx:           ctx,
-		removeIDField: removeIDField,
+		removeIDField: opts.RemoveIDField,
+		extendedJSON:  opts.ExtendedJSON,
+		idFields:      opts.IDFields,
+		writeOptions: domain.WriteOptions{
+			Mode:      mode,
+			KeyFields: opts.KeyFields,
+			// A failed write aborts a transaction, so writes can't skip failed documents
+			Unordered: (opts.Unordered || opts.DeadLetter != nil) && opts.Transaction == domain.TransactionNone,
+		},
+		deadLetter: opts.DeadLetter,
+		fileBudget: opts.FileErrorBudget,
+		runBudget:  &runBudget{budget: opts.RunErrorBudget},
+		runID:      newRunID(),
+		runField:   opts.RunField,
+		resume:     opts.Resume,
+		force:      opts.Force,
+
+		transaction:         opts.Transaction,
+		maxTransactionBytes: maxTransactionBytes,
+		workers:             workers,
+		insertWorkers:       opts.InsertWorkers,
+		swapMinRatio:        opts.SwapMinRatio,
+		resetCounts:         make(map[string]int),
+		hashes:              make(map[string]string),
+
+		dropMissingCollections: opts.DropMissingCollections,
+		preview:                opts.Preview,
 	}
 }
 
 // ImportPath determines if the path is a file or directory and processes accordingly
+// The run is recorded in the runs collection so it can be rolled back
 func (m *MongoImporter) ImportPath(path string) (any, error) {
+	return m.trackRun(path, func() (any, error) {
+		return m.importPath(path)
+	})
+}
+
+// importPath imports or syncs a file or directory
+func (m *MongoImporter) importPath(path string) (any, error) {
+	if m.resume {
+		if err := m.checkResumeMode(); err != nil {
+			return nil, err
+		}
+	}
+
 	// Check if path is a directory or file
 	isDir, err := m.fileUtils.IsDirectory(path)
 	if err != nil {
 		return nil, fmt.Errorf("error checking path %s: %w", path, err)
 	}
 
+	// Sync mode mirrors the files instead of adding to the collections
+	if m.writeOptions.Mode == domain.ImportModeSync {
+		if isDir {
+			return m.SyncDirectory(path)
+		}
+		return m.SyncFile(path)
+	}
+
 	if isDir {
 		return m.ImportDirectory(path)
 	}
 
-	return m.ImportFile(path)
+	result, err := m.ImportFile(path)
+	if err != nil {
+		return result, err
+	}
+	if err := m.runBudget.finish(path); err != nil {
+		return result, err
+	}
+	return result, nil
 }
 
-// ImportFile imports a single JSON file to MongoDB
-func (m *MongoImporter) ImportFile(filePath string) (*domain.ImportResult, error) {
-	startTime := time.Now()
-	result := &domain.ImportResult{
+// importTarget describes where the documents of a file are written
+type importTarget struct {
+	ctx           context.Context     // Context of the writes, carrying the session of a transaction if any
+	collection    string              // Collection written to, which is a staging collection when staging
+	writeOptions  domain.WriteOptions // How documents are written
+	deadLetter    DeadLetterSink      // Receives rejected documents, nil to fail on the first one
+	checkpoints   bool                // Record each written batch so an interrupted import can be resumed
+	insertWorkers int                 // Batches of a file written at the same time; only inserts can be written out of order
+}
+
+// defaultTarget returns the target of a collection outside of transactions and staging
+func (m *MongoImporter) defaultTarget(collectionName string) importTarget {
+	target := importTarget{
+		ctx:          m.ctx,
+		collection:   collectionName,
+		writeOptions: m.writeOptions,
+		deadLetter:   m.deadLetter,
+		// A reload starts from an empty collection, so it can't continue where it stopped
+		checkpoints: m.writeOptions.Mode != domain.ImportModeReload,
+	}
+	if !m.writeOptions.Mode.MatchesByKey() {
+		target.insertWorkers = m.insertWorkers
+	}
+	return target
+}
+
+// newImportResult returns an empty result for a file
+func newImportResult(filePath string) *domain.ImportResult {
+	return &domain.ImportResult{
 		FileName:       filepath.Base(filePath),
 		CollectionName: utils.FilePathToCollectionName(filePath),
 	}
+}
 
-	// Parse JSON file
-	documents, err := m.fileUtils.ParseJSONFile(filePath)
-	if err != nil {
-		result.Error = fmt.Errorf("error parsing file %s: %w", filePath, err)
+// ImportFile imports a single JSON file to MongoDB
+func (m *MongoImporter) ImportFile(filePath string) (*domain.ImportResult, error) {
+	if m.transaction != domain.TransactionNone {
+		results, _ := m.importInTransaction(filePath, []string{filePath})
+		return results[0], results[0].Error
+	}
+	if m.writeOptions.Mode == domain.ImportModeSwap {
+		results, _ := m.swapCollection(utils.FilePathToCollectionName(filePath), []string{filePath})
+		return results[0], results[0].Error
+	}
+	return m.importFile(filePath, m.defaultTarget(utils.FilePathToCollectionName(filePath)))
+}
+
+// importFile imports a single file to the given target
+// The result reports the file's own collection, also when a staging collection is written
+func (m *MongoImporter) importFile(filePath string, target importTarget) (*domain.ImportResult, error) {
+	startTime := time.Now()
+	result := newImportResult(filePath)
+
+	// Files that start after an error budget was exceeded, the run deadline passed
+	// or the import was stopped are not imported
+	if err := cmp.Or(m.runBudget.err(), m.deadlineErr(), m.stopErr()); err != nil {
+		result.Error = fmt.Errorf("import of %s skipped: %w", filePath, err)
 		return result, result.Error
 	}
 
-	// Convert to domain models
-	var domainDocs []domain.Document
-	for _, doc := range documents {
-		// Use direct conversion since domain.Document is a map type alias
-		domainDocs = append(domainDocs, domain.Document(doc))
+	// In reload mode the collection is emptied before anything is imported
+	if target.writeOptions.Mode == domain.ImportModeReload {
+		previousCount, err := m.resetCollection(result.CollectionName)
+		result.PreviousCount = previousCount
+		if err != nil {
+			result.Duration = time.Since(startTime)
+			result.Error = fmt.Errorf("error resetting collection %s: %w", result.CollectionName, err)
+			return result, result.Error
+		}
+	}
+
+	// Written batches are checkpointed; when resuming, documents written before are skipped
+	var checkpoint *fileCheckpoint
+	if target.checkpoints {
+		var err error
+		checkpoint, err = m.startCheckpoint(filePath, result.CollectionName)
+		if err != nil {
+			result.Duration = time.Since(startTime)
+			result.Error = err
+			return result, result.Error
+		}
+	}
+
+	// Stream the file and insert each batch as soon as it is filled,
+	// so memory use is bounded by the batch size rather than the file size
+	var importErr error
+	var tracker positionTracker
+	budget := &budgetTracker{file: filePath, budget: m.fileBudget, run: m.runBudget, minSample: m.batchSize}
+
+	// With several insert workers, batches are written concurrently while the file is read
+	var pipeline *batchPipeline
+	if target.insertWorkers > 1 {
+		pipeline = m.newBatchPipeline(target, result, filePath, budget, checkpoint)
 	}
+	handler := func(batch []bson.D) error {
+		// Stop as soon as another file has exceeded an error budget or the import was stopped;
+		// batches already written stay checkpointed
+		if err := cmp.Or(m.runBudget.err(), m.stopErr()); err != nil {
+			importErr = err
+			return err
+		}
 
-	// Clean documents by removing _id fields before import
-	domainDocs = m.cleanDocuments(domainDocs)
+		// Convert to domain models
+		domainDocs := make([]domain.Document, 0, len(batch))
+		positions := make([]int, 0, len(batch))
+		for _, doc := range batch {
+			position := tracker.next()
+			if checkpoint.written(position) {
+				result.ResumedCount++
+				continue
+			}
+			// Use direct conversion since domain.Document is based on bson.D
+			domainDocs = append(domainDocs, domain.Document(doc))
+			positions = append(positions, position)
+		}
+		if len(domainDocs) == 0 {
+			return nil
+		}
+		result.ProcessedCount += len(domainDocs)
+
+		if pipeline != nil {
+			// The batch ends at the position of the next document to read
+			if err := pipeline.submit(domainDocs, positions, result.ResumedCount+result.ProcessedCount); err != nil {
+				importErr = err
+				return err
+			}
+			return nil
+		}
+
+		if err := m.importBatch(target, result, filePath, domainDocs, positions); err != nil {
+			importErr = err
+			return err
+		}
+		if err := budget.check(result, 0, false); err != nil {
+			importErr = err
+			return err
+		}
+		if checkpoint != nil {
+			// Every position up to the end of this batch has been read and handled
+			if err := m.commitCheckpoint(checkpoint, result.ResumedCount+result.ProcessedCount); err != nil {
+				importErr = err
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if target.deadLetter != nil {
+		// Documents the parser can skip go to the dead-letter sink
+		err = m.fileUtils.StreamDocumentsWithRejects(filePath, m.batchSize, handler, func(position int, raw string, parseErr *utils.ParseError) error {
+			tracker.skip(position)
+			if checkpoint.written(position) {
+				result.ResumedCount++
+				return nil
+			}
+			result.ProcessedCount++
+			result.ErrorCount++
+			rejected := domain.RejectedDocument{
+				Document:   raw,
+				SourceFile: filePath,
+				Format:     utils.FileFormat(filePath),
+				Position:   position,
+				Line:       parseErr.Line,
+				Stage:      domain.RejectStageParse,
+				Error:      parseErr.Err.Error(),
+			}
+			if err := target.reject(result, rejected); err != nil {
+				importErr = err
+				return err
+			}
+			return nil
+		})
+	} else {
+		err = m.fileUtils.StreamDocuments(filePath, m.batchSize, handler)
+	}
+	if pipeline != nil {
+		// The batches in flight are written and accounted for also when the import stops early
+		if pipelineErr := pipeline.finish(); pipelineErr != nil && importErr == nil {
+			importErr = pipelineErr
+		}
+	}
+	if checkpoint != nil && importErr == nil {
+		// Every batch read was written, so documents rejected after the last one are done too
+		if err := m.commitRejects(checkpoint, result.ResumedCount+result.ProcessedCount); err != nil {
+			importErr = err
+		}
+	}
+	result.Duration = time.Since(startTime)
 
-	// Import documents in batches
-	count, err := m.processBatches(domainDocs, result.CollectionName)
+	if importErr != nil {
+		result.Error = fmt.Errorf("error importing documents to collection %s: %w", result.CollectionName, importErr)
+		return result, result.Error
+	}
 	if err != nil {
-		result.Error = fmt.Errorf("error importing documents to collection %s: %w", result.CollectionName, err)
+		result.Error = fmt.Errorf("error parsing file %s: %w", filePath, err)
 		return result, result.Error
 	}
 
-	// Update result
-	result.InsertedCount = count
-	result.Duration = time.Since(startTime)
+	// The error ratio of the file is checked once more now that it has been read completely
+	if err := budget.check(result, 0, true); err != nil {
+		result.Error = fmt.Errorf("error importing documents to collection %s: %w", result.CollectionName, err)
+		return result, result.Error
+	}
 
+	if checkpoint != nil {
+		m.finishCheckpoint(checkpoint)
+	}
 	return result, nil
 }
 
@@ -124,30 +498,56 @@ func (m *MongoImporter) ImportDirectory(dirPath string) ([]*domain.ImportResult,
 		return nil, fmt.Errorf("no JSON files found in directory %s", dirPath)
 	}
 
-	// Process each file in parallel
-	var wg sync.WaitGroup
-	resultChan := make(chan *domain.ImportResult, len(jsonFiles))
-
-	for _, file := range jsonFiles {
-		wg.Add(1)
-		go func(filePath string) {
-			defer wg.Done()
+	// Files imported before and unchanged since are skipped
+	changed, results, ledger, err := m.scanLedger(jsonFiles)
+	if err != nil {
+		return nil, err
+	}
+	if len(changed) == 0 {
+		return m.directoryResults(dirPath, inputOrder(jsonFiles, results))
+	}
 
-			result, _ := m.ImportFile(filePath)
-			resultChan <- result
-		}(file)
+	// All files are imported in one transaction, one after another
+	if m.transaction == domain.TransactionRun {
+		imported, err := m.importInTransaction(dirPath, changed)
+		for i, result := range imported {
+			results[changed[i]] = result
+		}
+		if err != nil {
+			return inputOrder(jsonFiles, results), err
+		}
+		for _, file := range changed {
+			m.recordLedger(ledger[file])
+		}
+		if err := m.runBudget.finish(dirPath); err != nil {
+			return inputOrder(jsonFiles, results), err
+		}
+		return inputOrder(jsonFiles, results), nil
 	}
 
-	// Wait for all imports to complete
-	wg.Wait()
-	close(resultChan)
+	// Each collection is loaded into a staging collection and swapped in as a whole
+	if m.writeOptions.Mode == domain.ImportModeSwap {
+		return m.directoryResults(dirPath, m.swapDirectory(changed))
+	}
 
-	// Collect results
-	var results []*domain.ImportResult
-	for result := range resultChan {
-		results = append(results, result)
+	// Import the files on a bounded number of workers
+	imported := make([]*domain.ImportResult, len(changed))
+	runWorkers(m.workers, len(changed), func(i int) {
+		result, err := m.ImportFile(changed[i])
+		if err == nil {
+			m.recordLedger(ledger[changed[i]])
+		}
+		imported[i] = result
+	})
+	for i, result := range imported {
+		results[changed[i]] = result
 	}
 
+	return m.directoryResults(dirPath, inputOrder(jsonFiles, results))
+}
+
+// directoryResults returns the results of a directory import with an error if any file failed
+func (m *MongoImporter) directoryResults(dirPath string, results []*domain.ImportResult) ([]*domain.ImportResult, error) {
 	// Check if any imports failed
 	var importErrors []error
 	for _, result := range results {
@@ -156,88 +556,243 @@ func (m *MongoImporter) ImportDirectory(dirPath string) ([]*domain.ImportResult,
 		}
 	}
 
+	// An exceeded error budget aborts the whole run, so it is reported as the cause
+	budgetErr := m.runBudget.finish(dirPath)
+
 	if len(importErrors) > 0 {
+		// Files cut short by the run deadline or by stopping the import fail with it,
+		// so it is reported as the cause too
+		cause := cmp.Or(budgetErr, m.deadlineErr(), m.stopErr())
+
 		// Return partial results with an error indicating some imports failed
-		return results, fmt.Errorf("%d out of %d files failed to import", len(importErrors), len(jsonFiles))
+		if cause != nil {
+			return results, fmt.Errorf("%d out of %d files failed to import: %w", len(importErrors), len(results), cause)
+		}
+		return results, fmt.Errorf("%d out of %d files failed to import", len(importErrors), len(results))
+	}
+	if budgetErr != nil {
+		return results, budgetErr
 	}
 
 	return results, nil
 }
 
-// processBatches processes a slice of documents in batches
-func (m *MongoImporter) processBatches(documents []domain.Document, collectionName string) (int, error) {
-	// Call InsertDocuments and use the result
-	result, err := m.repo.InsertDocuments(m.ctx, collectionName, documents)
+// importBatch cleans and writes one batch of documents read from a source file
+// positions holds the position of each document in the source file
+// With a dead-letter sink, documents that fail conversion or writing are rejected
+// one by one; otherwise the first failure fails the batch
+func (m *MongoImporter) importBatch(target importTarget, result *domain.ImportResult, sourceFile string, documents []domain.Document, positions []int) error {
+	// Clean documents by removing or converting _id fields before import
+	// Documents that are kept are moved to the front of the slices
+	cleaned := documents[:0]
+	cleanedPositions := positions[:0]
+	for i, doc := range documents {
+		cleanedDoc, err := m.cleanDocument(doc)
+		if err != nil {
+			result.ErrorCount++
+			err = fmt.Errorf("document %d: %w", positions[i], err)
+			if target.deadLetter == nil {
+				return err
+			}
+			rejected := domain.RejectedDocument{
+				Document:   bson.D(doc),
+				SourceFile: sourceFile,
+				Position:   positions[i],
+				Stage:      domain.RejectStageConvert,
+				Error:      err.Error(),
+			}
+			if err := target.reject(result, rejected); err != nil {
+				return err
+			}
+			continue
+		}
+		m.stamp(&cleanedDoc)
+		cleaned = append(cleaned, cleanedDoc)
+		cleanedPositions = append(cleanedPositions, positions[i])
+	}
+
+	if len(cleaned) == 0 {
+		return nil
+	}
+
+	// Versions are saved for the collection the file feeds, also when writing to a staging collection
+	if err := m.saveVersions(target.ctx, result.CollectionName, cleaned); err != nil {
+		return err
+	}
+
+	batchResult, err := m.writeBatch(target, cleaned)
+	result.AddCounts(batchResult)
+	if batchResult != nil {
+		// Failed document indexes are relative to the batch, make them relative to the file
+		for _, failure := range batchResult.Failed {
+			result.ErrorCount++
+			if target.deadLetter != nil {
+				rejected := domain.RejectedDocument{
+					Document:   bson.D(cleaned[failure.Index]),
+					SourceFile: sourceFile,
+					Position:   cleanedPositions[failure.Index],
+					Stage:      domain.RejectStageWrite,
+					Error:      failure.Message,
+					Code:       failure.Code,
+				}
+				if err := target.reject(result, rejected); err != nil {
+					return err
+				}
+			}
+			failure.Index = cleanedPositions[failure.Index]
+			result.Failed = append(result.Failed, failure)
+		}
+	}
+	return err
+}
+
+// positionTracker maps documents passed to a batch handler to their position in
+// the source file, accounting for the documents the parser rejected before them
+type positionTracker struct {
+	skipped []int // Positions of rejected documents, in ascending order
+	passed  int   // Number of documents passed to the handler so far
+	done    int   // Number of skipped positions already accounted for
+}
+
+// skip records the position of a document rejected by the parser
+func (t *positionTracker) skip(position int) {
+	t.skipped = append(t.skipped, position)
+}
+
+// next returns the position of the next document passed to the handler
+func (t *positionTracker) next() int {
+	position := t.passed + t.done
+	for t.done < len(t.skipped) && t.skipped[t.done] <= position {
+		t.done++
+		position++
+	}
+	t.passed++
+	return position
+}
+
+// resetCollection empties a collection for reload mode
+// Each collection is reset only once, so several files feeding the same
+// collection do not drop each other's documents
+func (m *MongoImporter) resetCollection(collectionName string) (int, error) {
+	m.resetMu.Lock()
+	defer m.resetMu.Unlock()
+
+	if previousCount, ok := m.resetCounts[collectionName]; ok {
+		return previousCount, nil
+	}
+
+	previousCount, err := m.repo.ResetCollection(m.ctx, collectionName)
 	if err != nil {
 		return 0, err
 	}
-
-	return result.InsertedCount, nil
+	m.resetCounts[collectionName] = previousCount
+	return previousCount, nil
 }
 
-// cleanDocuments removes _id fields from all documents to prevent MongoDB import errors
-func (m *MongoImporter) cleanDocuments(documents []domain.Document) []domain.Document {
-	if !m.removeIDField {
-		return documents
+// writeBatch writes one batch of documents read from a file using the configured write mode
+// In unordered mode failed documents don't stop the batch and are reported in the result
+func (m *MongoImporter) writeBatch(target importTarget, documents []domain.Document) (*domain.ImportResult, error) {
+	if !target.writeOptions.Mode.MatchesByKey() && !target.writeOptions.Unordered {
+		return m.processBatches(target.ctx, documents, target.collection)
 	}
 
-	idCount := 0
-	for i := range documents {
-		if _, hasID := documents[i]["_id"]; hasID {
-			idCount++
+	return m.repo.WriteDocuments(target.ctx, target.collection, documents, target.writeOptions)
+}
 
-			// _idの種類も確認
-			// fmt.Printf("Document %d has _id of type %T: %v\n",
-			// 	i, documents[i]["_id"], documents[i]["_id"])
+// processBatches inserts one batch of documents read from a file
+// The result carries the inserted count and the batch size tuned by the repository, if any
+func (m *MongoImporter) processBatches(ctx context.Context, documents []domain.Document, collectionName string) (*domain.ImportResult, error) {
+	// Call InsertDocuments and use the result
+	result, err := m.repo.InsertDocuments(ctx, collectionName, documents)
+	if err != nil {
+		return nil, err
+	}
 
-			delete(documents[i], "_id")
+	return &domain.ImportResult{InsertedCount: result.InsertedCount, BatchSize: result.BatchSize}, nil
+}
 
-			// 削除後に確認
-			// if _, stillHasID := documents[i]["_id"]; stillHasID {
-			// 	fmt.Printf("WARNING: Document %d still has _id after deletion!\n", i)
-			// }
+// cleanDocuments prepares documents for import
+// When removeIDField is set, _id fields are removed to prevent MongoDB import errors;
+// otherwise they are kept and converted to ObjectIDs where possible (see assignID)
+// Field order of each document is preserved
+func (m *MongoImporter) cleanDocuments(documents []domain.Document) ([]domain.Document, error) {
+	for i := range documents {
+		doc, err := m.cleanDocument(documents[i])
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
 		}
+		documents[i] = doc
+	}
 
-		// 各フィールドを再帰的に処理して日付を変換
-		documents[i] = m.processDocumentDates(documents[i])
+	return documents, nil
+}
+
+// cleanDocument prepares a single document for import (see cleanDocuments)
+func (m *MongoImporter) cleanDocument(doc domain.Document) (domain.Document, error) {
+	if m.removeIDField {
+		doc.Delete("_id")
 	}
 
-	return documents
+	// 各フィールドを再帰的に処理して日付を変換
+	// Extended JSON では型が明示されているため、日付に見える文字列や $date/$oid のキーもそのまま保存する
+	if !m.extendedJSON {
+		doc = m.processDocumentDates(doc)
+	}
+
+	if err := m.assignID(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
 }
 
 // processDocumentDates recursively processes all fields in a document
-// converting date strings and MongoDB's $date format to time.Time objects
+// converting date strings and MongoDB's $date format to time.Time objects,
+// and MongoDB's $oid format to ObjectIDs so references between documents keep working
+// Values are replaced in place so the field order is unchanged
 func (m *MongoImporter) processDocumentDates(doc domain.Document) domain.Document {
-	for key, value := range doc {
-		switch v := value.(type) {
-		case map[string]interface{}:
-			// $dateフィールドを持つオブジェクトをチェック
-			if dateStr, ok := v["$date"]; ok {
-				if ds, ok := dateStr.(string); ok {
-					// 日付文字列をtime.Time型に変換
-					t, err := parseDateTime(ds)
-					if err == nil {
-						// time.Time型をセット (MongoDB ドライバーが自動的に日付型として扱う)
-						doc[key] = t
-					} else {
-						fmt.Printf("Warning: Failed to parse date string '%s': %v\n", ds, err)
-					}
-				}
-			} else {
-				// ネストされたマップを再帰的に処理
-				doc[key] = m.processDocumentDates(v)
-			}
-		case string:
-			// 文字列が日付形式かチェック
-			if isDateString(v) {
-				t, err := parseDateTime(v)
+	for i, elem := range doc {
+		doc[i].Value = m.processDateValue(elem.Value)
+	}
+	return doc
+}
+
+// processDateValue converts a single value for processDocumentDates,
+// walking into nested documents and the elements of arrays
+func (m *MongoImporter) processDateValue(value any) any {
+	switch v := value.(type) {
+	case bson.D:
+		// $dateフィールドを持つオブジェクトをチェック
+		if dateStr, ok := domain.Document(v).Get("$date"); ok {
+			if ds, ok := dateStr.(string); ok {
+				// 日付文字列をtime.Time型に変換
+				t, err := parseDateTime(ds)
 				if err == nil {
-					doc[key] = t
+					// time.Time型をセット (MongoDB ドライバーが自動的に日付型として扱う)
+					return t
 				}
+				fmt.Printf("Warning: Failed to parse date string '%s': %v\n", ds, err)
+			}
+		} else if oid, ok := objectIDFromWrapper(v); ok {
+			return oid
+		} else {
+			// ネストされたドキュメントを再帰的に処理
+			return bson.D(m.processDocumentDates(domain.Document(v)))
+		}
+	case bson.A:
+		// 配列の要素も同じように処理
+		for i := range v {
+			v[i] = m.processDateValue(v[i])
+		}
+	case string:
+		// 文字列が日付形式かチェック
+		if isDateString(v) {
+			t, err := parseDateTime(v)
+			if err == nil {
+				return t
 			}
 		}
 	}
-	return doc
+	return value
 }
 
 // parseDateTime parses a date string in various formats
@@ -3,18 +3,31 @@ package service
 import (
 	"context"
 	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
 	"github.com/OTakumi/data-importer/internal/domain"
+	"github.com/OTakumi/data-importer/internal/utils"
 )
 
 // MockFileUtils is a mock implementation of the file utilities for testing
 type MockFileUtils struct {
-	IsDirectoryFunc   func(path string) (bool, error)
-	FindJSONFilesFunc func(dirPath string) ([]string, error)
-	ParseJSONFileFunc func(filePath string) ([]map[string]any, error)
+	IsDirectoryFunc      func(path string) (bool, error)
+	FileSizeFunc         func(path string) (int64, error)
+	FileModTimeFunc      func(path string) (time.Time, error)
+	FileHashFunc         func(path string) (string, error)
+	FindJSONFilesFunc    func(dirPath string) ([]string, error)
+	ParseJSONFileFunc    func(filePath string) ([]bson.D, error)
+	StreamDocumentsFunc  func(filePath string, batchSize int, handler utils.BatchHandler) error
+	StreamRejectsFunc    func(filePath string, batchSize int, handler utils.BatchHandler, reject utils.RejectHandler) error
+	StreamDeadLetterFunc func(filePath string, batchSize int, handler utils.BatchHandler) error
+	ParseDocumentFunc    func(text, format string) (bson.D, error)
 }
 
 // IsDirectory mocks the IsDirectory method
@@ -22,20 +35,110 @@ func (m *MockFileUtils) IsDirectory(path string) (bool, error) {
 	return m.IsDirectoryFunc(path)
 }
 
+// FileSize mocks the FileSize method; files are empty unless FileSizeFunc is set
+func (m *MockFileUtils) FileSize(path string) (int64, error) {
+	if m.FileSizeFunc != nil {
+		return m.FileSizeFunc(path)
+	}
+	return 0, nil
+}
+
+// FileModTime mocks the FileModTime method; files have the zero time unless FileModTimeFunc is set
+func (m *MockFileUtils) FileModTime(path string) (time.Time, error) {
+	if m.FileModTimeFunc != nil {
+		return m.FileModTimeFunc(path)
+	}
+	return time.Time{}, nil
+}
+
+// FileHash mocks the FileHash method; every file has the same empty hash unless FileHashFunc is set
+func (m *MockFileUtils) FileHash(path string) (string, error) {
+	if m.FileHashFunc != nil {
+		return m.FileHashFunc(path)
+	}
+	return "", nil
+}
+
 // FindJSONFiles mocks the FindJSONFiles method
 func (m *MockFileUtils) FindJSONFiles(dirPath string) ([]string, error) {
 	return m.FindJSONFilesFunc(dirPath)
 }
 
 // ParseJSONFile mocks the ParseJSONFile method
-func (m *MockFileUtils) ParseJSONFile(filePath string) ([]map[string]any, error) {
+func (m *MockFileUtils) ParseJSONFile(filePath string) ([]bson.D, error) {
 	return m.ParseJSONFileFunc(filePath)
 }
 
+// StreamDocuments mocks the StreamDocuments method
+// When StreamDocumentsFunc is not set, the documents returned by ParseJSONFileFunc
+// are passed to the handler in batches of batchSize
+func (m *MockFileUtils) StreamDocuments(filePath string, batchSize int, handler utils.BatchHandler) error {
+	if m.StreamDocumentsFunc != nil {
+		return m.StreamDocumentsFunc(filePath, batchSize, handler)
+	}
+
+	documents, err := m.ParseJSONFileFunc(filePath)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(documents); start += batchSize {
+		end := min(start+batchSize, len(documents))
+		if err := handler(documents[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamDocumentsWithRejects mocks the StreamDocumentsWithRejects method
+// When StreamRejectsFunc is not set, it behaves like StreamDocuments
+func (m *MockFileUtils) StreamDocumentsWithRejects(filePath string, batchSize int, handler utils.BatchHandler, reject utils.RejectHandler) error {
+	if m.StreamRejectsFunc != nil {
+		return m.StreamRejectsFunc(filePath, batchSize, handler, reject)
+	}
+	return m.StreamDocuments(filePath, batchSize, handler)
+}
+
+// StreamDeadLetter mocks the StreamDeadLetter method
+func (m *MockFileUtils) StreamDeadLetter(filePath string, batchSize int, handler utils.BatchHandler) error {
+	return m.StreamDeadLetterFunc(filePath, batchSize, handler)
+}
+
+// ParseDocument mocks the ParseDocument method
+// When ParseDocumentFunc is not set, the text is parsed with the default parse options
+func (m *MockFileUtils) ParseDocument(text, format string) (bson.D, error) {
+	if m.ParseDocumentFunc != nil {
+		return m.ParseDocumentFunc(text, format)
+	}
+	return utils.NewFileUtils(nil).ParseDocument(text, format)
+}
+
 // MockRepository is a mock implementation of the document repository for testing
 type MockRepository struct {
-	InsertDocumentsFunc func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error)
-	DisconnectFunc      func(ctx context.Context) error
+	InsertDocumentsFunc  func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error)
+	WriteDocumentsFunc   func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error)
+	ScanDocumentsFunc    func(ctx context.Context, collectionName string, fn func(doc bson.Raw) error) error
+	DeleteDocumentsFunc  func(ctx context.Context, collectionName string, ids []any) (int, error)
+	ListCollectionsFunc  func(ctx context.Context) ([]string, error)
+	DropCollectionFunc   func(ctx context.Context, collectionName string) error
+	ResetCollectionFunc  func(ctx context.Context, collectionName string) (int, error)
+	MergeCollectionFunc  func(ctx context.Context, source, target string, opts domain.WriteOptions) (int, error)
+	WithTransactionFunc  func(ctx context.Context, fn func(ctx context.Context) error) error
+	CreateStagingFunc    func(ctx context.Context, source, staging string) error
+	CountDocumentsFunc   func(ctx context.Context, collectionName string) (int, error)
+	ValidateFunc         func(ctx context.Context, collectionName string) error
+	RenameFunc           func(ctx context.Context, source, target string) error
+	SaveImportRunFunc    func(ctx context.Context, run *domain.ImportRun) error
+	FindImportRunFunc    func(ctx context.Context, runID string) (*domain.ImportRun, error)
+	FindMatchingFunc     func(ctx context.Context, collectionName string, documents []domain.Document, keyFields []string) ([]domain.Document, error)
+	SaveVersionsFunc     func(ctx context.Context, runID, collectionName string, documents []domain.Document) error
+	RollbackFunc         func(ctx context.Context, runID, runField, collectionName string) (*domain.RollbackResult, error)
+	SaveCheckpointFunc   func(ctx context.Context, checkpoint *domain.Checkpoint) error
+	FindCheckpointFunc   func(ctx context.Context, file string) (*domain.Checkpoint, error)
+	DeleteCheckpointFunc func(ctx context.Context, file string) error
+	FindLedgerFunc       func(ctx context.Context, file string) (*domain.LedgerEntry, error)
+	SaveLedgerFunc       func(ctx context.Context, entry *domain.LedgerEntry) error
+	DisconnectFunc       func(ctx context.Context) error
 }
 
 // InsertDocuments mocks the InsertDocuments method
@@ -43,6 +146,143 @@ func (m *MockRepository) InsertDocuments(ctx context.Context, collectionName str
 	return m.InsertDocumentsFunc(ctx, collectionName, documents)
 }
 
+// WriteDocuments mocks the WriteDocuments method
+func (m *MockRepository) WriteDocuments(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+	return m.WriteDocumentsFunc(ctx, collectionName, documents, opts)
+}
+
+// ScanDocuments mocks the ScanDocuments method
+func (m *MockRepository) ScanDocuments(ctx context.Context, collectionName string, fn func(doc bson.Raw) error) error {
+	return m.ScanDocumentsFunc(ctx, collectionName, fn)
+}
+
+// DeleteDocuments mocks the DeleteDocuments method
+func (m *MockRepository) DeleteDocuments(ctx context.Context, collectionName string, ids []any) (int, error) {
+	return m.DeleteDocumentsFunc(ctx, collectionName, ids)
+}
+
+// ListCollections mocks the ListCollections method
+func (m *MockRepository) ListCollections(ctx context.Context) ([]string, error) {
+	return m.ListCollectionsFunc(ctx)
+}
+
+// DropCollection mocks the DropCollection method
+func (m *MockRepository) DropCollection(ctx context.Context, collectionName string) error {
+	return m.DropCollectionFunc(ctx, collectionName)
+}
+
+// ResetCollection mocks the ResetCollection method
+func (m *MockRepository) ResetCollection(ctx context.Context, collectionName string) (int, error) {
+	if m.ResetCollectionFunc != nil {
+		return m.ResetCollectionFunc(ctx, collectionName)
+	}
+	return 0, nil
+}
+
+// MergeCollection mocks the MergeCollection method
+func (m *MockRepository) MergeCollection(ctx context.Context, source, target string, opts domain.WriteOptions) (int, error) {
+	return m.MergeCollectionFunc(ctx, source, target, opts)
+}
+
+// WithTransaction mocks the WithTransaction method
+// When WithTransactionFunc is not set, fn runs without a transaction
+func (m *MockRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if m.WithTransactionFunc != nil {
+		return m.WithTransactionFunc(ctx, fn)
+	}
+	return fn(ctx)
+}
+
+// CreateStagingCollection mocks the CreateStagingCollection method
+func (m *MockRepository) CreateStagingCollection(ctx context.Context, source, staging string) error {
+	return m.CreateStagingFunc(ctx, source, staging)
+}
+
+// CountDocuments mocks the CountDocuments method
+func (m *MockRepository) CountDocuments(ctx context.Context, collectionName string) (int, error) {
+	return m.CountDocumentsFunc(ctx, collectionName)
+}
+
+// ValidateCollection mocks the ValidateCollection method
+func (m *MockRepository) ValidateCollection(ctx context.Context, collectionName string) error {
+	return m.ValidateFunc(ctx, collectionName)
+}
+
+// RenameCollection mocks the RenameCollection method
+func (m *MockRepository) RenameCollection(ctx context.Context, source, target string) error {
+	return m.RenameFunc(ctx, source, target)
+}
+
+// SaveImportRun mocks the SaveImportRun method
+// When SaveImportRunFunc is not set, the run is not recorded
+func (m *MockRepository) SaveImportRun(ctx context.Context, run *domain.ImportRun) error {
+	if m.SaveImportRunFunc != nil {
+		return m.SaveImportRunFunc(ctx, run)
+	}
+	return nil
+}
+
+// FindImportRun mocks the FindImportRun method
+func (m *MockRepository) FindImportRun(ctx context.Context, runID string) (*domain.ImportRun, error) {
+	return m.FindImportRunFunc(ctx, runID)
+}
+
+// FindMatchingDocuments mocks the FindMatchingDocuments method
+func (m *MockRepository) FindMatchingDocuments(ctx context.Context, collectionName string, documents []domain.Document, keyFields []string) ([]domain.Document, error) {
+	return m.FindMatchingFunc(ctx, collectionName, documents, keyFields)
+}
+
+// SaveDocumentVersions mocks the SaveDocumentVersions method
+func (m *MockRepository) SaveDocumentVersions(ctx context.Context, runID, collectionName string, documents []domain.Document) error {
+	return m.SaveVersionsFunc(ctx, runID, collectionName, documents)
+}
+
+// RollbackCollection mocks the RollbackCollection method
+func (m *MockRepository) RollbackCollection(ctx context.Context, runID, runField, collectionName string) (*domain.RollbackResult, error) {
+	return m.RollbackFunc(ctx, runID, runField, collectionName)
+}
+
+// SaveCheckpoint mocks the SaveCheckpoint method
+// When SaveCheckpointFunc is not set, the checkpoint is not recorded
+func (m *MockRepository) SaveCheckpoint(ctx context.Context, checkpoint *domain.Checkpoint) error {
+	if m.SaveCheckpointFunc != nil {
+		return m.SaveCheckpointFunc(ctx, checkpoint)
+	}
+	return nil
+}
+
+// FindCheckpoint mocks the FindCheckpoint method
+func (m *MockRepository) FindCheckpoint(ctx context.Context, file string) (*domain.Checkpoint, error) {
+	return m.FindCheckpointFunc(ctx, file)
+}
+
+// DeleteCheckpoint mocks the DeleteCheckpoint method
+// When DeleteCheckpointFunc is not set, nothing is deleted
+func (m *MockRepository) DeleteCheckpoint(ctx context.Context, file string) error {
+	if m.DeleteCheckpointFunc != nil {
+		return m.DeleteCheckpointFunc(ctx, file)
+	}
+	return nil
+}
+
+// FindLedgerEntry mocks the FindLedgerEntry method
+// When FindLedgerFunc is not set, no file was imported before
+func (m *MockRepository) FindLedgerEntry(ctx context.Context, file string) (*domain.LedgerEntry, error) {
+	if m.FindLedgerFunc != nil {
+		return m.FindLedgerFunc(ctx, file)
+	}
+	return nil, nil
+}
+
+// SaveLedgerEntry mocks the SaveLedgerEntry method
+// When SaveLedgerFunc is not set, the entry is not recorded
+func (m *MockRepository) SaveLedgerEntry(ctx context.Context, entry *domain.LedgerEntry) error {
+	if m.SaveLedgerFunc != nil {
+		return m.SaveLedgerFunc(ctx, entry)
+	}
+	return nil
+}
+
 // Disconnect mocks the Disconnect method
 func (m *MockRepository) Disconnect(ctx context.Context) error {
 	if m.DisconnectFunc != nil {
@@ -68,10 +308,10 @@ func TestImportFile(t *testing.T) {
 			name:     "Successful import",
 			filePath: "/data/users.json",
 			mockFileUtils: &MockFileUtils{
-				ParseJSONFileFunc: func(filePath string) ([]map[string]any, error) {
-					return []map[string]any{
-						{"name": "John", "age": 30},
-						{"name": "Jane", "age": 25},
+				ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+					return []bson.D{
+						{{Key: "name", Value: "John"}, {Key: "age", Value: 30}},
+						{{Key: "name", Value: "Jane"}, {Key: "age", Value: 25}},
 					}, nil
 				},
 			},
@@ -102,7 +342,7 @@ func TestImportFile(t *testing.T) {
 			name:     "Parse error",
 			filePath: "/data/invalid.json",
 			mockFileUtils: &MockFileUtils{
-				ParseJSONFileFunc: func(filePath string) ([]map[string]any, error) {
+				ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
 					return nil, errors.New("parse error")
 				},
 			},
@@ -124,9 +364,9 @@ func TestImportFile(t *testing.T) {
 			name:     "Database error",
 			filePath: "/data/users.json",
 			mockFileUtils: &MockFileUtils{
-				ParseJSONFileFunc: func(filePath string) ([]map[string]any, error) {
-					return []map[string]any{
-						{"name": "John", "age": 30},
+				ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+					return []bson.D{
+						{{Key: "name", Value: "John"}, {Key: "age", Value: 30}},
 					}, nil
 				},
 			},
@@ -182,6 +422,271 @@ func TestImportFile(t *testing.T) {
 	}
 }
 
+// TestImportFileStreaming tests that ImportFile inserts each batch as it is read
+func TestImportFileStreaming(t *testing.T) {
+	ctx := context.Background()
+
+	// Test cases
+	tests := []struct {
+		name            string
+		streamErr       error
+		expectedBatches []int
+		expectedCount   int
+		expectedError   string
+	}{
+		{
+			name:            "All batches inserted",
+			streamErr:       nil,
+			expectedBatches: []int{100, 100, 50},
+			expectedCount:   250,
+		},
+		{
+			name:            "Parse error after some batches",
+			streamErr:       errors.New("unexpected EOF"),
+			expectedBatches: []int{100, 100, 50},
+			expectedCount:   250,
+			expectedError:   "error parsing file /data/events.json: unexpected EOF",
+		},
+	}
+
+	// Run test cases
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockFileUtils := &MockFileUtils{
+				StreamDocumentsFunc: func(filePath string, batchSize int, handler utils.BatchHandler) error {
+					// Simulate a parser that yields 250 documents in batches
+					for start := 0; start < 250; start += batchSize {
+						batch := make([]bson.D, 0, batchSize)
+						for i := start; i < min(start+batchSize, 250); i++ {
+							batch = append(batch, bson.D{{Key: "id", Value: i}})
+						}
+						if err := handler(batch); err != nil {
+							return err
+						}
+					}
+					return tt.streamErr
+				},
+			}
+
+			var batches []int
+			mockRepo := &MockRepository{
+				InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+					batches = append(batches, len(documents))
+					return &domain.ImportResult{
+						CollectionName: collectionName,
+						InsertedCount:  len(documents),
+					}, nil
+				},
+			}
+
+			importer := NewMongoImporterWithOptions(ctx, mockFileUtils, mockRepo, 100, false)
+			result, err := importer.ImportFile("/data/events.json")
+
+			if tt.expectedError == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tt.expectedError != "" && (err == nil || err.Error() != tt.expectedError) {
+				t.Errorf("Expected error %q, got %v", tt.expectedError, err)
+			}
+			if !reflect.DeepEqual(batches, tt.expectedBatches) {
+				t.Errorf("Expected batches %v, got %v", tt.expectedBatches, batches)
+			}
+			if result.InsertedCount != tt.expectedCount {
+				t.Errorf("Expected count %d, got %d", tt.expectedCount, result.InsertedCount)
+			}
+		})
+	}
+}
+
+// TestImportFileExtendedJSON tests that Extended JSON documents are stored exactly as decoded:
+// strings that look like dates or ObjectIDs stay strings and only explicit type wrappers become BSON types
+func TestImportFileExtendedJSON(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "events.json")
+	source := `[{"_id": "5f1d7a0e8b3e4a0001000000", "label": "2024-01-15", "note": "2024-01-15T10:00:00Z",` +
+		` "created": {"$date": "2024-01-15T10:00:00Z"}, "ref": {"$oid": "5f1d7a0e8b3e4a0001000001"},` +
+		` "nested": {"at": "2024-01-15T10:00:00.000Z"}}]`
+	if err := os.WriteFile(filePath, []byte(source), 0o644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	var stored []domain.Document
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			stored = append(stored, documents...)
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	fileUtils := utils.NewFileUtilsWithOptions(nil, utils.ParseOptions{ExtendedJSON: true})
+	importer := NewMongoImporter(context.Background(), fileUtils, mockRepo, ImporterOptions{ExtendedJSON: true})
+	if _, err := importer.ImportFile(filePath); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ref, _ := primitive.ObjectIDFromHex("5f1d7a0e8b3e4a0001000001")
+	created := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	expected := []domain.Document{{
+		{Key: "_id", Value: "5f1d7a0e8b3e4a0001000000"},
+		{Key: "label", Value: "2024-01-15"},
+		{Key: "note", Value: "2024-01-15T10:00:00Z"},
+		{Key: "created", Value: primitive.NewDateTimeFromTime(created)},
+		{Key: "ref", Value: ref},
+		{Key: "nested", Value: bson.D{{Key: "at", Value: "2024-01-15T10:00:00.000Z"}}},
+	}}
+	if !reflect.DeepEqual(stored, expected) {
+		t.Errorf("Expected the documents to be stored as decoded\nexpected: %v\ngot:      %v", expected, stored)
+	}
+}
+
+// TestImportFileWriteMode tests that upsert, replace and merge modes use WriteDocuments
+func TestImportFileWriteMode(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{
+				{{Key: "_id", Value: "507f1f77bcf86cd799439011"}, {Key: "email", Value: "a@example.com"}},
+				{{Key: "_id", Value: "507f1f77bcf86cd799439012"}, {Key: "email", Value: "b@example.com"}},
+			}, nil
+		},
+	}
+
+	var gotOpts domain.WriteOptions
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			t.Error("InsertDocuments should not be called in upsert mode")
+			return nil, errors.New("unexpected insert")
+		},
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			gotOpts = opts
+			return &domain.ImportResult{
+				CollectionName: collectionName,
+				MatchedCount:   1,
+				ModifiedCount:  1,
+				UpsertedCount:  len(documents) - 1,
+			}, nil
+		},
+	}
+
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{
+		BatchSize: 100,
+		Mode:      domain.ImportModeUpsert,
+		KeyFields: []string{"email"},
+	})
+	result, err := importer.ImportFile("/data/users.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedOpts := domain.WriteOptions{Mode: domain.ImportModeUpsert, KeyFields: []string{"email"}}
+	if !reflect.DeepEqual(gotOpts, expectedOpts) {
+		t.Errorf("Expected write options %+v, got %+v", expectedOpts, gotOpts)
+	}
+	if result.InsertedCount != 0 || result.MatchedCount != 1 || result.ModifiedCount != 1 || result.UpsertedCount != 1 {
+		t.Errorf("Unexpected counts: %+v", result)
+	}
+}
+
+// TestImportFileUnordered tests that failed documents are reported with their position in the file
+func TestImportFileUnordered(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			docs := make([]bson.D, 0, 5)
+			for i := 0; i < 5; i++ {
+				docs = append(docs, bson.D{{Key: "n", Value: i}})
+			}
+			return docs, nil
+		},
+	}
+
+	mockRepo := &MockRepository{
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			if !opts.Unordered {
+				t.Error("Expected unordered write options")
+			}
+			// The first document of every batch fails
+			return &domain.ImportResult{
+				CollectionName: collectionName,
+				InsertedCount:  len(documents) - 1,
+				Failed:         []domain.DocumentFailure{{Index: 0, Code: 121, Message: "Document failed validation"}},
+			}, nil
+		},
+	}
+
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{BatchSize: 2, Unordered: true})
+	result, err := importer.ImportFile("/data/users.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.InsertedCount != 2 {
+		t.Errorf("Expected 2 inserted documents, got %d", result.InsertedCount)
+	}
+	var indexes []int
+	for _, failure := range result.Failed {
+		indexes = append(indexes, failure.Index)
+	}
+	if !reflect.DeepEqual(indexes, []int{0, 2, 4}) {
+		t.Errorf("Expected failures at 0, 2 and 4, got %v", indexes)
+	}
+}
+
+// TestImportFileReload tests that reload mode resets each collection once before inserting
+func TestImportFileReload(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "code", Value: "JP"}}, {{Key: "code", Value: "US"}}}, nil
+		},
+	}
+
+	var events []string
+	mockRepo := &MockRepository{
+		ResetCollectionFunc: func(ctx context.Context, collectionName string) (int, error) {
+			events = append(events, "reset "+collectionName)
+			return 7, nil
+		},
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			events = append(events, "insert "+collectionName)
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{Mode: domain.ImportModeReload})
+
+	// Two files feeding the same collection must not reset it twice
+	for _, path := range []string{"/data/countries.json", "/more/countries.csv"} {
+		result, err := importer.ImportFile(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.PreviousCount != 7 || result.InsertedCount != 2 {
+			t.Errorf("Expected previous count 7 and 2 inserted, got %d and %d", result.PreviousCount, result.InsertedCount)
+		}
+	}
+
+	expected := []string{"reset countries", "insert countries", "insert countries"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected %v, got %v", expected, events)
+	}
+
+	// A failed reset stops the import before anything is inserted
+	events = nil
+	mockRepo.ResetCollectionFunc = func(ctx context.Context, collectionName string) (int, error) {
+		return 0, errors.New("not authorized")
+	}
+	_, err := importer.ImportFile("/data/regions.json")
+	if err == nil || err.Error() != "error resetting collection regions: not authorized" {
+		t.Errorf("Expected reset error, got %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no inserts after a failed reset, got %v", events)
+	}
+}
+
 // TestImportDirectory tests the ImportDirectory method
 func TestImportDirectory(t *testing.T) {
 	ctx := context.Background()
@@ -202,17 +707,17 @@ func TestImportDirectory(t *testing.T) {
 				FindJSONFilesFunc: func(dirPath string) ([]string, error) {
 					return []string{"/data/users.json", "/data/products.json"}, nil
 				},
-				ParseJSONFileFunc: func(filePath string) ([]map[string]any, error) {
+				ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
 					if filePath == "/data/users.json" {
-						return []map[string]any{
-							{"name": "User1"},
-							{"name": "User2"},
+						return []bson.D{
+							{{Key: "name", Value: "User1"}},
+							{{Key: "name", Value: "User2"}},
 						}, nil
 					}
-					return []map[string]any{
-						{"id": 1, "name": "Product1"},
-						{"id": 2, "name": "Product2"},
-						{"id": 3, "name": "Product3"},
+					return []bson.D{
+						{{Key: "id", Value: 1}, {Key: "name", Value: "Product1"}},
+						{{Key: "id", Value: 2}, {Key: "name", Value: "Product2"}},
+						{{Key: "id", Value: 3}, {Key: "name", Value: "Product3"}},
 					}, nil
 				},
 			},
@@ -282,9 +787,9 @@ func TestImportDirectory(t *testing.T) {
 				FindJSONFilesFunc: func(dirPath string) ([]string, error) {
 					return []string{"/mixed/valid.json", "/mixed/invalid.json"}, nil
 				},
-				ParseJSONFileFunc: func(filePath string) ([]map[string]any, error) {
+				ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
 					if filePath == "/mixed/valid.json" {
-						return []map[string]any{{"valid": true}}, nil
+						return []bson.D{{{Key: "valid", Value: true}}}, nil
 					}
 					return nil, errors.New("parse error")
 				},
@@ -385,8 +890,8 @@ func TestImportPath(t *testing.T) {
 				IsDirectoryFunc: func(path string) (bool, error) {
 					return false, nil
 				},
-				ParseJSONFileFunc: func(filePath string) ([]map[string]any, error) {
-					return []map[string]any{{"name": "User1"}}, nil
+				ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+					return []bson.D{{{Key: "name", Value: "User1"}}}, nil
 				},
 			},
 			mockRepo: &MockRepository{
@@ -411,8 +916,8 @@ func TestImportPath(t *testing.T) {
 				FindJSONFilesFunc: func(dirPath string) ([]string, error) {
 					return []string{"/data/file1.json"}, nil
 				},
-				ParseJSONFileFunc: func(filePath string) ([]map[string]any, error) {
-					return []map[string]any{{"key": "value"}}, nil
+				ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+					return []bson.D{{{Key: "key", Value: "value"}}}, nil
 				},
 			},
 			mockRepo: &MockRepository{
@@ -486,8 +991,8 @@ func TestProcessBatches(t *testing.T) {
 	// Create test data with 250 documents
 	documents := make([]domain.Document, 250)
 	for i := 0; i < 250; i++ {
-		// Use map conversion since domain.Document is a map type alias
-		documents[i] = domain.Document{"id": i, "name": "Test"}
+		// domain.Document is based on bson.D, so fields are listed in order
+		documents[i] = domain.Document{{Key: "id", Value: i}, {Key: "name", Value: "Test"}}
 	}
 
 	// Test cases
@@ -552,7 +1057,11 @@ func TestProcessBatches(t *testing.T) {
 			importer := NewMongoImporterWithOptions(ctx, &MockFileUtils{}, tt.mockRepo, tt.batchSize, false)
 
 			// Call the method directly (it's private, but we can access it in tests)
-			count, err := importer.processBatches(tt.documents, "test_collection")
+			result, err := importer.processBatches(ctx, tt.documents, "test_collection")
+			count := 0
+			if result != nil {
+				count = result.InsertedCount
+			}
 
 			// Check the error
 			if tt.expectError && err == nil {
@@ -588,6 +1097,9 @@ func TestCleanDocuments(t *testing.T) {
 	dateStr3 := "2014-02-19T14:24:08.000Z"
 	timeObj3, _ := time.Parse(time.RFC3339, dateStr3)
 
+	oid1, _ := primitive.ObjectIDFromHex("67aea3a5369bca5b08f38a67")
+	oid2, _ := primitive.ObjectIDFromHex("67aea3a5369bca5b08f38a68")
+
 	// Test cases
 	tests := []struct {
 		name          string
@@ -599,24 +1111,24 @@ func TestCleanDocuments(t *testing.T) {
 			name: "Basic: Remove _id fields",
 			input: []domain.Document{
 				{
-					"_id":  map[string]any{"$oid": "67aea3a5369bca5b08f38a67"},
-					"name": "Test Document",
-					"age":  30,
+					{Key: "_id", Value: bson.D{{Key: "$oid", Value: "67aea3a5369bca5b08f38a67"}}},
+					{Key: "name", Value: "Test Document"},
+					{Key: "age", Value: 30},
 				},
 				{
-					"_id":    map[string]any{"$oid": "67aea3a5369bca5b08f38a68"},
-					"name":   "Another Document",
-					"active": true,
+					{Key: "_id", Value: bson.D{{Key: "$oid", Value: "67aea3a5369bca5b08f38a68"}}},
+					{Key: "name", Value: "Another Document"},
+					{Key: "active", Value: true},
 				},
 			},
 			expected: []domain.Document{
 				{
-					"name": "Test Document",
-					"age":  30,
+					{Key: "name", Value: "Test Document"},
+					{Key: "age", Value: 30},
 				},
 				{
-					"name":   "Another Document",
-					"active": true,
+					{Key: "name", Value: "Another Document"},
+					{Key: "active", Value: true},
 				},
 			},
 			removeIDField: true,
@@ -625,64 +1137,117 @@ func TestCleanDocuments(t *testing.T) {
 			name: "Date Fields: Convert $date fields to time.Time",
 			input: []domain.Document{
 				{
-					"_id":        map[string]any{"$oid": "67aea3a5369bca5b08f38a67"},
-					"name":       "Document with dates",
-					"created_at": map[string]any{"$date": "2024-05-22T16:04:35.000Z"},
-					"updated_at": map[string]any{"$date": "2024-05-23T10:15:20.000Z"},
+					{Key: "_id", Value: bson.D{{Key: "$oid", Value: "67aea3a5369bca5b08f38a67"}}},
+					{Key: "name", Value: "Document with dates"},
+					{Key: "created_at", Value: bson.D{{Key: "$date", Value: "2024-05-22T16:04:35.000Z"}}},
+					{Key: "updated_at", Value: bson.D{{Key: "$date", Value: "2024-05-23T10:15:20.000Z"}}},
 				},
 			},
 			expected: []domain.Document{
 				{
-					"name":       "Document with dates",
-					"created_at": timeObj1,
-					"updated_at": timeObj2,
+					{Key: "name", Value: "Document with dates"},
+					{Key: "created_at", Value: timeObj1},
+					{Key: "updated_at", Value: timeObj2},
 				},
 			},
 			removeIDField: true,
 		},
 		{
-			name: "No Removal: When removeIDField is false",
+			name: "No Removal: $oid converted to ObjectID when removeIDField is false",
 			input: []domain.Document{
 				{
-					"_id":  map[string]any{"$oid": "67aea3a5369bca5b08f38a67"},
-					"name": "Document with _id preserved",
+					{Key: "_id", Value: bson.D{{Key: "$oid", Value: "67aea3a5369bca5b08f38a67"}}},
+					{Key: "name", Value: "Document with _id preserved"},
 				},
 			},
 			expected: []domain.Document{
 				{
-					"_id":  map[string]any{"$oid": "67aea3a5369bca5b08f38a67"},
-					"name": "Document with _id preserved",
+					{Key: "_id", Value: oid1},
+					{Key: "name", Value: "Document with _id preserved"},
 				},
 			},
 			removeIDField: false,
 		},
+		{
+			name: "No Removal: hex string _id converted and moved to the front",
+			input: []domain.Document{
+				{
+					{Key: "name", Value: "Document with hex _id"},
+					{Key: "_id", Value: "67aea3a5369bca5b08f38a67"},
+					{Key: "owner", Value: bson.D{{Key: "$oid", Value: "67aea3a5369bca5b08f38a68"}}},
+					{Key: "created_at", Value: bson.D{{Key: "$date", Value: dateStr1}}},
+				},
+				{
+					{Key: "_id", Value: "custom-id"},
+				},
+			},
+			expected: []domain.Document{
+				{
+					{Key: "_id", Value: oid1},
+					{Key: "name", Value: "Document with hex _id"},
+					{Key: "owner", Value: oid2},
+					{Key: "created_at", Value: timeObj1},
+				},
+				{
+					{Key: "_id", Value: "custom-id"},
+				},
+			},
+			removeIDField: false,
+		},
+		{
+			name: "Arrays: $oid and $date elements converted",
+			input: []domain.Document{
+				{
+					{Key: "name", Value: "Document with references"},
+					{Key: "members", Value: bson.A{
+						bson.D{{Key: "$oid", Value: "67aea3a5369bca5b08f38a67"}},
+						bson.D{{Key: "$oid", Value: "67aea3a5369bca5b08f38a68"}},
+					}},
+					{Key: "history", Value: bson.A{
+						bson.D{{Key: "at", Value: bson.D{{Key: "$date", Value: dateStr1}}}},
+						bson.A{"plain", 1},
+					}},
+				},
+			},
+			expected: []domain.Document{
+				{
+					{Key: "name", Value: "Document with references"},
+					{Key: "members", Value: bson.A{oid1, oid2}},
+					{Key: "history", Value: bson.A{
+						bson.D{{Key: "at", Value: timeObj1}},
+						bson.A{"plain", 1},
+					}},
+				},
+			},
+			removeIDField: true,
+		},
 		{
 			name: "Complex Document: Multiple fields and types",
 			input: []domain.Document{
 				{
-					"_id":                map[string]any{"$oid": "67aea3a5369bca5b08f38a67"},
-					"user_id":            4,
-					"name":               "",
-					"business_form_type": "CORPORATION",
-					"tel":                "080-9966-0373",
-					"zip":                "1530064",
-					"created_at":         map[string]any{"$date": "2014-02-19T14:24:08.000Z"},
-					"updated_at":         map[string]any{"$date": "2024-05-22T16:04:35.000Z"},
-					"division_type":      nil,
-					"buyer_team_id":      4,
+					{Key: "_id", Value: bson.D{{Key: "$oid", Value: "67aea3a5369bca5b08f38a67"}}},
+					{Key: "user_id", Value: 4},
+					{Key: "name", Value: ""},
+					{Key: "business_form_type", Value: "CORPORATION"},
+					{Key: "tel", Value: "080-9966-0373"},
+					{Key: "zip", Value: "1530064"},
+					{Key: "created_at", Value: bson.D{{Key: "$date", Value: "2014-02-19T14:24:08.000Z"}}},
+					{Key: "updated_at", Value: bson.D{{Key: "$date", Value: "2024-05-22T16:04:35.000Z"}}},
+					{Key: "division_type", Value: nil},
+					{Key: "buyer_team_id", Value: 4},
 				},
 			},
 			expected: []domain.Document{
 				{
-					"user_id":            4,
-					"name":               "",
-					"business_form_type": "CORPORATION",
-					"tel":                "080-9966-0373",
-					"zip":                "1530064",
-					"created_at":         timeObj3,
-					"updated_at":         timeObj1,
-					"division_type":      nil,
-					"buyer_team_id":      4,
+					{Key: "user_id", Value: 4},
+					{Key: "name", Value: ""},
+					{Key: "business_form_type", Value: "CORPORATION"},
+					{Key: "tel", Value: "080-9966-0373"},
+					{Key: "zip", Value: "1530064"},
+					{Key: "created_at", Value: timeObj3},
+					{Key: "updated_at", Value: timeObj1},
+					{Key: "division_type", Value: nil},
+					{Key: "buyer_team_id", Value: 4},
 				},
 			},
 			removeIDField: true,
@@ -697,14 +1262,14 @@ func TestCleanDocuments(t *testing.T) {
 			name: "Documents Without ID: Should not change",
 			input: []domain.Document{
 				{
-					"name": "Document without _id",
-					"age":  25,
+					{Key: "name", Value: "Document without _id"},
+					{Key: "age", Value: 25},
 				},
 			},
 			expected: []domain.Document{
 				{
-					"name": "Document without _id",
-					"age":  25,
+					{Key: "name", Value: "Document without _id"},
+					{Key: "age", Value: 25},
 				},
 			},
 			removeIDField: true,
@@ -718,17 +1283,41 @@ func TestCleanDocuments(t *testing.T) {
 			importer.removeIDField = tt.removeIDField
 
 			// Call the function being tested
-			result := importer.cleanDocuments(tt.input)
+			result, err := importer.cleanDocuments(tt.input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
 
 			// Check results
 			if !reflect.DeepEqual(result, tt.expected) {
 				t.Errorf("Expected\n%+v\ngot\n%+v", tt.expected, result)
 
 				// 実際の型情報を表示して確認
-				for k, v := range result[0] {
-					t.Logf("Field %s: Type %T, Value %v", k, v, v)
+				for _, elem := range result[0] {
+					t.Logf("Field %s: Type %T, Value %v", elem.Key, elem.Value, elem.Value)
 				}
 			}
 		})
 	}
 }
+
+// TestImportFileReportsTunedBatchSize tests that the batch size tuned by the repository is reported
+func TestImportFileReportsTunedBatchSize(t *testing.T) {
+	tuned := []int{200, 400, 300}
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			batchSize := tuned[0]
+			tuned = tuned[1:]
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents), BatchSize: batchSize}, nil
+		},
+	}
+
+	importer := NewMongoImporter(context.Background(), checkpointFileUtils("abc"), mockRepo, ImporterOptions{BatchSize: 2})
+	result, err := importer.ImportFile("/data/users.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.BatchSize != 300 || result.InsertedCount != 5 {
+		t.Errorf("Expected the last tuned batch size 300 and 5 documents, got %+v", result)
+	}
+}
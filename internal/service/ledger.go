@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+	"github.com/OTakumi/data-importer/internal/utils"
+)
+
+// usesLedger reports whether files unchanged since their last import can be skipped
+// Reload and swap rebuild whole collections from their files, so every file is imported again
+func (m *MongoImporter) usesLedger() bool {
+	switch m.writeOptions.Mode {
+	case domain.ImportModeReload, domain.ImportModeSwap, domain.ImportModeSync:
+		return false
+	}
+	return true
+}
+
+// scanLedger splits the files of a directory into the files to import and the results of
+// the files skipped because their content is unchanged since they were last imported, keyed by path
+// It also returns the ledger entry to record for each file once it is imported
+// With force set, no file is skipped but the ledger is still updated
+func (m *MongoImporter) scanLedger(files []string) ([]string, map[string]*domain.ImportResult, map[string]*domain.LedgerEntry, error) {
+	skipped := make(map[string]*domain.ImportResult)
+	if !m.usesLedger() {
+		return files, skipped, nil, nil
+	}
+
+	var changed []string
+	entries := make(map[string]*domain.LedgerEntry, len(files))
+	for _, file := range files {
+		entry, err := m.ledgerEntry(file)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		entries[file] = entry
+
+		if !m.force {
+			previous, err := m.repo.FindLedgerEntry(m.ctx, entry.File)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("error reading import ledger for %s: %w", file, err)
+			}
+			if previous != nil && previous.Hash == entry.Hash && previous.Collection == entry.Collection {
+				result := newImportResult(file)
+				result.Unchanged = true
+				skipped[file] = result
+				continue
+			}
+		}
+		changed = append(changed, file)
+	}
+	return changed, skipped, entries, nil
+}
+
+// ledgerEntry describes the current content of a file for the import ledger
+func (m *MongoImporter) ledgerEntry(file string) (*domain.LedgerEntry, error) {
+	absPath, err := filepath.Abs(file)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving path %s: %w", file, err)
+	}
+	size, err := m.fileUtils.FileSize(file)
+	if err != nil {
+		return nil, err
+	}
+	modTime, err := m.fileUtils.FileModTime(file)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := m.fileHash(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.LedgerEntry{
+		File:       absPath,
+		Collection: utils.FilePathToCollectionName(file),
+		Size:       size,
+		ModTime:    modTime,
+		Hash:       hash,
+	}, nil
+}
+
+// fileHash returns the hash of a file, reading the file only the first time in a run
+// so the ledger and the checkpoint of a file don't read it once each
+func (m *MongoImporter) fileHash(file string) (string, error) {
+	m.hashMu.Lock()
+	hash, ok := m.hashes[file]
+	m.hashMu.Unlock()
+	if ok {
+		return hash, nil
+	}
+
+	hash, err := m.fileUtils.FileHash(file)
+	if err != nil {
+		return "", err
+	}
+	m.hashMu.Lock()
+	m.hashes[file] = hash
+	m.hashMu.Unlock()
+	return hash, nil
+}
+
+// recordLedger records a successfully imported file in the import ledger
+// A failure only means the file is imported again next time, so it doesn't fail the import
+func (m *MongoImporter) recordLedger(entry *domain.LedgerEntry) {
+	if entry == nil {
+		return
+	}
+
+	entry.RunID = m.runID
+	entry.ImportedAt = time.Now().UTC()
+	if err := m.repo.SaveLedgerEntry(context.WithoutCancel(m.ctx), entry); err != nil {
+		fmt.Printf("Warning: could not record %s in the import ledger: %v\n", entry.File, err)
+	}
+}
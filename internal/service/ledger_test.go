@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// TestImportDirectoryLedger tests that files unchanged since their last import are skipped
+func TestImportDirectoryLedger(t *testing.T) {
+	mockFileUtils := &MockFileUtils{
+		FindJSONFilesFunc: func(dirPath string) ([]string, error) {
+			return []string{"/data/a.json", "/data/b.json", "/data/c.json"}, nil
+		},
+		FileHashFunc: func(path string) (string, error) {
+			return "hash-" + filepath.Base(path), nil
+		},
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "n", Value: 1}}}, nil
+		},
+	}
+
+	// a.json is unchanged, b.json has changed and c.json was never imported
+	ledger := map[string]*domain.LedgerEntry{
+		"/data/a.json": {File: "/data/a.json", Collection: "a", Hash: "hash-a.json"},
+		"/data/b.json": {File: "/data/b.json", Collection: "b", Hash: "hash-old"},
+	}
+
+	newRepo := func(written, recorded *[]string) *MockRepository {
+		var mu sync.Mutex
+		return &MockRepository{
+			FindLedgerFunc: func(ctx context.Context, file string) (*domain.LedgerEntry, error) {
+				return ledger[file], nil
+			},
+			SaveLedgerFunc: func(ctx context.Context, entry *domain.LedgerEntry) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if entry.Hash != "hash-"+filepath.Base(entry.File) || entry.RunID == "" {
+					t.Errorf("Expected the current hash and the run ID, got %+v", entry)
+				}
+				*recorded = append(*recorded, entry.Collection)
+				return nil
+			},
+			InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				*written = append(*written, collectionName)
+				return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+			},
+		}
+	}
+
+	t.Run("SkipUnchanged", func(t *testing.T) {
+		var written, recorded []string
+		importer := NewMongoImporter(context.Background(), mockFileUtils, newRepo(&written, &recorded), ImporterOptions{})
+		results, err := importer.ImportDirectory("/data")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		sort.Strings(written)
+		sort.Strings(recorded)
+		if !reflect.DeepEqual(written, []string{"b", "c"}) || !reflect.DeepEqual(recorded, []string{"b", "c"}) {
+			t.Errorf("Expected b and c to be imported and recorded, got %v and %v", written, recorded)
+		}
+		if len(results) != 3 || !results[0].Unchanged || results[0].CollectionName != "a" {
+			t.Errorf("Expected a result for each file with a.json skipped, got %+v", results)
+		}
+	})
+
+	t.Run("Force", func(t *testing.T) {
+		var written, recorded []string
+		importer := NewMongoImporter(context.Background(), mockFileUtils, newRepo(&written, &recorded), ImporterOptions{Force: true})
+		if _, err := importer.ImportDirectory("/data"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(written) != 3 || len(recorded) != 3 {
+			t.Errorf("Expected every file to be imported and recorded, got %v and %v", written, recorded)
+		}
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		var written, recorded []string
+		mockRepo := newRepo(&written, &recorded)
+		mockRepo.FindLedgerFunc = func(ctx context.Context, file string) (*domain.LedgerEntry, error) {
+			t.Error("Expected the ledger not to be used in reload mode")
+			return nil, nil
+		}
+		mockRepo.ResetCollectionFunc = func(ctx context.Context, collectionName string) (int, error) {
+			return 0, nil
+		}
+
+		importer := NewMongoImporter(context.Background(), mockFileUtils, mockRepo, ImporterOptions{Mode: domain.ImportModeReload})
+		if _, err := importer.ImportDirectory("/data"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(written) != 3 {
+			t.Errorf("Expected every file to be imported, got %v", written)
+		}
+	})
+}
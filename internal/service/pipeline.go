@@ -0,0 +1,225 @@
+package service
+
+import (
+	"cmp"
+	"sync"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// batchPipeline writes the batches of one file on several insert workers while the file is read
+// Batches are accounted for in file order: the counts, error budgets and checkpoint of the file
+// only move past a batch once every batch before it has been written
+// At most one batch per worker is held in memory besides the batch being read
+// The pipeline is driven from the goroutine that reads the file, so the result is only
+// modified there; workers write each batch into a result of its own
+type batchPipeline struct {
+	m          *MongoImporter
+	target     importTarget
+	result     *domain.ImportResult
+	filePath   string
+	budget     *budgetTracker
+	checkpoint *fileCheckpoint
+
+	jobs     chan batchJob
+	outcomes chan batchOutcome
+	workers  sync.WaitGroup
+
+	inFlight  int                    // Batches submitted and not received back yet
+	unsettled int                    // Documents of the batches submitted and not accounted for yet
+	submitted int                    // Batches submitted so far, i.e. the sequence number of the next one
+	next      int                    // Sequence number of the next batch to account for
+	end       int                    // Position after the last submitted batch
+	pending   map[int]batchOutcome   // Written batches waiting for the batches before them
+	err       error                  // Error of the batch the import stopped at
+	ahead     []domain.PositionRange // Batches written after the batch the import stopped at
+}
+
+// batchJob is one batch of documents handed to an insert worker
+type batchJob struct {
+	seq       int
+	span      domain.PositionRange // Positions of the file covered by the batch
+	documents []domain.Document
+	positions []int
+}
+
+// batchOutcome is the result of writing one batch
+type batchOutcome struct {
+	seq    int
+	span   domain.PositionRange
+	size   int                  // Number of documents in the batch
+	result *domain.ImportResult // Counts of this batch alone
+	err    error
+}
+
+// newBatchPipeline starts the insert workers of a file
+func (m *MongoImporter) newBatchPipeline(target importTarget, result *domain.ImportResult, filePath string, budget *budgetTracker, checkpoint *fileCheckpoint) *batchPipeline {
+	p := &batchPipeline{
+		m:          m,
+		target:     target,
+		result:     result,
+		filePath:   filePath,
+		budget:     budget,
+		checkpoint: checkpoint,
+		jobs:       make(chan batchJob),
+		// Workers never wait to hand back an outcome, since at most one batch per worker is in flight
+		outcomes: make(chan batchOutcome, target.insertWorkers),
+		pending:  make(map[int]batchOutcome),
+	}
+	if checkpoint != nil {
+		p.end = checkpoint.resumeFrom
+	}
+
+	for range target.insertWorkers {
+		p.workers.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// work writes the batches handed to a worker until the pipeline is finished
+func (p *batchPipeline) work() {
+	defer p.workers.Done()
+	for job := range p.jobs {
+		outcome := batchOutcome{
+			seq:    job.seq,
+			span:   job.span,
+			size:   len(job.documents),
+			result: &domain.ImportResult{CollectionName: p.result.CollectionName},
+		}
+		// Batches still queued when the import is cancelled are not written
+		if err := p.target.ctx.Err(); err != nil {
+			outcome.err = err
+		} else {
+			outcome.err = p.m.importBatch(p.target, outcome.result, p.filePath, job.documents, job.positions)
+		}
+		p.outcomes <- outcome
+	}
+}
+
+// submit hands a batch ending before position end to the next free worker
+// It returns the error of an earlier batch, after which no more batches should be submitted
+func (p *batchPipeline) submit(documents []domain.Document, positions []int, end int) error {
+	if p.err != nil {
+		return p.err
+	}
+	// The documents were read, but they only count for the budgets once the batch is accounted for
+	p.unsettled += len(documents)
+
+	// Wait until a worker is free, accounting for the batches written meanwhile
+	for p.inFlight == p.target.insertWorkers {
+		if err := p.receive(<-p.outcomes); err != nil {
+			return err
+		}
+	}
+	// No batch is started once the import is cancelled or stopped
+	if err := cmp.Or(p.target.ctx.Err(), p.m.stopErr()); err != nil {
+		return err
+	}
+
+	p.jobs <- batchJob{
+		seq:       p.submitted,
+		span:      domain.PositionRange{Start: p.end, End: end},
+		documents: documents,
+		positions: positions,
+	}
+	p.inFlight++
+	p.submitted++
+	p.end = end
+
+	// Account for the batches that are already written without waiting for the others
+	for {
+		select {
+		case outcome := <-p.outcomes:
+			if err := p.receive(outcome); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// finish waits for the batches in flight and stops the workers
+// Batches written after the batch the import stopped at are recorded in the checkpoint
+func (p *batchPipeline) finish() error {
+	close(p.jobs)
+	for p.inFlight > 0 {
+		p.receive(<-p.outcomes)
+	}
+	p.workers.Wait()
+
+	if p.checkpoint != nil && len(p.ahead) > 0 {
+		p.m.saveAhead(p.checkpoint, p.ahead)
+	}
+	return p.err
+}
+
+// receive accounts for a written batch and every batch waiting for it
+func (p *batchPipeline) receive(outcome batchOutcome) error {
+	p.inFlight--
+	if p.err != nil {
+		p.stopped(outcome)
+		return p.err
+	}
+
+	p.pending[outcome.seq] = outcome
+	for {
+		next, ok := p.pending[p.next]
+		if !ok {
+			return nil
+		}
+		delete(p.pending, p.next)
+		p.next++
+
+		if err := p.account(next); err != nil {
+			p.err = err
+			if next.err == nil {
+				// The batch was written even though the import stops at it
+				p.ahead = append(p.ahead, next.span)
+			}
+			for seq := p.next; seq < p.submitted; seq++ {
+				if later, ok := p.pending[seq]; ok {
+					p.stopped(later)
+				}
+			}
+			clear(p.pending)
+			return err
+		}
+	}
+}
+
+// account adds a batch to the result of the file and moves the checkpoint past it
+// The budgets only see the batches accounted for so far, not the documents still being written
+func (p *batchPipeline) account(outcome batchOutcome) error {
+	addBatchResult(p.result, outcome.result)
+	p.unsettled -= outcome.size
+	if outcome.err != nil {
+		return outcome.err
+	}
+	if err := p.budget.check(p.result, p.unsettled, false); err != nil {
+		return err
+	}
+	if p.checkpoint != nil {
+		return p.m.commitCheckpoint(p.checkpoint, outcome.span.End)
+	}
+	return nil
+}
+
+// stopped adds a batch that finished after the import stopped to the result of the file
+// Its documents stay written, so it is recorded to be skipped when the import is resumed
+func (p *batchPipeline) stopped(outcome batchOutcome) {
+	addBatchResult(p.result, outcome.result)
+	p.unsettled -= outcome.size
+	if outcome.err == nil {
+		p.ahead = append(p.ahead, outcome.span)
+	}
+}
+
+// addBatchResult adds the counts and failures of a batch to the result of its file
+func addBatchResult(result, batch *domain.ImportResult) {
+	result.AddCounts(batch)
+	result.ErrorCount += batch.ErrorCount
+	result.RejectedCount += batch.RejectedCount
+	result.Failed = append(result.Failed, batch.Failed...)
+}
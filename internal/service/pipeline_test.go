@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// documentNumbers returns the n field of each document
+func documentNumbers(documents []domain.Document) []int {
+	var numbers []int
+	for _, doc := range documents {
+		n, _ := doc.Get("n")
+		numbers = append(numbers, n.(int))
+	}
+	return numbers
+}
+
+// TestImportFileInsertWorkers tests that batches are inserted concurrently but checkpointed in file order
+func TestImportFileInsertWorkers(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	var saved []int
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			mu.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+
+			// The first batches take the longest, so they finish last
+			time.Sleep(time.Duration(5-documentNumbers(documents)[0]) * 5 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+		SaveCheckpointFunc: func(ctx context.Context, checkpoint *domain.Checkpoint) error {
+			saved = append(saved, checkpoint.Documents)
+			return nil
+		},
+	}
+
+	importer := NewMongoImporter(context.Background(), checkpointFileUtils("abc"), mockRepo, ImporterOptions{BatchSize: 1, InsertWorkers: 3})
+	result, err := importer.ImportFile("/data/users.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if maxRunning > 3 {
+		t.Errorf("Expected at most 3 batches in flight, got %d", maxRunning)
+	}
+	if result.InsertedCount != 5 || result.ProcessedCount != 5 {
+		t.Errorf("Expected 5 documents inserted, got %+v", result)
+	}
+	if expected := []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(saved, expected) {
+		t.Errorf("Expected checkpoints %v, got %v", expected, saved)
+	}
+}
+
+// TestImportFileInsertWorkersFailure tests that batches written after a failed one are recorded in the checkpoint
+func TestImportFileInsertWorkersFailure(t *testing.T) {
+	var saved []domain.Checkpoint
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			// The second batch fails after the later batches were written by the other worker
+			if documentNumbers(documents)[0] == 1 {
+				time.Sleep(30 * time.Millisecond)
+				return nil, errors.New("duplicate key")
+			}
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+		SaveCheckpointFunc: func(ctx context.Context, checkpoint *domain.Checkpoint) error {
+			saved = append(saved, *checkpoint)
+			return nil
+		},
+	}
+
+	importer := NewMongoImporter(context.Background(), checkpointFileUtils("abc"), mockRepo, ImporterOptions{BatchSize: 1, InsertWorkers: 2})
+	result, err := importer.ImportFile("/data/users.json")
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	if result.InsertedCount != 4 {
+		t.Errorf("Expected the 4 written documents to be counted, got %+v", result)
+	}
+	last := saved[len(saved)-1]
+	expected := []domain.PositionRange{{Start: 2, End: 3}, {Start: 3, End: 4}, {Start: 4, End: 5}}
+	if last.Documents != 1 || !reflect.DeepEqual(last.Ahead, expected) {
+		t.Errorf("Expected a checkpoint after 1 document with %v written ahead, got %+v", expected, last)
+	}
+}
+
+// TestImportFileInsertWorkersErrorRatio tests that the error ratio of a file only counts
+// the batches written so far, not the documents still being written by other workers
+func TestImportFileInsertWorkersErrorRatio(t *testing.T) {
+	var mu sync.Mutex
+	writes := 0
+	allStarted := make(chan struct{})
+	mockRepo := &MockRepository{
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			mu.Lock()
+			writes++
+			if writes == 3 {
+				close(allStarted)
+			}
+			mu.Unlock()
+
+			if documentNumbers(documents)[0] != 0 {
+				return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+			}
+			// The first batch fails once every worker is busy with a later batch
+			select {
+			case <-allStarted:
+			case <-time.After(time.Second):
+				t.Error("Expected 3 batches to be written at the same time")
+			}
+			return &domain.ImportResult{
+				CollectionName: collectionName,
+				Failed:         []domain.DocumentFailure{{Index: 0, Code: 121, Message: "Document failed validation"}},
+			}, nil
+		},
+	}
+
+	importer := NewMongoImporter(context.Background(), checkpointFileUtils("abc"), mockRepo, ImporterOptions{
+		BatchSize:       1,
+		InsertWorkers:   3,
+		Unordered:       true,
+		FileErrorBudget: ErrorBudget{MaxErrorRatio: 0.5},
+	})
+	_, err := importer.ImportFile("/data/users.json")
+
+	// 1 of the 5 documents fails, but it is the only document written when its batch is accounted for
+	var budgetErr *BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("Expected a BudgetExceededError, got %v", err)
+	}
+	if budgetErr.Errors != 1 || budgetErr.Processed != 1 {
+		t.Errorf("Expected the ratio over the written document only, got %+v", budgetErr)
+	}
+}
+
+// TestImportFileResumeAhead tests that a resumed import skips the batches written ahead of its checkpoint
+func TestImportFileResumeAhead(t *testing.T) {
+	interrupted := &domain.Checkpoint{
+		File:       "/data/users.json",
+		Collection: "users",
+		Hash:       "abc",
+		Documents:  1,
+		Batches:    1,
+		Ahead:      []domain.PositionRange{{Start: 2, End: 4}},
+	}
+
+	var written []int
+	var saved []domain.Checkpoint
+	mockRepo := &MockRepository{
+		FindCheckpointFunc: func(ctx context.Context, file string) (*domain.Checkpoint, error) {
+			return interrupted, nil
+		},
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			written = append(written, documentNumbers(documents)...)
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+		SaveCheckpointFunc: func(ctx context.Context, checkpoint *domain.Checkpoint) error {
+			saved = append(saved, *checkpoint)
+			return nil
+		},
+	}
+
+	importer := NewMongoImporter(context.Background(), checkpointFileUtils("abc"), mockRepo, ImporterOptions{BatchSize: 2, Resume: true})
+	output, err := importer.ImportPath("/data/users.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	result := output.(*domain.ImportResult)
+	if !reflect.DeepEqual(written, []int{1, 4}) {
+		t.Errorf("Expected only documents 1 and 4 to be written, got %v", written)
+	}
+	if result.ResumedCount != 3 || result.InsertedCount != 2 {
+		t.Errorf("Expected 3 skipped and 2 inserted documents, got %+v", result)
+	}
+	if last := saved[len(saved)-1]; last.Documents != 5 || len(last.Ahead) != 0 {
+		t.Errorf("Expected the batches written ahead to be dropped once passed, got %+v", last)
+	}
+}
+
+// TestImportFileInsertWorkersCancel tests that a cancelled import stops submitting batches
+func TestImportFileInsertWorkersCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var written []int
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			// The first batch is written and cancels the import, the batch beside it fails on the cancellation
+			if documentNumbers(documents)[0] != 0 {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			mu.Lock()
+			written = append(written, 0)
+			mu.Unlock()
+			cancel()
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	importer := NewMongoImporter(ctx, checkpointFileUtils("abc"), mockRepo, ImporterOptions{BatchSize: 1, InsertWorkers: 2})
+	result, err := importer.ImportFile("/data/users.json")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the import to be cancelled, got %v", err)
+	}
+	if !slices.Equal(written, []int{0}) || result.InsertedCount != 1 {
+		t.Errorf("Expected only the first batch to be written, got %v and %+v", written, result)
+	}
+}
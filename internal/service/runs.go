@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// RunID returns the ID of this run
+// It is recorded in the runs collection and, with a run field, stamped into every written document
+func (m *MongoImporter) RunID() string {
+	return m.runID
+}
+
+// trackRun records an import run in the runs collection around fn
+// The run is recorded before anything is written, so a run that stops midway can still be rolled back
+// Previews write nothing and are not recorded
+func (m *MongoImporter) trackRun(path string, fn func() (any, error)) (any, error) {
+	if m.preview {
+		return fn()
+	}
+
+	run := &domain.ImportRun{
+		ID:        m.runID,
+		Path:      path,
+		Mode:      m.writeOptions.Mode,
+		RunField:  m.runField,
+		Status:    domain.ImportRunRunning,
+		StartedAt: time.Now().UTC(),
+	}
+	if err := m.repo.SaveImportRun(m.ctx, run); err != nil {
+		return nil, fmt.Errorf("error recording import run %s: %w", m.runID, err)
+	}
+
+	output, err := fn()
+
+	finishedAt := time.Now().UTC()
+	run.FinishedAt = &finishedAt
+	run.Files = runFiles(output)
+	run.Collections = runCollections(run.Files)
+	run.Status = domain.ImportRunCompleted
+	if err != nil {
+		run.Status = domain.ImportRunFailed
+		run.Error = err.Error()
+	}
+	// The run is recorded even when the import was cancelled
+	if saveErr := m.repo.SaveImportRun(context.WithoutCancel(m.ctx), run); saveErr != nil {
+		fmt.Printf("Warning: could not record the end of import run %s: %v\n", m.runID, saveErr)
+	}
+	return output, err
+}
+
+// runFiles converts the results of an import or sync to the files of a run record
+func runFiles(output any) []domain.ImportRunFile {
+	var files []domain.ImportRunFile
+	addImport := func(result *domain.ImportResult) {
+		if result == nil {
+			return
+		}
+		file := domain.ImportRunFile{
+			File:       result.FileName,
+			Collection: result.CollectionName,
+			Inserted:   result.InsertedCount,
+			Matched:    result.MatchedCount,
+			Modified:   result.ModifiedCount,
+			Upserted:   result.UpsertedCount,
+		}
+		if result.Error != nil {
+			file.Error = result.Error.Error()
+		}
+		files = append(files, file)
+	}
+	addSync := func(result *domain.SyncResult) {
+		if result == nil {
+			return
+		}
+		file := domain.ImportRunFile{
+			File:       result.FileName,
+			Collection: result.CollectionName,
+			Inserted:   result.InsertedCount,
+			Modified:   result.UpdatedCount,
+			Deleted:    result.DeletedCount,
+		}
+		if result.Error != nil {
+			file.Error = result.Error.Error()
+		}
+		files = append(files, file)
+	}
+
+	switch r := output.(type) {
+	case *domain.ImportResult:
+		addImport(r)
+	case []*domain.ImportResult:
+		for _, result := range r {
+			addImport(result)
+		}
+	case *domain.SyncResult:
+		addSync(r)
+	case []*domain.SyncResult:
+		for _, result := range r {
+			addSync(result)
+		}
+	}
+	return files
+}
+
+// runCollections returns the collections of the files of a run, in order of first appearance
+func runCollections(files []domain.ImportRunFile) []string {
+	collections := []string{}
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if file.Collection == "" || seen[file.Collection] {
+			continue
+		}
+		seen[file.Collection] = true
+		collections = append(collections, file.Collection)
+	}
+	return collections
+}
+
+// stamp writes the run ID into a document when a run field is configured
+func (m *MongoImporter) stamp(doc *domain.Document) {
+	if m.runField != "" {
+		doc.Set(m.runField, m.runID)
+	}
+}
+
+// saveVersions saves the stored documents that a batch is about to overwrite, so a rollback can restore them
+// Documents already stamped by this run are skipped, since their previous version was saved before
+// Without a run field runs can't be rolled back, so nothing is saved
+func (m *MongoImporter) saveVersions(ctx context.Context, collectionName string, documents []domain.Document) error {
+	if m.runField == "" || !m.writeOptions.Mode.MatchesByKey() {
+		return nil
+	}
+
+	stored, err := m.repo.FindMatchingDocuments(ctx, collectionName, documents, m.writeOptions.KeyFields)
+	if err != nil {
+		return fmt.Errorf("error saving previous versions: %w", err)
+	}
+	return m.savePrevious(ctx, collectionName, stored)
+}
+
+// savePrevious saves stored documents before they are overwritten or deleted by this run
+func (m *MongoImporter) savePrevious(ctx context.Context, collectionName string, stored []domain.Document) error {
+	if m.runField == "" {
+		return nil
+	}
+
+	previous := make([]domain.Document, 0, len(stored))
+	for _, doc := range stored {
+		if runID, ok := doc.Get(m.runField); ok && runID == m.runID {
+			continue
+		}
+		previous = append(previous, doc)
+	}
+	if len(previous) == 0 {
+		return nil
+	}
+	if err := m.repo.SaveDocumentVersions(ctx, m.runID, collectionName, previous); err != nil {
+		return fmt.Errorf("error saving previous versions: %w", err)
+	}
+	return nil
+}
+
+// RollbackRun undoes what an import run wrote
+// Documents stamped with the run ID are deleted, then the documents the run overwrote or
+// deleted are restored from the versions it saved. Changes made to those documents by
+// later runs are lost, and runs that replaced whole collections (reload and swap) or
+// did not stamp their documents can't be rolled back
+func (m *MongoImporter) RollbackRun(runID string) ([]*domain.RollbackResult, error) {
+	run, err := m.repo.FindImportRun(m.ctx, runID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading import run %s: %w", runID, err)
+	}
+	if run == nil {
+		return nil, fmt.Errorf("import run %s not found", runID)
+	}
+
+	switch {
+	case run.Status == domain.ImportRunRolledBack:
+		return nil, fmt.Errorf("import run %s was already rolled back", runID)
+	case run.Mode == domain.ImportModeReload || run.Mode == domain.ImportModeSwap:
+		return nil, fmt.Errorf("import run %s replaced whole collections in %s mode and can't be rolled back", runID, run.Mode)
+	case run.RunField == "":
+		return nil, fmt.Errorf("import run %s did not stamp its documents with a run field and can't be rolled back", runID)
+	}
+
+	var results []*domain.RollbackResult
+	failedCount := 0
+	for _, collectionName := range run.Collections {
+		result, err := m.repo.RollbackCollection(m.ctx, run.ID, run.RunField, collectionName)
+		if result == nil {
+			result = &domain.RollbackResult{CollectionName: collectionName}
+		}
+		if err != nil {
+			result.Error = fmt.Errorf("error rolling back collection %s: %w", collectionName, err)
+			failedCount++
+		}
+		results = append(results, result)
+	}
+
+	// Rolling back again is safe, so a failed run stays open for another attempt
+	if failedCount > 0 {
+		return results, fmt.Errorf("%d out of %d collections failed to roll back", failedCount, len(results))
+	}
+
+	rolledBackAt := time.Now().UTC()
+	run.Status = domain.ImportRunRolledBack
+	run.RolledBackAt = &rolledBackAt
+	if err := m.repo.SaveImportRun(m.ctx, run); err != nil {
+		return results, fmt.Errorf("error recording the rollback of import run %s: %w", runID, err)
+	}
+	return results, nil
+}
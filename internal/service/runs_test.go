@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// TestImportPathRecordsRun tests that an import is recorded when it starts and when it ends
+func TestImportPathRecordsRun(t *testing.T) {
+	mockFileUtils := &MockFileUtils{
+		IsDirectoryFunc: func(path string) (bool, error) {
+			return false, nil
+		},
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "n", Value: 1}}, {{Key: "n", Value: 2}}}, nil
+		},
+	}
+
+	var saved []domain.ImportRun
+	mockRepo := &MockRepository{
+		SaveImportRunFunc: func(ctx context.Context, run *domain.ImportRun) error {
+			saved = append(saved, *run)
+			return nil
+		},
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	importer := NewMongoImporter(context.Background(), mockFileUtils, mockRepo, ImporterOptions{RunField: "_importRun"})
+	if _, err := importer.ImportPath("/data/users.json"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(saved) != 2 {
+		t.Fatalf("Expected the run to be recorded twice, got %d", len(saved))
+	}
+	if saved[0].ID != importer.RunID() || saved[0].Status != domain.ImportRunRunning || saved[0].RunField != "_importRun" {
+		t.Errorf("Expected a running record of this run, got %+v", saved[0])
+	}
+	final := saved[1]
+	if final.Status != domain.ImportRunCompleted || final.FinishedAt == nil {
+		t.Errorf("Expected a completed record, got %+v", final)
+	}
+	expectedFiles := []domain.ImportRunFile{{File: "users.json", Collection: "users", Inserted: 2}}
+	if !reflect.DeepEqual(final.Files, expectedFiles) || !reflect.DeepEqual(final.Collections, []string{"users"}) {
+		t.Errorf("Expected users.json in the record, got files %+v and collections %v", final.Files, final.Collections)
+	}
+}
+
+// TestImportFileSavesVersions tests that documents are stamped and overwritten versions are saved
+func TestImportFileSavesVersions(t *testing.T) {
+	mockFileUtils := &MockFileUtils{
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "code", Value: "JP"}}, {{Key: "code", Value: "US"}}}, nil
+		},
+	}
+
+	var versions []domain.Document
+	var written []domain.Document
+	var importer *MongoImporter
+	mockRepo := &MockRepository{
+		FindMatchingFunc: func(ctx context.Context, collectionName string, documents []domain.Document, keyFields []string) ([]domain.Document, error) {
+			if !reflect.DeepEqual(keyFields, []string{"code"}) {
+				t.Errorf("Expected documents to be matched by code, got %v", keyFields)
+			}
+			// US was already written by this run, JP by an earlier one
+			return []domain.Document{
+				{{Key: "_id", Value: 1}, {Key: "code", Value: "JP"}, {Key: "_importRun", Value: "earlier"}},
+				{{Key: "_id", Value: 2}, {Key: "code", Value: "US"}, {Key: "_importRun", Value: importer.RunID()}},
+			}, nil
+		},
+		SaveVersionsFunc: func(ctx context.Context, runID, collectionName string, documents []domain.Document) error {
+			if runID != importer.RunID() || collectionName != "countries" {
+				t.Errorf("Expected versions of countries for this run, got %s and %s", runID, collectionName)
+			}
+			versions = append(versions, documents...)
+			return nil
+		},
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			written = append(written, documents...)
+			return &domain.ImportResult{CollectionName: collectionName, MatchedCount: len(documents)}, nil
+		},
+	}
+
+	importer = NewMongoImporter(context.Background(), mockFileUtils, mockRepo, ImporterOptions{
+		RemoveIDField: true,
+		Mode:          domain.ImportModeUpsert,
+		KeyFields:     []string{"code"},
+		RunField:      "_importRun",
+	})
+	if _, err := importer.ImportFile("/data/countries.json"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(versions) != 1 || versions[0][0].Value != 1 {
+		t.Errorf("Expected only the version of JP to be saved, got %v", versions)
+	}
+	for _, doc := range written {
+		if runID, _ := doc.Get("_importRun"); runID != importer.RunID() {
+			t.Errorf("Expected written documents to be stamped with the run ID, got %v", doc)
+		}
+	}
+}
+
+// TestSyncFileIgnoresRunField tests that sync compares documents without the run field
+func TestSyncFileIgnoresRunField(t *testing.T) {
+	mockFileUtils := &MockFileUtils{
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{
+				{{Key: "code", Value: "JP"}, {Key: "name", Value: "Japan"}},
+				{{Key: "code", Value: "US"}, {Key: "name", Value: "United States of America"}},
+			}, nil
+		},
+	}
+
+	// JP only differs by the run that wrote it, US has changed and DE is no longer in the file
+	stored := []domain.Document{
+		{{Key: "_id", Value: int32(1)}, {Key: "code", Value: "JP"}, {Key: "name", Value: "Japan"}, {Key: "_importRun", Value: "earlier"}},
+		{{Key: "_id", Value: int32(2)}, {Key: "code", Value: "US"}, {Key: "name", Value: "United States"}, {Key: "_importRun", Value: "earlier"}},
+		{{Key: "_id", Value: int32(3)}, {Key: "code", Value: "DE"}, {Key: "name", Value: "Germany"}},
+	}
+
+	var versions []any
+	var importer *MongoImporter
+	mockRepo := &MockRepository{
+		ScanDocumentsFunc: scanDocuments(stored),
+		FindMatchingFunc: func(ctx context.Context, collectionName string, documents []domain.Document, keyFields []string) ([]domain.Document, error) {
+			var matched []domain.Document
+			for _, filter := range documents {
+				id, _ := filter.Get("_id")
+				for _, doc := range stored {
+					if storedID, _ := doc.Get("_id"); storedID == id {
+						matched = append(matched, doc)
+					}
+				}
+			}
+			return matched, nil
+		},
+		SaveVersionsFunc: func(ctx context.Context, runID, collectionName string, documents []domain.Document) error {
+			for _, doc := range documents {
+				id, _ := doc.Get("_id")
+				versions = append(versions, id)
+			}
+			return nil
+		},
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			for _, doc := range documents {
+				if runID, _ := doc.Get("_importRun"); runID != importer.RunID() {
+					t.Errorf("Expected written documents to be stamped with the run ID, got %v", doc)
+				}
+			}
+			return &domain.ImportResult{CollectionName: collectionName}, nil
+		},
+		DeleteDocumentsFunc: func(ctx context.Context, collectionName string, ids []any) (int, error) {
+			return len(ids), nil
+		},
+	}
+
+	importer = NewMongoImporter(context.Background(), mockFileUtils, mockRepo, ImporterOptions{
+		RemoveIDField: true,
+		Mode:          domain.ImportModeSync,
+		KeyFields:     []string{"code"},
+		RunField:      "_importRun",
+	})
+	result, err := importer.SyncFile("/master/countries.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.UnchangedCount != 1 || result.UpdatedCount != 1 || result.DeletedCount != 1 {
+		t.Errorf("Unexpected counts: %+v", result)
+	}
+	// The updated and the deleted document can be restored
+	if !reflect.DeepEqual(versions, []any{int32(2), int32(3)}) {
+		t.Errorf("Expected versions of documents 2 and 3, got %v", versions)
+	}
+}
+
+// TestRollbackRun tests that a run is rolled back collection by collection
+func TestRollbackRun(t *testing.T) {
+	run := domain.ImportRun{
+		ID:          "20260101T000000_abcdef",
+		Mode:        domain.ImportModeUpsert,
+		RunField:    "_importRun",
+		Status:      domain.ImportRunCompleted,
+		Collections: []string{"users", "orders"},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		var rolledBack []string
+		var saved *domain.ImportRun
+		mockRepo := &MockRepository{
+			FindImportRunFunc: func(ctx context.Context, runID string) (*domain.ImportRun, error) {
+				found := run
+				return &found, nil
+			},
+			RollbackFunc: func(ctx context.Context, runID, runField, collectionName string) (*domain.RollbackResult, error) {
+				if runID != run.ID || runField != "_importRun" {
+					t.Errorf("Expected the run ID and field of the run, got %s and %s", runID, runField)
+				}
+				rolledBack = append(rolledBack, collectionName)
+				return &domain.RollbackResult{CollectionName: collectionName, DeletedCount: 2, RestoredCount: 1}, nil
+			},
+			SaveImportRunFunc: func(ctx context.Context, run *domain.ImportRun) error {
+				saved = run
+				return nil
+			},
+		}
+
+		importer := NewMongoImporter(context.Background(), &MockFileUtils{}, mockRepo, ImporterOptions{})
+		results, err := importer.RollbackRun(run.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(rolledBack, []string{"users", "orders"}) || len(results) != 2 {
+			t.Errorf("Expected users and orders to be rolled back, got %v", rolledBack)
+		}
+		if saved == nil || saved.Status != domain.ImportRunRolledBack || saved.RolledBackAt == nil {
+			t.Errorf("Expected the run to be recorded as rolled back, got %+v", saved)
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		mockRepo := &MockRepository{
+			FindImportRunFunc: func(ctx context.Context, runID string) (*domain.ImportRun, error) {
+				found := run
+				return &found, nil
+			},
+			RollbackFunc: func(ctx context.Context, runID, runField, collectionName string) (*domain.RollbackResult, error) {
+				if collectionName == "orders" {
+					return nil, errors.New("delete failed")
+				}
+				return &domain.RollbackResult{CollectionName: collectionName}, nil
+			},
+			SaveImportRunFunc: func(ctx context.Context, run *domain.ImportRun) error {
+				t.Error("Expected the run to stay open for another attempt")
+				return nil
+			},
+		}
+
+		importer := NewMongoImporter(context.Background(), &MockFileUtils{}, mockRepo, ImporterOptions{})
+		results, err := importer.RollbackRun(run.ID)
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		if len(results) != 2 || results[1].CollectionName != "orders" || results[1].Error == nil {
+			t.Errorf("Expected orders to report the error, got %+v", results)
+		}
+	})
+
+	refused := []struct {
+		name   string
+		run    *domain.ImportRun
+		errMsg string
+	}{
+		{name: "NotFound", run: nil, errMsg: "not found"},
+		{name: "AlreadyRolledBack", run: &domain.ImportRun{ID: run.ID, RunField: "_importRun", Status: domain.ImportRunRolledBack}, errMsg: "already rolled back"},
+		{name: "Reload", run: &domain.ImportRun{ID: run.ID, RunField: "_importRun", Mode: domain.ImportModeReload}, errMsg: "reload mode"},
+		{name: "NotStamped", run: &domain.ImportRun{ID: run.ID, Mode: domain.ImportModeInsert}, errMsg: "did not stamp"},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockRepository{
+				FindImportRunFunc: func(ctx context.Context, runID string) (*domain.ImportRun, error) {
+					return tt.run, nil
+				},
+			}
+
+			importer := NewMongoImporter(context.Background(), &MockFileUtils{}, mockRepo, ImporterOptions{})
+			if _, err := importer.RollbackRun(run.ID); err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Expected an error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"errors"
+)
+
+// ErrImportInterrupted is the error of the files and batches not started after Stop was called
+var ErrImportInterrupted = errors.New("import interrupted")
+
+// Stop asks a running import to shut down gracefully
+// Batches being written are finished and accounted for, and their checkpoints are saved,
+// but no new file or batch is started; the import then returns ErrImportInterrupted
+// It is safe to call from another goroutine, e.g. a signal handler
+func (m *MongoImporter) Stop() {
+	m.stopped.Store(true)
+}
+
+// stopErr returns ErrImportInterrupted once Stop has been called
+func (m *MongoImporter) stopErr() error {
+	if m.stopped.Load() {
+		return ErrImportInterrupted
+	}
+	return nil
+}
+
+// Resumable reports whether files left unfinished by an interrupted import can be continued with Resume
+func (m *MongoImporter) Resumable() bool {
+	return m.checkResumeMode() == nil
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// TestImportFileStop tests that stopping an import finishes the batch being written,
+// starts no further batch and keeps the checkpoint for resuming
+func TestImportFileStop(t *testing.T) {
+	var importer *MongoImporter
+	var written []int
+	var saved []domain.Checkpoint
+	deleted := false
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			// The import is stopped while the first batch is written
+			importer.Stop()
+			written = append(written, documentNumbers(documents)...)
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+		SaveCheckpointFunc: func(ctx context.Context, checkpoint *domain.Checkpoint) error {
+			saved = append(saved, *checkpoint)
+			return nil
+		},
+		DeleteCheckpointFunc: func(ctx context.Context, file string) error {
+			deleted = true
+			return nil
+		},
+	}
+
+	importer = NewMongoImporter(context.Background(), checkpointFileUtils("abc"), mockRepo, ImporterOptions{BatchSize: 2})
+	result, err := importer.ImportFile("/data/users.json")
+	if !errors.Is(err, ErrImportInterrupted) {
+		t.Fatalf("Expected the import to be interrupted, got %v", err)
+	}
+
+	if !slices.Equal(written, []int{0, 1}) || result.InsertedCount != 2 {
+		t.Errorf("Expected only the first batch to be written, got %v and %+v", written, result)
+	}
+	if len(saved) == 0 || saved[len(saved)-1].Documents != 2 {
+		t.Errorf("Expected the checkpoint to be saved after the first batch, got %+v", saved)
+	}
+	if deleted {
+		t.Error("Expected the checkpoint to be kept for resuming")
+	}
+}
+
+// TestImportFileStopInsertWorkers tests that batches in flight when the import is stopped
+// are finished and accounted for
+func TestImportFileStopInsertWorkers(t *testing.T) {
+	var importer *MongoImporter
+	var mu sync.Mutex
+	var written []int
+	stopped := make(chan struct{})
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			// The second batch stops the import while the first one is still being written
+			switch documentNumbers(documents)[0] {
+			case 0:
+				<-stopped
+			case 1:
+				importer.Stop()
+				close(stopped)
+			}
+			mu.Lock()
+			written = append(written, documentNumbers(documents)...)
+			mu.Unlock()
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	importer = NewMongoImporter(context.Background(), checkpointFileUtils("abc"), mockRepo, ImporterOptions{BatchSize: 1, InsertWorkers: 2})
+	result, err := importer.ImportFile("/data/users.json")
+	if !errors.Is(err, ErrImportInterrupted) {
+		t.Fatalf("Expected the import to be interrupted, got %v", err)
+	}
+
+	slices.Sort(written)
+	if !slices.Equal(written, []int{0, 1}) || result.InsertedCount != 2 {
+		t.Errorf("Expected the batches in flight to be written, got %v and %+v", written, result)
+	}
+}
+
+// TestImportDirectoryStop tests that files not started before the import was stopped are skipped
+func TestImportDirectoryStop(t *testing.T) {
+	var importer *MongoImporter
+	files := 0
+	mockFileUtils := checkpointFileUtils("abc")
+	mockFileUtils.FindJSONFilesFunc = func(dirPath string) ([]string, error) {
+		return []string{"/data/a.json", "/data/b.json"}, nil
+	}
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			files++
+			importer.Stop()
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	importer = NewMongoImporter(context.Background(), mockFileUtils, mockRepo, ImporterOptions{Workers: 1})
+	results, err := importer.ImportDirectory("/data")
+	if !errors.Is(err, ErrImportInterrupted) {
+		t.Fatalf("Expected the import to be interrupted, got %v", err)
+	}
+	if files != 1 || results[0].InsertedCount != 5 {
+		t.Errorf("Expected the first file to be written completely, got %d files and %+v", files, results[0])
+	}
+	if !errors.Is(results[1].Error, ErrImportInterrupted) {
+		t.Errorf("Expected the second file to be skipped, got %v", results[1].Error)
+	}
+}
+
+// TestSyncDirectoryStop tests that stopping a sync finishes the batch being written
+// but deletes nothing, skips the remaining files and drops no collection
+func TestSyncDirectoryStop(t *testing.T) {
+	var importer *MongoImporter
+	mockFileUtils := checkpointFileUtils("abc")
+	mockFileUtils.FindJSONFilesFunc = func(dirPath string) ([]string, error) {
+		return []string{"/data/a.json", "/data/b.json"}, nil
+	}
+	mockFileUtils.ParseJSONFileFunc = func(filePath string) ([]bson.D, error) {
+		var documents []bson.D
+		for n := 0; n < 5; n++ {
+			documents = append(documents, bson.D{{Key: "_id", Value: fmt.Sprintf("k%d", n)}, {Key: "n", Value: n}})
+		}
+		return documents, nil
+	}
+
+	var written []int
+	var deleted, dropped bool
+	mockRepo := &MockRepository{
+		ScanDocumentsFunc: scanDocuments([]domain.Document{{{Key: "_id", Value: "stale"}}}),
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			// The sync is stopped while the first batch is written
+			importer.Stop()
+			written = append(written, documentNumbers(documents)...)
+			return &domain.ImportResult{CollectionName: collectionName, UpsertedCount: len(documents)}, nil
+		},
+		DeleteDocumentsFunc: func(ctx context.Context, collectionName string, ids []any) (int, error) {
+			deleted = true
+			return len(ids), nil
+		},
+		ListCollectionsFunc: func(ctx context.Context) ([]string, error) {
+			return []string{"a", "b", "legacy"}, nil
+		},
+		DropCollectionFunc: func(ctx context.Context, collectionName string) error {
+			dropped = true
+			return nil
+		},
+	}
+
+	importer = NewMongoImporter(context.Background(), mockFileUtils, mockRepo, ImporterOptions{
+		Mode:                   domain.ImportModeSync,
+		BatchSize:              2,
+		DropMissingCollections: true,
+	})
+	results, err := importer.SyncDirectory("/data")
+	if !errors.Is(err, ErrImportInterrupted) {
+		t.Fatalf("Expected the sync to be interrupted, got %v", err)
+	}
+
+	if !slices.Equal(written, []int{0, 1}) {
+		t.Errorf("Expected only the first batch to be written, got %v", written)
+	}
+	if deleted || dropped {
+		t.Errorf("Expected nothing to be deleted or dropped, got deleted=%v dropped=%v", deleted, dropped)
+	}
+	if len(results) != 2 || !errors.Is(results[0].Error, ErrImportInterrupted) || !errors.Is(results[1].Error, ErrImportInterrupted) {
+		t.Errorf("Expected both files to fail with the interruption, got %+v", results)
+	}
+	if !strings.Contains(results[1].Error.Error(), "skipped") {
+		t.Errorf("Expected the second file to be skipped, got %v", results[1].Error)
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+	"github.com/OTakumi/data-importer/internal/utils"
+)
+
+// StagingCollectionInfix separates the target collection from the run ID in staging collection names
+const StagingCollectionInfix = "__staging_"
+
+// newRunID returns an ID that identifies one importer run
+// It starts with the UTC start time so IDs sort chronologically
+func newRunID() string {
+	random := make([]byte, 3)
+	_, _ = rand.Read(random)
+	return time.Now().UTC().Format("20060102T150405") + "_" + hex.EncodeToString(random)
+}
+
+// stagingCollectionName returns the staging collection used for a target collection during this run
+func (m *MongoImporter) stagingCollectionName(collectionName string) string {
+	return collectionName + StagingCollectionInfix + m.runID
+}
+
+// isStagingCollection reports whether a collection is a staging collection
+func isStagingCollection(name string) bool {
+	return strings.Contains(name, StagingCollectionInfix)
+}
+
+// importStaged imports files into staging collections and writes them to their target
+// collections only once every file was imported, so a file that fails leaves the targets untouched
+// Unlike a transaction, a failure while writing to the targets can leave them partially written
+// Staged imports run one at a time, since files of the same collection share a staging collection
+func (m *MongoImporter) importStaged(path string, files []string) ([]*domain.ImportResult, error) {
+	m.stagingMu.Lock()
+	defer m.stagingMu.Unlock()
+
+	// Documents are inserted as they are; the write mode applies when they are merged
+	stagingOptions := domain.WriteOptions{Mode: domain.ImportModeInsert, Unordered: m.deadLetter != nil}
+
+	var results []*domain.ImportResult
+	var collections []string
+	staged := make(map[string]bool)
+	var importErr error
+	for _, file := range files {
+		collectionName := utils.FilePathToCollectionName(file)
+		if !staged[collectionName] {
+			staged[collectionName] = true
+			collections = append(collections, collectionName)
+		}
+
+		target := importTarget{
+			ctx:          m.ctx,
+			collection:   m.stagingCollectionName(collectionName),
+			writeOptions: stagingOptions,
+			deadLetter:   m.deadLetter,
+		}
+		result, err := m.importFile(file, target)
+		result.Staged = true
+		results = append(results, result)
+		if err != nil {
+			importErr = err
+			break
+		}
+	}
+
+	// Nothing reaches the targets unless every file was staged
+	merged := false
+	if importErr == nil {
+		for _, collectionName := range collections {
+			if _, err := m.repo.MergeCollection(m.ctx, m.stagingCollectionName(collectionName), collectionName, m.writeOptions); err != nil {
+				importErr = fmt.Errorf("error writing staged documents to collection %s: %w", collectionName, err)
+				break
+			}
+			merged = true
+		}
+	}
+
+	// Staging collections are dropped whether the import succeeded or not
+	for _, collectionName := range collections {
+		if err := m.repo.DropCollection(m.ctx, m.stagingCollectionName(collectionName)); err != nil {
+			fmt.Printf("Warning: could not drop staging collection %s: %v\n", m.stagingCollectionName(collectionName), err)
+		}
+	}
+
+	if importErr == nil {
+		return results, nil
+	}
+	if merged {
+		// Some target collections were already written
+		for _, result := range results {
+			if result.Error == nil {
+				result.Error = importErr
+			}
+		}
+		return results, fmt.Errorf("import of %s failed after some collections were written: %w", path, importErr)
+	}
+	results = rollBack(results, files, importErr)
+	return results, fmt.Errorf("import of %s rolled back: %w", path, importErr)
+}
+
+// rollBack marks the results of files whose writes were discarded
+// Files that were never started get a result of their own, so every file is reported
+func rollBack(results []*domain.ImportResult, files []string, err error) []*domain.ImportResult {
+	for _, result := range results {
+		result.Discard()
+		if result.Error == nil {
+			result.Error = fmt.Errorf("rolled back: %w", err)
+		}
+	}
+	for _, file := range files[len(results):] {
+		result := newImportResult(file)
+		result.RolledBack = true
+		result.Error = fmt.Errorf("not imported: %w", err)
+		results = append(results, result)
+	}
+	return results
+}
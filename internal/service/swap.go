@@ -0,0 +1,128 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+	"github.com/OTakumi/data-importer/internal/utils"
+)
+
+// swapCollection replaces a collection with the documents of files without ever leaving it empty
+// The files are imported into a staging collection with the indexes of the live collection,
+// which is checked and then renamed over the live one in a single step
+// If any step fails, the staging collection is dropped and the live collection is left untouched
+func (m *MongoImporter) swapCollection(collectionName string, files []string) ([]*domain.ImportResult, error) {
+	staging := m.stagingCollectionName(collectionName)
+
+	if err := m.repo.CreateStagingCollection(m.ctx, collectionName, staging); err != nil {
+		err = fmt.Errorf("error creating staging collection %s: %w", staging, err)
+		m.dropStaging(staging)
+		return rollBack(nil, files, err), err
+	}
+
+	// Documents are inserted as they are, the staging collection starts empty
+	target := importTarget{
+		ctx:           m.ctx,
+		collection:    staging,
+		writeOptions:  domain.WriteOptions{Mode: domain.ImportModeInsert, Unordered: m.deadLetter != nil},
+		deadLetter:    m.deadLetter,
+		insertWorkers: m.insertWorkers,
+	}
+
+	var results []*domain.ImportResult
+	var swapErr error
+	inserted := 0
+	for _, file := range files {
+		result, err := m.importFile(file, target)
+		result.Staged = true
+		results = append(results, result)
+		if err != nil {
+			swapErr = err
+			break
+		}
+		inserted += result.InsertedCount
+	}
+
+	previousCount := 0
+	if swapErr == nil {
+		previousCount, swapErr = m.checkStaging(collectionName, staging, inserted)
+	}
+	if swapErr == nil {
+		if err := m.repo.RenameCollection(m.ctx, staging, collectionName); err != nil {
+			swapErr = fmt.Errorf("error renaming %s to %s: %w", staging, collectionName, err)
+		}
+	}
+
+	if swapErr != nil {
+		m.dropStaging(staging)
+		results = rollBack(results, files, swapErr)
+		return results, fmt.Errorf("swap of collection %s aborted: %w", collectionName, swapErr)
+	}
+
+	for _, result := range results {
+		result.PreviousCount = previousCount
+	}
+	return results, nil
+}
+
+// checkStaging checks a staging collection before it replaces the live collection
+// It returns the document count of the live collection
+func (m *MongoImporter) checkStaging(collectionName, staging string, inserted int) (int, error) {
+	if err := m.repo.ValidateCollection(m.ctx, staging); err != nil {
+		return 0, fmt.Errorf("staging collection %s failed validation: %w", staging, err)
+	}
+
+	stagedCount, err := m.repo.CountDocuments(m.ctx, staging)
+	if err != nil {
+		return 0, err
+	}
+	if stagedCount != inserted {
+		return 0, fmt.Errorf("staging collection %s holds %d documents, expected %d", staging, stagedCount, inserted)
+	}
+
+	previousCount, err := m.repo.CountDocuments(m.ctx, collectionName)
+	if err != nil {
+		return 0, err
+	}
+	// Guards against replacing a collection with a truncated export
+	if m.swapMinRatio > 0 && float64(stagedCount) < m.swapMinRatio*float64(previousCount) {
+		return previousCount, fmt.Errorf("staging collection %s holds %d documents, less than %g of the %d in %s",
+			staging, stagedCount, m.swapMinRatio, previousCount, collectionName)
+	}
+	return previousCount, nil
+}
+
+// dropStaging drops a staging collection after a failed swap
+func (m *MongoImporter) dropStaging(staging string) {
+	if err := m.repo.DropCollection(m.ctx, staging); err != nil {
+		fmt.Printf("Warning: could not drop staging collection %s: %v\n", staging, err)
+	}
+}
+
+// swapDirectory swaps each collection fed by the files of a directory
+// Files of the same collection are loaded into one staging collection, and collections are swapped on
+// a bounded number of workers; the results are returned in the order of the files
+func (m *MongoImporter) swapDirectory(files []string) []*domain.ImportResult {
+	var collections []string
+	filesByCollection := make(map[string][]string)
+	for _, file := range files {
+		collectionName := utils.FilePathToCollectionName(file)
+		if _, ok := filesByCollection[collectionName]; !ok {
+			collections = append(collections, collectionName)
+		}
+		filesByCollection[collectionName] = append(filesByCollection[collectionName], file)
+	}
+
+	swapped := make([][]*domain.ImportResult, len(collections))
+	runWorkers(m.workers, len(collections), func(i int) {
+		swapped[i], _ = m.swapCollection(collections[i], filesByCollection[collections[i]])
+	})
+
+	results := make(map[string]*domain.ImportResult, len(files))
+	for i, collectionName := range collections {
+		for j, result := range swapped[i] {
+			results[filesByCollection[collectionName][j]] = result
+		}
+	}
+	return inputOrder(files, results)
+}
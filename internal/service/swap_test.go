@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// swapMock returns a repository that records the swap steps in events
+// The staging collection holds stagedCount documents and the live one liveCount
+func swapMock(events *[]string, stagedCount, liveCount int) *MockRepository {
+	var mu sync.Mutex
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		*events = append(*events, event)
+	}
+	return &MockRepository{
+		CreateStagingFunc: func(ctx context.Context, source, staging string) error {
+			record("create " + staging + " like " + source)
+			return nil
+		},
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			record("insert " + collectionName)
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+		ValidateFunc: func(ctx context.Context, collectionName string) error {
+			record("validate " + collectionName)
+			return nil
+		},
+		CountDocumentsFunc: func(ctx context.Context, collectionName string) (int, error) {
+			if isStagingCollection(collectionName) {
+				return stagedCount, nil
+			}
+			return liveCount, nil
+		},
+		RenameFunc: func(ctx context.Context, source, target string) error {
+			record("rename " + source + " to " + target)
+			return nil
+		},
+		DropCollectionFunc: func(ctx context.Context, collectionName string) error {
+			record("drop " + collectionName)
+			return nil
+		},
+	}
+}
+
+// TestImportFileSwap tests that swap mode loads a staging collection and renames it over the live one
+func TestImportFileSwap(t *testing.T) {
+	mockFileUtils := &MockFileUtils{
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "name", Value: "a"}}, {{Key: "name", Value: "b"}}}, nil
+		},
+	}
+
+	var events []string
+	importer := NewMongoImporter(context.Background(), mockFileUtils, swapMock(&events, 2, 5), ImporterOptions{Mode: domain.ImportModeSwap})
+	result, err := importer.ImportFile("/data/users.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	staging := "users" + StagingCollectionInfix + importer.runID
+	expected := []string{
+		"create " + staging + " like users",
+		"insert " + staging,
+		"validate " + staging,
+		"rename " + staging + " to users",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+	if result.CollectionName != "users" || result.InsertedCount != 2 || result.PreviousCount != 5 {
+		t.Errorf("Expected 2 documents inserted into users replacing 5, got %+v", result)
+	}
+}
+
+// TestImportFileSwapFailure tests that a failed check drops the staging collection and keeps the live one
+func TestImportFileSwapFailure(t *testing.T) {
+	mockFileUtils := &MockFileUtils{
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "name", Value: "a"}}, {{Key: "name", Value: "b"}}}, nil
+		},
+	}
+
+	tests := []struct {
+		name        string
+		stagedCount int
+		liveCount   int
+		minRatio    float64
+		validateErr error
+	}{
+		{name: "CountMismatch", stagedCount: 1, liveCount: 5},
+		{name: "BelowMinRatio", stagedCount: 2, liveCount: 5, minRatio: 0.5},
+		{name: "ValidationFailed", stagedCount: 2, liveCount: 5, validateErr: errors.New("index corrupt")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []string
+			mockRepo := swapMock(&events, tt.stagedCount, tt.liveCount)
+			if tt.validateErr != nil {
+				mockRepo.ValidateFunc = func(ctx context.Context, collectionName string) error {
+					return tt.validateErr
+				}
+			}
+			mockRepo.RenameFunc = func(ctx context.Context, source, target string) error {
+				t.Error("Expected the live collection to be kept")
+				return nil
+			}
+
+			importer := NewMongoImporter(context.Background(), mockFileUtils, mockRepo, ImporterOptions{
+				Mode:         domain.ImportModeSwap,
+				SwapMinRatio: tt.minRatio,
+			})
+			result, err := importer.ImportFile("/data/users.json")
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+
+			staging := "users" + StagingCollectionInfix + importer.runID
+			if events[len(events)-1] != "drop "+staging {
+				t.Errorf("Expected the staging collection to be dropped, got events %v", events)
+			}
+			if !result.RolledBack || result.InsertedCount != 0 {
+				t.Errorf("Expected a rolled back result, got %+v", result)
+			}
+		})
+	}
+}
+
+// TestImportDirectorySwap tests that files of the same collection are swapped in together
+func TestImportDirectorySwap(t *testing.T) {
+	mockFileUtils := &MockFileUtils{
+		IsDirectoryFunc: func(path string) (bool, error) {
+			return true, nil
+		},
+		FindJSONFilesFunc: func(dirPath string) ([]string, error) {
+			return []string{"/data/users.json", "/data/users.ndjson", "/data/orders.json"}, nil
+		},
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "n", Value: 1}}}, nil
+		},
+	}
+
+	var mu sync.Mutex
+	renamed := make(map[string]int)
+	var events []string
+	mockRepo := swapMock(&events, 0, 0)
+	mockRepo.CountDocumentsFunc = func(ctx context.Context, collectionName string) (int, error) {
+		if collectionName == "users"+StagingCollectionInfix+"test" {
+			return 2, nil
+		}
+		if collectionName == "orders"+StagingCollectionInfix+"test" {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	mockRepo.RenameFunc = func(ctx context.Context, source, target string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		renamed[target]++
+		return nil
+	}
+
+	importer := NewMongoImporter(context.Background(), mockFileUtils, mockRepo, ImporterOptions{Mode: domain.ImportModeSwap})
+	importer.runID = "test"
+	output, err := importer.ImportPath("/data")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(renamed, map[string]int{"users": 1, "orders": 1}) {
+		t.Errorf("Expected users and orders to be swapped once each, got %v", renamed)
+	}
+	if results := output.([]*domain.ImportResult); len(results) != 3 {
+		t.Errorf("Expected a result for each file, got %d", len(results))
+	}
+}
@@ -0,0 +1,391 @@
+package service
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+	"github.com/OTakumi/data-importer/internal/utils"
+)
+
+// SyncFile mirrors a single file into its collection
+// Documents are upserted by key, and documents in the collection that are
+// missing from the file are deleted once the whole file has been read
+// In preview mode the changes are only computed, nothing is written
+func (m *MongoImporter) SyncFile(filePath string) (*domain.SyncResult, error) {
+	startTime := time.Now()
+	result := &domain.SyncResult{
+		FileName:       filepath.Base(filePath),
+		CollectionName: utils.FilePathToCollectionName(filePath),
+		Preview:        m.preview,
+	}
+
+	err := m.syncFile(filePath, result)
+	result.Duration = time.Since(startTime)
+	if err != nil {
+		result.Error = err
+		return result, err
+	}
+
+	return result, nil
+}
+
+// SyncDirectory mirrors every file in a directory into its collection
+// Files are processed one at a time in path order so the summary is stable
+// When dropMissingCollections is set, collections without a matching file are dropped
+func (m *MongoImporter) SyncDirectory(dirPath string) ([]*domain.SyncResult, error) {
+	jsonFiles, err := m.fileUtils.FindJSONFiles(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("error finding JSON files in directory %s: %w", dirPath, err)
+	}
+
+	if len(jsonFiles) == 0 {
+		return nil, fmt.Errorf("no JSON files found in directory %s", dirPath)
+	}
+
+	// Each collection must be fed by exactly one file, otherwise the files
+	// would delete each other's documents
+	collections := make(map[string]string, len(jsonFiles))
+	for _, file := range jsonFiles {
+		collectionName := utils.FilePathToCollectionName(file)
+		if other, ok := collections[collectionName]; ok {
+			return nil, fmt.Errorf("files %s and %s both map to collection %s", other, file, collectionName)
+		}
+		collections[collectionName] = file
+	}
+
+	var results []*domain.SyncResult
+	failedCount := 0
+	for _, file := range jsonFiles {
+		result, _ := m.SyncFile(file)
+		if result.Error != nil {
+			failedCount++
+		}
+		results = append(results, result)
+	}
+
+	// Files cut short by the run deadline or by stopping the sync fail with it,
+	// so it is reported as the cause
+	cause := cmp.Or(m.deadlineErr(), m.stopErr())
+	if failedCount > 0 {
+		// Don't drop anything when the folder could not be mirrored completely
+		if cause != nil {
+			return results, fmt.Errorf("%d out of %d files failed to sync: %w", failedCount, len(jsonFiles), cause)
+		}
+		return results, fmt.Errorf("%d out of %d files failed to sync", failedCount, len(jsonFiles))
+	}
+	if cause != nil {
+		return results, fmt.Errorf("dropping collections without a file skipped: %w", cause)
+	}
+
+	if m.dropMissingCollections {
+		dropped, err := m.dropMissing(collections)
+		results = append(results, dropped...)
+		if err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// syncFile computes and applies the changes needed to make a collection match a file
+func (m *MongoImporter) syncFile(filePath string, result *domain.SyncResult) error {
+	// Files that start after the run deadline passed or the sync was stopped are not synced
+	if err := cmp.Or(m.deadlineErr(), m.stopErr()); err != nil {
+		return fmt.Errorf("sync of %s skipped: %w", filePath, err)
+	}
+
+	keyFields := m.writeOptions.KeyFields
+	if len(keyFields) == 0 {
+		keyFields = []string{"_id"}
+	}
+
+	// Index the current contents of the collection by key
+	// Only the _id and a hash of the content are kept, not the documents themselves
+	var stored []storedDocument
+	index := make(map[string]int)
+	err := m.repo.ScanDocuments(m.ctx, result.CollectionName, func(raw bson.Raw) error {
+		var doc storedDocument
+		if err := raw.Lookup("_id").Unmarshal(&doc.id); err != nil {
+			return fmt.Errorf("error reading _id: %w", err)
+		}
+		hash, err := contentHash(raw, m.runField)
+		if err != nil {
+			return err
+		}
+		doc.hash = hash
+
+		// Documents without a key can't match the file and duplicates beyond the first are deleted
+		var fields domain.Document
+		if err := bson.Unmarshal(raw, &fields); err == nil {
+			if key, err := syncKey(fields, keyFields); err == nil {
+				if _, ok := index[key]; !ok {
+					doc.key = key
+					index[key] = len(stored)
+				}
+			}
+		}
+		stored = append(stored, doc)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading collection %s: %w", result.CollectionName, err)
+	}
+
+	// Stream the file and upsert the documents that are new or changed
+	seen := make(map[string]bool)
+	position := 0
+	var importErr error
+	err = m.fileUtils.StreamDocuments(filePath, m.batchSize, func(batch []bson.D) error {
+		// Batches already written stay written, the rest of the file is left for the next sync
+		if err := cmp.Or(m.deadlineErr(), m.stopErr()); err != nil {
+			importErr = err
+			return err
+		}
+
+		docs := make([]domain.Document, 0, len(batch))
+		for _, doc := range batch {
+			docs = append(docs, domain.Document(doc))
+		}
+
+		docs, err := m.cleanDocuments(docs)
+		if err != nil {
+			importErr = err
+			return err
+		}
+
+		changed := make([]domain.Document, 0, len(docs))
+		var previous []any
+		for _, doc := range docs {
+			position++
+			key, err := syncKey(doc, keyFields)
+			if err != nil {
+				importErr = fmt.Errorf("document %d: %w", position, err)
+				return importErr
+			}
+			if seen[key] {
+				importErr = fmt.Errorf("document %d: duplicate key %s", position, formatKey(doc, keyFields))
+				return importErr
+			}
+			seen[key] = true
+
+			i, ok := index[key]
+			if !ok {
+				result.InsertedCount++
+				changed = append(changed, doc)
+				continue
+			}
+			same, err := stored[i].matches(doc, m.runField)
+			if err != nil {
+				importErr = fmt.Errorf("document %d: %w", position, err)
+				return importErr
+			}
+			if same {
+				result.UnchangedCount++
+			} else {
+				result.UpdatedCount++
+				changed = append(changed, doc)
+				previous = append(previous, stored[i].id)
+			}
+		}
+
+		if m.preview || len(changed) == 0 {
+			return nil
+		}
+
+		// Only written documents are stamped, so unchanged ones keep the run that last wrote them
+		if err := m.savePreviousByID(result.CollectionName, previous); err != nil {
+			importErr = err
+			return err
+		}
+		for i := range changed {
+			m.stamp(&changed[i])
+		}
+
+		// Only changed documents are written, so stop at the first failure
+		opts := domain.WriteOptions{Mode: domain.ImportModeUpsert, KeyFields: m.writeOptions.KeyFields}
+		if _, err := m.repo.WriteDocuments(m.ctx, result.CollectionName, changed, opts); err != nil {
+			importErr = err
+			return err
+		}
+		return nil
+	})
+
+	if importErr != nil {
+		return fmt.Errorf("error syncing documents to collection %s: %w", result.CollectionName, importErr)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing file %s: %w", filePath, err)
+	}
+
+	// Delete what is no longer in the file, only after it has been read completely
+	if err := cmp.Or(m.deadlineErr(), m.stopErr()); err != nil {
+		return fmt.Errorf("deleting documents from collection %s skipped: %w", result.CollectionName, err)
+	}
+	var staleIDs []any
+	for _, doc := range stored {
+		if doc.key != "" && seen[doc.key] {
+			continue
+		}
+		staleIDs = append(staleIDs, doc.id)
+	}
+
+	result.DeletedCount = len(staleIDs)
+	if m.preview || len(staleIDs) == 0 {
+		return nil
+	}
+
+	if err := m.savePreviousByID(result.CollectionName, staleIDs); err != nil {
+		return fmt.Errorf("error deleting documents from collection %s: %w", result.CollectionName, err)
+	}
+
+	deleted, err := m.repo.DeleteDocuments(m.ctx, result.CollectionName, staleIDs)
+	result.DeletedCount = deleted
+	if err != nil {
+		return fmt.Errorf("error deleting documents from collection %s: %w", result.CollectionName, err)
+	}
+	return nil
+}
+
+// savePreviousByID saves the stored versions of the documents with the given _ids
+// before they are overwritten or deleted, reading them back a batch at a time
+func (m *MongoImporter) savePreviousByID(collectionName string, ids []any) error {
+	if m.runField == "" {
+		return nil
+	}
+
+	for start := 0; start < len(ids); start += m.batchSize {
+		batch := ids[start:min(start+m.batchSize, len(ids))]
+		filters := make([]domain.Document, 0, len(batch))
+		for _, id := range batch {
+			filters = append(filters, domain.Document{{Key: "_id", Value: id}})
+		}
+
+		stored, err := m.repo.FindMatchingDocuments(m.ctx, collectionName, filters, []string{"_id"})
+		if err != nil {
+			return fmt.Errorf("error reading previous versions: %w", err)
+		}
+		if err := m.savePrevious(m.ctx, collectionName, stored); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropMissing drops the collections that have no matching file
+// System, dead-letter, staging and importer record collections are never dropped
+func (m *MongoImporter) dropMissing(collections map[string]string) ([]*domain.SyncResult, error) {
+	names, err := m.repo.ListCollections(m.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing collections: %w", err)
+	}
+
+	var results []*domain.SyncResult
+	var dropErrors []error
+	for _, name := range names {
+		if _, ok := collections[name]; ok || strings.HasPrefix(name, "system.") || strings.HasSuffix(name, DeadLetterCollectionSuffix) || isStagingCollection(name) || domain.IsImporterCollection(name) {
+			continue
+		}
+
+		result := &domain.SyncResult{CollectionName: name, Dropped: true, Preview: m.preview}
+		if !m.preview {
+			if err := m.repo.DropCollection(m.ctx, name); err != nil {
+				result.Error = fmt.Errorf("error dropping collection %s: %w", name, err)
+				dropErrors = append(dropErrors, result.Error)
+			}
+		}
+		results = append(results, result)
+	}
+
+	if len(dropErrors) > 0 {
+		return results, fmt.Errorf("%d out of %d collections failed to drop", len(dropErrors), len(results))
+	}
+	return results, nil
+}
+
+// syncKey builds a comparable key from the key fields of a document
+func syncKey(doc domain.Document, keyFields []string) (string, error) {
+	key := make(bson.D, 0, len(keyFields))
+	for _, field := range keyFields {
+		value, ok := doc.Lookup(field)
+		if !ok {
+			return "", fmt.Errorf("key field %q is missing", field)
+		}
+		key = append(key, bson.E{Key: field, Value: value})
+	}
+
+	raw, err := bson.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// formatKey formats the key fields of a document for error messages
+func formatKey(doc domain.Document, keyFields []string) string {
+	parts := make([]string, 0, len(keyFields))
+	for _, field := range keyFields {
+		value, _ := doc.Lookup(field)
+		parts = append(parts, fmt.Sprintf("%s=%v", field, value))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// storedDocument is what a sync keeps of a document in the collection
+type storedDocument struct {
+	key  string // Empty when the document can't match the file
+	id   any
+	hash [sha256.Size]byte
+}
+
+// matches reports whether a stored document already matches a document from the file
+// The incoming document is marshaled as it would be written, so values that BSON
+// can't hold exactly (sub-millisecond times, for example) compare as stored
+// The stored _id is only compared when the file provides one, and the run field is ignored
+func (s storedDocument) matches(incoming domain.Document, runField string) (bool, error) {
+	data, err := bson.Marshal(bson.D(incoming))
+	if err != nil {
+		return false, err
+	}
+	raw := bson.Raw(data)
+	if id, err := raw.LookupErr("_id"); err == nil {
+		storedID, err := bson.Marshal(bson.D{{Key: "_id", Value: s.id}})
+		if err != nil {
+			return false, err
+		}
+		if !id.Equal(bson.Raw(storedID).Lookup("_id")) {
+			return false, nil
+		}
+	}
+
+	hash, err := contentHash(raw, runField)
+	if err != nil {
+		return false, err
+	}
+	return hash == s.hash, nil
+}
+
+// contentHash hashes the fields of a document other than _id and the run field
+// The server moves _id to the front, so it is left out rather than compared by position
+func contentHash(doc bson.Raw, runField string) ([sha256.Size]byte, error) {
+	elements, err := doc.Elements()
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+
+	h := sha256.New()
+	for _, element := range elements {
+		if key := element.Key(); key == "_id" || (runField != "" && key == runField) {
+			continue
+		}
+		h.Write(element)
+	}
+	return [sha256.Size]byte(h.Sum(nil)), nil
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// TestSyncFile tests that a file is mirrored into its collection
+func TestSyncFile(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{
+				{{Key: "code", Value: "JP"}, {Key: "name", Value: "Japan"}},
+				{{Key: "code", Value: "US"}, {Key: "name", Value: "United States of America"}},
+				{{Key: "code", Value: "FR"}, {Key: "name", Value: "France"}},
+			}, nil
+		},
+	}
+
+	// Stored documents: JP is unchanged, US has changed, DE is no longer in the file
+	newRepo := func(written *[]string, deleted *[]any) *MockRepository {
+		return &MockRepository{
+			ScanDocumentsFunc: scanDocuments([]domain.Document{
+				{{Key: "_id", Value: 1}, {Key: "code", Value: "JP"}, {Key: "name", Value: "Japan"}},
+				{{Key: "_id", Value: 2}, {Key: "code", Value: "US"}, {Key: "name", Value: "United States"}},
+				{{Key: "_id", Value: 3}, {Key: "code", Value: "DE"}, {Key: "name", Value: "Germany"}},
+			}),
+			WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+				if opts.Mode != domain.ImportModeUpsert {
+					t.Errorf("Expected upsert mode, got %s", opts.Mode)
+				}
+				for _, doc := range documents {
+					code, _ := doc.Get("code")
+					*written = append(*written, code.(string))
+				}
+				return &domain.ImportResult{CollectionName: collectionName}, nil
+			},
+			DeleteDocumentsFunc: func(ctx context.Context, collectionName string, ids []any) (int, error) {
+				*deleted = append(*deleted, ids...)
+				return len(ids), nil
+			},
+		}
+	}
+
+	t.Run("Apply changes", func(t *testing.T) {
+		var written []string
+		var deleted []any
+		importer := NewMongoImporter(ctx, mockFileUtils, newRepo(&written, &deleted), ImporterOptions{
+			RemoveIDField: true,
+			Mode:          domain.ImportModeSync,
+			KeyFields:     []string{"code"},
+		})
+
+		result, err := importer.SyncFile("/master/countries.json")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if result.InsertedCount != 1 || result.UpdatedCount != 1 || result.DeletedCount != 1 || result.UnchangedCount != 1 {
+			t.Errorf("Unexpected counts: %+v", result)
+		}
+		if !reflect.DeepEqual(written, []string{"US", "FR"}) {
+			t.Errorf("Expected only changed documents to be written, got %v", written)
+		}
+		if !reflect.DeepEqual(deleted, []any{int32(3)}) {
+			t.Errorf("Expected stale document 3 to be deleted, got %v", deleted)
+		}
+	})
+
+	t.Run("Preview", func(t *testing.T) {
+		var written []string
+		var deleted []any
+		importer := NewMongoImporter(ctx, mockFileUtils, newRepo(&written, &deleted), ImporterOptions{
+			RemoveIDField: true,
+			Mode:          domain.ImportModeSync,
+			KeyFields:     []string{"code"},
+			Preview:       true,
+		})
+
+		result, err := importer.SyncFile("/master/countries.json")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if !result.Preview || result.InsertedCount != 1 || result.UpdatedCount != 1 || result.DeletedCount != 1 {
+			t.Errorf("Unexpected preview result: %+v", result)
+		}
+		if len(written) != 0 || len(deleted) != 0 {
+			t.Errorf("Expected nothing to be written in preview, got writes %v and deletes %v", written, deleted)
+		}
+	})
+
+	t.Run("Parse error keeps stale documents", func(t *testing.T) {
+		var written []string
+		var deleted []any
+		failingFileUtils := &MockFileUtils{
+			ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+				return nil, errors.New("unexpected EOF")
+			},
+		}
+		importer := NewMongoImporter(ctx, failingFileUtils, newRepo(&written, &deleted), ImporterOptions{
+			Mode:      domain.ImportModeSync,
+			KeyFields: []string{"code"},
+		})
+
+		if _, err := importer.SyncFile("/master/countries.json"); err == nil {
+			t.Error("Expected an error but got none")
+		}
+		if len(deleted) != 0 {
+			t.Errorf("Expected no deletes after a parse error, got %v", deleted)
+		}
+	})
+}
+
+// TestSyncFileTwice tests that syncing the same file twice finds nothing to update,
+// although the server moves _id to the front and BSON drops sub-millisecond times
+func TestSyncFileTwice(t *testing.T) {
+	mockFileUtils := &MockFileUtils{
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{
+				{{Key: "code", Value: "JP"}, {Key: "_id", Value: "jp"}, {Key: "updatedAt", Value: time.Date(2026, 1, 2, 3, 4, 5, 123456789, time.UTC)}},
+				{{Key: "code", Value: "US"}, {Key: "rate", Value: 1.0}},
+			}, nil
+		},
+	}
+
+	// Documents are stored the way the server writes them: _id first, generated when missing
+	var collection []domain.Document
+	mockRepo := &MockRepository{
+		ScanDocumentsFunc: func(ctx context.Context, collectionName string, fn func(doc bson.Raw) error) error {
+			return scanDocuments(collection)(ctx, collectionName, fn)
+		},
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			for _, doc := range documents {
+				id, ok := doc.Get("_id")
+				if !ok {
+					id = primitive.NewObjectID()
+				}
+				stored := domain.Document{{Key: "_id", Value: id}}
+				for _, element := range doc {
+					if element.Key != "_id" {
+						stored = append(stored, element)
+					}
+				}
+				var decoded domain.Document
+				raw, _ := bson.Marshal(bson.D(stored))
+				if err := bson.Unmarshal(raw, &decoded); err != nil {
+					return nil, err
+				}
+				collection = append(collection, decoded)
+			}
+			return &domain.ImportResult{CollectionName: collectionName, UpsertedCount: len(documents)}, nil
+		},
+		DeleteDocumentsFunc: func(ctx context.Context, collectionName string, ids []any) (int, error) {
+			t.Errorf("Expected nothing to be deleted, got %v", ids)
+			return 0, nil
+		},
+	}
+
+	importer := NewMongoImporter(context.Background(), mockFileUtils, mockRepo, ImporterOptions{
+		Mode:      domain.ImportModeSync,
+		KeyFields: []string{"code"},
+	})
+	first, err := importer.SyncFile("/master/countries.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if first.InsertedCount != 2 {
+		t.Fatalf("Expected both documents to be inserted, got %+v", first)
+	}
+
+	second, err := importer.SyncFile("/master/countries.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if second.InsertedCount != 0 || second.UpdatedCount != 0 || second.UnchangedCount != 2 {
+		t.Errorf("Expected both documents to be unchanged, got %+v", second)
+	}
+}
+
+// TestSyncDirectory tests syncing a directory and dropping collections without a file
+func TestSyncDirectory(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		FindJSONFilesFunc: func(dirPath string) ([]string, error) {
+			return []string{"/master/countries.json", "/master/currencies.json"}, nil
+		},
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "_id", Value: "a"}}}, nil
+		},
+	}
+
+	var dropped []string
+	mockRepo := &MockRepository{
+		ScanDocumentsFunc: scanDocuments(nil),
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			return &domain.ImportResult{CollectionName: collectionName, UpsertedCount: len(documents)}, nil
+		},
+		ListCollectionsFunc: func(ctx context.Context) ([]string, error) {
+			return []string{"countries", "currencies", "legacy", "system.views"}, nil
+		},
+		DropCollectionFunc: func(ctx context.Context, collectionName string) error {
+			dropped = append(dropped, collectionName)
+			return nil
+		},
+	}
+
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{
+		Mode:                   domain.ImportModeSync,
+		DropMissingCollections: true,
+	})
+
+	results, err := importer.SyncDirectory("/master")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var collections []string
+	for _, r := range results {
+		collections = append(collections, r.CollectionName)
+	}
+	if !reflect.DeepEqual(collections, []string{"countries", "currencies", "legacy"}) {
+		t.Errorf("Unexpected results: %v", collections)
+	}
+	if !results[2].Dropped || !reflect.DeepEqual(dropped, []string{"legacy"}) {
+		t.Errorf("Expected only legacy to be dropped, got %v", dropped)
+	}
+
+	// Two files for the same collection are rejected before anything is written
+	mockFileUtils.FindJSONFilesFunc = func(dirPath string) ([]string, error) {
+		return []string{"/master/countries.csv", "/master/countries.json"}, nil
+	}
+	if _, err := importer.SyncDirectory("/master"); err == nil {
+		t.Error("Expected an error for two files mapping to the same collection")
+	}
+}
+
+// scanDocuments returns a ScanDocumentsFunc that passes documents as they are stored
+func scanDocuments(documents []domain.Document) func(ctx context.Context, collectionName string, fn func(doc bson.Raw) error) error {
+	return func(ctx context.Context, collectionName string, fn func(doc bson.Raw) error) error {
+		for _, doc := range documents {
+			raw, err := bson.Marshal(bson.D(doc))
+			if err != nil {
+				return err
+			}
+			if err := fn(raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
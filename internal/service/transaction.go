@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+	"github.com/OTakumi/data-importer/internal/utils"
+)
+
+// DefaultMaxTransactionBytes is the input size above which a file, or all files of
+// a run, are imported through staging collections instead of a single transaction
+// MongoDB aborts transactions that run longer than 60 seconds by default, and large
+// transactions put pressure on the server's cache, so big imports are not attempted
+const DefaultMaxTransactionBytes = 16 << 20
+
+// checkTransactionMode returns an error if the write mode can't be used in a transaction
+// Reload and swap drop or rename collections, which isn't allowed in a transaction,
+// and sync deletes documents based on a full read of each collection
+func (m *MongoImporter) checkTransactionMode() error {
+	switch m.writeOptions.Mode {
+	case domain.ImportModeReload, domain.ImportModeSwap, domain.ImportModeSync:
+		return fmt.Errorf("transactions can't be used in %s mode", m.writeOptions.Mode)
+	}
+	return nil
+}
+
+// importInTransaction imports files in a single transaction, so they are either imported
+// completely or not at all. path names the imported file or directory in messages
+// Imports larger than maxTransactionBytes, and imports that hit the transaction limits
+// of the server, go through staging collections instead (see importStaged)
+func (m *MongoImporter) importInTransaction(path string, files []string) ([]*domain.ImportResult, error) {
+	if err := m.checkTransactionMode(); err != nil {
+		return rollBack(nil, files, err), err
+	}
+
+	var size int64
+	for _, file := range files {
+		fileSize, err := m.fileUtils.FileSize(file)
+		if err != nil {
+			return rollBack(nil, files, err), err
+		}
+		size += fileSize
+	}
+	if size > m.maxTransactionBytes {
+		fmt.Printf("%s is larger than %d bytes, importing through staging collections\n", path, m.maxTransactionBytes)
+		return m.importStaged(path, files)
+	}
+
+	// Rejected documents are held back until it is known whether this attempt is kept,
+	// so they are not reported twice when the import is redone through staging collections
+	var pending *pendingDeadLetter
+	var deadLetter DeadLetterSink
+	if m.deadLetter != nil {
+		pending = &pendingDeadLetter{sink: m.deadLetter}
+		deadLetter = pending
+	}
+
+	var results []*domain.ImportResult
+	err := m.repo.WithTransaction(m.ctx, func(ctx context.Context) error {
+		// The transaction may be retried as a whole, so nothing is kept from an earlier attempt
+		results = nil
+		if pending != nil {
+			pending.rejected = nil
+		}
+		for _, file := range files {
+			target := importTarget{
+				ctx:          ctx,
+				collection:   utils.FilePathToCollectionName(file),
+				writeOptions: m.writeOptions,
+				deadLetter:   deadLetter,
+			}
+			result, err := m.importFile(file, target)
+			results = append(results, result)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, domain.ErrTransactionLimit) {
+		fmt.Printf("Import of %s exceeded the transaction limits, importing through staging collections\n", path)
+		return m.importStaged(path, files)
+	}
+
+	if pending != nil {
+		if flushErr := pending.flush(); flushErr != nil && err == nil {
+			// The documents were written, only the dead-letter output is incomplete
+			return results, flushErr
+		}
+	}
+	if err != nil {
+		results = rollBack(results, files, err)
+		return results, fmt.Errorf("import of %s rolled back: %w", path, err)
+	}
+	return results, nil
+}
+
+// pendingDeadLetter holds rejected documents until they are flushed to the actual sink
+type pendingDeadLetter struct {
+	sink     DeadLetterSink
+	rejected []domain.RejectedDocument
+}
+
+// Reject holds a rejected document
+func (p *pendingDeadLetter) Reject(rejected domain.RejectedDocument) error {
+	p.rejected = append(p.rejected, rejected)
+	return nil
+}
+
+// Close does nothing, the actual sink is closed by its owner
+func (p *pendingDeadLetter) Close() error {
+	return nil
+}
+
+// flush sends the held documents to the actual sink
+func (p *pendingDeadLetter) flush() error {
+	for _, rejected := range p.rejected {
+		if err := p.sink.Reject(rejected); err != nil {
+			return err
+		}
+	}
+	p.rejected = nil
+	return nil
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+	"github.com/OTakumi/data-importer/internal/utils"
+)
+
+// sessionKey marks the context passed to a transaction callback
+type sessionKey struct{}
+
+// transactionMock returns a WithTransactionFunc that runs fn with a marked context
+// and returns its error, and counts the transactions
+func transactionMock(count *int) func(ctx context.Context, fn func(ctx context.Context) error) error {
+	return func(ctx context.Context, fn func(ctx context.Context) error) error {
+		*count++
+		return fn(context.WithValue(ctx, sessionKey{}, true))
+	}
+}
+
+// TestImportFileTransaction tests that a file is written in a transaction and rolled back on failure
+func TestImportFileTransaction(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "n", Value: 1}}, {{Key: "n", Value: 2}}, {{Key: "n", Value: 3}}}, nil
+		},
+	}
+
+	transactions := 0
+	batches := 0
+	mockRepo := &MockRepository{
+		WithTransactionFunc: transactionMock(&transactions),
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			if ctx.Value(sessionKey{}) == nil {
+				t.Error("Expected the write to use the transaction context")
+			}
+			batches++
+			if batches == 2 {
+				return nil, errors.New("write failed")
+			}
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{
+		BatchSize:   2,
+		Unordered:   true,
+		Transaction: domain.TransactionFile,
+	})
+	if importer.writeOptions.Unordered {
+		t.Error("Expected writes to be ordered in a transaction")
+	}
+
+	result, err := importer.ImportFile("/data/users.json")
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if transactions != 1 {
+		t.Errorf("Expected 1 transaction, got %d", transactions)
+	}
+	// The first batch was written, but it is rolled back with the second one
+	if !result.RolledBack || result.InsertedCount != 0 {
+		t.Errorf("Expected a rolled back result without inserted documents, got %+v", result)
+	}
+}
+
+// TestImportFileStaged tests that files too large for a transaction go through a staging collection
+func TestImportFileStaged(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		FileSizeFunc: func(path string) (int64, error) {
+			return 100, nil
+		},
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "code", Value: "JP"}}, {{Key: "code", Value: "US"}}}, nil
+		},
+	}
+
+	var events []string
+	mockRepo := &MockRepository{
+		WithTransactionFunc: func(ctx context.Context, fn func(ctx context.Context) error) error {
+			t.Error("Expected no transaction")
+			return fn(ctx)
+		},
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			events = append(events, "insert "+collectionName)
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+		MergeCollectionFunc: func(ctx context.Context, source, target string, opts domain.WriteOptions) (int, error) {
+			if opts.Mode != domain.ImportModeUpsert || !reflect.DeepEqual(opts.KeyFields, []string{"code"}) {
+				t.Errorf("Expected the configured write options, got %+v", opts)
+			}
+			events = append(events, "merge "+source+" into "+target)
+			return 2, nil
+		},
+		DropCollectionFunc: func(ctx context.Context, collectionName string) error {
+			events = append(events, "drop "+collectionName)
+			return nil
+		},
+	}
+
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{
+		Mode:                domain.ImportModeUpsert,
+		KeyFields:           []string{"code"},
+		Transaction:         domain.TransactionFile,
+		MaxTransactionBytes: 10,
+	})
+	result, err := importer.ImportFile("/data/countries.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	staging := "countries" + StagingCollectionInfix + importer.runID
+	expected := []string{"insert " + staging, "merge " + staging + " into countries", "drop " + staging}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+	if !result.Staged || result.CollectionName != "countries" {
+		t.Errorf("Expected a staged result for countries, got %+v", result)
+	}
+}
+
+// TestImportFileTransactionLimit tests the fallback to staging when a transaction hits the server limits
+func TestImportFileTransactionLimit(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		StreamRejectsFunc: func(filePath string, batchSize int, handler utils.BatchHandler, reject utils.RejectHandler) error {
+			if err := reject(0, `{"n":`, &utils.ParseError{File: filePath, Line: 1, Err: errors.New("unexpected EOF")}); err != nil {
+				return err
+			}
+			return handler([]bson.D{{{Key: "n", Value: 2}}})
+		},
+	}
+
+	var inserted []string
+	mockRepo := &MockRepository{
+		WithTransactionFunc: func(ctx context.Context, fn func(ctx context.Context) error) error {
+			if err := fn(ctx); err != nil {
+				return err
+			}
+			return fmt.Errorf("commit: %w", domain.ErrTransactionLimit)
+		},
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			inserted = append(inserted, collectionName)
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+		WriteDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document, opts domain.WriteOptions) (*domain.ImportResult, error) {
+			inserted = append(inserted, collectionName)
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+		MergeCollectionFunc: func(ctx context.Context, source, target string, opts domain.WriteOptions) (int, error) {
+			return 1, nil
+		},
+		DropCollectionFunc: func(ctx context.Context, collectionName string) error {
+			return nil
+		},
+	}
+
+	sink := &MockDeadLetterSink{}
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{
+		BatchSize:   10,
+		DeadLetter:  sink,
+		Transaction: domain.TransactionFile,
+	})
+	result, err := importer.ImportFile("/data/users.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(inserted) != 2 || inserted[0] != "users" || !strings.HasPrefix(inserted[1], "users"+StagingCollectionInfix) {
+		t.Errorf("Expected a transactional write followed by a staged write, got %v", inserted)
+	}
+	if !result.Staged {
+		t.Errorf("Expected a staged result, got %+v", result)
+	}
+	// The document rejected by the abandoned attempt is reported once
+	if len(sink.Rejected) != 1 || sink.Rejected[0].CollectionName != "users" {
+		t.Errorf("Expected 1 rejected document for users, got %+v", sink.Rejected)
+	}
+}
+
+// TestImportFileTransactionRetried tests that a retried transaction reports only its last attempt
+func TestImportFileTransactionRetried(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		StreamRejectsFunc: func(filePath string, batchSize int, handler utils.BatchHandler, reject utils.RejectHandler) error {
+			if err := reject(0, `{"n":`, &utils.ParseError{File: filePath, Line: 1, Err: errors.New("unexpected EOF")}); err != nil {
+				return err
+			}
+			return handler([]bson.D{{{Key: "n", Value: 2}}, {{Key: "n", Value: 3}}})
+		},
+	}
+
+	attempts := 0
+	mockRepo := &MockRepository{
+		// The repository runs fn again after a transient transaction error
+		WithTransactionFunc: func(ctx context.Context, fn func(ctx context.Context) error) error {
+			for {
+				attempts++
+				err := fn(ctx)
+				if attempts == 1 {
+					continue
+				}
+				return err
+			}
+		},
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	sink := &MockDeadLetterSink{}
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{
+		BatchSize:   10,
+		DeadLetter:  sink,
+		Transaction: domain.TransactionFile,
+	})
+	result, err := importer.ImportFile("/data/users.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if attempts != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", attempts)
+	}
+	if result.InsertedCount != 2 || result.RejectedCount != 1 {
+		t.Errorf("Expected the counts of the last attempt, got %+v", result)
+	}
+	if len(sink.Rejected) != 1 {
+		t.Errorf("Expected the rejected document to be reported once, got %+v", sink.Rejected)
+	}
+}
+
+// TestImportDirectoryTransaction tests that a run transaction rolls back every file
+func TestImportDirectoryTransaction(t *testing.T) {
+	ctx := context.Background()
+
+	mockFileUtils := &MockFileUtils{
+		IsDirectoryFunc: func(path string) (bool, error) {
+			return true, nil
+		},
+		FindJSONFilesFunc: func(dirPath string) ([]string, error) {
+			return []string{"/data/a.json", "/data/b.json", "/data/c.json"}, nil
+		},
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "n", Value: 1}}}, nil
+		},
+	}
+
+	transactions := 0
+	var written []string
+	mockRepo := &MockRepository{
+		WithTransactionFunc: transactionMock(&transactions),
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			written = append(written, collectionName)
+			if collectionName == "b" {
+				return nil, errors.New("write failed")
+			}
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	importer := NewMongoImporter(ctx, mockFileUtils, mockRepo, ImporterOptions{Transaction: domain.TransactionRun})
+	output, err := importer.ImportPath("/data")
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	// Files are imported one after another in a single transaction, up to the failing one
+	if transactions != 1 || !reflect.DeepEqual(written, []string{"a", "b"}) {
+		t.Errorf("Expected 1 transaction writing a and b, got %d writing %v", transactions, written)
+	}
+	results := output.([]*domain.ImportResult)
+	if len(results) != 3 {
+		t.Fatalf("Expected a result for each file, got %d", len(results))
+	}
+	for _, result := range results {
+		if !result.RolledBack || result.InsertedCount != 0 || result.Error == nil {
+			t.Errorf("Expected %s to be rolled back, got %+v", result.FileName, result)
+		}
+	}
+}
+
+// TestImportTransactionMode tests that modes that can't run in a transaction are refused
+func TestImportTransactionMode(t *testing.T) {
+	importer := NewMongoImporter(context.Background(), &MockFileUtils{}, &MockRepository{}, ImporterOptions{
+		Mode:        domain.ImportModeReload,
+		Transaction: domain.TransactionFile,
+	})
+
+	result, err := importer.ImportFile("/data/users.json")
+	if err == nil || result.CollectionName != utils.FilePathToCollectionName("/data/users.json") {
+		t.Errorf("Expected an error result for users, got %+v (err=%v)", result, err)
+	}
+}
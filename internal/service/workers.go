@@ -0,0 +1,43 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// runWorkers calls fn for every index below n on at most workers goroutines
+// Indexes are handed out in increasing order and runWorkers returns once every call has returned
+func runWorkers(workers, n int, fn func(i int)) {
+	workers = max(min(workers, n), 1)
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := range n {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// inputOrder returns the results of files in the order of the files
+// Files without a result are left out
+func inputOrder(files []string, results map[string]*domain.ImportResult) []*domain.ImportResult {
+	ordered := make([]*domain.ImportResult, 0, len(results))
+	for _, file := range files {
+		if result, ok := results[file]; ok {
+			ordered = append(ordered, result)
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/OTakumi/data-importer/internal/domain"
+)
+
+// TestImportDirectoryWorkers tests that directory imports run on a bounded number of workers
+// and return the results in the order of the files
+func TestImportDirectoryWorkers(t *testing.T) {
+	var files []string
+	for i := range 6 {
+		files = append(files, fmt.Sprintf("/data/file%d.json", i))
+	}
+	mockFileUtils := &MockFileUtils{
+		FindJSONFilesFunc: func(dirPath string) ([]string, error) {
+			return files, nil
+		},
+		ParseJSONFileFunc: func(filePath string) ([]bson.D, error) {
+			return []bson.D{{{Key: "n", Value: 1}}}, nil
+		},
+	}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	mockRepo := &MockRepository{
+		InsertDocumentsFunc: func(ctx context.Context, collectionName string, documents []domain.Document) (*domain.ImportResult, error) {
+			mu.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+
+			// The first files take the longest, so they finish last
+			time.Sleep(time.Duration(6-int(collectionName[len("file")]-'0')) * 5 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return &domain.ImportResult{CollectionName: collectionName, InsertedCount: len(documents)}, nil
+		},
+	}
+
+	importer := NewMongoImporter(context.Background(), mockFileUtils, mockRepo, ImporterOptions{Workers: 2})
+	results, err := importer.ImportDirectory("/data")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if maxRunning > 2 {
+		t.Errorf("Expected at most 2 files imported at the same time, got %d", maxRunning)
+	}
+	if len(results) != len(files) {
+		t.Fatalf("Expected %d results, got %d", len(files), len(results))
+	}
+	for i, result := range results {
+		if expected := fmt.Sprintf("file%d.json", i); result.FileName != expected {
+			t.Errorf("Expected result %d to be %s, got %s", i, expected, result.FileName)
+		}
+	}
+}
+
+// TestRunWorkers tests that every index is handed to exactly one call
+func TestRunWorkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		n       int
+	}{
+		{name: "MoreItemsThanWorkers", workers: 3, n: 10},
+		{name: "MoreWorkersThanItems", workers: 8, n: 2},
+		{name: "NoWorkers", workers: 0, n: 4},
+		{name: "NoItems", workers: 4, n: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := make([]int, tt.n)
+			var mu sync.Mutex
+			runWorkers(tt.workers, tt.n, func(i int) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls[i]++
+			})
+			for i, count := range calls {
+				if count != 1 {
+					t.Errorf("Expected index %d to be handled once, got %d", i, count)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Column types that can be given as hints in a CSV header, e.g. "age:int"
+const (
+	ColumnTypeString = "string"
+	ColumnTypeInt    = "int"
+	ColumnTypeFloat  = "float"
+	ColumnTypeBool   = "bool"
+	ColumnTypeDate   = "date"
+)
+
+// csvColumn describes one header column of a CSV file
+type csvColumn struct {
+	path     []string // Field path, split on dots to build nested documents
+	typeName string   // Type hint used to convert cell values
+}
+
+// IsCSVFile reports whether the file holds delimiter-separated values (.csv or .tsv)
+func IsCSVFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".csv" || ext == ".tsv"
+}
+
+// streamCSV reads a CSV or TSV file whose first record is a header row
+// Each following record becomes one document, converted according to the header type hints
+// Records whose values can't be converted or that are malformed are passed to reject when it is not nil
+func (fu *FileUtils) streamCSV(reader *bufio.Reader, filePath string, delimiter, quote rune, batchSize int, handler BatchHandler, reject RejectHandler) error {
+	// Spreadsheet exports often start with a UTF-8 byte order mark, which is not part of the first column name
+	if c, _, err := reader.ReadRune(); err == nil && c != '\ufeff' {
+		reader.UnreadRune()
+	}
+
+	records := newCSVRecordReader(reader, delimiter, quote)
+
+	header, headerLine, err := records.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return &ParseError{File: filePath, Line: headerLine, Err: err}
+	}
+	headerText := records.Text()
+
+	columns, err := parseCSVHeader(header)
+	if err != nil {
+		return &ParseError{File: filePath, Line: headerLine, Err: err}
+	}
+
+	batch := make([]bson.D, 0, batchSize)
+	position := 0
+	for {
+		record, line, err := records.Read()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errUnterminatedQuote) && reject != nil {
+			// The quote ran to the end of the file, so only the line it starts on is rejected
+			// and the lines after it are read again as records of their own
+			first, rest, _ := strings.Cut(records.Text(), "\n")
+			position++
+			if err := reject(position-1, headerText+"\n"+strings.TrimSuffix(first, "\r"), &ParseError{File: filePath, Line: line, Err: err}); err != nil {
+				return err
+			}
+			records.restart(rest, line)
+			continue
+		}
+		if err != nil {
+			return &ParseError{File: filePath, Line: line, Err: err}
+		}
+
+		// Skip empty lines
+		if len(record) == 1 && record[0] == "" {
+			continue
+		}
+
+		document, err := csvRecordToDocument(columns, record, fu.opts.UseDecimal128)
+		position++
+		if err != nil {
+			parseErr := &ParseError{File: filePath, Line: line, Err: err}
+			if reject == nil {
+				return parseErr
+			}
+			// The record is passed on as its original text after the header line,
+			// so that it can be parsed again on its own once fixed (see ParseDocument)
+			if err := reject(position-1, headerText+"\n"+records.Text(), parseErr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		batch = append(batch, document)
+		if len(batch) == batchSize {
+			if err := handler(batch); err != nil {
+				return err
+			}
+			batch = make([]bson.D, 0, batchSize)
+		}
+	}
+
+	if len(batch) > 0 {
+		return handler(batch)
+	}
+	return nil
+}
+
+// parseCSVDocument converts the text of a rejected CSV or TSV record, its header line
+// followed by the record as passed to a RejectHandler, into a document
+// The values are converted like those of the source file; format is FormatCSV or FormatTSV
+func (fu *FileUtils) parseCSVDocument(text, format string) (bson.D, error) {
+	var documents []bson.D
+	collect := func(batch []bson.D) error {
+		documents = append(documents, batch...)
+		return nil
+	}
+	err := fu.streamCSV(bufio.NewReader(strings.NewReader(text)), "", fu.csvDelimiter(format), fu.opts.CSVQuote, DefaultBatchSize, collect, nil)
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return nil, parseErr.Err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(documents) != 1 {
+		return nil, fmt.Errorf("expected a header line and one record, found %d records", len(documents))
+	}
+	return documents[0], nil
+}
+
+// parseCSVHeader parses header cells of the form "name" or "name:type"
+// Dotted names such as "address.city" are stored as nested field paths
+func parseCSVHeader(header []string) ([]csvColumn, error) {
+	columns := make([]csvColumn, 0, len(header))
+	seen := make(map[string]bool)
+	for i, cell := range header {
+		name, typeName, hasType := strings.Cut(strings.TrimSpace(cell), ":")
+		name = strings.TrimSpace(name)
+		typeName = strings.ToLower(strings.TrimSpace(typeName))
+		if !hasType {
+			typeName = ColumnTypeString
+		}
+
+		switch typeName {
+		case ColumnTypeString, ColumnTypeInt, ColumnTypeFloat, ColumnTypeBool, ColumnTypeDate:
+		default:
+			return nil, fmt.Errorf("column %d (%s): unknown type %q", i+1, name, typeName)
+		}
+
+		path := strings.Split(name, ".")
+		for _, part := range path {
+			if part == "" {
+				return nil, fmt.Errorf("column %d: invalid column name %q", i+1, name)
+			}
+		}
+
+		if seen[name] {
+			return nil, fmt.Errorf("column %d: duplicate column name %q", i+1, name)
+		}
+		seen[name] = true
+
+		columns = append(columns, csvColumn{path: path, typeName: typeName})
+	}
+
+	// A column can't be both a value and the parent of another column
+	for _, column := range columns {
+		for j := 1; j < len(column.path); j++ {
+			if parent := strings.Join(column.path[:j], "."); seen[parent] {
+				return nil, fmt.Errorf("column %q conflicts with nested column %q", parent, strings.Join(column.path, "."))
+			}
+		}
+	}
+	return columns, nil
+}
+
+// csvRecordToDocument converts one record into a document using the header columns
+// Fields appear in header order; a sub-document is placed where its first column appears
+func csvRecordToDocument(columns []csvColumn, record []string, useDecimal128 bool) (bson.D, error) {
+	if len(record) != len(columns) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(columns), len(record))
+	}
+
+	document := make(bson.D, 0, len(columns))
+	for i, column := range columns {
+		value, err := convertCSVValue(record[i], column.typeName, useDecimal128)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", strings.Join(column.path, "."), err)
+		}
+
+		document = setPath(document, column.path, value)
+	}
+	return document, nil
+}
+
+// setPath sets the value at a dotted field path, creating sub-documents as needed
+func setPath(document bson.D, path []string, value any) bson.D {
+	if len(path) == 1 {
+		return setField(document, path[0], value)
+	}
+
+	for i := range document {
+		if document[i].Key == path[0] {
+			child, _ := document[i].Value.(bson.D)
+			document[i].Value = setPath(child, path[1:], value)
+			return document
+		}
+	}
+	return append(document, bson.E{Key: path[0], Value: setPath(nil, path[1:], value)})
+}
+
+// convertCSVValue converts a cell to the type named by its column hint
+// Empty cells in typed columns become null
+// Numbers follow the same rules as JSON numbers (see ConvertNumber)
+func convertCSVValue(value, typeName string, useDecimal128 bool) (any, error) {
+	if typeName == ColumnTypeString {
+		return value, nil
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	switch typeName {
+	case ColumnTypeInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid int %q", value)
+		}
+		return ConvertNumber(value, false), nil
+	case ColumnTypeFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %q", value)
+		}
+		if useDecimal128 {
+			if d, err := primitive.ParseDecimal128(value); err == nil {
+				return d, nil
+			}
+		}
+		return f, nil
+	case ColumnTypeBool:
+		switch strings.ToLower(value) {
+		case "true", "t", "yes", "y", "1":
+			return true, nil
+		case "false", "f", "no", "n", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid bool %q", value)
+	case ColumnTypeDate:
+		for _, format := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(format, value); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid date %q", value)
+	}
+	return value, nil
+}
+
+// errUnterminatedQuote is returned for a quoted field that is still open at the end of the file
+var errUnterminatedQuote = errors.New("unterminated quoted field")
+
+// csvRecordReader splits delimiter-separated text into records
+// Unlike encoding/csv it supports a configurable quote character
+// Quoted fields may contain delimiters, newlines and doubled quote characters
+type csvRecordReader struct {
+	reader    *bufio.Reader
+	delimiter rune
+	quote     rune
+	line      int             // Number of lines consumed so far
+	text      strings.Builder // Original text of the last record read
+}
+
+// newCSVRecordReader creates a record reader over the given input
+func newCSVRecordReader(reader *bufio.Reader, delimiter, quote rune) *csvRecordReader {
+	return &csvRecordReader{reader: reader, delimiter: delimiter, quote: quote}
+}
+
+// restart continues reading from text, which starts on the line after line
+// It is used to read again what a quote that was never closed ran over
+func (r *csvRecordReader) restart(text string, line int) {
+	r.reader = bufio.NewReader(strings.NewReader(text))
+	r.line = line
+}
+
+// Text returns the original text of the last record read, including its quoting,
+// without the line break that ends it
+func (r *csvRecordReader) Text() string {
+	return strings.TrimSuffix(r.text.String(), "\r")
+}
+
+// Read returns the next record and the line number it starts on
+// It returns io.EOF when there are no more records
+func (r *csvRecordReader) Read() ([]string, int, error) {
+	startLine := r.line + 1
+	r.text.Reset()
+
+	var fields []string
+	var field strings.Builder
+	inQuotes := false
+	atFieldStart := true
+	readAny := false
+
+	for {
+		c, _, err := r.reader.ReadRune()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, startLine, errUnterminatedQuote
+			}
+			if !readAny {
+				return nil, startLine, io.EOF
+			}
+			r.line++
+			return append(fields, field.String()), startLine, nil
+		}
+		if err != nil {
+			return nil, startLine, err
+		}
+		readAny = true
+		if c != '\n' || inQuotes {
+			r.text.WriteRune(c)
+		}
+
+		if inQuotes {
+			if c == r.quote {
+				// A doubled quote is a literal quote, a single one closes the field
+				next, _, err := r.reader.ReadRune()
+				if err == nil && next == r.quote {
+					field.WriteRune(r.quote)
+					r.text.WriteRune(r.quote)
+					continue
+				}
+				if err == nil {
+					r.reader.UnreadRune()
+				}
+				inQuotes = false
+				continue
+			}
+			if c == '\n' {
+				r.line++
+			}
+			field.WriteRune(c)
+			continue
+		}
+
+		switch {
+		case c == r.quote && atFieldStart:
+			inQuotes = true
+			atFieldStart = false
+		case c == r.delimiter:
+			fields = append(fields, field.String())
+			field.Reset()
+			atFieldStart = true
+		case c == '\n':
+			r.line++
+			return append(fields, strings.TrimSuffix(field.String(), "\r")), startLine, nil
+		default:
+			field.WriteRune(c)
+			atFieldStart = false
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestStreamDocumentsCSV tests StreamDocuments with CSV and TSV files
+func TestStreamDocumentsCSV(t *testing.T) {
+	joined, _ := time.Parse("2006-01-02", "2024-04-01")
+
+	// Setup mock filesystem with test data
+	mockFS := NewMockFileSystem()
+	mockFS.AddFile("/users.csv", []byte(
+		"name,age:int,active:bool,joined:date,address.city,address.zip\r\n"+
+			"\"Doe, John\",30,true,2024-04-01,Tokyo,100-0001\r\n"+
+			"\r\n"+
+			"\"Jane \"\"JJ\"\" Roe\",,no,,\"Osaka\nKita\",\r\n"))
+	mockFS.AddFile("/users.tsv", []byte("name\tscore:float\nJohn\t1.5\n"))
+	mockFS.AddFile("/bom.csv", []byte("\ufeffname,age:int\nJohn,30\n"))
+	mockFS.AddFile("/bad_int.csv", []byte("name,age:int\nJohn,30\nJane,thirty\n"))
+	mockFS.AddFile("/short_row.csv", []byte("name,age:int\nJohn\n"))
+	mockFS.AddFile("/bad_header.csv", []byte("name,age:number\nJohn,30\n"))
+	mockFS.AddFile("/conflict.csv", []byte("address,address.city\nx,y\n"))
+	mockFS.AddFile("/unterminated.csv", []byte("name\n\"John\n"))
+
+	// Test cases
+	tests := []struct {
+		name         string
+		filePath     string
+		opts         ParseOptions
+		expectedDocs []bson.D
+		expectedLine int
+	}{
+		{
+			name:     "Typed and nested columns",
+			filePath: "/users.csv",
+			expectedDocs: []bson.D{
+				{
+					{Key: "name", Value: "Doe, John"},
+					{Key: "age", Value: int32(30)},
+					{Key: "active", Value: true},
+					{Key: "joined", Value: joined},
+					{Key: "address", Value: bson.D{{Key: "city", Value: "Tokyo"}, {Key: "zip", Value: "100-0001"}}},
+				},
+				{
+					{Key: "name", Value: `Jane "JJ" Roe`},
+					{Key: "age", Value: nil},
+					{Key: "active", Value: false},
+					{Key: "joined", Value: nil},
+					{Key: "address", Value: bson.D{{Key: "city", Value: "Osaka\nKita"}, {Key: "zip", Value: ""}}},
+				},
+			},
+		},
+		{
+			name:     "TSV uses tab delimiter",
+			filePath: "/users.tsv",
+			expectedDocs: []bson.D{
+				{{Key: "name", Value: "John"}, {Key: "score", Value: 1.5}},
+			},
+		},
+		{
+			name:     "Byte order mark before the header",
+			filePath: "/bom.csv",
+			expectedDocs: []bson.D{
+				{{Key: "name", Value: "John"}, {Key: "age", Value: int32(30)}},
+			},
+		},
+		{
+			name:         "Invalid int reports its line number",
+			filePath:     "/bad_int.csv",
+			expectedLine: 3,
+		},
+		{
+			name:         "Row with missing fields",
+			filePath:     "/short_row.csv",
+			expectedLine: 2,
+		},
+		{
+			name:         "Unknown type hint",
+			filePath:     "/bad_header.csv",
+			expectedLine: 1,
+		},
+		{
+			name:         "Column conflicts with nested column",
+			filePath:     "/conflict.csv",
+			expectedLine: 1,
+		},
+		{
+			name:         "Unterminated quoted field",
+			filePath:     "/unterminated.csv",
+			expectedLine: 2,
+		},
+	}
+
+	// Run test cases
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fu := NewFileUtilsWithOptions(mockFS, tt.opts)
+
+			var docs []bson.D
+			err := fu.StreamDocuments(tt.filePath, 10, func(batch []bson.D) error {
+				docs = append(docs, batch...)
+				return nil
+			})
+
+			if tt.expectedLine == 0 {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(docs, tt.expectedDocs) {
+					t.Errorf("Expected documents %v, got %v", tt.expectedDocs, docs)
+				}
+				return
+			}
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Expected a ParseError, got %v", err)
+			}
+			if parseErr.Line != tt.expectedLine {
+				t.Errorf("Expected error at line %d, got line %d (%v)", tt.expectedLine, parseErr.Line, err)
+			}
+		})
+	}
+}
+
+// TestCSVCustomDelimiterAndQuote tests the configurable delimiter and quote character
+func TestCSVCustomDelimiterAndQuote(t *testing.T) {
+	mockFS := NewMockFileSystem()
+	mockFS.AddFile("/items.csv", []byte("id:int;label\n1;'a;b'\n2;'it''s'\n"))
+
+	fu := NewFileUtilsWithOptions(mockFS, ParseOptions{CSVDelimiter: ';', CSVQuote: '\''})
+	docs, err := fu.ParseJSONFile("/items.csv")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []bson.D{
+		{{Key: "id", Value: int32(1)}, {Key: "label", Value: "a;b"}},
+		{{Key: "id", Value: int32(2)}, {Key: "label", Value: "it's"}},
+	}
+	if !reflect.DeepEqual(docs, expected) {
+		t.Errorf("Expected documents %v, got %v", expected, docs)
+	}
+}
+
+// TestParseCSVDocument tests that the text of a rejected record is converted like its source file
+func TestParseCSVDocument(t *testing.T) {
+	fu := NewFileUtils(NewMockFileSystem())
+
+	tests := []struct {
+		name        string
+		text        string
+		format      string
+		expected    bson.D
+		expectError bool
+	}{
+		{
+			name:     "CSV with quoting",
+			text:     "id:int,name\n4,\"Smith, \"\"J\"\"\"",
+			format:   FormatCSV,
+			expected: bson.D{{Key: "id", Value: int32(4)}, {Key: "name", Value: `Smith, "J"`}},
+		},
+		{
+			name:     "TSV",
+			text:     "id:int\tname\n5\tLee",
+			format:   FormatTSV,
+			expected: bson.D{{Key: "id", Value: int32(5)}, {Key: "name", Value: "Lee"}},
+		},
+		{
+			name:        "Value still invalid",
+			text:        "id:int,name\nx,Bob",
+			format:      FormatCSV,
+			expectError: true,
+		},
+		{
+			name:        "Header without record",
+			text:        "id:int,name",
+			format:      FormatCSV,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := fu.ParseDocument(tt.text, tt.format)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected an error but got %v", doc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(doc, tt.expected) {
+				t.Errorf("Expected document %v, got %v", tt.expected, doc)
+			}
+		})
+	}
+}
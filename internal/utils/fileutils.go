@@ -1,11 +1,20 @@
 package utils
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 // FileSystem defines the interface for file operations
@@ -14,6 +23,7 @@ import (
 type FileSystem interface {
 	Stat(name string) (os.FileInfo, error)
 	ReadFile(filename string) ([]byte, error)
+	Open(name string) (io.ReadCloser, error)
 	Walk(root string, fn filepath.WalkFunc) error
 }
 
@@ -31,24 +41,65 @@ func (fs RealFileSystem) ReadFile(filename string) ([]byte, error) {
 	return os.ReadFile(filename)
 }
 
+// Open wraps os.Open to read file contents as a stream
+func (fs RealFileSystem) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
 // Walk wraps filepath.Walk to traverse directory trees
 func (fs RealFileSystem) Walk(root string, fn filepath.WalkFunc) error {
 	return filepath.Walk(root, fn)
 }
 
+// ParseOptions controls how input files are parsed
+type ParseOptions struct {
+	CSVDelimiter rune // Field delimiter for .csv files (.tsv files always use a tab)
+	CSVQuote     rune // Quote character for .csv and .tsv files
+
+	// ExtendedJSON decodes .json, .jsonl and .ndjson files as MongoDB Extended JSON v2,
+	// accepting both canonical and relaxed forms ($oid, $date, $numberLong, ...)
+	ExtendedJSON bool
+
+	// UseDecimal128 stores JSON numbers with a fractional part or exponent, and
+	// integers that don't fit in 64 bits, as Decimal128 instead of double
+	UseDecimal128 bool
+}
+
+// DefaultParseOptions returns the options used by NewFileUtils
+func DefaultParseOptions() ParseOptions {
+	return ParseOptions{
+		CSVDelimiter: ',',
+		CSVQuote:     '"',
+	}
+}
+
 // FileUtils provides utility functions for file operations
 // required by the MongoDB JSON importer
 type FileUtils struct {
-	fs FileSystem // The file system implementation to use
+	fs   FileSystem   // The file system implementation to use
+	opts ParseOptions // Options for parsing input files
 }
 
 // NewFileUtils creates a new FileUtils instance with the given filesystem
 // If nil is passed, it defaults to using the real file system
 func NewFileUtils(fs FileSystem) *FileUtils {
+	return NewFileUtilsWithOptions(fs, DefaultParseOptions())
+}
+
+// NewFileUtilsWithOptions creates a new FileUtils instance with the given filesystem and parse options
+// Zero values in opts are replaced by their defaults
+func NewFileUtilsWithOptions(fs FileSystem, opts ParseOptions) *FileUtils {
 	if fs == nil {
 		fs = RealFileSystem{}
 	}
-	return &FileUtils{fs: fs}
+	defaults := DefaultParseOptions()
+	if opts.CSVDelimiter == 0 {
+		opts.CSVDelimiter = defaults.CSVDelimiter
+	}
+	if opts.CSVQuote == 0 {
+		opts.CSVQuote = defaults.CSVQuote
+	}
+	return &FileUtils{fs: fs, opts: opts}
 }
 
 // IsDirectory checks if the provided path is a directory
@@ -62,8 +113,104 @@ func (fu *FileUtils) IsDirectory(path string) (bool, error) {
 	return fileInfo.IsDir(), nil
 }
 
-// FindJSONFiles recursively finds all JSON files in the given directory
-// Returns a slice of absolute paths to all JSON files in the directory tree
+// FileSize returns the size of a file in bytes
+func (fu *FileUtils) FileSize(path string) (int64, error) {
+	fileInfo, err := fu.fs.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("error checking file %s: %w", path, err)
+	}
+	return fileInfo.Size(), nil
+}
+
+// FileModTime returns the last modification time of a file
+func (fu *FileUtils) FileModTime(path string) (time.Time, error) {
+	fileInfo, err := fu.fs.Stat(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error checking file %s: %w", path, err)
+	}
+	return fileInfo.ModTime(), nil
+}
+
+// FileHash returns the SHA-256 hash of a file's content as a hex string
+// The file is streamed, so large files are not loaded into memory
+func (fu *FileUtils) FileHash(path string) (string, error) {
+	file, err := fu.fs.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("error reading file %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// supportedExtensions lists the file extensions picked up by FindJSONFiles
+var supportedExtensions = map[string]bool{
+	".json":   true,
+	".jsonl":  true,
+	".ndjson": true,
+	".csv":    true,
+	".tsv":    true,
+}
+
+// IsSupportedFile reports whether the file has an extension the importer can read
+func IsSupportedFile(path string) bool {
+	return supportedExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// DeadLetterSuffix is appended to the name of a source file, without its extension,
+// to name the file that receives its rejected documents
+const DeadLetterSuffix = ".rejected.ndjson"
+
+// DeadLetterPath returns the path of the dead-letter file kept next to a source file
+// For example: "/path/to/users.json" becomes "/path/to/users.rejected.ndjson"
+func DeadLetterPath(sourcePath string) string {
+	return strings.TrimSuffix(sourcePath, filepath.Ext(sourcePath)) + DeadLetterSuffix
+}
+
+// IsDeadLetterFile reports whether the file is a dead-letter file written by the importer
+func IsDeadLetterFile(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), DeadLetterSuffix)
+}
+
+// Formats of importable files, recorded with rejected documents so that their text can be parsed again
+const (
+	FormatJSON = "json" // JSON and JSON Lines
+	FormatCSV  = "csv"
+	FormatTSV  = "tsv"
+)
+
+// FileFormat returns the format of an importable file from its extension
+func FileFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV
+	case ".tsv":
+		return FormatTSV
+	}
+	return FormatJSON
+}
+
+// csvDelimiter returns the delimiter of a CSV or TSV file; TSV files are always tab-separated
+func (fu *FileUtils) csvDelimiter(format string) rune {
+	if format == FormatTSV {
+		return '\t'
+	}
+	return fu.opts.CSVDelimiter
+}
+
+// IsNDJSONFile reports whether the file holds newline-delimited JSON (.jsonl or .ndjson)
+func IsNDJSONFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".jsonl" || ext == ".ndjson"
+}
+
+// FindJSONFiles recursively finds all importable files in the given directory
+// Returns a slice of absolute paths to all .json, .jsonl, .ndjson, .csv and .tsv files in the directory tree
+// Dead-letter files are skipped, they are re-imported with ReplayDeadLetter instead
 // Returns an error if the directory doesn't exist or can't be accessed
 func (fu *FileUtils) FindJSONFiles(dirPath string) ([]string, error) {
 	var jsonFiles []string
@@ -73,8 +220,8 @@ func (fu *FileUtils) FindJSONFiles(dirPath string) ([]string, error) {
 		if err != nil {
 			return err
 		}
-		// Only add files with .json extension (case insensitive)
-		if !info.IsDir() && strings.ToLower(filepath.Ext(path)) == ".json" {
+		// Only add files with a supported extension (case insensitive)
+		if !info.IsDir() && IsSupportedFile(path) && !IsDeadLetterFile(path) {
 			jsonFiles = append(jsonFiles, path)
 		}
 		return nil
@@ -84,36 +231,278 @@ func (fu *FileUtils) FindJSONFiles(dirPath string) ([]string, error) {
 		return nil, fmt.Errorf("error finding JSON files in directory %s: %w", dirPath, err)
 	}
 
+	// Sorted so that directory imports see the files in the same order on every run
+	sort.Strings(jsonFiles)
 	return jsonFiles, nil
 }
 
-// ParseJSONFile parses a JSON file into a slice of maps
+// DefaultBatchSize is the number of documents passed to a BatchHandler
+// when no positive batch size is given to StreamDocuments
+const DefaultBatchSize = 1000
+
+// ParseError describes a document in a line-oriented (JSON Lines, CSV) file that could not be parsed
+type ParseError struct {
+	File string // Path of the file being read
+	Line int    // 1-based line number of the document
+	Err  error  // Underlying decoding error
+}
+
+// Error returns the error message including the line number
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid document in file %s at line %d: %v", e.File, e.Line, e.Err)
+}
+
+// Unwrap returns the underlying decoding error
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// BatchHandler receives documents read from a file, at most batchSize at a time
+// Returning an error stops the stream and the error is returned unchanged
+// Documents are bson.D values that keep the field order of the source file
+type BatchHandler func(batch []bson.D) error
+
+// RejectHandler receives a document that could not be parsed, when the format allows
+// skipping it (JSON Lines and CSV); position is the 0-based index of the document in the file
+// and raw its original text (for CSV, the header line followed by the record)
+// Returning an error stops the stream and the error is returned unchanged
+type RejectHandler func(position int, raw string, err *ParseError) error
+
+// ParseJSONFile parses a JSON file into a slice of ordered documents
 // It handles two formats:
 // 1. Array format: [{"key": "value"}, {"key": "value2"}]
 // 2. Single object format: {"key": "value"}
-// Returns a slice of maps representing JSON objects
+// Returns a slice of bson.D representing JSON objects, with fields in source order
 // Returns an error if the file doesn't exist, can't be read, or contains invalid JSON
-func (fu *FileUtils) ParseJSONFile(filePath string) ([]map[string]any, error) {
-	// Read file content
-	fileContent, err := fu.fs.ReadFile(filePath)
+// The whole file is held in memory; use StreamDocuments for large files
+func (fu *FileUtils) ParseJSONFile(filePath string) ([]bson.D, error) {
+	var documents []bson.D
+	err := fu.StreamDocuments(filePath, DefaultBatchSize, func(batch []bson.D) error {
+		documents = append(documents, batch...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+// StreamDocuments reads a file document by document and passes them
+// to handler in batches of at most batchSize documents
+// .json files accept the same formats as ParseJSONFile, but a top-level array is
+// walked token by token so memory use is bounded by the batch size, not the file size
+// .jsonl and .ndjson files are read line by line, one document per line
+// .csv and .tsv files are read record by record using the header row (see streamCSV)
+func (fu *FileUtils) StreamDocuments(filePath string, batchSize int, handler BatchHandler) error {
+	return fu.StreamDocumentsWithRejects(filePath, batchSize, handler, nil)
+}
+
+// StreamDocumentsWithRejects works like StreamDocuments, but documents of JSON Lines
+// and CSV files that can't be parsed are passed to reject and skipped instead of
+// stopping the stream. A syntax error in a .json file still stops the stream,
+// since the rest of the file can't be read reliably. A nil reject behaves like StreamDocuments
+func (fu *FileUtils) StreamDocumentsWithRejects(filePath string, batchSize int, handler BatchHandler, reject RejectHandler) error {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	file, err := fu.fs.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	if IsNDJSONFile(filePath) {
+		return fu.streamJSONLines(bufio.NewReader(file), filePath, batchSize, handler, reject)
+	}
+	if IsCSVFile(filePath) {
+		return fu.streamCSV(bufio.NewReader(file), filePath, fu.csvDelimiter(FileFormat(filePath)), fu.opts.CSVQuote, batchSize, handler, reject)
+	}
+	return fu.streamJSON(bufio.NewReader(file), filePath, batchSize, handler)
+}
+
+// StreamDeadLetter reads the records of a dead-letter file in batches
+// Dead-letter files always hold one MongoDB Extended JSON v2 record per line,
+// whatever the file is named, so they can be read after being moved aside
+func (fu *FileUtils) StreamDeadLetter(filePath string, batchSize int, handler BatchHandler) error {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	file, err := fu.fs.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	extended := *fu
+	extended.opts.ExtendedJSON = true
+	return extended.streamJSONLines(bufio.NewReader(file), filePath, batchSize, handler, nil)
+}
+
+// ParseDocument converts the text of a rejected document, as passed to a RejectHandler, into a document
+// The text is parsed like its source file of the given format: CSV and TSV records are converted with
+// the CSV options, anything else is decoded as one JSON object with the number and Extended JSON options
+func (fu *FileUtils) ParseDocument(text, format string) (bson.D, error) {
+	if format == FormatCSV || format == FormatTSV {
+		return fu.parseCSVDocument(text, format)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	document, err := fu.nextDocument(decoder)
+	if err == nil {
+		err = expectEOF(decoder)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// streamJSONLines decodes one JSON object per line, skipping blank lines
+// Lines are read with ReadBytes so there is no limit on line length
+func (fu *FileUtils) streamJSONLines(reader *bufio.Reader, filePath string, batchSize int, handler BatchHandler, reject RejectHandler) error {
+	batch := make([]bson.D, 0, batchSize)
+	lineNumber := 0
+	position := 0
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("error reading file %s at line %d: %w", filePath, lineNumber+1, readErr)
+		}
+		if len(line) > 0 {
+			lineNumber++
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			decoder := json.NewDecoder(bytes.NewReader(line))
+			decoder.UseNumber()
+			document, err := fu.nextDocument(decoder)
+			if err == nil {
+				err = expectEOF(decoder)
+			}
+			position++
+			if err != nil {
+				parseErr := &ParseError{File: filePath, Line: lineNumber, Err: err}
+				if reject == nil {
+					return parseErr
+				}
+				if err := reject(position-1, string(line), parseErr); err != nil {
+					return err
+				}
+			} else {
+				batch = append(batch, document)
+			}
+
+			if len(batch) == batchSize {
+				if err := handler(batch); err != nil {
+					return err
+				}
+				batch = make([]bson.D, 0, batchSize)
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	if len(batch) > 0 {
+		return handler(batch)
+	}
+	return nil
+}
+
+// streamJSON decodes either a top-level array of objects or a single object
+func (fu *FileUtils) streamJSON(reader *bufio.Reader, filePath string, batchSize int, handler BatchHandler) error {
+	first, err := peekNonSpace(reader)
 	if err != nil {
-		return nil, fmt.Errorf("error reading file %s: %w", filePath, err)
+		return fmt.Errorf("invalid JSON format in file %s: %w", filePath, err)
+	}
+
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+
+	// Single object format: decode it as one document
+	if first != '[' {
+		document, err := fu.nextDocument(decoder)
+		if err != nil {
+			return fmt.Errorf("invalid JSON format in file %s: %w", filePath, err)
+		}
+		if err := expectEOF(decoder); err != nil {
+			return fmt.Errorf("invalid JSON format in file %s: %w", filePath, err)
+		}
+		return handler([]bson.D{document})
+	}
+
+	// Consume the opening bracket of the array
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("invalid JSON format in file %s: %w", filePath, err)
+	}
+
+	batch := make([]bson.D, 0, batchSize)
+	index := 0
+	for decoder.More() {
+		document, err := fu.nextDocument(decoder)
+		if err != nil {
+			return fmt.Errorf("invalid JSON format in file %s (document %d): %w", filePath, index, err)
+		}
+		index++
+
+		batch = append(batch, document)
+		if len(batch) == batchSize {
+			if err := handler(batch); err != nil {
+				return err
+			}
+			// Start a new slice so the handler may keep the previous one
+			batch = make([]bson.D, 0, batchSize)
+		}
 	}
 
-	// Try to parse as array first
-	var documents []map[string]any
-	if err := json.Unmarshal(fileContent, &documents); err == nil {
-		return documents, nil
+	// Consume the closing bracket and make sure nothing follows it
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("invalid JSON format in file %s: %w", filePath, err)
 	}
+	if err := expectEOF(decoder); err != nil {
+		return fmt.Errorf("invalid JSON format in file %s: %w", filePath, err)
+	}
+
+	if len(batch) > 0 {
+		return handler(batch)
+	}
+	return nil
+}
 
-	// If parsing as array failed, try as single object
-	var document map[string]any
-	if err := json.Unmarshal(fileContent, &document); err != nil {
-		return nil, fmt.Errorf("invalid JSON format in file %s: %w", filePath, err)
+// peekNonSpace skips leading whitespace and returns the next byte without consuming it
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		return b, reader.UnreadByte()
 	}
+}
 
-	// Return single object in a slice
-	return []map[string]any{document}, nil
+// expectEOF returns an error if the decoder has any data left after the top-level value
+func expectEOF(decoder *json.Decoder) error {
+	if _, err := decoder.Token(); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("unexpected data after top-level value at offset %d", decoder.InputOffset())
+	}
+	return nil
 }
 
 // FilePathToCollectionName converts a file path to a collection name
@@ -127,6 +516,13 @@ func FilePathToCollectionName(filePath string) string {
 // FileUtilsInterface defines the interface for file operations
 type FileUtilsInterface interface {
 	IsDirectory(path string) (bool, error)
+	FileSize(path string) (int64, error)
+	FileModTime(path string) (time.Time, error)
+	FileHash(path string) (string, error)
 	FindJSONFiles(dirPath string) ([]string, error)
-	ParseJSONFile(filePath string) ([]map[string]interface{}, error)
+	ParseJSONFile(filePath string) ([]bson.D, error)
+	StreamDocuments(filePath string, batchSize int, handler BatchHandler) error
+	StreamDocumentsWithRejects(filePath string, batchSize int, handler BatchHandler, reject RejectHandler) error
+	StreamDeadLetter(filePath string, batchSize int, handler BatchHandler) error
+	ParseDocument(text, format string) (bson.D, error)
 }
@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -8,6 +12,9 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // MockFileInfo implements os.FileInfo interface for testing
@@ -15,18 +22,19 @@ import (
 type MockFileInfo struct {
 	isDir bool
 	name  string
+	size  int64
 }
 
 // Name returns the base name of the file
 func (m MockFileInfo) Name() string { return m.name }
 
-// Size returns the length in bytes - not used in our tests
-func (m MockFileInfo) Size() int64 { return 0 }
+// Size returns the length in bytes
+func (m MockFileInfo) Size() int64 { return m.size }
 
 // Mode returns the file mode bits - not used in our tests
 func (m MockFileInfo) Mode() os.FileMode { return 0 }
 
-// ModTime returns the modification time - not used in our tests
+// ModTime returns the modification time, which is always the zero time in our tests
 func (m MockFileInfo) ModTime() time.Time { return time.Time{} }
 
 // IsDir reports whether the file is a directory
@@ -58,8 +66,8 @@ func (m *MockFileSystem) Stat(name string) (os.FileInfo, error) {
 	}
 
 	// Check if it's a file
-	if _, ok := m.files[name]; ok {
-		return MockFileInfo{isDir: false, name: filepath.Base(name)}, nil
+	if content, ok := m.files[name]; ok {
+		return MockFileInfo{isDir: false, name: filepath.Base(name), size: int64(len(content))}, nil
 	}
 
 	return nil, os.ErrNotExist
@@ -74,6 +82,15 @@ func (m *MockFileSystem) ReadFile(filename string) ([]byte, error) {
 	return content, nil
 }
 
+// Open returns a reader over the content of a file
+func (m *MockFileSystem) Open(name string) (io.ReadCloser, error) {
+	content, exists := m.files[name]
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
 // Walk simulates traversing a directory tree
 func (m *MockFileSystem) Walk(root string, fn filepath.WalkFunc) error {
 	// Check if root exists
@@ -175,6 +192,64 @@ func (m *MockFileSystem) ensureParentDirs(dir string) {
 }
 
 // TestIsDirectory tests the IsDirectory function
+func TestFileSize(t *testing.T) {
+	mockFS := NewMockFileSystem()
+	mockFS.AddFile("/test_file.json", []byte(`{"key":"value"}`))
+
+	fu := NewFileUtils(mockFS)
+
+	size, err := fu.FileSize("/test_file.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if size != 15 {
+		t.Errorf("Expected size 15, got %d", size)
+	}
+
+	if _, err := fu.FileSize("/non_existent"); err == nil {
+		t.Error("Expected an error for a non-existent file")
+	}
+}
+
+func TestFileModTime(t *testing.T) {
+	mockFS := NewMockFileSystem()
+	mockFS.AddFile("/test_file.json", []byte(`{"key":"value"}`))
+
+	fu := NewFileUtils(mockFS)
+
+	modTime, err := fu.FileModTime("/test_file.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !modTime.IsZero() {
+		t.Errorf("Expected the modification time of the mock file system, got %v", modTime)
+	}
+
+	if _, err := fu.FileModTime("/non_existent"); err == nil {
+		t.Error("Expected an error for a non-existent file")
+	}
+}
+
+func TestFileHash(t *testing.T) {
+	mockFS := NewMockFileSystem()
+	mockFS.AddFile("/test_file.json", []byte(`{"key":"value"}`))
+
+	fu := NewFileUtils(mockFS)
+
+	hash, err := fu.FileHash("/test_file.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// sha256sum of {"key":"value"}
+	if hash != "e43abcf3375244839c012f9633f95862d232a95b00d5bc7348b3098b9fed7f32" {
+		t.Errorf("Unexpected hash %s", hash)
+	}
+
+	if _, err := fu.FileHash("/non_existent"); err == nil {
+		t.Error("Expected an error for a non-existent file")
+	}
+}
+
 func TestIsDirectory(t *testing.T) {
 	// Setup mock filesystem with test data
 	mockFS := NewMockFileSystem()
@@ -244,6 +319,9 @@ func TestFindJSONFiles(t *testing.T) {
 	mockFS.AddFile("/root/dir1/file3.json", []byte("{}"))
 	mockFS.AddFile("/root/dir2/file4.json", []byte("{}"))
 	mockFS.AddFile("/root/dir2/file5.txt", []byte("text"))
+	mockFS.AddFile("/root/dir2/events.jsonl", []byte("{}\n"))
+	mockFS.AddFile("/root/dir2/logs.NDJSON", []byte("{}\n"))
+	mockFS.AddFile("/root/dir1/sheet.csv", []byte("name\n"))
 
 	fu := NewFileUtils(mockFS)
 
@@ -257,13 +335,13 @@ func TestFindJSONFiles(t *testing.T) {
 		{
 			name:          "Root directory",
 			dirPath:       "/root",
-			expectedFiles: []string{"/root/file1.json", "/root/dir1/file3.json", "/root/dir2/file4.json"},
+			expectedFiles: []string{"/root/file1.json", "/root/dir1/file3.json", "/root/dir2/file4.json", "/root/dir2/events.jsonl", "/root/dir2/logs.NDJSON", "/root/dir1/sheet.csv"},
 			expectError:   false,
 		},
 		{
 			name:          "Subdirectory",
 			dirPath:       "/root/dir1",
-			expectedFiles: []string{"/root/dir1/file3.json"},
+			expectedFiles: []string{"/root/dir1/file3.json", "/root/dir1/sheet.csv"},
 			expectError:   false,
 		},
 		{
@@ -330,23 +408,23 @@ func TestParseJSONFile(t *testing.T) {
 	tests := []struct {
 		name         string
 		filePath     string
-		expectedDocs []map[string]interface{}
+		expectedDocs []bson.D
 		expectError  bool
 	}{
 		{
 			name:     "Array format JSON",
 			filePath: "/array.json",
-			expectedDocs: []map[string]interface{}{
-				{"id": float64(1), "name": "Item 1"},
-				{"id": float64(2), "name": "Item 2"},
+			expectedDocs: []bson.D{
+				{{Key: "id", Value: int32(1)}, {Key: "name", Value: "Item 1"}},
+				{{Key: "id", Value: int32(2)}, {Key: "name", Value: "Item 2"}},
 			},
 			expectError: false,
 		},
 		{
 			name:     "Single object format JSON",
 			filePath: "/object.json",
-			expectedDocs: []map[string]interface{}{
-				{"id": float64(1), "name": "Single Item"},
+			expectedDocs: []bson.D{
+				{{Key: "id", Value: int32(1)}, {Key: "name", Value: "Single Item"}},
 			},
 			expectError: false,
 		},
@@ -386,6 +464,439 @@ func TestParseJSONFile(t *testing.T) {
 	}
 }
 
+// TestStreamDocuments tests the StreamDocuments function
+func TestStreamDocuments(t *testing.T) {
+	// Setup mock filesystem with test data
+	mockFS := NewMockFileSystem()
+
+	// Array of 5 documents, pretty printed to exercise whitespace handling
+	var buf bytes.Buffer
+	buf.WriteString("\n  [\n")
+	for i := 0; i < 5; i++ {
+		if i > 0 {
+			buf.WriteString(",\n")
+		}
+		fmt.Fprintf(&buf, `  {"id":%d}`, i)
+	}
+	buf.WriteString("\n]\n")
+	mockFS.AddFile("/array.json", buf.Bytes())
+	mockFS.AddFile("/object.json", []byte(`{"id":1}`))
+	mockFS.AddFile("/empty_array.json", []byte(`[]`))
+	mockFS.AddFile("/broken.json", []byte(`[{"id":0},{"id":1},{"id":2},{"id":`))
+	mockFS.AddFile("/trailing.json", []byte(`[{"id":0}] [{"id":1}]`))
+
+	fu := NewFileUtils(mockFS)
+
+	// Test cases
+	tests := []struct {
+		name            string
+		filePath        string
+		batchSize       int
+		expectedBatches []int
+		expectError     bool
+	}{
+		{
+			name:            "Array split into batches",
+			filePath:        "/array.json",
+			batchSize:       2,
+			expectedBatches: []int{2, 2, 1},
+			expectError:     false,
+		},
+		{
+			name:            "Array smaller than batch size",
+			filePath:        "/array.json",
+			batchSize:       10,
+			expectedBatches: []int{5},
+			expectError:     false,
+		},
+		{
+			name:            "Single object",
+			filePath:        "/object.json",
+			batchSize:       2,
+			expectedBatches: []int{1},
+			expectError:     false,
+		},
+		{
+			name:            "Empty array",
+			filePath:        "/empty_array.json",
+			batchSize:       2,
+			expectedBatches: nil,
+			expectError:     false,
+		},
+		{
+			name:            "Truncated array delivers complete batches before failing",
+			filePath:        "/broken.json",
+			batchSize:       2,
+			expectedBatches: []int{2},
+			expectError:     true,
+		},
+		{
+			name:            "Trailing data after array",
+			filePath:        "/trailing.json",
+			batchSize:       2,
+			expectedBatches: nil,
+			expectError:     true,
+		},
+		{
+			name:            "Non-existent file",
+			filePath:        "/non_existent.json",
+			batchSize:       2,
+			expectedBatches: nil,
+			expectError:     true,
+		},
+	}
+
+	// Run test cases
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var batches []int
+			err := fu.StreamDocuments(tt.filePath, tt.batchSize, func(batch []bson.D) error {
+				batches = append(batches, len(batch))
+				return nil
+			})
+
+			// Check error expectation
+			if tt.expectError && err == nil {
+				t.Error("Expected an error but got none")
+			}
+
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(batches, tt.expectedBatches) {
+				t.Errorf("Expected batch sizes %v, got %v", tt.expectedBatches, batches)
+			}
+		})
+	}
+
+	// Errors returned by the handler stop the stream and are returned unchanged
+	handlerErr := errors.New("handler error")
+	calls := 0
+	err := fu.StreamDocuments("/array.json", 2, func(batch []bson.D) error {
+		calls++
+		return handlerErr
+	})
+	if err != handlerErr {
+		t.Errorf("Expected handler error to be returned, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected handler to be called once, got %d", calls)
+	}
+}
+
+// TestStreamDocumentsNDJSON tests StreamDocuments with newline-delimited JSON files
+func TestStreamDocumentsNDJSON(t *testing.T) {
+	// Setup mock filesystem with test data
+	mockFS := NewMockFileSystem()
+	mockFS.AddFile("/events.jsonl", []byte("{\"id\":1}\n{\"id\":2}\r\n\n{\"id\":3}\n{\"id\":4}"))
+	mockFS.AddFile("/events.ndjson", []byte("{\"id\":1}\n"))
+	mockFS.AddFile("/broken.jsonl", []byte("{\"id\":1}\n{\"id\":2}\n\n{\"id\":\n{\"id\":4}\n"))
+	mockFS.AddFile("/array_line.jsonl", []byte("[1,2]\n"))
+
+	fu := NewFileUtils(mockFS)
+
+	// Test cases
+	tests := []struct {
+		name            string
+		filePath        string
+		expectedIDs     []int32
+		expectedBatches []int
+		expectedLine    int
+	}{
+		{
+			name:            "Lines with blank lines and CRLF",
+			filePath:        "/events.jsonl",
+			expectedIDs:     []int32{1, 2, 3, 4},
+			expectedBatches: []int{3, 1},
+		},
+		{
+			name:            "ndjson extension",
+			filePath:        "/events.ndjson",
+			expectedIDs:     []int32{1},
+			expectedBatches: []int{1},
+		},
+		{
+			name:            "Invalid line reports its line number",
+			filePath:        "/broken.jsonl",
+			expectedIDs:     nil,
+			expectedBatches: nil,
+			expectedLine:    4,
+		},
+		{
+			name:            "Line that is not an object",
+			filePath:        "/array_line.jsonl",
+			expectedIDs:     nil,
+			expectedBatches: nil,
+			expectedLine:    1,
+		},
+	}
+
+	// Run test cases
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []int32
+			var batches []int
+			err := fu.StreamDocuments(tt.filePath, 3, func(batch []bson.D) error {
+				batches = append(batches, len(batch))
+				for _, doc := range batch {
+					ids = append(ids, doc[0].Value.(int32))
+				}
+				return nil
+			})
+
+			if tt.expectedLine == 0 {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			} else {
+				var parseErr *ParseError
+				if !errors.As(err, &parseErr) {
+					t.Fatalf("Expected a ParseError, got %v", err)
+				}
+				if parseErr.Line != tt.expectedLine {
+					t.Errorf("Expected error at line %d, got line %d", tt.expectedLine, parseErr.Line)
+				}
+			}
+
+			if !reflect.DeepEqual(ids, tt.expectedIDs) {
+				t.Errorf("Expected ids %v, got %v", tt.expectedIDs, ids)
+			}
+			if !reflect.DeepEqual(batches, tt.expectedBatches) {
+				t.Errorf("Expected batch sizes %v, got %v", tt.expectedBatches, batches)
+			}
+		})
+	}
+}
+
+// TestStreamDocumentsWithRejects tests that unparseable documents are skipped and reported
+func TestStreamDocumentsWithRejects(t *testing.T) {
+	mockFS := NewMockFileSystem()
+	mockFS.AddFile("/events.jsonl", []byte("{\"id\":1}\n{\"id\":\n\n{\"id\":3}\n"))
+	mockFS.AddFile("/users.csv", []byte("id:int,name\r\n1,Alice\r\nx,Bob\r\n\"4x\",\"Smith, \"\"J\"\"\"\r\n3,Carol\r\n"))
+	mockFS.AddFile("/unbalanced.csv", []byte("id:int,name\n1,Alice\n2,\"Bob\r\n3,Carol\n"))
+	mockFS.AddFile("/broken.json", []byte(`[{"id":1},{"id":`))
+
+	fu := NewFileUtils(mockFS)
+
+	type rejection struct {
+		position int
+		raw      string
+		line     int
+	}
+
+	tests := []struct {
+		name         string
+		filePath     string
+		expectedDocs int
+		expected     []rejection
+		expectError  bool
+	}{
+		{
+			name:         "JSON Lines",
+			filePath:     "/events.jsonl",
+			expectedDocs: 2,
+			expected:     []rejection{{position: 1, raw: `{"id":`, line: 2}},
+		},
+		{
+			name:         "CSV",
+			filePath:     "/users.csv",
+			expectedDocs: 2,
+			// Rejected records keep their original quoting and are preceded by the header line
+			expected: []rejection{
+				{position: 1, raw: "id:int,name\nx,Bob", line: 3},
+				{position: 2, raw: "id:int,name\n\"4x\",\"Smith, \"\"J\"\"\"", line: 4},
+			},
+		},
+		{
+			name:         "CSV with an unbalanced quote",
+			filePath:     "/unbalanced.csv",
+			expectedDocs: 2,
+			// Only the line the quote starts on is rejected, the following lines are still read
+			expected: []rejection{{position: 1, raw: "id:int,name\n2,\"Bob", line: 3}},
+		},
+		{
+			name:        "JSON array syntax errors still stop the stream",
+			filePath:    "/broken.json",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs := 0
+			var rejections []rejection
+			err := fu.StreamDocumentsWithRejects(tt.filePath, 10, func(batch []bson.D) error {
+				docs += len(batch)
+				return nil
+			}, func(position int, raw string, parseErr *ParseError) error {
+				rejections = append(rejections, rejection{position: position, raw: raw, line: parseErr.Line})
+				return nil
+			})
+
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected an error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if docs != tt.expectedDocs {
+				t.Errorf("Expected %d documents, got %d", tt.expectedDocs, docs)
+			}
+			if !reflect.DeepEqual(rejections, tt.expected) {
+				t.Errorf("Expected rejections %v, got %v", tt.expected, rejections)
+			}
+		})
+	}
+}
+
+// TestDeadLetterFiles tests dead-letter file naming and reading
+func TestDeadLetterFiles(t *testing.T) {
+	if path := DeadLetterPath("/data/users.json"); path != "/data/users.rejected.ndjson" {
+		t.Errorf("Unexpected dead-letter path %s", path)
+	}
+	if !IsDeadLetterFile("/data/users.rejected.ndjson") || IsDeadLetterFile("/data/users.ndjson") {
+		t.Error("IsDeadLetterFile does not recognize dead-letter files")
+	}
+
+	// Dead-letter files are skipped when searching a directory
+	mockFS := NewMockFileSystem()
+	mockFS.AddDirectory("/data")
+	mockFS.AddFile("/data/users.json", []byte(`[]`))
+	mockFS.AddFile("/data/users.rejected.ndjson", []byte(``))
+	fu := NewFileUtils(mockFS)
+
+	files, err := fu.FindJSONFiles("/data")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(files, []string{"/data/users.json"}) {
+		t.Errorf("Expected only the source file, got %v", files)
+	}
+
+	// Records are read as Extended JSON lines whatever the file is named
+	mockFS.AddFile("/data/users.rejected.ndjson.replayed",
+		[]byte(`{"document":{"n":{"$numberLong":"5"}},"collection":"users"}`+"\n"))
+	var records []bson.D
+	err = fu.StreamDeadLetter("/data/users.rejected.ndjson.replayed", 10, func(batch []bson.D) error {
+		records = append(records, batch...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []bson.D{{
+		{Key: "document", Value: bson.D{{Key: "n", Value: int64(5)}}},
+		{Key: "collection", Value: "users"},
+	}}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected %v, got %v", expected, records)
+	}
+}
+
+// TestParseDocumentJSON tests that the text of a rejected JSON document is decoded like its source file
+func TestParseDocumentJSON(t *testing.T) {
+	price, _ := primitive.ParseDecimal128("1.5")
+	id, _ := primitive.ObjectIDFromHex("507f1f77bcf86cd799439011")
+
+	tests := []struct {
+		name        string
+		opts        ParseOptions
+		text        string
+		expected    bson.D
+		expectError bool
+	}{
+		{
+			name:     "Plain JSON numbers",
+			text:     `{"n": 1, "big": 12345678901, "price": 1.5}`,
+			expected: bson.D{{Key: "n", Value: int32(1)}, {Key: "big", Value: int64(12345678901)}, {Key: "price", Value: 1.5}},
+		},
+		{
+			name:     "Decimal128",
+			opts:     ParseOptions{UseDecimal128: true},
+			text:     `{"price": 1.5}`,
+			expected: bson.D{{Key: "price", Value: price}},
+		},
+		{
+			name:     "Type wrappers stay objects without Extended JSON",
+			text:     `{"_id": {"$oid": "507f1f77bcf86cd799439011"}}`,
+			expected: bson.D{{Key: "_id", Value: bson.D{{Key: "$oid", Value: "507f1f77bcf86cd799439011"}}}},
+		},
+		{
+			name:     "Extended JSON",
+			opts:     ParseOptions{ExtendedJSON: true},
+			text:     `{"_id": {"$oid": "507f1f77bcf86cd799439011"}}`,
+			expected: bson.D{{Key: "_id", Value: id}},
+		},
+		{
+			name:        "Still invalid",
+			text:        `{"n": `,
+			expectError: true,
+		},
+		{
+			name:        "Trailing data",
+			text:        `{"n": 1} {"n": 2}`,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fu := NewFileUtilsWithOptions(NewMockFileSystem(), tt.opts)
+			doc, err := fu.ParseDocument(tt.text, FormatJSON)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected an error but got %v", doc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(doc, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, doc)
+			}
+		})
+	}
+}
+
+// TestParseJSONFileFieldOrder tests that documents keep the field order of the source
+func TestParseJSONFileFieldOrder(t *testing.T) {
+	mockFS := NewMockFileSystem()
+	mockFS.AddFile("/ordered.json", []byte(`[{"z":1,"a":{"y":true,"b":null},"m":[{"k":"v","c":"d"}],"a2":"x","z":2}]`))
+	mockFS.AddFile("/ordered.jsonl", []byte(`{"z":1,"a":"x"}`+"\n"))
+
+	fu := NewFileUtils(mockFS)
+
+	docs, err := fu.ParseJSONFile("/ordered.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A repeated key keeps its first position and its last value
+	expected := []bson.D{{
+		{Key: "z", Value: int32(2)},
+		{Key: "a", Value: bson.D{{Key: "y", Value: true}, {Key: "b", Value: nil}}},
+		{Key: "m", Value: bson.A{bson.D{{Key: "k", Value: "v"}, {Key: "c", Value: "d"}}}},
+		{Key: "a2", Value: "x"},
+	}}
+	if !reflect.DeepEqual(docs, expected) {
+		t.Errorf("Expected documents %v, got %v", expected, docs)
+	}
+
+	docs, err = fu.ParseJSONFile("/ordered.jsonl")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = []bson.D{{{Key: "z", Value: int32(1)}, {Key: "a", Value: "x"}}}
+	if !reflect.DeepEqual(docs, expected) {
+		t.Errorf("Expected documents %v, got %v", expected, docs)
+	}
+}
+
 // TestFilePathToCollectionName tests the FilePathToCollectionName function
 func TestFilePathToCollectionName(t *testing.T) {
 	// Test cases
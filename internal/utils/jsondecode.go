@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// nextDocument reads the next JSON object from the decoder as an ordered document
+// With the ExtendedJSON option the object is decoded as Extended JSON, otherwise as plain JSON
+func (fu *FileUtils) nextDocument(decoder *json.Decoder) (bson.D, error) {
+	if !fu.opts.ExtendedJSON {
+		return fu.decodeDocument(decoder)
+	}
+
+	var raw json.RawMessage
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return decodeExtendedJSON(raw)
+}
+
+// decodeExtendedJSON decodes one MongoDB Extended JSON v2 object, canonical or relaxed
+// Type wrappers become real BSON values (ObjectID, DateTime, Decimal128, Binary, ...),
+// nested objects become bson.D and arrays bson.A, keeping the source field order
+func decodeExtendedJSON(raw []byte) (bson.D, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, fmt.Errorf("expected a JSON object, got %.20s", trimmed)
+	}
+
+	var document bson.D
+	if err := bson.UnmarshalExtJSON(trimmed, false, &document); err != nil {
+		return nil, fmt.Errorf("invalid Extended JSON: %w", err)
+	}
+	return document, nil
+}
+
+// decodeDocument reads the next JSON object from the decoder as an ordered document
+// Fields keep the order they have in the source, nested objects become bson.D
+// and arrays become bson.A; if a key repeats, the last value wins
+// The decoder must have UseNumber enabled so numbers keep their precision
+func (fu *FileUtils) decodeDocument(decoder *json.Decoder) (bson.D, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("expected a JSON object, got %v", token)
+	}
+	return fu.decodeObject(decoder)
+}
+
+// decodeObject reads the members of an object whose opening brace has been consumed
+func (fu *FileUtils) decodeObject(decoder *json.Decoder) (bson.D, error) {
+	document := bson.D{}
+	for decoder.More() {
+		keyToken, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := keyToken.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected an object key, got %v", keyToken)
+		}
+
+		value, err := fu.decodeValue(decoder)
+		if err != nil {
+			return nil, err
+		}
+		document = setField(document, key, value)
+	}
+
+	// Consume the closing brace
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// decodeArray reads the elements of an array whose opening bracket has been consumed
+func (fu *FileUtils) decodeArray(decoder *json.Decoder) (bson.A, error) {
+	array := bson.A{}
+	for decoder.More() {
+		value, err := fu.decodeValue(decoder)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, value)
+	}
+
+	// Consume the closing bracket
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return array, nil
+}
+
+// decodeValue reads any JSON value, converting numbers with ConvertNumber
+func (fu *FileUtils) decodeValue(decoder *json.Decoder) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			return fu.decodeObject(decoder)
+		case '[':
+			return fu.decodeArray(decoder)
+		}
+		return nil, fmt.Errorf("unexpected delimiter %v", t)
+	case json.Number:
+		return ConvertNumber(t.String(), fu.opts.UseDecimal128), nil
+	}
+
+	// Strings, booleans and null
+	return token, nil
+}
+
+// setField sets key to value, replacing an existing field in place or appending a new one
+func setField(document bson.D, key string, value any) bson.D {
+	for i := range document {
+		if document[i].Key == key {
+			document[i].Value = value
+			return document
+		}
+	}
+	return append(document, bson.E{Key: key, Value: value})
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// canonicalDocument is a mongoexport style document in canonical Extended JSON
+const canonicalDocument = `{"_id":{"$oid":"67aea3a5369bca5b08f38a67"},` +
+	`"int":{"$numberInt":"42"},` +
+	`"long":{"$numberLong":"9007199254740993"},` +
+	`"double":{"$numberDouble":"1.5"},` +
+	`"decimal":{"$numberDecimal":"12345.6789"},` +
+	`"date":{"$date":{"$numberLong":"1716393875000"}},` +
+	`"binary":{"$binary":{"base64":"AQID","subType":"00"}},` +
+	`"uuid":{"$binary":{"base64":"c8edabc3f7384ca3b68dd6c6d6b6a8a1","subType":"04"}},` +
+	`"ts":{"$timestamp":{"t":1716393875,"i":1}},` +
+	`"regex":{"$regularExpression":{"pattern":"^abc","options":"i"}},` +
+	`"min":{"$minKey":1},` +
+	`"max":{"$maxKey":1},` +
+	`"nested":{"list":[{"$numberInt":"1"},{"$oid":"67aea3a5369bca5b08f38a68"}]}}`
+
+// TestStreamDocumentsExtendedJSON tests decoding of canonical and relaxed Extended JSON
+func TestStreamDocumentsExtendedJSON(t *testing.T) {
+	mockFS := NewMockFileSystem()
+	mockFS.AddFile("/canonical.json", []byte("["+canonicalDocument+"]"))
+	mockFS.AddFile("/canonical.jsonl", []byte(canonicalDocument+"\n"))
+	mockFS.AddFile("/relaxed.json", []byte(`{"_id":{"$oid":"67aea3a5369bca5b08f38a67"},"count":3,"big":9007199254740993,`+
+		`"price":1.5,"created":{"$date":"2024-05-22T16:04:35Z"},"id":{"$uuid":"c8edabc3-f738-4ca3-b68d-d6c6d6b6a8a1"}}`))
+	mockFS.AddFile("/invalid.json", []byte(`[{"_id":{"$oid":"not-an-object-id"}}]`))
+
+	fu := NewFileUtilsWithOptions(mockFS, ParseOptions{ExtendedJSON: true})
+
+	t.Run("Canonical form round-trips losslessly", func(t *testing.T) {
+		for _, path := range []string{"/canonical.json", "/canonical.jsonl"} {
+			docs, err := fu.ParseJSONFile(path)
+			if err != nil {
+				t.Fatalf("Unexpected error for %s: %v", path, err)
+			}
+			if len(docs) != 1 {
+				t.Fatalf("Expected 1 document for %s, got %d", path, len(docs))
+			}
+
+			exported, err := bson.MarshalExtJSON(docs[0], true, false)
+			if err != nil {
+				t.Fatalf("Failed to marshal document: %v", err)
+			}
+			if string(exported) != canonicalDocument {
+				t.Errorf("Round trip mismatch for %s\nexpected: %s\ngot:      %s", path, canonicalDocument, exported)
+			}
+		}
+	})
+
+	t.Run("Relaxed form produces BSON types", func(t *testing.T) {
+		docs, err := fu.ParseJSONFile("/relaxed.json")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		oid, _ := primitive.ObjectIDFromHex("67aea3a5369bca5b08f38a67")
+		created := time.Date(2024, 5, 22, 16, 4, 35, 0, time.UTC)
+		expected := []struct {
+			key   string
+			value any
+		}{
+			{key: "_id", value: oid},
+			{key: "count", value: int32(3)},
+			{key: "big", value: int64(9007199254740993)},
+			{key: "price", value: 1.5},
+			{key: "created", value: primitive.NewDateTimeFromTime(created)},
+		}
+
+		doc := docs[0]
+		for i, e := range expected {
+			if doc[i].Key != e.key || doc[i].Value != e.value {
+				t.Errorf("Field %d: expected %s=%v (%T), got %s=%v (%T)",
+					i, e.key, e.value, e.value, doc[i].Key, doc[i].Value, doc[i].Value)
+			}
+		}
+		if binary, ok := doc[5].Value.(primitive.Binary); !ok || binary.Subtype != 0x04 {
+			t.Errorf("Expected $uuid to decode as a subtype 4 binary, got %T %v", doc[5].Value, doc[5].Value)
+		}
+	})
+
+	t.Run("Invalid wrapper is an error", func(t *testing.T) {
+		if _, err := fu.ParseJSONFile("/invalid.json"); err == nil {
+			t.Error("Expected an error but got none")
+		}
+	})
+
+	t.Run("Wrappers stay plain objects without the option", func(t *testing.T) {
+		plain := NewFileUtils(mockFS)
+		docs, err := plain.ParseJSONFile("/relaxed.json")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, ok := docs[0][0].Value.(bson.D); !ok {
+			t.Errorf("Expected _id to stay a nested document, got %T", docs[0][0].Value)
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ConvertNumber converts the text of a JSON number to a BSON numeric type
+// Integers become int32 when they fit and int64 otherwise; integers beyond 64 bits always
+// become Decimal128 so that no digit is lost, or stay text when they exceed its 34 digits
+// Numbers with a fractional part or exponent become float64, or Decimal128 when
+// useDecimal128 is set
+func ConvertNumber(number string, useDecimal128 bool) any {
+	if !strings.ContainsAny(number, ".eE") {
+		if n, err := strconv.ParseInt(number, 10, 64); err == nil {
+			if n >= math.MinInt32 && n <= math.MaxInt32 {
+				return int32(n)
+			}
+			return n
+		}
+		if d, err := primitive.ParseDecimal128(number); err == nil {
+			return d
+		}
+		// A double would silently round the integer, so the original text is kept
+		return number
+	}
+
+	if useDecimal128 {
+		if d, err := primitive.ParseDecimal128(number); err == nil {
+			return d
+		}
+	}
+
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		// Out of float64 range, keep the original text rather than losing it
+		return number
+	}
+	return f
+}
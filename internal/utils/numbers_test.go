@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestConvertNumber tests the ConvertNumber function
+func TestConvertNumber(t *testing.T) {
+	decimal := func(s string) primitive.Decimal128 {
+		d, err := primitive.ParseDecimal128(s)
+		if err != nil {
+			t.Fatalf("Invalid decimal %s: %v", s, err)
+		}
+		return d
+	}
+
+	// Test cases
+	tests := []struct {
+		name          string
+		number        string
+		useDecimal128 bool
+		expected      any
+	}{
+		{name: "Small integer", number: "3", expected: int32(3)},
+		{name: "Negative integer", number: "-42", expected: int32(-42)},
+		{name: "Max int32", number: "2147483647", expected: int32(math.MaxInt32)},
+		{name: "Above int32", number: "2147483648", expected: int64(2147483648)},
+		{name: "Above 2^53", number: "9007199254740993", expected: int64(9007199254740993)},
+		{name: "Fraction", number: "1.5", expected: 1.5},
+		{name: "Exponent", number: "1e3", expected: float64(1000)},
+		{name: "Beyond int64 as Decimal128 without the option", number: "18446744073709551616", expected: decimal("18446744073709551616")},
+		{name: "Beyond Decimal128 kept as text", number: "-1234567890123456789012345678901234567", expected: "-1234567890123456789012345678901234567"},
+		{name: "Fraction as Decimal128", number: "0.1", useDecimal128: true, expected: decimal("0.1")},
+		{name: "Integer with Decimal128 option", number: "7", useDecimal128: true, expected: int32(7)},
+		{name: "Beyond int64 as Decimal128", number: "18446744073709551616", useDecimal128: true, expected: decimal("18446744073709551616")},
+	}
+
+	// Run test cases
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ConvertNumber(tt.number, tt.useDecimal128)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Expected %v (%T), got %v (%T)", tt.expected, tt.expected, result, result)
+			}
+		})
+	}
+}
+
+// TestStreamDocumentsNumbers tests that nested numbers keep their precision
+func TestStreamDocumentsNumbers(t *testing.T) {
+	mockFS := NewMockFileSystem()
+	mockFS.AddFile("/numbers.json", []byte(`[{"count":3,"id":9223372036854775807,"price":19.99,"nested":{"n":1},"list":[1,2.5]}]`))
+	mockFS.AddFile("/numbers.jsonl", []byte(`{"count":3,"id":9223372036854775807}`+"\n"))
+
+	fu := NewFileUtils(mockFS)
+
+	docs, err := fu.ParseJSONFile("/numbers.json")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []bson.D{
+		{
+			{Key: "count", Value: int32(3)},
+			{Key: "id", Value: int64(math.MaxInt64)},
+			{Key: "price", Value: 19.99},
+			{Key: "nested", Value: bson.D{{Key: "n", Value: int32(1)}}},
+			{Key: "list", Value: bson.A{int32(1), 2.5}},
+		},
+	}
+	if !reflect.DeepEqual(docs, expected) {
+		t.Errorf("Expected documents %v, got %v", expected, docs)
+	}
+
+	docs, err = fu.ParseJSONFile("/numbers.jsonl")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = []bson.D{{{Key: "count", Value: int32(3)}, {Key: "id", Value: int64(math.MaxInt64)}}}
+	if !reflect.DeepEqual(docs, expected) {
+		t.Errorf("Expected documents %v, got %v", expected, docs)
+	}
+}
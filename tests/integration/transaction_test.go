@@ -0,0 +1,186 @@
+package integration
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/OTakumi/data-importer/internal/config"
+	"github.com/OTakumi/data-importer/internal/domain"
+	"github.com/OTakumi/data-importer/internal/repository"
+	"github.com/OTakumi/data-importer/internal/service"
+	"github.com/OTakumi/data-importer/internal/utils"
+)
+
+// TestTransactionalImport tests transactional imports against a replica set
+// Transactions are not available on a standalone server, so this test only runs when
+// TEST_MONGODB_REPLSET_URI points to a replica set (see the mongo-rs service in compose.yaml)
+func TestTransactionalImport(t *testing.T) {
+	uri := os.Getenv("TEST_MONGODB_REPLSET_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGODB_REPLSET_URI is not set. Skipping transaction tests.")
+	}
+
+	cfg := config.NewConfig()
+	cfg.MongoURI = uri
+	cfg.DatabaseName = "test_db_transactions"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	waitForPrimary(ctx, t, uri)
+	dropDatabase(ctx, t, uri, cfg.DatabaseName)
+	defer dropDatabase(context.Background(), t, uri, cfg.DatabaseName)
+
+	repo, err := repository.NewMongoRepository(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer func() {
+		if err := repo.Disconnect(context.Background()); err != nil {
+			t.Logf("Error disconnecting from MongoDB: %v", err)
+		}
+	}()
+
+	// The second file fails on a duplicate _id after its first document was written
+	dir := t.TempDir()
+	goodPath := writeTestFile(t, dir, "tx_good.ndjson", `{"_id": 1, "name": "a"}`, `{"_id": 2, "name": "b"}`, `{"_id": 3, "name": "c"}`)
+	badPath := writeTestFile(t, dir, "tx_bad.ndjson", `{"_id": 1, "name": "a"}`, `{"_id": 1, "name": "duplicate"}`)
+
+	newImporter := func(scope domain.TransactionScope, maxBytes int64) *service.MongoImporter {
+		return service.NewMongoImporter(ctx, utils.NewFileUtils(nil), repo, service.ImporterOptions{
+			BatchSize:           1,
+			Transaction:         scope,
+			MaxTransactionBytes: maxBytes,
+		})
+	}
+
+	t.Run("FileCommitted", func(t *testing.T) {
+		if _, err := newImporter(domain.TransactionFile, 0).ImportFile(goodPath); err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		assertCount(ctx, t, repo, "tx_good", 3)
+	})
+
+	t.Run("FileRolledBack", func(t *testing.T) {
+		result, err := newImporter(domain.TransactionFile, 0).ImportFile(badPath)
+		if err == nil {
+			t.Fatal("Expected the import to fail")
+		}
+		if !result.RolledBack {
+			t.Errorf("Expected a rolled back result, got %+v", result)
+		}
+		assertCount(ctx, t, repo, "tx_bad", 0)
+	})
+
+	t.Run("RunRolledBack", func(t *testing.T) {
+		resetCollections(ctx, t, repo)
+		if _, err := newImporter(domain.TransactionRun, 0).ImportDirectory(dir); err == nil {
+			t.Fatal("Expected the import to fail")
+		}
+		assertCount(ctx, t, repo, "tx_good", 0)
+		assertCount(ctx, t, repo, "tx_bad", 0)
+	})
+
+	t.Run("StagedWhenTooLarge", func(t *testing.T) {
+		resetCollections(ctx, t, repo)
+		result, err := newImporter(domain.TransactionFile, 1).ImportFile(goodPath)
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		if !result.Staged {
+			t.Errorf("Expected a staged result, got %+v", result)
+		}
+		assertCount(ctx, t, repo, "tx_good", 3)
+
+		// A failing staged file leaves its collection untouched
+		if _, err := newImporter(domain.TransactionFile, 1).ImportFile(badPath); err == nil {
+			t.Fatal("Expected the import to fail")
+		}
+		assertCount(ctx, t, repo, "tx_bad", 0)
+
+		names, err := repo.ListCollections(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list collections: %v", err)
+		}
+		for _, name := range names {
+			if strings.Contains(name, service.StagingCollectionInfix) {
+				t.Errorf("Expected staging collections to be dropped, found %s", name)
+			}
+		}
+	})
+}
+
+// waitForPrimary waits until the replica set has elected a primary
+func waitForPrimary(ctx context.Context, t *testing.T, uri string) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	for {
+		var hello struct {
+			IsWritablePrimary bool `bson:"isWritablePrimary"`
+		}
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err == nil && hello.IsWritablePrimary {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Replica set has no primary: %v", err)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// dropDatabase drops the test database
+func dropDatabase(ctx context.Context, t *testing.T, uri, name string) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	if err := client.Database(name).Drop(ctx); err != nil {
+		t.Fatalf("Failed to drop database %s: %v", name, err)
+	}
+}
+
+// resetCollections drops the collections written by the transaction tests
+func resetCollections(ctx context.Context, t *testing.T, repo *repository.MongoRepository) {
+	for _, name := range []string{"tx_good", "tx_bad"} {
+		if err := repo.DropCollection(ctx, name); err != nil {
+			t.Fatalf("Failed to drop collection %s: %v", name, err)
+		}
+	}
+}
+
+// writeTestFile writes an NDJSON file with the given lines
+func writeTestFile(t *testing.T, dir, name string, lines ...string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	return path
+}
+
+// assertCount checks the number of documents in a collection
+func assertCount(ctx context.Context, t *testing.T, repo *repository.MongoRepository, collectionName string, expected int) {
+	t.Helper()
+	documents, err := repo.FindDocuments(ctx, collectionName)
+	if err != nil {
+		t.Fatalf("Failed to read collection %s: %v", collectionName, err)
+	}
+	if len(documents) != expected {
+		t.Errorf("Expected %d documents in %s, got %d", expected, collectionName, len(documents))
+	}
+}